@@ -0,0 +1,240 @@
+// Command pubsub-cli answers "where did my message go" from the files pubsubd
+// writes. trace rebuilds the path of a message from an audit log written with
+// message tracing on, and replay publishes a recorded message again.
+//
+//	pubsubd -audit-log /var/log/pubsubd/audit.jsonl -trace -record /var/log/pubsubd/publishes.jsonl
+//	pubsub-cli trace -audit-log /var/log/pubsubd/audit.jsonl order-1234
+//	pubsub-cli replay -recording /var/log/pubsubd/publishes.jsonl -socket /run/pubsubd.sock order-1234
+//
+// A replayed message keeps its topic, payload and headers but not its ID, so
+// the broker's dedup window doesn't drop it, and not its ordering key or
+// publisher, which the remote protocol can't carry.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/remote"
+)
+
+const usage = `usage:
+  pubsub-cli trace [-audit-log file] <message-id>
+  pubsub-cli replay [-recording file] [-socket path] <message-id>
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "trace":
+		err = runTrace(os.Args[2:], os.Stdout)
+	case "replay":
+		err = runReplay(os.Args[2:], os.Stdout)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "pubsub-cli:", err)
+		os.Exit(1)
+	}
+}
+
+// parseArgs parses a subcommand's flags and returns its single message ID
+func parseArgs(flags *flag.FlagSet, args []string) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() != 1 {
+		return "", fmt.Errorf("%s takes one message ID", flags.Name())
+	}
+	return flags.Arg(0), nil
+}
+
+func runTrace(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("trace", flag.ContinueOnError)
+	auditLog := flags.String("audit-log", "/var/log/pubsubd/audit.jsonl", "audit log written by pubsubd -audit-log -trace")
+	messageID, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	events, err := readTrace(*auditLog, messageID)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		return fmt.Errorf("no trace of message '%s' in '%s'; was pubsubd run with -trace?", messageID, *auditLog)
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	start := events[0].Time
+	fmt.Fprintf(w, "TIME\tEVENT\tSUBSCRIBER\tTOPIC\tDETAILS\n")
+	for _, event := range events {
+		offset := "+" + event.Time.Sub(start).String()
+		if event.Time.Equal(start) {
+			offset = start.Format(time.RFC3339Nano)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", offset,
+			strings.TrimPrefix(event.Operation, "message."),
+			dash(event.SubscriberID), dash(event.Topic), details(event))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// Where the message ended up for each subscriber
+	last := make(map[string]string)
+	var subscribers []string
+	for _, event := range events {
+		if event.SubscriberID == "" {
+			continue
+		}
+		if _, ok := last[event.SubscriberID]; !ok {
+			subscribers = append(subscribers, event.SubscriberID)
+		}
+		last[event.SubscriberID] = strings.TrimPrefix(event.Operation, "message.")
+	}
+	if len(subscribers) > 0 {
+		fmt.Fprintln(out)
+		for _, subscriber := range subscribers {
+			fmt.Fprintf(out, "%s: %s\n", subscriber, last[subscriber])
+		}
+	}
+	return nil
+}
+
+// readTrace returns the message events of an audit log for a message ID, in
+// the order they happened
+func readTrace(path, messageID string) ([]pubsub.AuditEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var events []pubsub.AuditEvent
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for line := 1; ; line++ {
+		var event pubsub.AuditEvent
+		if err := decoder.Decode(&event); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read audit log '%s' at line %d: %w", path, line, err)
+		}
+		if strings.HasPrefix(event.Operation, "message.") && event.Details["message_id"] == messageID {
+			events = append(events, event)
+		}
+	}
+
+	// A publish is logged after the deliveries it made, stamped with when it
+	// started
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events, nil
+}
+
+// details formats an event's details other than the message ID
+func details(event pubsub.AuditEvent) string {
+	var parts []string
+	for key, value := range event.Details {
+		if key != "message_id" {
+			parts = append(parts, key+"="+value)
+		}
+	}
+	sort.Strings(parts)
+	if event.Error != "" {
+		parts = append(parts, "error="+event.Error)
+	}
+	return dash(strings.Join(parts, " "))
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func runReplay(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	recording := flags.String("recording", "/var/log/pubsubd/publishes.jsonl", "recording written by pubsubd -record")
+	socketPath := flags.String("socket", "/run/pubsubd.sock", "pubsubd socket")
+	messageID, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	record, err := findRecord(*recording, messageID)
+	if err != nil {
+		return err
+	}
+	if record.Binary != nil {
+		return fmt.Errorf("message '%s' has a binary payload, which the remote protocol can't carry", messageID)
+	}
+
+	headers := make(map[string]string)
+	for name, value := range record.Headers {
+		switch name {
+		case pubsub.RecordHeaderMessageID, pubsub.RecordHeaderOrderingKey, pubsub.RecordHeaderPublisherID:
+		default:
+			headers[name] = value
+		}
+	}
+
+	client, err := remote.Dial("unix", *socketPath)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.PublishWithHeaders(record.Topic, record.Payload, headers); err != nil {
+		return fmt.Errorf("failed to replay message '%s': %w", messageID, err)
+	}
+	fmt.Fprintf(out, "replayed message '%s' to topic '%s', first published %s\n",
+		messageID, record.Topic, record.Time.Format(time.RFC3339Nano))
+	return nil
+}
+
+// findRecord returns the last publish of a message ID in a recording
+func findRecord(path, messageID string) (*pubsub.RecordedPublish, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer file.Close()
+
+	var found *pubsub.RecordedPublish
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for line := 1; ; line++ {
+		var record pubsub.RecordedPublish
+		if err := decoder.Decode(&record); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read recording '%s' at line %d: %w", path, line, err)
+		}
+		if record.Headers[pubsub.RecordHeaderMessageID] == messageID {
+			found = &record
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no publish of message '%s' in '%s'", messageID, path)
+	}
+	return found, nil
+}
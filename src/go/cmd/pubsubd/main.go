@@ -0,0 +1,277 @@
+// Command pubsubd runs the broker as a daemon, serving it to other processes
+// on a Unix domain socket with the remote package's protocol.
+//
+//	pubsubd -socket /run/pubsubd.sock -config /etc/pubsubd.json -pidfile /run/pubsubd.pid
+//
+// Under systemd it can be socket activated, taking its listener from the
+// socket unit instead of -socket, and reports readiness for Type=notify:
+//
+//	# pubsubd.socket
+//	[Socket]
+//	ListenStream=/run/pubsubd.sock
+//
+//	# pubsubd.service
+//	[Service]
+//	Type=notify
+//	ExecStart=/usr/local/bin/pubsubd -config /etc/pubsubd.json
+//	ExecReload=/bin/kill -HUP $MAINPID
+//
+// With -audit-log, control-plane operations are appended to a file as JSON
+// lines, and with -trace as well the lifecycle of every message published
+// with an ID, for pubsub-cli trace. -record records publishes to a new file
+// for pubsub-cli replay.
+//
+// The broker core's own log events go to stderr along with pubsubd's, at
+// -log-level and above; -log-level debug-4 includes the core's trace events.
+//
+// SIGHUP reloads the configuration file without dropping connections or
+// queued messages; without -config it is logged and ignored. SIGINT and
+// SIGTERM stop accepting connections, close the open ones and wait for running
+// handlers before exiting.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/remote"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+func main() {
+	socketPath := flag.String("socket", "/run/pubsubd.sock", "Unix socket to listen on when not socket activated")
+	configPath := flag.String("config", "", "broker configuration file, reloaded on SIGHUP")
+	pidFile := flag.String("pidfile", "", "file to write the process ID to")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for running handlers on shutdown")
+	sessionTTL := flag.Duration("session-ttl", remote.DefaultSessionTTL, "how long the subscriptions of a disconnected session are kept for resuming")
+	auditLog := flag.String("audit-log", "", "file to append audit events to as JSON lines")
+	trace := flag.Bool("trace", false, "add the lifecycle of messages published with an ID to the audit log")
+	recordPath := flag.String("record", "", "new file to record publishes to")
+	var logLevel slog.Level
+	flag.TextVar(&logLevel, "log-level", slog.LevelInfo, "least severe level to log: debug, info, warn or error")
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+	pubsub.SetLogger(logger.With("component", "core"))
+	closeDiagnostics, err := setupDiagnostics(*auditLog, *trace, *recordPath)
+	if err == nil {
+		err = run(logger, *socketPath, *configPath, *pidFile, *shutdownTimeout, *sessionTTL)
+		closeDiagnostics()
+	}
+	if err != nil {
+		logger.Error("pubsubd failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(logger *slog.Logger, socketPath, configPath, pidFile string, shutdownTimeout, sessionTTL time.Duration) error {
+	if configPath != "" {
+		if err := pubsub.ReloadConfig(configPath); err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+	}
+
+	listeners, err := activationListeners()
+	if err != nil {
+		return err
+	}
+	if len(listeners) == 0 {
+		l, err := listenUnix(socketPath)
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	if pidFile != "" {
+		if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+			return fmt.Errorf("failed to write pidfile: %w", err)
+		}
+		defer os.Remove(pidFile)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if configPath != "" {
+		go pubsub.ReloadConfigOnSIGHUP(ctx, configPath, func(err error) {
+			if err != nil {
+				logger.Error("failed to reload configuration", "error", err)
+			} else {
+				logger.Info("reloaded configuration", "path", configPath)
+			}
+		})
+	} else {
+		ignoreSIGHUP(ctx, logger)
+	}
+
+	server := &remote.Server{
+		SessionTTL: sessionTTL,
+		OnError: func(err error) {
+			logger.Warn("connection error", "error", err)
+		},
+	}
+	serveErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		logger.Info("listening", "address", l.Addr().String())
+		go func() {
+			serveErr <- server.Serve(l)
+		}()
+	}
+	notifySystemd("READY=1")
+
+	select {
+	case <-ctx.Done():
+		logger.Info("shutting down")
+	case err = <-serveErr:
+	}
+
+	notifySystemd("STOPPING=1")
+	server.Close()
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if closeErr := pubsub.Close(closeCtx); closeErr != nil {
+		logger.Warn("handlers still running at exit", "error", closeErr)
+	}
+	return err
+}
+
+// setupDiagnostics starts the audit log, message tracing and recording the
+// flags ask for, returning a function that flushes and closes their files
+func setupDiagnostics(auditLog string, trace bool, recordPath string) (func(), error) {
+	if trace && auditLog == "" {
+		return nil, errors.New("-trace needs -audit-log")
+	}
+
+	var closers []func() error
+	closeAll := func() {
+		for _, close := range closers {
+			close()
+		}
+	}
+	if auditLog != "" {
+		file, err := os.OpenFile(auditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		if err := pubsub.SetAuditSink(pubsub.NewFileAuditSink(file)); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to start audit log: %w", err)
+		}
+		closers = append(closers, func() error {
+			pubsub.SetAuditSink(nil)
+			return file.Close()
+		})
+	}
+	if trace {
+		if err := pubsub.SetMessageTracing(true); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("failed to start message tracing: %w", err)
+		}
+	}
+	if recordPath != "" {
+		recorder, err := pubsub.Record(recordPath)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		closers = append(closers, recorder.Close)
+	}
+	return closeAll, nil
+}
+
+// listenUnix listens on a Unix socket. A socket file left by an earlier run
+// that didn't shut down is removed first, but one that a running daemon is
+// still listening on is left alone.
+func listenUnix(socketPath string) (net.Listener, error) {
+	if info, err := os.Lstat(socketPath); err == nil && info.Mode().Type() == os.ModeSocket {
+		conn, err := net.Dial("unix", socketPath)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to listen on '%s': another process is listening on it", socketPath)
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			os.Remove(socketPath)
+		}
+	}
+
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on '%s': %w", socketPath, err)
+	}
+	return l, nil
+}
+
+// ignoreSIGHUP logs SIGHUP until ctx is done, rather than letting it terminate
+// the daemon, when there is no configuration file to reload
+func ignoreSIGHUP(ctx context.Context, logger *slog.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				logger.Warn("ignoring SIGHUP: no configuration file to reload, start with -config")
+			}
+		}
+	}()
+}
+
+// activationListeners returns the sockets passed by systemd socket
+// activation, if the process was started that way
+func activationListeners() ([]net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		syscall.CloseOnExec(fd)
+		file := os.NewFile(uintptr(fd), "listen-fd-"+strconv.Itoa(fd))
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to use activated socket %d: %w", fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// notifySystemd sends a state to the service manager, if it asked for one
+func notifySystemd(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
@@ -0,0 +1,342 @@
+// Command ffigen generates the Go side of the core's C interface from
+// pubsub_core.h, which cbindgen generates from the Rust crate. Run it with go
+// generate in the pubsub package:
+//
+//	//go:generate go run ../internal/ffigen -codes PUBLISH_
+//
+// It writes ffi_generated.go holding
+//
+//   - a constant for every integer #define, core followed by the name in
+//     camel case, so PUBLISH_NO_TOPIC becomes corePublishNoTopic
+//   - for every prefix given with -codes, a function returning the name of a
+//     code, so corePublishName(C.PUBLISH_NO_TOPIC) is "PUBLISH_NO_TOPIC"
+//   - a thin wrapper for every function whose arguments and result convert
+//     to Go types by themselves: strings are copied to C and freed after the
+//     call, and a char* result is copied and released with free_string.
+//     set_group_cooldown becomes coreSetGroupCooldown.
+//
+// Functions taking pointers or callbacks are left to handwritten code, as are
+// those whose wrapper would take a constant's name, such as abi_version. With
+// -check, ffigen writes nothing and fails if ffi_generated.go is out of date.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Integer literals, and the shifts cbindgen writes for bit flags
+	define       = regexp.MustCompile(`^#define\s+(\w+)\s+(-?(?:0x[0-9A-Fa-f]+|\d+)[uUlL]*|\(\d+ << \d+\))$`)
+	blockComment = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	prototype    = regexp.MustCompile(`^(?:extern\s+)?([\w\s\*]+?)\s*\b(\w+)\s*\(([^()]*)\)$`)
+)
+
+// initialisms are the words written in upper case in Go names
+var initialisms = map[string]bool{"abi": true, "id": true, "json": true, "ok": true, "ttl": true, "url": true}
+
+// cTypes maps the C types wrappers accept to the Go types they take and
+// return. size_t is an int on the Go side, as lengths are.
+var cTypes = map[string]string{
+	"bool":     "bool",
+	"double":   "float64",
+	"int32_t":  "int32",
+	"int64_t":  "int64",
+	"size_t":   "int",
+	"uint32_t": "uint32",
+	"uint64_t": "uint64",
+}
+
+type constant struct {
+	name string
+	// comment is the comment above the constant's group in the header
+	comment string
+}
+
+type param struct {
+	cType, name string
+}
+
+type function struct {
+	result, name string
+	params       []param
+}
+
+func main() {
+	header := flag.String("header", "pubsub_core.h", "header to generate from")
+	output := flag.String("output", "ffi_generated.go", "file to write")
+	codes := flag.String("codes", "", "comma-separated prefixes of the #defines to generate name functions for")
+	check := flag.Bool("check", false, "fail if the output is out of date instead of writing it")
+	flag.Parse()
+
+	if err := run(*header, *output, *codes, *check); err != nil {
+		fmt.Fprintln(os.Stderr, "ffigen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(header, output, codes string, check bool) error {
+	source, err := os.ReadFile(header)
+	if err != nil {
+		return err
+	}
+	constants, functions, err := parse(string(source))
+	if err != nil {
+		return fmt.Errorf("%s: %w", header, err)
+	}
+
+	var prefixes []string
+	if codes != "" {
+		prefixes = strings.Split(codes, ",")
+	}
+	generated, err := generate(header, constants, functions, prefixes)
+	if err != nil {
+		return err
+	}
+
+	if check {
+		current, err := os.ReadFile(output)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(current, generated) {
+			return fmt.Errorf("%s is out of date with %s; run go generate", output, header)
+		}
+		return nil
+	}
+	return os.WriteFile(output, generated, 0o644)
+}
+
+// parse reads the integer #defines and the function prototypes of a header
+func parse(source string) ([]constant, []function, error) {
+	var constants []constant
+	var functions []function
+	var comment []string
+	var statement strings.Builder
+	depth := 0
+
+	source = blockComment.ReplaceAllString(source, "")
+	for _, line := range strings.Split(source, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "//"):
+			comment = append(comment, strings.TrimSpace(strings.TrimPrefix(line, "//")))
+			continue
+		case line == "":
+			comment = nil
+			continue
+		case strings.HasPrefix(line, "#"):
+			if m := define.FindStringSubmatch(line); m != nil {
+				constants = append(constants, constant{name: m[1], comment: strings.Join(comment, " ")})
+			} else {
+				comment = nil
+			}
+			continue
+		}
+		comment = nil
+
+		// Statements end at a semicolon outside braces, so struct bodies and
+		// prototypes cbindgen wraps over several lines are read whole
+		for _, r := range line {
+			switch r {
+			case '{':
+				depth++
+			case '}':
+				depth--
+			}
+			if r == ';' && depth == 0 {
+				if fn, ok, err := parsePrototype(statement.String()); err != nil {
+					return nil, nil, err
+				} else if ok {
+					functions = append(functions, fn)
+				}
+				statement.Reset()
+				continue
+			}
+			statement.WriteRune(r)
+		}
+		statement.WriteByte(' ')
+	}
+	if depth != 0 {
+		return nil, nil, errors.New("unbalanced braces")
+	}
+	return constants, functions, nil
+}
+
+// parsePrototype parses a statement that declares a function, reporting
+// false for other statements
+func parsePrototype(statement string) (function, bool, error) {
+	statement = strings.Join(strings.Fields(statement), " ")
+	if statement == "" || strings.HasPrefix(statement, "typedef") {
+		return function{}, false, nil
+	}
+	m := prototype.FindStringSubmatch(statement)
+	if m == nil {
+		return function{}, false, nil
+	}
+
+	fn := function{result: normalizeType(m[1]), name: m[2]}
+	params := strings.TrimSpace(m[3])
+	if params == "" || params == "void" {
+		return fn, true, nil
+	}
+	for _, p := range strings.Split(params, ",") {
+		p = strings.TrimSpace(p)
+		split := strings.LastIndexAny(p, " *")
+		if split < 0 {
+			return function{}, false, fmt.Errorf("parameter '%s' of %s has no name", p, fn.name)
+		}
+		fn.params = append(fn.params, param{cType: normalizeType(p[:split+1]), name: p[split+1:]})
+	}
+	return fn, true, nil
+}
+
+// normalizeType writes pointer types as cbindgen and the handwritten header
+// both might, "const char *" and "const char*" alike, in the second form
+func normalizeType(t string) string {
+	t = strings.Join(strings.Fields(t), " ")
+	return strings.ReplaceAll(t, " *", "*")
+}
+
+// camel converts a snake or screaming snake case name to camel case, with
+// an upper case first letter if exported
+func camel(name string, exported bool) string {
+	var b strings.Builder
+	for i, word := range strings.Split(strings.ToLower(name), "_") {
+		switch {
+		case word == "":
+		case i == 0 && !exported:
+			b.WriteString(word)
+		case initialisms[word]:
+			b.WriteString(strings.ToUpper(word))
+		default:
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
+
+// paramName is the Go name of a parameter, which mustn't be a keyword
+func paramName(name string) string {
+	goName := camel(name, false)
+	if token.IsKeyword(goName) {
+		goName += "_"
+	}
+	return goName
+}
+
+func generate(header string, constants []constant, functions []function, codes []string) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by ffigen from %s; DO NOT EDIT.\n\n", filepath.Base(header))
+
+	taken := make(map[string]bool)
+	for _, c := range constants {
+		taken["core"+camel(c.name, true)] = true
+	}
+	var wrappers bytes.Buffer
+	usesStrings := false
+	for _, fn := range functions {
+		if taken["core"+camel(fn.name, true)] {
+			continue
+		}
+		if writeWrapper(&wrappers, fn) {
+			usesStrings = true
+		}
+	}
+
+	fmt.Fprintf(&b, "package pubsub\n\n// #include %q\nimport \"C\"\n\n", filepath.Base(header))
+	if usesStrings {
+		b.WriteString("import \"unsafe\"\n\n")
+	}
+
+	b.WriteString("const (\n")
+	for i, c := range constants {
+		if c.comment != "" && (i == 0 || constants[i-1].comment != c.comment) {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "// %s\n", c.comment)
+		}
+		fmt.Fprintf(&b, "core%s = C.%s\n", camel(c.name, true), c.name)
+	}
+	b.WriteString(")\n")
+
+	for _, prefix := range codes {
+		name := "core" + camel(strings.TrimSuffix(prefix, "_"), true) + "Name"
+		fmt.Fprintf(&b, "\n// %s returns the name of a %s code\n", name, prefix)
+		fmt.Fprintf(&b, "func %s(code uint32) string {\nswitch code {\n", name)
+		found := false
+		for _, c := range constants {
+			if strings.HasPrefix(c.name, prefix) {
+				fmt.Fprintf(&b, "case C.%s:\nreturn %q\n", c.name, c.name)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no #define starts with %s", prefix)
+		}
+		fmt.Fprintf(&b, "default:\nreturn \"unknown %s code\"\n}\n}\n", prefix)
+	}
+
+	b.Write(wrappers.Bytes())
+
+	formatted, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+	return formatted, nil
+}
+
+// writeWrapper writes the wrapper of a function if its types allow one,
+// reporting whether it takes a string
+func writeWrapper(b *bytes.Buffer, fn function) bool {
+	var goParams, args, setup []string
+	for _, p := range fn.params {
+		name := paramName(p.name)
+		switch goType, ok := cTypes[p.cType]; {
+		case ok:
+			goParams = append(goParams, name+" "+goType)
+			args = append(args, fmt.Sprintf("C.%s(%s)", p.cType, name))
+		case p.cType == "const char*":
+			cName := "c" + camel(p.name, true)
+			goParams = append(goParams, name+" string")
+			args = append(args, cName)
+			setup = append(setup, fmt.Sprintf("%s := C.CString(%s)\ndefer C.free(unsafe.Pointer(%s))", cName, name, cName))
+		default:
+			return false
+		}
+	}
+
+	call := fmt.Sprintf("C.%s(%s)", fn.name, strings.Join(args, ", "))
+	var result, body string
+	switch goType, ok := cTypes[fn.result]; {
+	case fn.result == "void":
+		body = call
+	case ok:
+		result = goType
+		body = fmt.Sprintf("return %s(%s)", goType, call)
+	case fn.result == "char*":
+		// A null result is how the core reports failure
+		result = "(string, bool)"
+		body = fmt.Sprintf("result := %s\nif result == nil {\nreturn \"\", false\n}\ndefer C.free_string(result)\nreturn C.GoString(result), true", call)
+	default:
+		return false
+	}
+
+	name := "core" + camel(fn.name, true)
+	fmt.Fprintf(b, "\n// %s calls %s\n", name, fn.name)
+	fmt.Fprintf(b, "func %s(%s) %s {\n", name, strings.Join(goParams, ", "), result)
+	for _, s := range setup {
+		b.WriteString(s + "\n")
+	}
+	b.WriteString(body + "\n}\n")
+	return len(setup) > 0
+}
@@ -0,0 +1,199 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// DefaultAckTimeout is how long a message from Fetch may go unacknowledged
+// before it is redelivered, unless SetAckTimeout changes it
+const DefaultAckTimeout = 30 * time.Second
+
+// ErrUnknownDelivery is returned when acknowledging a delivery that is no
+// longer in flight: it was acked or nacked already, or was redelivered after
+// its ack timeout passed
+var ErrUnknownDelivery = errors.New("delivery is not in flight")
+
+// Delivery is a message taken from a subscriber's queue by Fetch. It stays in
+// flight until it is acknowledged with Ack, or given back with Nack.
+type Delivery struct {
+	Message
+	// Tag identifies the delivery to the broker
+	Tag uint64
+	// Attempt counts the times the message has been fetched, starting at 1
+	Attempt int
+
+	subscriberID string
+}
+
+// Fetch takes up to maxMessages queued messages for a subscriber and holds
+// them in flight rather than removing them. A message that isn't acked within
+// the ack timeout, or is nacked, is queued again ahead of newer messages and
+// returned by a later Fetch with its Attempt raised, so a consumer that fails
+// partway loses nothing: it gets at-least-once delivery without a callback.
+// If topic is empty, it fetches messages from any topic.
+// If the next message is larger than the receive buffer it is left queued and
+// an *ErrMessageTruncated is returned.
+func Fetch(subscriberID string, topic string, maxMessages int) ([]Delivery, error) {
+	deliveries, _, err := fetchMessages(subscriberID, topic, maxMessages, true)
+	return deliveries, err
+}
+
+// Ack acknowledges the delivery, removing the message for good
+func (d *Delivery) Ack() error {
+	cSubscriberID := C.CString(d.subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	if !C.ack_message(cSubscriberID, C.uint64_t(d.Tag)) {
+		return checkInternal(fmt.Errorf("failed to ack message: %w", ErrUnknownDelivery))
+	}
+	return nil
+}
+
+// Nack gives the delivery back, queueing the message for immediate redelivery
+func (d *Delivery) Nack() error {
+	cSubscriberID := C.CString(d.subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	if !C.nack_message(cSubscriberID, C.uint64_t(d.Tag)) {
+		return checkInternal(fmt.Errorf("failed to nack message: %w", ErrUnknownDelivery))
+	}
+	return nil
+}
+
+// ExtendVisibility keeps the delivery in flight for timeout from now, in
+// place of what was left of its visibility timeout, so a consumer that needs
+// longer to process it doesn't see it redelivered meanwhile. A timeout of 0
+// makes it due for redelivery at once.
+func (d *Delivery) ExtendVisibility(timeout time.Duration) error {
+	if timeout < 0 {
+		return errors.New("failed to extend visibility: timeout must not be negative")
+	}
+
+	cSubscriberID := C.CString(d.subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	if !C.extend_visibility(cSubscriberID, C.uint64_t(d.Tag), C.uint64_t(timeout.Milliseconds())) {
+		return checkInternal(fmt.Errorf("failed to extend visibility: %w", ErrUnknownDelivery))
+	}
+	return nil
+}
+
+// SetAckTimeout sets how long a message from Fetch may go unacknowledged
+// before it is redelivered, for subscriptions without their own
+// WithVisibilityTimeout. It applies to messages fetched afterwards.
+func SetAckTimeout(timeout time.Duration) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"ack_timeout": timeout.String()}}, err)
+	}()
+
+	if timeout < time.Millisecond {
+		return errors.New("failed to set ack timeout: timeout must be at least a millisecond")
+	}
+
+	if !C.set_ack_timeout(C.uint64_t(timeout.Milliseconds())) {
+		return errors.New("failed to set ack timeout")
+	}
+	return nil
+}
+
+// InFlightMessage describes a message fetched with Fetch and not yet acked
+type InFlightMessage struct {
+	SubscriberID string
+	Topic        string
+	// Tag identifies the delivery to Redeliver and Discard
+	Tag uint64
+	// MessageID is the ID the message was published with, if any
+	MessageID string
+	// Attempt counts the times the message has been fetched, starting at 1
+	Attempt int
+	// Age is the time since the message was fetched
+	Age time.Duration
+	// RedeliverIn is the time left before the message is redelivered
+	RedeliverIn time.Duration
+}
+
+type inFlightJSON struct {
+	DeliveryTag   uint64 `json:"delivery_tag"`
+	SubscriberID  string `json:"subscriber_id"`
+	Topic         string `json:"topic"`
+	MessageID     string `json:"message_id"`
+	Attempt       int    `json:"attempt"`
+	AgeMs         int64  `json:"age_ms"`
+	RedeliverInMs int64  `json:"redeliver_in_ms"`
+}
+
+// ListInFlight returns the messages fetched and not yet acked, oldest
+// delivery first, for operating consumers that use Fetch: a message with a
+// high attempt count is one its consumer keeps failing on, and an old one
+// belongs to a consumer that has stalled. An empty subscriberID or topic
+// matches every subscriber or topic.
+func ListInFlight(subscriberID, topic string) ([]InFlightMessage, error) {
+	var cSubscriberID, cTopic *C.char
+	if subscriberID != "" {
+		cSubscriberID = C.CString(subscriberID)
+		defer C.free(unsafe.Pointer(cSubscriberID))
+	}
+	if topic != "" {
+		cTopic = C.CString(ResolveTopic(topic))
+		defer C.free(unsafe.Pointer(cTopic))
+	}
+
+	cInFlight := C.list_in_flight(cSubscriberID, cTopic)
+	if cInFlight == nil {
+		return nil, checkInternal(errors.New("failed to list in-flight messages"))
+	}
+	defer C.free_string(cInFlight)
+
+	var raw []inFlightJSON
+	if err := json.Unmarshal([]byte(C.GoString(cInFlight)), &raw); err != nil {
+		return nil, err
+	}
+
+	messages := make([]InFlightMessage, 0, len(raw))
+	for _, r := range raw {
+		messages = append(messages, InFlightMessage{
+			SubscriberID: r.SubscriberID,
+			Topic:        r.Topic,
+			Tag:          r.DeliveryTag,
+			MessageID:    r.MessageID,
+			Attempt:      r.Attempt,
+			Age:          time.Duration(r.AgeMs) * time.Millisecond,
+			RedeliverIn:  time.Duration(r.RedeliverInMs) * time.Millisecond,
+		})
+	}
+	return messages, nil
+}
+
+// Redeliver queues an in-flight message again for immediate redelivery, as
+// its consumer calling Nack would
+func Redeliver(subscriberID string, tag uint64) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditMessageRedeliver, SubscriberID: subscriberID, Details: map[string]string{"tag": strconv.FormatUint(tag, 10)}}, err)
+	}()
+
+	d := Delivery{Tag: tag, subscriberID: subscriberID}
+	return d.Nack()
+}
+
+// Discard drops an in-flight message without delivering it again, for a
+// message no consumer can process
+func Discard(subscriberID string, tag uint64) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditMessageDiscard, SubscriberID: subscriberID, Details: map[string]string{"tag": strconv.FormatUint(tag, 10)}}, err)
+	}()
+
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	if !C.discard_message(cSubscriberID, C.uint64_t(tag)) {
+		return checkInternal(fmt.Errorf("failed to discard message: %w", ErrUnknownDelivery))
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+// Package admin serves a REST API for administering the broker over HTTP,
+// described by an OpenAPI document generated from the types it serves, so
+// that web UIs and clients can be built against it:
+//
+//	http.Handle("/admin/", http.StripPrefix("/admin", admin.Handler()))
+//
+// The API has no authentication of its own. Serve it only behind middleware
+// that authenticates callers, or on a listener only operators can reach.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// Topic is a topic as listed by GET /topics
+type Topic struct {
+	Name string `json:"name"`
+	// Delivery is the topic's delivery mode: any, fanout, queue or keyed
+	Delivery    string `json:"delivery"`
+	Subscribers int    `json:"subscribers"`
+}
+
+// Subscriber is a subscriber of a topic as listed by GET
+// /topics/{topic}/subscribers
+type Subscriber struct {
+	SubscriberID string            `json:"subscriber_id"`
+	Group        string            `json:"group,omitempty"`
+	JoinedAt     time.Time         `json:"joined_at"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	// QueueDepth is the number of messages waiting in the subscriber's queue
+	// for the topic
+	QueueDepth int `json:"queue_depth"`
+}
+
+// Peek is the head of a subscriber's queue for a topic, as returned by GET
+// /topics/{topic}/subscribers/{subscriber}/peek
+type Peek struct {
+	QueueDepth int `json:"queue_depth"`
+	// Content is the message the subscriber receives next, left queued, or
+	// null if the queue is empty. Bytes that aren't UTF-8 are replaced.
+	Content *string `json:"content"`
+}
+
+// Stats is the broker's counters and delivery metrics, as served by GET /stats
+type Stats struct {
+	Published       uint64         `json:"published"`
+	Delivered       uint64         `json:"delivered"`
+	Dropped         uint64         `json:"dropped"`
+	Topics          int            `json:"topics"`
+	Subscribers     int            `json:"subscribers"`
+	QueueDepth      int            `json:"queue_depth"`
+	RetainedBytes   uint64         `json:"retained_bytes"`
+	SpilledBytes    uint64         `json:"spilled_bytes"`
+	TopicsCollected uint64         `json:"topics_collected"`
+	Subscriptions   []Subscription `json:"subscriptions"`
+	Publishers      []Publisher    `json:"publishers"`
+}
+
+// Subscription holds the delivery metrics of a subscriber and topic
+type Subscription struct {
+	SubscriberID string `json:"subscriber_id"`
+	Topic        string `json:"topic"`
+	// Lag is the time from publish until delivery, and Handler the time
+	// spent in the callback
+	Lag     Latency `json:"lag"`
+	Handler Latency `json:"handler"`
+}
+
+// Latency summarizes a latency distribution in microseconds
+type Latency struct {
+	Count uint64 `json:"count"`
+	SumUs int64  `json:"sum_us"`
+	P50Us int64  `json:"p50_us"`
+	P95Us int64  `json:"p95_us"`
+	P99Us int64  `json:"p99_us"`
+}
+
+// Publisher holds the counters of a registered publisher
+type Publisher struct {
+	PublisherID string            `json:"publisher_id"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Published   uint64            `json:"published"`
+	Bytes       uint64            `json:"bytes"`
+}
+
+// Error is the body of every response with an error status
+type Error struct {
+	Error string `json:"error"`
+}
+
+// route is an endpoint of the API. The routes drive both the handler and the
+// OpenAPI document, so the two can't disagree.
+type route struct {
+	method  string
+	pattern string
+	// id is the OpenAPI operation ID, the name of the handler
+	id      string
+	summary string
+	// status is the status of a successful response, and response a value of
+	// the type of its body, or nil if it has none
+	status   int
+	response any
+	// notFound is set if the endpoint responds 404 to an unknown topic or
+	// subscriber
+	notFound bool
+	handle   func(w http.ResponseWriter, r *http.Request)
+}
+
+var routes = []route{
+	{
+		method:   http.MethodGet,
+		pattern:  "/topics",
+		id:       "listTopics",
+		summary:  "List topics, excluding reserved ones",
+		status:   http.StatusOK,
+		response: []Topic{},
+		handle:   listTopics,
+	},
+	{
+		method:   http.MethodDelete,
+		pattern:  "/topics/{topic}",
+		id:       "deleteTopic",
+		summary:  "Delete a topic and its subscriptions",
+		status:   http.StatusNoContent,
+		notFound: true,
+		handle:   deleteTopic,
+	},
+	{
+		method:   http.MethodGet,
+		pattern:  "/topics/{topic}/subscribers",
+		id:       "listSubscribers",
+		summary:  "List the subscribers of a topic",
+		status:   http.StatusOK,
+		response: []Subscriber{},
+		notFound: true,
+		handle:   listSubscribers,
+	},
+	{
+		method:   http.MethodGet,
+		pattern:  "/topics/{topic}/subscribers/{subscriber}/peek",
+		id:       "peekMessage",
+		summary:  "Peek at the next message queued for a subscriber of a topic",
+		status:   http.StatusOK,
+		response: Peek{},
+		notFound: true,
+		handle:   peekMessage,
+	},
+	{
+		method:   http.MethodGet,
+		pattern:  "/stats",
+		id:       "getStats",
+		summary:  "Get the broker's counters and delivery metrics",
+		status:   http.StatusOK,
+		response: Stats{},
+		handle:   getStats,
+	},
+}
+
+// Handler serves the API, and its OpenAPI document at GET /openapi.json.
+// Topics in paths are escaped as path segments, so "orders/eu" is
+// "/topics/orders%2Feu".
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		mux.HandleFunc(rt.method+" "+rt.pattern, rt.handle)
+	}
+	spec := Spec()
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	})
+	return mux
+}
+
+func listTopics(w http.ResponseWriter, r *http.Request) {
+	names, err := pubsub.ListTopics()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	topics := []Topic{}
+	for _, name := range names {
+		if strings.HasPrefix(name, pubsub.ReservedPrefix) {
+			continue
+		}
+		subscribers, err := pubsub.Presence(name)
+		if err != nil {
+			// Deleted since it was listed
+			continue
+		}
+		topics = append(topics, Topic{
+			Name:        name,
+			Delivery:    pubsub.TopicDeliveryMode(name).String(),
+			Subscribers: len(subscribers),
+		})
+	}
+	writeJSON(w, http.StatusOK, topics)
+}
+
+func deleteTopic(w http.ResponseWriter, r *http.Request) {
+	topic, ok := existingTopic(w, r)
+	if !ok {
+		return
+	}
+	if err := pubsub.DeleteTopic(topic); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listSubscribers(w http.ResponseWriter, r *http.Request) {
+	topic, ok := existingTopic(w, r)
+	if !ok {
+		return
+	}
+	infos, err := pubsub.Presence(topic)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	subscribers := make([]Subscriber, 0, len(infos))
+	for _, info := range infos {
+		subscribers = append(subscribers, Subscriber{
+			SubscriberID: info.SubscriberID,
+			Group:        info.Group,
+			JoinedAt:     info.JoinedAt,
+			Labels:       info.Labels,
+			QueueDepth:   pubsub.QueueDepth(info.SubscriberID, topic),
+		})
+	}
+	writeJSON(w, http.StatusOK, subscribers)
+}
+
+func peekMessage(w http.ResponseWriter, r *http.Request) {
+	topic, ok := existingTopic(w, r)
+	if !ok {
+		return
+	}
+	subscriberID := r.PathValue("subscriber")
+	infos, err := pubsub.Presence(topic)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	if !slices.ContainsFunc(infos, func(info pubsub.SubscriberInfo) bool { return info.SubscriberID == subscriberID }) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("'%s' is not subscribed to '%s'", subscriberID, topic))
+		return
+	}
+
+	peek := Peek{QueueDepth: pubsub.QueueDepth(subscriberID, topic)}
+	if peek.QueueDepth > 0 {
+		msg, err := pubsub.PeekMessage(subscriberID, topic)
+		if err != nil {
+			writeError(w, errorStatus(err), err)
+			return
+		}
+		peek.Content = &msg.Content
+	}
+	writeJSON(w, http.StatusOK, peek)
+}
+
+func getStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := GetStats()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// GetStats returns the broker's stats as GET /stats serves them, for serving
+// them by other means such as a stream
+func GetStats() (*Stats, error) {
+	stats, err := pubsub.Stats()
+	if err != nil {
+		return nil, err
+	}
+
+	body := &Stats{
+		Published:       stats.Published,
+		Delivered:       stats.Delivered,
+		Dropped:         stats.Dropped,
+		Topics:          stats.Topics,
+		Subscribers:     stats.Subscribers,
+		QueueDepth:      stats.QueueDepth,
+		RetainedBytes:   stats.RetainedBytes,
+		SpilledBytes:    stats.SpilledBytes,
+		TopicsCollected: stats.TopicsCollected,
+		Subscriptions:   make([]Subscription, 0, len(stats.Subscriptions)),
+		Publishers:      make([]Publisher, 0, len(stats.Publishers)),
+	}
+	for _, s := range stats.Subscriptions {
+		body.Subscriptions = append(body.Subscriptions, Subscription{
+			SubscriberID: s.SubscriberID,
+			Topic:        s.Topic,
+			Lag:          latency(s.Lag),
+			Handler:      latency(s.Handler),
+		})
+	}
+	for _, p := range stats.Publishers {
+		body.Publishers = append(body.Publishers, Publisher{p.PublisherID, p.Labels, p.Published, p.Bytes})
+	}
+	return body, nil
+}
+
+func latency(summary pubsub.LatencySummary) Latency {
+	return Latency{
+		Count: summary.Count,
+		SumUs: summary.Sum.Microseconds(),
+		P50Us: summary.P50.Microseconds(),
+		P95Us: summary.P95.Microseconds(),
+		P99Us: summary.P99.Microseconds(),
+	}
+}
+
+// existingTopic returns the request's topic, resolving aliases, or responds
+// 404 if there is no such topic or it is reserved
+func existingTopic(w http.ResponseWriter, r *http.Request) (string, bool) {
+	topic := pubsub.ResolveTopic(r.PathValue("topic"))
+	if strings.HasPrefix(topic, pubsub.ReservedPrefix) {
+		writeError(w, http.StatusNotFound, pubsub.ErrReservedTopic)
+		return "", false
+	}
+	topics, err := pubsub.ListTopics()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return "", false
+	}
+	if !slices.Contains(topics, topic) {
+		writeError(w, http.StatusNotFound, pubsub.ErrNoTopic)
+		return "", false
+	}
+	return topic, true
+}
+
+// errorStatus is the status of a response to a failed call
+func errorStatus(err error) int {
+	var internal *pubsub.InternalError
+	if errors.As(err, &internal) {
+		return http.StatusInternalServerError
+	}
+	return http.StatusConflict
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, Error{Error: err.Error()})
+}
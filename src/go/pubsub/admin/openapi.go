@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pathParameter matches the wildcards of a route pattern, which are written
+// as OpenAPI path parameters are
+var pathParameter = regexp.MustCompile(`\{(\w+)\}`)
+
+// Spec returns the OpenAPI 3.0 document describing the API as JSON. Its
+// schemas are generated from the Go types of the response bodies, with the
+// names their JSON encoding uses.
+func Spec() []byte {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+
+	for _, rt := range routes {
+		operation := map[string]any{
+			"summary":     rt.summary,
+			"operationId": rt.id,
+		}
+		var parameters []any
+		for _, match := range pathParameter.FindAllStringSubmatch(rt.pattern, -1) {
+			parameters = append(parameters, map[string]any{
+				"name":     match[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		if parameters != nil {
+			operation["parameters"] = parameters
+		}
+
+		responses := map[string]any{}
+		success := map[string]any{"description": http.StatusText(rt.status)}
+		if rt.response != nil {
+			success["content"] = jsonContent(schemaOf(reflect.TypeOf(rt.response), schemas))
+		}
+		responses[strconv.Itoa(rt.status)] = success
+		errorStatuses := []int{http.StatusInternalServerError}
+		if rt.notFound {
+			errorStatuses = append(errorStatuses, http.StatusNotFound, http.StatusConflict)
+		}
+		for _, status := range errorStatuses {
+			responses[strconv.Itoa(status)] = map[string]any{
+				"description": http.StatusText(status),
+				"content":     jsonContent(schemaOf(reflect.TypeOf(Error{}), schemas)),
+			}
+		}
+		operation["responses"] = responses
+
+		if paths[rt.pattern] == nil {
+			paths[rt.pattern] = map[string]any{}
+		}
+		paths[rt.pattern][strings.ToLower(rt.method)] = operation
+	}
+
+	spec, _ := json.MarshalIndent(map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "pubsub admin API",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}, "", "  ")
+	return spec
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the schema of a type's JSON encoding. Named structs are
+// added to schemas and referred to, so each is described once.
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Pointer:
+		schema := schemaOf(t.Elem(), schemas)
+		if _, ok := schema["$ref"]; ok {
+			// A $ref can't have siblings in OpenAPI 3.0
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := schemas[t.Name()]; !ok {
+			// Added before its fields, in case they refer back to it
+			schemas[t.Name()] = nil
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return ref
+	default:
+		return map[string]any{}
+	}
+}
+
+// structSchema describes the fields of a struct as encoding/json encodes them
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" && options == "" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaOf(field.Type, schemas)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,2183 @@
+// Admin service of a pubsub broker. Regenerate adminpb with `make proto`
+// after changing this file.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: admin.proto
+
+package adminpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Delivery mode of a topic, with the values of the core's DELIVERY_* codes
+type DeliveryMode int32
+
+const (
+	DeliveryMode_DELIVERY_MODE_ANY    DeliveryMode = 0
+	DeliveryMode_DELIVERY_MODE_FANOUT DeliveryMode = 1
+	DeliveryMode_DELIVERY_MODE_QUEUE  DeliveryMode = 2
+	DeliveryMode_DELIVERY_MODE_KEYED  DeliveryMode = 3
+)
+
+// Enum value maps for DeliveryMode.
+var (
+	DeliveryMode_name = map[int32]string{
+		0: "DELIVERY_MODE_ANY",
+		1: "DELIVERY_MODE_FANOUT",
+		2: "DELIVERY_MODE_QUEUE",
+		3: "DELIVERY_MODE_KEYED",
+	}
+	DeliveryMode_value = map[string]int32{
+		"DELIVERY_MODE_ANY":    0,
+		"DELIVERY_MODE_FANOUT": 1,
+		"DELIVERY_MODE_QUEUE":  2,
+		"DELIVERY_MODE_KEYED":  3,
+	}
+)
+
+func (x DeliveryMode) Enum() *DeliveryMode {
+	p := new(DeliveryMode)
+	*p = x
+	return p
+}
+
+func (x DeliveryMode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (DeliveryMode) Descriptor() protoreflect.EnumDescriptor {
+	return file_admin_proto_enumTypes[0].Descriptor()
+}
+
+func (DeliveryMode) Type() protoreflect.EnumType {
+	return &file_admin_proto_enumTypes[0]
+}
+
+func (x DeliveryMode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use DeliveryMode.Descriptor instead.
+func (DeliveryMode) EnumDescriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{0}
+}
+
+type ListTopicsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTopicsRequest) Reset() {
+	*x = ListTopicsRequest{}
+	mi := &file_admin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTopicsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTopicsRequest) ProtoMessage() {}
+
+func (x *ListTopicsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTopicsRequest.ProtoReflect.Descriptor instead.
+func (*ListTopicsRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{0}
+}
+
+type ListTopicsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Topics        []*Topic               `protobuf:"bytes,1,rep,name=topics,proto3" json:"topics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTopicsResponse) Reset() {
+	*x = ListTopicsResponse{}
+	mi := &file_admin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTopicsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTopicsResponse) ProtoMessage() {}
+
+func (x *ListTopicsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTopicsResponse.ProtoReflect.Descriptor instead.
+func (*ListTopicsResponse) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ListTopicsResponse) GetTopics() []*Topic {
+	if x != nil {
+		return x.Topics
+	}
+	return nil
+}
+
+type Topic struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Delivery      DeliveryMode           `protobuf:"varint,2,opt,name=delivery,proto3,enum=pubsub.admin.v1.DeliveryMode" json:"delivery,omitempty"`
+	Subscribers   int64                  `protobuf:"varint,3,opt,name=subscribers,proto3" json:"subscribers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Topic) Reset() {
+	*x = Topic{}
+	mi := &file_admin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Topic) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Topic) ProtoMessage() {}
+
+func (x *Topic) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Topic.ProtoReflect.Descriptor instead.
+func (*Topic) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Topic) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Topic) GetDelivery() DeliveryMode {
+	if x != nil {
+		return x.Delivery
+	}
+	return DeliveryMode_DELIVERY_MODE_ANY
+}
+
+func (x *Topic) GetSubscribers() int64 {
+	if x != nil {
+		return x.Subscribers
+	}
+	return 0
+}
+
+type DeleteTopicRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Topic         string                 `protobuf:"bytes,1,opt,name=topic,proto3" json:"topic,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteTopicRequest) Reset() {
+	*x = DeleteTopicRequest{}
+	mi := &file_admin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteTopicRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteTopicRequest) ProtoMessage() {}
+
+func (x *DeleteTopicRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteTopicRequest.ProtoReflect.Descriptor instead.
+func (*DeleteTopicRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *DeleteTopicRequest) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+type WatchTopicsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchTopicsRequest) Reset() {
+	*x = WatchTopicsRequest{}
+	mi := &file_admin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchTopicsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchTopicsRequest) ProtoMessage() {}
+
+func (x *WatchTopicsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchTopicsRequest.ProtoReflect.Descriptor instead.
+func (*WatchTopicsRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{4}
+}
+
+type TopicEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// created, empty, deleted or collected
+	Type          string `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
+	Topic         string `protobuf:"bytes,2,opt,name=topic,proto3" json:"topic,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TopicEvent) Reset() {
+	*x = TopicEvent{}
+	mi := &file_admin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TopicEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TopicEvent) ProtoMessage() {}
+
+func (x *TopicEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TopicEvent.ProtoReflect.Descriptor instead.
+func (*TopicEvent) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *TopicEvent) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *TopicEvent) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+type GetPresenceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Topic         string                 `protobuf:"bytes,1,opt,name=topic,proto3" json:"topic,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPresenceRequest) Reset() {
+	*x = GetPresenceRequest{}
+	mi := &file_admin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPresenceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPresenceRequest) ProtoMessage() {}
+
+func (x *GetPresenceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPresenceRequest.ProtoReflect.Descriptor instead.
+func (*GetPresenceRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetPresenceRequest) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+type GetPresenceResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Subscribers   []*Subscriber          `protobuf:"bytes,1,rep,name=subscribers,proto3" json:"subscribers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetPresenceResponse) Reset() {
+	*x = GetPresenceResponse{}
+	mi := &file_admin_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetPresenceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetPresenceResponse) ProtoMessage() {}
+
+func (x *GetPresenceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetPresenceResponse.ProtoReflect.Descriptor instead.
+func (*GetPresenceResponse) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetPresenceResponse) GetSubscribers() []*Subscriber {
+	if x != nil {
+		return x.Subscribers
+	}
+	return nil
+}
+
+type Subscriber struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	SubscriberId string                 `protobuf:"bytes,1,opt,name=subscriber_id,json=subscriberId,proto3" json:"subscriber_id,omitempty"`
+	// Consumer group the subscriber joined the topic through, if any
+	Group    string                 `protobuf:"bytes,2,opt,name=group,proto3" json:"group,omitempty"`
+	JoinedAt *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=joined_at,json=joinedAt,proto3" json:"joined_at,omitempty"`
+	Labels   map[string]string      `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Messages waiting in the subscriber's queue for the topic
+	QueueDepth    int64 `protobuf:"varint,5,opt,name=queue_depth,json=queueDepth,proto3" json:"queue_depth,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Subscriber) Reset() {
+	*x = Subscriber{}
+	mi := &file_admin_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Subscriber) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Subscriber) ProtoMessage() {}
+
+func (x *Subscriber) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Subscriber.ProtoReflect.Descriptor instead.
+func (*Subscriber) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Subscriber) GetSubscriberId() string {
+	if x != nil {
+		return x.SubscriberId
+	}
+	return ""
+}
+
+func (x *Subscriber) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Subscriber) GetJoinedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.JoinedAt
+	}
+	return nil
+}
+
+func (x *Subscriber) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Subscriber) GetQueueDepth() int64 {
+	if x != nil {
+		return x.QueueDepth
+	}
+	return 0
+}
+
+type ListSubscriptionsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SubscriberId  string                 `protobuf:"bytes,1,opt,name=subscriber_id,json=subscriberId,proto3" json:"subscriber_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSubscriptionsRequest) Reset() {
+	*x = ListSubscriptionsRequest{}
+	mi := &file_admin_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSubscriptionsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSubscriptionsRequest) ProtoMessage() {}
+
+func (x *ListSubscriptionsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSubscriptionsRequest.ProtoReflect.Descriptor instead.
+func (*ListSubscriptionsRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListSubscriptionsRequest) GetSubscriberId() string {
+	if x != nil {
+		return x.SubscriberId
+	}
+	return ""
+}
+
+type ListSubscriptionsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Topics        []string               `protobuf:"bytes,1,rep,name=topics,proto3" json:"topics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSubscriptionsResponse) Reset() {
+	*x = ListSubscriptionsResponse{}
+	mi := &file_admin_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSubscriptionsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSubscriptionsResponse) ProtoMessage() {}
+
+func (x *ListSubscriptionsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSubscriptionsResponse.ProtoReflect.Descriptor instead.
+func (*ListSubscriptionsResponse) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListSubscriptionsResponse) GetTopics() []string {
+	if x != nil {
+		return x.Topics
+	}
+	return nil
+}
+
+type SubscriptionRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SubscriberId  string                 `protobuf:"bytes,1,opt,name=subscriber_id,json=subscriberId,proto3" json:"subscriber_id,omitempty"`
+	Topic         string                 `protobuf:"bytes,2,opt,name=topic,proto3" json:"topic,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscriptionRequest) Reset() {
+	*x = SubscriptionRequest{}
+	mi := &file_admin_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscriptionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscriptionRequest) ProtoMessage() {}
+
+func (x *SubscriptionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscriptionRequest.ProtoReflect.Descriptor instead.
+func (*SubscriptionRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *SubscriptionRequest) GetSubscriberId() string {
+	if x != nil {
+		return x.SubscriberId
+	}
+	return ""
+}
+
+func (x *SubscriptionRequest) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+type EvictRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SubscriberId  string                 `protobuf:"bytes,1,opt,name=subscriber_id,json=subscriberId,proto3" json:"subscriber_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EvictRequest) Reset() {
+	*x = EvictRequest{}
+	mi := &file_admin_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EvictRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EvictRequest) ProtoMessage() {}
+
+func (x *EvictRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EvictRequest.ProtoReflect.Descriptor instead.
+func (*EvictRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *EvictRequest) GetSubscriberId() string {
+	if x != nil {
+		return x.SubscriberId
+	}
+	return ""
+}
+
+type GetStatsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetStatsRequest) Reset() {
+	*x = GetStatsRequest{}
+	mi := &file_admin_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetStatsRequest) ProtoMessage() {}
+
+func (x *GetStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetStatsRequest.ProtoReflect.Descriptor instead.
+func (*GetStatsRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{13}
+}
+
+type WatchStatsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Time between stats, one second if unset. Intervals under 100ms are
+	// raised to 100ms.
+	Interval      *durationpb.Duration `protobuf:"bytes,1,opt,name=interval,proto3" json:"interval,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchStatsRequest) Reset() {
+	*x = WatchStatsRequest{}
+	mi := &file_admin_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchStatsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchStatsRequest) ProtoMessage() {}
+
+func (x *WatchStatsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchStatsRequest.ProtoReflect.Descriptor instead.
+func (*WatchStatsRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *WatchStatsRequest) GetInterval() *durationpb.Duration {
+	if x != nil {
+		return x.Interval
+	}
+	return nil
+}
+
+type Stats struct {
+	state           protoimpl.MessageState `protogen:"open.v1"`
+	Published       uint64                 `protobuf:"varint,1,opt,name=published,proto3" json:"published,omitempty"`
+	Delivered       uint64                 `protobuf:"varint,2,opt,name=delivered,proto3" json:"delivered,omitempty"`
+	Dropped         uint64                 `protobuf:"varint,3,opt,name=dropped,proto3" json:"dropped,omitempty"`
+	Topics          int64                  `protobuf:"varint,4,opt,name=topics,proto3" json:"topics,omitempty"`
+	Subscribers     int64                  `protobuf:"varint,5,opt,name=subscribers,proto3" json:"subscribers,omitempty"`
+	QueueDepth      int64                  `protobuf:"varint,6,opt,name=queue_depth,json=queueDepth,proto3" json:"queue_depth,omitempty"`
+	RetainedBytes   uint64                 `protobuf:"varint,7,opt,name=retained_bytes,json=retainedBytes,proto3" json:"retained_bytes,omitempty"`
+	SpilledBytes    uint64                 `protobuf:"varint,8,opt,name=spilled_bytes,json=spilledBytes,proto3" json:"spilled_bytes,omitempty"`
+	TopicsCollected uint64                 `protobuf:"varint,9,opt,name=topics_collected,json=topicsCollected,proto3" json:"topics_collected,omitempty"`
+	Subscriptions   []*SubscriptionStats   `protobuf:"bytes,10,rep,name=subscriptions,proto3" json:"subscriptions,omitempty"`
+	Publishers      []*PublisherStats      `protobuf:"bytes,11,rep,name=publishers,proto3" json:"publishers,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Stats) Reset() {
+	*x = Stats{}
+	mi := &file_admin_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Stats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Stats) ProtoMessage() {}
+
+func (x *Stats) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Stats.ProtoReflect.Descriptor instead.
+func (*Stats) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *Stats) GetPublished() uint64 {
+	if x != nil {
+		return x.Published
+	}
+	return 0
+}
+
+func (x *Stats) GetDelivered() uint64 {
+	if x != nil {
+		return x.Delivered
+	}
+	return 0
+}
+
+func (x *Stats) GetDropped() uint64 {
+	if x != nil {
+		return x.Dropped
+	}
+	return 0
+}
+
+func (x *Stats) GetTopics() int64 {
+	if x != nil {
+		return x.Topics
+	}
+	return 0
+}
+
+func (x *Stats) GetSubscribers() int64 {
+	if x != nil {
+		return x.Subscribers
+	}
+	return 0
+}
+
+func (x *Stats) GetQueueDepth() int64 {
+	if x != nil {
+		return x.QueueDepth
+	}
+	return 0
+}
+
+func (x *Stats) GetRetainedBytes() uint64 {
+	if x != nil {
+		return x.RetainedBytes
+	}
+	return 0
+}
+
+func (x *Stats) GetSpilledBytes() uint64 {
+	if x != nil {
+		return x.SpilledBytes
+	}
+	return 0
+}
+
+func (x *Stats) GetTopicsCollected() uint64 {
+	if x != nil {
+		return x.TopicsCollected
+	}
+	return 0
+}
+
+func (x *Stats) GetSubscriptions() []*SubscriptionStats {
+	if x != nil {
+		return x.Subscriptions
+	}
+	return nil
+}
+
+func (x *Stats) GetPublishers() []*PublisherStats {
+	if x != nil {
+		return x.Publishers
+	}
+	return nil
+}
+
+// Delivery metrics of a subscriber and topic
+type SubscriptionStats struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	SubscriberId string                 `protobuf:"bytes,1,opt,name=subscriber_id,json=subscriberId,proto3" json:"subscriber_id,omitempty"`
+	Topic        string                 `protobuf:"bytes,2,opt,name=topic,proto3" json:"topic,omitempty"`
+	// Time from publish until delivery
+	Lag *Latency `protobuf:"bytes,3,opt,name=lag,proto3" json:"lag,omitempty"`
+	// Time spent in the subscriber's callback
+	Handler       *Latency `protobuf:"bytes,4,opt,name=handler,proto3" json:"handler,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscriptionStats) Reset() {
+	*x = SubscriptionStats{}
+	mi := &file_admin_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscriptionStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscriptionStats) ProtoMessage() {}
+
+func (x *SubscriptionStats) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscriptionStats.ProtoReflect.Descriptor instead.
+func (*SubscriptionStats) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *SubscriptionStats) GetSubscriberId() string {
+	if x != nil {
+		return x.SubscriberId
+	}
+	return ""
+}
+
+func (x *SubscriptionStats) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *SubscriptionStats) GetLag() *Latency {
+	if x != nil {
+		return x.Lag
+	}
+	return nil
+}
+
+func (x *SubscriptionStats) GetHandler() *Latency {
+	if x != nil {
+		return x.Handler
+	}
+	return nil
+}
+
+// Summary of a latency distribution
+type Latency struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Count         uint64                 `protobuf:"varint,1,opt,name=count,proto3" json:"count,omitempty"`
+	Sum           *durationpb.Duration   `protobuf:"bytes,2,opt,name=sum,proto3" json:"sum,omitempty"`
+	P50           *durationpb.Duration   `protobuf:"bytes,3,opt,name=p50,proto3" json:"p50,omitempty"`
+	P95           *durationpb.Duration   `protobuf:"bytes,4,opt,name=p95,proto3" json:"p95,omitempty"`
+	P99           *durationpb.Duration   `protobuf:"bytes,5,opt,name=p99,proto3" json:"p99,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Latency) Reset() {
+	*x = Latency{}
+	mi := &file_admin_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Latency) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Latency) ProtoMessage() {}
+
+func (x *Latency) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Latency.ProtoReflect.Descriptor instead.
+func (*Latency) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *Latency) GetCount() uint64 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *Latency) GetSum() *durationpb.Duration {
+	if x != nil {
+		return x.Sum
+	}
+	return nil
+}
+
+func (x *Latency) GetP50() *durationpb.Duration {
+	if x != nil {
+		return x.P50
+	}
+	return nil
+}
+
+func (x *Latency) GetP95() *durationpb.Duration {
+	if x != nil {
+		return x.P95
+	}
+	return nil
+}
+
+func (x *Latency) GetP99() *durationpb.Duration {
+	if x != nil {
+		return x.P99
+	}
+	return nil
+}
+
+type PublisherStats struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PublisherId   string                 `protobuf:"bytes,1,opt,name=publisher_id,json=publisherId,proto3" json:"publisher_id,omitempty"`
+	Labels        map[string]string      `protobuf:"bytes,2,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Published     uint64                 `protobuf:"varint,3,opt,name=published,proto3" json:"published,omitempty"`
+	Bytes         uint64                 `protobuf:"varint,4,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PublisherStats) Reset() {
+	*x = PublisherStats{}
+	mi := &file_admin_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PublisherStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PublisherStats) ProtoMessage() {}
+
+func (x *PublisherStats) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PublisherStats.ProtoReflect.Descriptor instead.
+func (*PublisherStats) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *PublisherStats) GetPublisherId() string {
+	if x != nil {
+		return x.PublisherId
+	}
+	return ""
+}
+
+func (x *PublisherStats) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *PublisherStats) GetPublished() uint64 {
+	if x != nil {
+		return x.Published
+	}
+	return 0
+}
+
+func (x *PublisherStats) GetBytes() uint64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+type GetQuotasRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetQuotasRequest) Reset() {
+	*x = GetQuotasRequest{}
+	mi := &file_admin_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetQuotasRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetQuotasRequest) ProtoMessage() {}
+
+func (x *GetQuotasRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetQuotasRequest.ProtoReflect.Descriptor instead.
+func (*GetQuotasRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{19}
+}
+
+type GetQuotasResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Quotas        []*QuotaUsage          `protobuf:"bytes,1,rep,name=quotas,proto3" json:"quotas,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetQuotasResponse) Reset() {
+	*x = GetQuotasResponse{}
+	mi := &file_admin_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetQuotasResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetQuotasResponse) ProtoMessage() {}
+
+func (x *GetQuotasResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetQuotasResponse.ProtoReflect.Descriptor instead.
+func (*GetQuotasResponse) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *GetQuotasResponse) GetQuotas() []*QuotaUsage {
+	if x != nil {
+		return x.Quotas
+	}
+	return nil
+}
+
+type Quota struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Sustained publish rate, with bursts of up to one second's worth
+	MessagesPerSecond float64 `protobuf:"fixed64,1,opt,name=messages_per_second,json=messagesPerSecond,proto3" json:"messages_per_second,omitempty"`
+	BytesPerDay       uint64  `protobuf:"varint,2,opt,name=bytes_per_day,json=bytesPerDay,proto3" json:"bytes_per_day,omitempty"`
+	// Message bytes that may wait in subscriber queues
+	MaxRetainedBytes uint64 `protobuf:"varint,3,opt,name=max_retained_bytes,json=maxRetainedBytes,proto3" json:"max_retained_bytes,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *Quota) Reset() {
+	*x = Quota{}
+	mi := &file_admin_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Quota) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Quota) ProtoMessage() {}
+
+func (x *Quota) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Quota.ProtoReflect.Descriptor instead.
+func (*Quota) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *Quota) GetMessagesPerSecond() float64 {
+	if x != nil {
+		return x.MessagesPerSecond
+	}
+	return 0
+}
+
+func (x *Quota) GetBytesPerDay() uint64 {
+	if x != nil {
+		return x.BytesPerDay
+	}
+	return 0
+}
+
+func (x *Quota) GetMaxRetainedBytes() uint64 {
+	if x != nil {
+		return x.MaxRetainedBytes
+	}
+	return 0
+}
+
+type QuotaUsage struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// namespace or publisher
+	Scope string `protobuf:"bytes,1,opt,name=scope,proto3" json:"scope,omitempty"`
+	// Namespace or publisher ID
+	Name          string `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Quota         *Quota `protobuf:"bytes,3,opt,name=quota,proto3" json:"quota,omitempty"`
+	BytesToday    uint64 `protobuf:"varint,4,opt,name=bytes_today,json=bytesToday,proto3" json:"bytes_today,omitempty"`
+	RetainedBytes uint64 `protobuf:"varint,5,opt,name=retained_bytes,json=retainedBytes,proto3" json:"retained_bytes,omitempty"`
+	// Publishes refused by the quota
+	Rejected      uint64 `protobuf:"varint,6,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QuotaUsage) Reset() {
+	*x = QuotaUsage{}
+	mi := &file_admin_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QuotaUsage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QuotaUsage) ProtoMessage() {}
+
+func (x *QuotaUsage) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QuotaUsage.ProtoReflect.Descriptor instead.
+func (*QuotaUsage) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *QuotaUsage) GetScope() string {
+	if x != nil {
+		return x.Scope
+	}
+	return ""
+}
+
+func (x *QuotaUsage) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *QuotaUsage) GetQuota() *Quota {
+	if x != nil {
+		return x.Quota
+	}
+	return nil
+}
+
+func (x *QuotaUsage) GetBytesToday() uint64 {
+	if x != nil {
+		return x.BytesToday
+	}
+	return 0
+}
+
+func (x *QuotaUsage) GetRetainedBytes() uint64 {
+	if x != nil {
+		return x.RetainedBytes
+	}
+	return 0
+}
+
+func (x *QuotaUsage) GetRejected() uint64 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
+}
+
+type SetQuotaRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Namespace or publisher ID
+	Name          string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Quota         *Quota `protobuf:"bytes,2,opt,name=quota,proto3" json:"quota,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetQuotaRequest) Reset() {
+	*x = SetQuotaRequest{}
+	mi := &file_admin_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetQuotaRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetQuotaRequest) ProtoMessage() {}
+
+func (x *SetQuotaRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetQuotaRequest.ProtoReflect.Descriptor instead.
+func (*SetQuotaRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *SetQuotaRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *SetQuotaRequest) GetQuota() *Quota {
+	if x != nil {
+		return x.Quota
+	}
+	return nil
+}
+
+type ListRoutesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRoutesRequest) Reset() {
+	*x = ListRoutesRequest{}
+	mi := &file_admin_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRoutesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRoutesRequest) ProtoMessage() {}
+
+func (x *ListRoutesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRoutesRequest.ProtoReflect.Descriptor instead.
+func (*ListRoutesRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{24}
+}
+
+type ListRoutesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Aliases       []*TopicAlias          `protobuf:"bytes,1,rep,name=aliases,proto3" json:"aliases,omitempty"`
+	Templates     []*TopicTemplate       `protobuf:"bytes,2,rep,name=templates,proto3" json:"templates,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListRoutesResponse) Reset() {
+	*x = ListRoutesResponse{}
+	mi := &file_admin_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListRoutesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListRoutesResponse) ProtoMessage() {}
+
+func (x *ListRoutesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListRoutesResponse.ProtoReflect.Descriptor instead.
+func (*ListRoutesResponse) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *ListRoutesResponse) GetAliases() []*TopicAlias {
+	if x != nil {
+		return x.Aliases
+	}
+	return nil
+}
+
+func (x *ListRoutesResponse) GetTemplates() []*TopicTemplate {
+	if x != nil {
+		return x.Templates
+	}
+	return nil
+}
+
+type TopicAlias struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Alias         string                 `protobuf:"bytes,1,opt,name=alias,proto3" json:"alias,omitempty"`
+	Topic         string                 `protobuf:"bytes,2,opt,name=topic,proto3" json:"topic,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TopicAlias) Reset() {
+	*x = TopicAlias{}
+	mi := &file_admin_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TopicAlias) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TopicAlias) ProtoMessage() {}
+
+func (x *TopicAlias) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TopicAlias.ProtoReflect.Descriptor instead.
+func (*TopicAlias) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *TopicAlias) GetAlias() string {
+	if x != nil {
+		return x.Alias
+	}
+	return ""
+}
+
+func (x *TopicAlias) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+// Configuration given to topics matching a pattern as they are created. Zero
+// fields leave the setting alone.
+type TopicTemplate struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Pattern        string                 `protobuf:"bytes,1,opt,name=pattern,proto3" json:"pattern,omitempty"`
+	Delivery       DeliveryMode           `protobuf:"varint,2,opt,name=delivery,proto3,enum=pubsub.admin.v1.DeliveryMode" json:"delivery,omitempty"`
+	History        int64                  `protobuf:"varint,3,opt,name=history,proto3" json:"history,omitempty"`
+	CompactionKey  string                 `protobuf:"bytes,4,opt,name=compaction_key,json=compactionKey,proto3" json:"compaction_key,omitempty"`
+	MaxSubscribers int64                  `protobuf:"varint,5,opt,name=max_subscribers,json=maxSubscribers,proto3" json:"max_subscribers,omitempty"`
+	Schema         *SchemaBinding         `protobuf:"bytes,6,opt,name=schema,proto3" json:"schema,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *TopicTemplate) Reset() {
+	*x = TopicTemplate{}
+	mi := &file_admin_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TopicTemplate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TopicTemplate) ProtoMessage() {}
+
+func (x *TopicTemplate) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TopicTemplate.ProtoReflect.Descriptor instead.
+func (*TopicTemplate) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *TopicTemplate) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+func (x *TopicTemplate) GetDelivery() DeliveryMode {
+	if x != nil {
+		return x.Delivery
+	}
+	return DeliveryMode_DELIVERY_MODE_ANY
+}
+
+func (x *TopicTemplate) GetHistory() int64 {
+	if x != nil {
+		return x.History
+	}
+	return 0
+}
+
+func (x *TopicTemplate) GetCompactionKey() string {
+	if x != nil {
+		return x.CompactionKey
+	}
+	return ""
+}
+
+func (x *TopicTemplate) GetMaxSubscribers() int64 {
+	if x != nil {
+		return x.MaxSubscribers
+	}
+	return 0
+}
+
+func (x *TopicTemplate) GetSchema() *SchemaBinding {
+	if x != nil {
+		return x.Schema
+	}
+	return nil
+}
+
+type SchemaBinding struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Subject string                 `protobuf:"bytes,1,opt,name=subject,proto3" json:"subject,omitempty"`
+	// Schema version, or 0 for the latest when the topic is created
+	Version       int64  `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	RejectsTopic  string `protobuf:"bytes,3,opt,name=rejects_topic,json=rejectsTopic,proto3" json:"rejects_topic,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SchemaBinding) Reset() {
+	*x = SchemaBinding{}
+	mi := &file_admin_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SchemaBinding) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SchemaBinding) ProtoMessage() {}
+
+func (x *SchemaBinding) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SchemaBinding.ProtoReflect.Descriptor instead.
+func (*SchemaBinding) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *SchemaBinding) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *SchemaBinding) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *SchemaBinding) GetRejectsTopic() string {
+	if x != nil {
+		return x.RejectsTopic
+	}
+	return ""
+}
+
+type AliasTopicRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Alias         string                 `protobuf:"bytes,1,opt,name=alias,proto3" json:"alias,omitempty"`
+	Topic         string                 `protobuf:"bytes,2,opt,name=topic,proto3" json:"topic,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AliasTopicRequest) Reset() {
+	*x = AliasTopicRequest{}
+	mi := &file_admin_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AliasTopicRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AliasTopicRequest) ProtoMessage() {}
+
+func (x *AliasTopicRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AliasTopicRequest.ProtoReflect.Descriptor instead.
+func (*AliasTopicRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *AliasTopicRequest) GetAlias() string {
+	if x != nil {
+		return x.Alias
+	}
+	return ""
+}
+
+func (x *AliasTopicRequest) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+type RemoveTopicAliasRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Alias         string                 `protobuf:"bytes,1,opt,name=alias,proto3" json:"alias,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveTopicAliasRequest) Reset() {
+	*x = RemoveTopicAliasRequest{}
+	mi := &file_admin_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveTopicAliasRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveTopicAliasRequest) ProtoMessage() {}
+
+func (x *RemoveTopicAliasRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveTopicAliasRequest.ProtoReflect.Descriptor instead.
+func (*RemoveTopicAliasRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *RemoveTopicAliasRequest) GetAlias() string {
+	if x != nil {
+		return x.Alias
+	}
+	return ""
+}
+
+type SetTopicTemplateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Template      *TopicTemplate         `protobuf:"bytes,1,opt,name=template,proto3" json:"template,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetTopicTemplateRequest) Reset() {
+	*x = SetTopicTemplateRequest{}
+	mi := &file_admin_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetTopicTemplateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetTopicTemplateRequest) ProtoMessage() {}
+
+func (x *SetTopicTemplateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetTopicTemplateRequest.ProtoReflect.Descriptor instead.
+func (*SetTopicTemplateRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *SetTopicTemplateRequest) GetTemplate() *TopicTemplate {
+	if x != nil {
+		return x.Template
+	}
+	return nil
+}
+
+type RemoveTopicTemplateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Pattern       string                 `protobuf:"bytes,1,opt,name=pattern,proto3" json:"pattern,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveTopicTemplateRequest) Reset() {
+	*x = RemoveTopicTemplateRequest{}
+	mi := &file_admin_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveTopicTemplateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveTopicTemplateRequest) ProtoMessage() {}
+
+func (x *RemoveTopicTemplateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_admin_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveTopicTemplateRequest.ProtoReflect.Descriptor instead.
+func (*RemoveTopicTemplateRequest) Descriptor() ([]byte, []int) {
+	return file_admin_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *RemoveTopicTemplateRequest) GetPattern() string {
+	if x != nil {
+		return x.Pattern
+	}
+	return ""
+}
+
+var File_admin_proto protoreflect.FileDescriptor
+
+const file_admin_proto_rawDesc = "" +
+	"\n" +
+	"\vadmin.proto\x12\x0fpubsub.admin.v1\x1a\x1egoogle/protobuf/duration.proto\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x13\n" +
+	"\x11ListTopicsRequest\"D\n" +
+	"\x12ListTopicsResponse\x12.\n" +
+	"\x06topics\x18\x01 \x03(\v2\x16.pubsub.admin.v1.TopicR\x06topics\"x\n" +
+	"\x05Topic\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x129\n" +
+	"\bdelivery\x18\x02 \x01(\x0e2\x1d.pubsub.admin.v1.DeliveryModeR\bdelivery\x12 \n" +
+	"\vsubscribers\x18\x03 \x01(\x03R\vsubscribers\"*\n" +
+	"\x12DeleteTopicRequest\x12\x14\n" +
+	"\x05topic\x18\x01 \x01(\tR\x05topic\"\x14\n" +
+	"\x12WatchTopicsRequest\"6\n" +
+	"\n" +
+	"TopicEvent\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x14\n" +
+	"\x05topic\x18\x02 \x01(\tR\x05topic\"*\n" +
+	"\x12GetPresenceRequest\x12\x14\n" +
+	"\x05topic\x18\x01 \x01(\tR\x05topic\"T\n" +
+	"\x13GetPresenceResponse\x12=\n" +
+	"\vsubscribers\x18\x01 \x03(\v2\x1b.pubsub.admin.v1.SubscriberR\vsubscribers\"\x9d\x02\n" +
+	"\n" +
+	"Subscriber\x12#\n" +
+	"\rsubscriber_id\x18\x01 \x01(\tR\fsubscriberId\x12\x14\n" +
+	"\x05group\x18\x02 \x01(\tR\x05group\x127\n" +
+	"\tjoined_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\bjoinedAt\x12?\n" +
+	"\x06labels\x18\x04 \x03(\v2'.pubsub.admin.v1.Subscriber.LabelsEntryR\x06labels\x12\x1f\n" +
+	"\vqueue_depth\x18\x05 \x01(\x03R\n" +
+	"queueDepth\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"?\n" +
+	"\x18ListSubscriptionsRequest\x12#\n" +
+	"\rsubscriber_id\x18\x01 \x01(\tR\fsubscriberId\"3\n" +
+	"\x19ListSubscriptionsResponse\x12\x16\n" +
+	"\x06topics\x18\x01 \x03(\tR\x06topics\"P\n" +
+	"\x13SubscriptionRequest\x12#\n" +
+	"\rsubscriber_id\x18\x01 \x01(\tR\fsubscriberId\x12\x14\n" +
+	"\x05topic\x18\x02 \x01(\tR\x05topic\"3\n" +
+	"\fEvictRequest\x12#\n" +
+	"\rsubscriber_id\x18\x01 \x01(\tR\fsubscriberId\"\x11\n" +
+	"\x0fGetStatsRequest\"J\n" +
+	"\x11WatchStatsRequest\x125\n" +
+	"\binterval\x18\x01 \x01(\v2\x19.google.protobuf.DurationR\binterval\"\xba\x03\n" +
+	"\x05Stats\x12\x1c\n" +
+	"\tpublished\x18\x01 \x01(\x04R\tpublished\x12\x1c\n" +
+	"\tdelivered\x18\x02 \x01(\x04R\tdelivered\x12\x18\n" +
+	"\adropped\x18\x03 \x01(\x04R\adropped\x12\x16\n" +
+	"\x06topics\x18\x04 \x01(\x03R\x06topics\x12 \n" +
+	"\vsubscribers\x18\x05 \x01(\x03R\vsubscribers\x12\x1f\n" +
+	"\vqueue_depth\x18\x06 \x01(\x03R\n" +
+	"queueDepth\x12%\n" +
+	"\x0eretained_bytes\x18\a \x01(\x04R\rretainedBytes\x12#\n" +
+	"\rspilled_bytes\x18\b \x01(\x04R\fspilledBytes\x12)\n" +
+	"\x10topics_collected\x18\t \x01(\x04R\x0ftopicsCollected\x12H\n" +
+	"\rsubscriptions\x18\n" +
+	" \x03(\v2\".pubsub.admin.v1.SubscriptionStatsR\rsubscriptions\x12?\n" +
+	"\n" +
+	"publishers\x18\v \x03(\v2\x1f.pubsub.admin.v1.PublisherStatsR\n" +
+	"publishers\"\xae\x01\n" +
+	"\x11SubscriptionStats\x12#\n" +
+	"\rsubscriber_id\x18\x01 \x01(\tR\fsubscriberId\x12\x14\n" +
+	"\x05topic\x18\x02 \x01(\tR\x05topic\x12*\n" +
+	"\x03lag\x18\x03 \x01(\v2\x18.pubsub.admin.v1.LatencyR\x03lag\x122\n" +
+	"\ahandler\x18\x04 \x01(\v2\x18.pubsub.admin.v1.LatencyR\ahandler\"\xd3\x01\n" +
+	"\aLatency\x12\x14\n" +
+	"\x05count\x18\x01 \x01(\x04R\x05count\x12+\n" +
+	"\x03sum\x18\x02 \x01(\v2\x19.google.protobuf.DurationR\x03sum\x12+\n" +
+	"\x03p50\x18\x03 \x01(\v2\x19.google.protobuf.DurationR\x03p50\x12+\n" +
+	"\x03p95\x18\x04 \x01(\v2\x19.google.protobuf.DurationR\x03p95\x12+\n" +
+	"\x03p99\x18\x05 \x01(\v2\x19.google.protobuf.DurationR\x03p99\"\xe7\x01\n" +
+	"\x0ePublisherStats\x12!\n" +
+	"\fpublisher_id\x18\x01 \x01(\tR\vpublisherId\x12C\n" +
+	"\x06labels\x18\x02 \x03(\v2+.pubsub.admin.v1.PublisherStats.LabelsEntryR\x06labels\x12\x1c\n" +
+	"\tpublished\x18\x03 \x01(\x04R\tpublished\x12\x14\n" +
+	"\x05bytes\x18\x04 \x01(\x04R\x05bytes\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x12\n" +
+	"\x10GetQuotasRequest\"H\n" +
+	"\x11GetQuotasResponse\x123\n" +
+	"\x06quotas\x18\x01 \x03(\v2\x1b.pubsub.admin.v1.QuotaUsageR\x06quotas\"\x89\x01\n" +
+	"\x05Quota\x12.\n" +
+	"\x13messages_per_second\x18\x01 \x01(\x01R\x11messagesPerSecond\x12\"\n" +
+	"\rbytes_per_day\x18\x02 \x01(\x04R\vbytesPerDay\x12,\n" +
+	"\x12max_retained_bytes\x18\x03 \x01(\x04R\x10maxRetainedBytes\"\xc8\x01\n" +
+	"\n" +
+	"QuotaUsage\x12\x14\n" +
+	"\x05scope\x18\x01 \x01(\tR\x05scope\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12,\n" +
+	"\x05quota\x18\x03 \x01(\v2\x16.pubsub.admin.v1.QuotaR\x05quota\x12\x1f\n" +
+	"\vbytes_today\x18\x04 \x01(\x04R\n" +
+	"bytesToday\x12%\n" +
+	"\x0eretained_bytes\x18\x05 \x01(\x04R\rretainedBytes\x12\x1a\n" +
+	"\brejected\x18\x06 \x01(\x04R\brejected\"S\n" +
+	"\x0fSetQuotaRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12,\n" +
+	"\x05quota\x18\x02 \x01(\v2\x16.pubsub.admin.v1.QuotaR\x05quota\"\x13\n" +
+	"\x11ListRoutesRequest\"\x89\x01\n" +
+	"\x12ListRoutesResponse\x125\n" +
+	"\aaliases\x18\x01 \x03(\v2\x1b.pubsub.admin.v1.TopicAliasR\aaliases\x12<\n" +
+	"\ttemplates\x18\x02 \x03(\v2\x1e.pubsub.admin.v1.TopicTemplateR\ttemplates\"8\n" +
+	"\n" +
+	"TopicAlias\x12\x14\n" +
+	"\x05alias\x18\x01 \x01(\tR\x05alias\x12\x14\n" +
+	"\x05topic\x18\x02 \x01(\tR\x05topic\"\x86\x02\n" +
+	"\rTopicTemplate\x12\x18\n" +
+	"\apattern\x18\x01 \x01(\tR\apattern\x129\n" +
+	"\bdelivery\x18\x02 \x01(\x0e2\x1d.pubsub.admin.v1.DeliveryModeR\bdelivery\x12\x18\n" +
+	"\ahistory\x18\x03 \x01(\x03R\ahistory\x12%\n" +
+	"\x0ecompaction_key\x18\x04 \x01(\tR\rcompactionKey\x12'\n" +
+	"\x0fmax_subscribers\x18\x05 \x01(\x03R\x0emaxSubscribers\x126\n" +
+	"\x06schema\x18\x06 \x01(\v2\x1e.pubsub.admin.v1.SchemaBindingR\x06schema\"h\n" +
+	"\rSchemaBinding\x12\x18\n" +
+	"\asubject\x18\x01 \x01(\tR\asubject\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x03R\aversion\x12#\n" +
+	"\rrejects_topic\x18\x03 \x01(\tR\frejectsTopic\"?\n" +
+	"\x11AliasTopicRequest\x12\x14\n" +
+	"\x05alias\x18\x01 \x01(\tR\x05alias\x12\x14\n" +
+	"\x05topic\x18\x02 \x01(\tR\x05topic\"/\n" +
+	"\x17RemoveTopicAliasRequest\x12\x14\n" +
+	"\x05alias\x18\x01 \x01(\tR\x05alias\"U\n" +
+	"\x17SetTopicTemplateRequest\x12:\n" +
+	"\btemplate\x18\x01 \x01(\v2\x1e.pubsub.admin.v1.TopicTemplateR\btemplate\"6\n" +
+	"\x1aRemoveTopicTemplateRequest\x12\x18\n" +
+	"\apattern\x18\x01 \x01(\tR\apattern*q\n" +
+	"\fDeliveryMode\x12\x15\n" +
+	"\x11DELIVERY_MODE_ANY\x10\x00\x12\x18\n" +
+	"\x14DELIVERY_MODE_FANOUT\x10\x01\x12\x17\n" +
+	"\x13DELIVERY_MODE_QUEUE\x10\x02\x12\x17\n" +
+	"\x13DELIVERY_MODE_KEYED\x10\x032\xbf\v\n" +
+	"\x05Admin\x12U\n" +
+	"\n" +
+	"ListTopics\x12\".pubsub.admin.v1.ListTopicsRequest\x1a#.pubsub.admin.v1.ListTopicsResponse\x12J\n" +
+	"\vDeleteTopic\x12#.pubsub.admin.v1.DeleteTopicRequest\x1a\x16.google.protobuf.Empty\x12Q\n" +
+	"\vWatchTopics\x12#.pubsub.admin.v1.WatchTopicsRequest\x1a\x1b.pubsub.admin.v1.TopicEvent0\x01\x12X\n" +
+	"\vGetPresence\x12#.pubsub.admin.v1.GetPresenceRequest\x1a$.pubsub.admin.v1.GetPresenceResponse\x12j\n" +
+	"\x11ListSubscriptions\x12).pubsub.admin.v1.ListSubscriptionsRequest\x1a*.pubsub.admin.v1.ListSubscriptionsResponse\x12E\n" +
+	"\x05Pause\x12$.pubsub.admin.v1.SubscriptionRequest\x1a\x16.google.protobuf.Empty\x12F\n" +
+	"\x06Resume\x12$.pubsub.admin.v1.SubscriptionRequest\x1a\x16.google.protobuf.Empty\x12>\n" +
+	"\x05Evict\x12\x1d.pubsub.admin.v1.EvictRequest\x1a\x16.google.protobuf.Empty\x12D\n" +
+	"\bGetStats\x12 .pubsub.admin.v1.GetStatsRequest\x1a\x16.pubsub.admin.v1.Stats\x12J\n" +
+	"\n" +
+	"WatchStats\x12\".pubsub.admin.v1.WatchStatsRequest\x1a\x16.pubsub.admin.v1.Stats0\x01\x12R\n" +
+	"\tGetQuotas\x12!.pubsub.admin.v1.GetQuotasRequest\x1a\".pubsub.admin.v1.GetQuotasResponse\x12M\n" +
+	"\x11SetNamespaceQuota\x12 .pubsub.admin.v1.SetQuotaRequest\x1a\x16.google.protobuf.Empty\x12M\n" +
+	"\x11SetPublisherQuota\x12 .pubsub.admin.v1.SetQuotaRequest\x1a\x16.google.protobuf.Empty\x12U\n" +
+	"\n" +
+	"ListRoutes\x12\".pubsub.admin.v1.ListRoutesRequest\x1a#.pubsub.admin.v1.ListRoutesResponse\x12H\n" +
+	"\n" +
+	"AliasTopic\x12\".pubsub.admin.v1.AliasTopicRequest\x1a\x16.google.protobuf.Empty\x12T\n" +
+	"\x10RemoveTopicAlias\x12(.pubsub.admin.v1.RemoveTopicAliasRequest\x1a\x16.google.protobuf.Empty\x12T\n" +
+	"\x10SetTopicTemplate\x12(.pubsub.admin.v1.SetTopicTemplateRequest\x1a\x16.google.protobuf.Empty\x12Z\n" +
+	"\x13RemoveTopicTemplate\x12+.pubsub.admin.v1.RemoveTopicTemplateRequest\x1a\x16.google.protobuf.EmptyB=Z;github.com/jbrinkman/go-rust-ffi/go/pubsub/adminrpc/adminpbb\x06proto3"
+
+var (
+	file_admin_proto_rawDescOnce sync.Once
+	file_admin_proto_rawDescData []byte
+)
+
+func file_admin_proto_rawDescGZIP() []byte {
+	file_admin_proto_rawDescOnce.Do(func() {
+		file_admin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_admin_proto_rawDesc), len(file_admin_proto_rawDesc)))
+	})
+	return file_admin_proto_rawDescData
+}
+
+var file_admin_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 35)
+var file_admin_proto_goTypes = []any{
+	(DeliveryMode)(0),                  // 0: pubsub.admin.v1.DeliveryMode
+	(*ListTopicsRequest)(nil),          // 1: pubsub.admin.v1.ListTopicsRequest
+	(*ListTopicsResponse)(nil),         // 2: pubsub.admin.v1.ListTopicsResponse
+	(*Topic)(nil),                      // 3: pubsub.admin.v1.Topic
+	(*DeleteTopicRequest)(nil),         // 4: pubsub.admin.v1.DeleteTopicRequest
+	(*WatchTopicsRequest)(nil),         // 5: pubsub.admin.v1.WatchTopicsRequest
+	(*TopicEvent)(nil),                 // 6: pubsub.admin.v1.TopicEvent
+	(*GetPresenceRequest)(nil),         // 7: pubsub.admin.v1.GetPresenceRequest
+	(*GetPresenceResponse)(nil),        // 8: pubsub.admin.v1.GetPresenceResponse
+	(*Subscriber)(nil),                 // 9: pubsub.admin.v1.Subscriber
+	(*ListSubscriptionsRequest)(nil),   // 10: pubsub.admin.v1.ListSubscriptionsRequest
+	(*ListSubscriptionsResponse)(nil),  // 11: pubsub.admin.v1.ListSubscriptionsResponse
+	(*SubscriptionRequest)(nil),        // 12: pubsub.admin.v1.SubscriptionRequest
+	(*EvictRequest)(nil),               // 13: pubsub.admin.v1.EvictRequest
+	(*GetStatsRequest)(nil),            // 14: pubsub.admin.v1.GetStatsRequest
+	(*WatchStatsRequest)(nil),          // 15: pubsub.admin.v1.WatchStatsRequest
+	(*Stats)(nil),                      // 16: pubsub.admin.v1.Stats
+	(*SubscriptionStats)(nil),          // 17: pubsub.admin.v1.SubscriptionStats
+	(*Latency)(nil),                    // 18: pubsub.admin.v1.Latency
+	(*PublisherStats)(nil),             // 19: pubsub.admin.v1.PublisherStats
+	(*GetQuotasRequest)(nil),           // 20: pubsub.admin.v1.GetQuotasRequest
+	(*GetQuotasResponse)(nil),          // 21: pubsub.admin.v1.GetQuotasResponse
+	(*Quota)(nil),                      // 22: pubsub.admin.v1.Quota
+	(*QuotaUsage)(nil),                 // 23: pubsub.admin.v1.QuotaUsage
+	(*SetQuotaRequest)(nil),            // 24: pubsub.admin.v1.SetQuotaRequest
+	(*ListRoutesRequest)(nil),          // 25: pubsub.admin.v1.ListRoutesRequest
+	(*ListRoutesResponse)(nil),         // 26: pubsub.admin.v1.ListRoutesResponse
+	(*TopicAlias)(nil),                 // 27: pubsub.admin.v1.TopicAlias
+	(*TopicTemplate)(nil),              // 28: pubsub.admin.v1.TopicTemplate
+	(*SchemaBinding)(nil),              // 29: pubsub.admin.v1.SchemaBinding
+	(*AliasTopicRequest)(nil),          // 30: pubsub.admin.v1.AliasTopicRequest
+	(*RemoveTopicAliasRequest)(nil),    // 31: pubsub.admin.v1.RemoveTopicAliasRequest
+	(*SetTopicTemplateRequest)(nil),    // 32: pubsub.admin.v1.SetTopicTemplateRequest
+	(*RemoveTopicTemplateRequest)(nil), // 33: pubsub.admin.v1.RemoveTopicTemplateRequest
+	nil,                                // 34: pubsub.admin.v1.Subscriber.LabelsEntry
+	nil,                                // 35: pubsub.admin.v1.PublisherStats.LabelsEntry
+	(*timestamppb.Timestamp)(nil),      // 36: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),        // 37: google.protobuf.Duration
+	(*emptypb.Empty)(nil),              // 38: google.protobuf.Empty
+}
+var file_admin_proto_depIdxs = []int32{
+	3,  // 0: pubsub.admin.v1.ListTopicsResponse.topics:type_name -> pubsub.admin.v1.Topic
+	0,  // 1: pubsub.admin.v1.Topic.delivery:type_name -> pubsub.admin.v1.DeliveryMode
+	9,  // 2: pubsub.admin.v1.GetPresenceResponse.subscribers:type_name -> pubsub.admin.v1.Subscriber
+	36, // 3: pubsub.admin.v1.Subscriber.joined_at:type_name -> google.protobuf.Timestamp
+	34, // 4: pubsub.admin.v1.Subscriber.labels:type_name -> pubsub.admin.v1.Subscriber.LabelsEntry
+	37, // 5: pubsub.admin.v1.WatchStatsRequest.interval:type_name -> google.protobuf.Duration
+	17, // 6: pubsub.admin.v1.Stats.subscriptions:type_name -> pubsub.admin.v1.SubscriptionStats
+	19, // 7: pubsub.admin.v1.Stats.publishers:type_name -> pubsub.admin.v1.PublisherStats
+	18, // 8: pubsub.admin.v1.SubscriptionStats.lag:type_name -> pubsub.admin.v1.Latency
+	18, // 9: pubsub.admin.v1.SubscriptionStats.handler:type_name -> pubsub.admin.v1.Latency
+	37, // 10: pubsub.admin.v1.Latency.sum:type_name -> google.protobuf.Duration
+	37, // 11: pubsub.admin.v1.Latency.p50:type_name -> google.protobuf.Duration
+	37, // 12: pubsub.admin.v1.Latency.p95:type_name -> google.protobuf.Duration
+	37, // 13: pubsub.admin.v1.Latency.p99:type_name -> google.protobuf.Duration
+	35, // 14: pubsub.admin.v1.PublisherStats.labels:type_name -> pubsub.admin.v1.PublisherStats.LabelsEntry
+	23, // 15: pubsub.admin.v1.GetQuotasResponse.quotas:type_name -> pubsub.admin.v1.QuotaUsage
+	22, // 16: pubsub.admin.v1.QuotaUsage.quota:type_name -> pubsub.admin.v1.Quota
+	22, // 17: pubsub.admin.v1.SetQuotaRequest.quota:type_name -> pubsub.admin.v1.Quota
+	27, // 18: pubsub.admin.v1.ListRoutesResponse.aliases:type_name -> pubsub.admin.v1.TopicAlias
+	28, // 19: pubsub.admin.v1.ListRoutesResponse.templates:type_name -> pubsub.admin.v1.TopicTemplate
+	0,  // 20: pubsub.admin.v1.TopicTemplate.delivery:type_name -> pubsub.admin.v1.DeliveryMode
+	29, // 21: pubsub.admin.v1.TopicTemplate.schema:type_name -> pubsub.admin.v1.SchemaBinding
+	28, // 22: pubsub.admin.v1.SetTopicTemplateRequest.template:type_name -> pubsub.admin.v1.TopicTemplate
+	1,  // 23: pubsub.admin.v1.Admin.ListTopics:input_type -> pubsub.admin.v1.ListTopicsRequest
+	4,  // 24: pubsub.admin.v1.Admin.DeleteTopic:input_type -> pubsub.admin.v1.DeleteTopicRequest
+	5,  // 25: pubsub.admin.v1.Admin.WatchTopics:input_type -> pubsub.admin.v1.WatchTopicsRequest
+	7,  // 26: pubsub.admin.v1.Admin.GetPresence:input_type -> pubsub.admin.v1.GetPresenceRequest
+	10, // 27: pubsub.admin.v1.Admin.ListSubscriptions:input_type -> pubsub.admin.v1.ListSubscriptionsRequest
+	12, // 28: pubsub.admin.v1.Admin.Pause:input_type -> pubsub.admin.v1.SubscriptionRequest
+	12, // 29: pubsub.admin.v1.Admin.Resume:input_type -> pubsub.admin.v1.SubscriptionRequest
+	13, // 30: pubsub.admin.v1.Admin.Evict:input_type -> pubsub.admin.v1.EvictRequest
+	14, // 31: pubsub.admin.v1.Admin.GetStats:input_type -> pubsub.admin.v1.GetStatsRequest
+	15, // 32: pubsub.admin.v1.Admin.WatchStats:input_type -> pubsub.admin.v1.WatchStatsRequest
+	20, // 33: pubsub.admin.v1.Admin.GetQuotas:input_type -> pubsub.admin.v1.GetQuotasRequest
+	24, // 34: pubsub.admin.v1.Admin.SetNamespaceQuota:input_type -> pubsub.admin.v1.SetQuotaRequest
+	24, // 35: pubsub.admin.v1.Admin.SetPublisherQuota:input_type -> pubsub.admin.v1.SetQuotaRequest
+	25, // 36: pubsub.admin.v1.Admin.ListRoutes:input_type -> pubsub.admin.v1.ListRoutesRequest
+	30, // 37: pubsub.admin.v1.Admin.AliasTopic:input_type -> pubsub.admin.v1.AliasTopicRequest
+	31, // 38: pubsub.admin.v1.Admin.RemoveTopicAlias:input_type -> pubsub.admin.v1.RemoveTopicAliasRequest
+	32, // 39: pubsub.admin.v1.Admin.SetTopicTemplate:input_type -> pubsub.admin.v1.SetTopicTemplateRequest
+	33, // 40: pubsub.admin.v1.Admin.RemoveTopicTemplate:input_type -> pubsub.admin.v1.RemoveTopicTemplateRequest
+	2,  // 41: pubsub.admin.v1.Admin.ListTopics:output_type -> pubsub.admin.v1.ListTopicsResponse
+	38, // 42: pubsub.admin.v1.Admin.DeleteTopic:output_type -> google.protobuf.Empty
+	6,  // 43: pubsub.admin.v1.Admin.WatchTopics:output_type -> pubsub.admin.v1.TopicEvent
+	8,  // 44: pubsub.admin.v1.Admin.GetPresence:output_type -> pubsub.admin.v1.GetPresenceResponse
+	11, // 45: pubsub.admin.v1.Admin.ListSubscriptions:output_type -> pubsub.admin.v1.ListSubscriptionsResponse
+	38, // 46: pubsub.admin.v1.Admin.Pause:output_type -> google.protobuf.Empty
+	38, // 47: pubsub.admin.v1.Admin.Resume:output_type -> google.protobuf.Empty
+	38, // 48: pubsub.admin.v1.Admin.Evict:output_type -> google.protobuf.Empty
+	16, // 49: pubsub.admin.v1.Admin.GetStats:output_type -> pubsub.admin.v1.Stats
+	16, // 50: pubsub.admin.v1.Admin.WatchStats:output_type -> pubsub.admin.v1.Stats
+	21, // 51: pubsub.admin.v1.Admin.GetQuotas:output_type -> pubsub.admin.v1.GetQuotasResponse
+	38, // 52: pubsub.admin.v1.Admin.SetNamespaceQuota:output_type -> google.protobuf.Empty
+	38, // 53: pubsub.admin.v1.Admin.SetPublisherQuota:output_type -> google.protobuf.Empty
+	26, // 54: pubsub.admin.v1.Admin.ListRoutes:output_type -> pubsub.admin.v1.ListRoutesResponse
+	38, // 55: pubsub.admin.v1.Admin.AliasTopic:output_type -> google.protobuf.Empty
+	38, // 56: pubsub.admin.v1.Admin.RemoveTopicAlias:output_type -> google.protobuf.Empty
+	38, // 57: pubsub.admin.v1.Admin.SetTopicTemplate:output_type -> google.protobuf.Empty
+	38, // 58: pubsub.admin.v1.Admin.RemoveTopicTemplate:output_type -> google.protobuf.Empty
+	41, // [41:59] is the sub-list for method output_type
+	23, // [23:41] is the sub-list for method input_type
+	23, // [23:23] is the sub-list for extension type_name
+	23, // [23:23] is the sub-list for extension extendee
+	0,  // [0:23] is the sub-list for field type_name
+}
+
+func init() { file_admin_proto_init() }
+func file_admin_proto_init() {
+	if File_admin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_admin_proto_rawDesc), len(file_admin_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   35,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_admin_proto_goTypes,
+		DependencyIndexes: file_admin_proto_depIdxs,
+		EnumInfos:         file_admin_proto_enumTypes,
+		MessageInfos:      file_admin_proto_msgTypes,
+	}.Build()
+	File_admin_proto = out.File
+	file_admin_proto_goTypes = nil
+	file_admin_proto_depIdxs = nil
+}
@@ -0,0 +1,110 @@
+// Package adminrpc serves a gRPC service for administering the broker from
+// outside its process, so that external tooling and dashboards needn't run in
+// it. The service, described by admin.proto, lists and deletes topics, lists,
+// pauses, resumes and evicts subscribers, reports and streams stats, and
+// manages quotas, topic aliases and topic templates.
+//
+// The package is a module of its own, so that programs that don't serve the
+// service don't take the gRPC dependencies. Register adds the service to a
+// server the application owns, which chooses the listener, TLS settings and
+// other services:
+//
+//	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+//	err := adminrpc.Register(server,
+//		adminrpc.WithTokens(adminrpc.Token{Name: "ops", Secret: secret, Role: adminrpc.RoleAdmin}),
+//		adminrpc.WithAuditSink(pubsub.NewSlogAuditSink(logger)))
+//
+// Every call is authenticated before its handler runs, with a bearer token or
+// a client certificate. There is no way to serve the service without either.
+package adminrpc
+
+import (
+	"crypto/x509"
+	"errors"
+
+	"google.golang.org/grpc"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/adminrpc/adminpb"
+)
+
+// Role is what a caller may do
+type Role int
+
+const (
+	// RoleRead may call the List, Get and Watch RPCs
+	RoleRead Role = iota + 1
+	// RoleAdmin may call every RPC
+	RoleAdmin
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleRead:
+		return "read"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// Token is a bearer token callers present as "authorization: Bearer <secret>"
+// metadata
+type Token struct {
+	// Name identifies the token's holder in the audit stream
+	Name   string
+	Secret string
+	Role   Role
+}
+
+// Option configures the service
+type Option func(*service)
+
+// WithTokens accepts calls with one of the tokens
+func WithTokens(tokens ...Token) Option {
+	return func(s *service) {
+		s.tokens = append(s.tokens, tokens...)
+	}
+}
+
+// WithClientCertificates accepts calls over mTLS connections whose verified
+// client certificate roleOf maps to a role. A certificate it returns an error
+// for is refused. The server's TLS configuration must verify client
+// certificates for there to be any.
+func WithClientCertificates(roleOf func(cert *x509.Certificate) (Role, error)) Option {
+	return func(s *service) {
+		s.roleOf = roleOf
+	}
+}
+
+// WithAuditSink records an event to the sink for every call that changes the
+// broker, naming the caller's credential. The functions the service calls
+// record their own events as usual, to the sink set with
+// pubsub.SetAuditSink, but those can only name the handler as the caller.
+func WithAuditSink(sink pubsub.AuditSink) Option {
+	return func(s *service) {
+		s.audit = sink
+	}
+}
+
+// Register adds the admin service to a server. It fails unless an option
+// gives a way to authenticate callers, so the service is never served open
+// by accident.
+func Register(server grpc.ServiceRegistrar, opts ...Option) error {
+	s := &service{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if len(s.tokens) == 0 && s.roleOf == nil {
+		return errors.New("failed to register admin service: no tokens or client certificates to authenticate callers with")
+	}
+	for _, token := range s.tokens {
+		if token.Secret == "" || (token.Role != RoleRead && token.Role != RoleAdmin) {
+			return errors.New("failed to register admin service: tokens need a secret and a role")
+		}
+	}
+
+	server.RegisterService(s.guard(&adminpb.Admin_ServiceDesc), s)
+	return nil
+}
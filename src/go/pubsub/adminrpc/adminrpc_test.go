@@ -0,0 +1,112 @@
+package adminrpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/adminrpc/adminpb"
+)
+
+// serve registers the service on an in-memory server and returns a client
+func serve(t *testing.T, opts ...Option) adminpb.AdminClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	if err := Register(server, opts...); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return adminpb.NewAdminClient(conn)
+}
+
+func withToken(secret string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+secret)
+}
+
+func TestRegisterNeedsCredentials(t *testing.T) {
+	if err := Register(grpc.NewServer()); err == nil {
+		t.Fatal("registered a service without a way to authenticate callers")
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	var events []pubsub.AuditEvent
+	client := serve(t,
+		WithTokens(Token{Name: "dashboard", Secret: "read-secret", Role: RoleRead}, Token{Name: "ops", Secret: "admin-secret", Role: RoleAdmin}),
+		WithAuditSink(pubsub.AuditSinkFunc(func(event pubsub.AuditEvent) { events = append(events, event) })))
+
+	if _, err := client.ListTopics(context.Background(), &adminpb.ListTopicsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("call without a token returned %v, want UNAUTHENTICATED", err)
+	}
+	if _, err := client.ListTopics(withToken("wrong"), &adminpb.ListTopicsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("call with an unknown token returned %v, want UNAUTHENTICATED", err)
+	}
+	if _, err := client.ListTopics(withToken("read-secret"), &adminpb.ListTopicsRequest{}); err != nil {
+		t.Fatalf("read call with the read token: %v", err)
+	}
+	alias := &adminpb.AliasTopicRequest{Alias: "adminrpc-test/alias", Topic: "adminrpc-test/topic"}
+	if _, err := client.AliasTopic(withToken("read-secret"), alias); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("admin call with the read token returned %v, want PERMISSION_DENIED", err)
+	}
+
+	if _, err := client.AliasTopic(withToken("admin-secret"), alias); err != nil {
+		t.Fatal(err)
+	}
+	defer pubsub.RemoveTopicAlias(alias.Alias)
+	if len(events) != 1 || events[0].Details["credential"] != "ops" || events[0].Caller != adminpb.Admin_AliasTopic_FullMethodName {
+		t.Fatalf("got audit events %+v, want one naming the ops token", events)
+	}
+
+	// Streams are authenticated too
+	stream, err := client.WatchTopics(context.Background(), &adminpb.WatchTopicsRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("stream without a token returned %v, want UNAUTHENTICATED", err)
+	}
+}
+
+func TestRoutesAndErrors(t *testing.T) {
+	client := serve(t, WithTokens(Token{Name: "ops", Secret: "secret", Role: RoleAdmin}))
+	ctx := withToken("secret")
+
+	if _, err := client.DeleteTopic(ctx, &adminpb.DeleteTopicRequest{Topic: "adminrpc-test/missing"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("deleting a missing topic returned %v, want NOT_FOUND", err)
+	}
+	if _, err := client.Pause(ctx, &adminpb.SubscriptionRequest{SubscriberId: "$internal", Topic: "t"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("pausing a reserved subscriber returned %v, want INVALID_ARGUMENT", err)
+	}
+
+	template := &adminpb.TopicTemplate{Pattern: "adminrpc-test/*/events", Delivery: adminpb.DeliveryMode_DELIVERY_MODE_QUEUE, History: 10}
+	if _, err := client.SetTopicTemplate(ctx, &adminpb.SetTopicTemplateRequest{Template: template}); err != nil {
+		t.Fatal(err)
+	}
+	defer pubsub.RemoveTopicTemplate(template.Pattern)
+
+	routes, err := client.ListRoutes(ctx, &adminpb.ListRoutesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes.Templates) != 1 || routes.Templates[0].Delivery != template.Delivery || routes.Templates[0].History != 10 {
+		t.Fatalf("got templates %v, want %v", routes.Templates, template)
+	}
+}
@@ -0,0 +1,167 @@
+package adminrpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// AuditOperation is the operation of the events recorded to the sink given
+// with WithAuditSink
+const AuditOperation = "adminrpc.call"
+
+// caller is an authenticated credential
+type caller struct {
+	// name is the token's name or the certificate's subject
+	name string
+	role Role
+}
+
+// guard returns a copy of the service description whose handlers
+// authenticate and authorize each call before running, and audit the calls
+// that change the broker. Wrapping the handlers rather than installing
+// interceptors keeps the checks in place whatever interceptors the
+// application gives its server.
+func (s *service) guard(desc *grpc.ServiceDesc) *grpc.ServiceDesc {
+	guarded := *desc
+	guarded.Methods = make([]grpc.MethodDesc, len(desc.Methods))
+	for i, method := range desc.Methods {
+		handler := method.Handler
+		method.Handler = func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			return handler(srv, ctx, dec, func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+				checked := func(ctx context.Context, req any) (any, error) {
+					return s.call(ctx, info.FullMethod, req, next)
+				}
+				if interceptor == nil {
+					return checked(ctx, req)
+				}
+				return interceptor(ctx, req, info, checked)
+			})
+		}
+		guarded.Methods[i] = method
+	}
+
+	guarded.Streams = make([]grpc.StreamDesc, len(desc.Streams))
+	for i, stream := range desc.Streams {
+		handler, name := stream.Handler, "/"+desc.ServiceName+"/"+stream.StreamName
+		stream.Handler = func(srv any, ss grpc.ServerStream) error {
+			if _, err := s.authorize(ss.Context(), name); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}
+		guarded.Streams[i] = stream
+	}
+	return &guarded
+}
+
+// call runs a unary call once its caller is authorized, auditing it if it
+// changes the broker
+func (s *service) call(ctx context.Context, method string, req any, next grpc.UnaryHandler) (any, error) {
+	who, err := s.authorize(ctx, method)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := next(ctx, req)
+	if s.audit != nil && !readOnly(method) {
+		s.audit.Audit(auditEvent(who, method, req, err))
+	}
+	return resp, err
+}
+
+// authorize authenticates the caller of a method and checks its role allows
+// the method
+func (s *service) authorize(ctx context.Context, method string) (caller, error) {
+	who, err := s.authenticate(ctx)
+	if err != nil {
+		return caller{}, err
+	}
+	if who.role != RoleAdmin && !(who.role == RoleRead && readOnly(method)) {
+		return caller{}, status.Errorf(codes.PermissionDenied, "%s may not call %s with role %s", who.name, method, who.role)
+	}
+	return who, nil
+}
+
+// authenticate identifies the caller by its bearer token, or failing that by
+// its verified client certificate
+func (s *service) authenticate(ctx context.Context) (caller, error) {
+	if secret, ok := bearerToken(ctx); ok && len(s.tokens) > 0 {
+		for _, token := range s.tokens {
+			if subtle.ConstantTimeCompare([]byte(secret), []byte(token.Secret)) == 1 {
+				return caller{name: token.Name, role: token.Role}, nil
+			}
+		}
+		return caller{}, status.Error(codes.Unauthenticated, "unknown token")
+	}
+
+	if s.roleOf != nil {
+		if p, ok := peer.FromContext(ctx); ok {
+			if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+				cert := info.State.VerifiedChains[0][0]
+				role, err := s.roleOf(cert)
+				if err != nil {
+					return caller{}, status.Errorf(codes.PermissionDenied, "certificate of %s refused: %v", cert.Subject, err)
+				}
+				return caller{name: cert.Subject.String(), role: role}, nil
+			}
+		}
+	}
+
+	return caller{}, status.Error(codes.Unauthenticated, "no credentials")
+}
+
+// bearerToken returns the secret of the call's authorization metadata
+func bearerToken(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	for _, value := range md.Get("authorization") {
+		if scheme, secret, ok := strings.Cut(value, " "); ok && strings.EqualFold(scheme, "bearer") {
+			return secret, true
+		}
+	}
+	return "", false
+}
+
+// readOnly reports whether a method only reads the broker, which callers
+// with RoleRead may call
+func readOnly(method string) bool {
+	name := method[strings.LastIndex(method, "/")+1:]
+	for _, prefix := range []string{"List", "Get", "Watch"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditEvent records a call that changed the broker or failed to
+func auditEvent(who caller, method string, req any, err error) pubsub.AuditEvent {
+	event := pubsub.AuditEvent{
+		Time:      time.Now(),
+		Operation: AuditOperation,
+		Caller:    method,
+		Details:   map[string]string{"credential": who.name, "role": who.role.String()},
+	}
+	if r, ok := req.(interface{ GetTopic() string }); ok {
+		event.Topic = r.GetTopic()
+	}
+	if r, ok := req.(interface{ GetSubscriberId() string }); ok {
+		event.SubscriberID = r.GetSubscriberId()
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return event
+}
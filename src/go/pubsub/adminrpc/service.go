@@ -0,0 +1,383 @@
+package adminrpc
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/adminrpc/adminpb"
+)
+
+const (
+	// defaultStatsInterval is the interval of WatchStats when the request
+	// gives none, and minStatsInterval the shortest it may give
+	defaultStatsInterval = time.Second
+	minStatsInterval     = 100 * time.Millisecond
+)
+
+// service implements the Admin service over the pubsub package
+type service struct {
+	adminpb.UnimplementedAdminServer
+
+	tokens []Token
+	roleOf func(cert *x509.Certificate) (Role, error)
+	audit  pubsub.AuditSink
+}
+
+func (s *service) ListTopics(ctx context.Context, req *adminpb.ListTopicsRequest) (*adminpb.ListTopicsResponse, error) {
+	names, err := pubsub.ListTopics()
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	resp := &adminpb.ListTopicsResponse{}
+	for _, name := range names {
+		if strings.HasPrefix(name, pubsub.ReservedPrefix) {
+			continue
+		}
+		subscribers, err := pubsub.Presence(name)
+		if err != nil {
+			// Deleted since it was listed
+			continue
+		}
+		resp.Topics = append(resp.Topics, &adminpb.Topic{
+			Name:        name,
+			Delivery:    adminpb.DeliveryMode(pubsub.TopicDeliveryMode(name)),
+			Subscribers: int64(len(subscribers)),
+		})
+	}
+	return resp, nil
+}
+
+func (s *service) DeleteTopic(ctx context.Context, req *adminpb.DeleteTopicRequest) (*emptypb.Empty, error) {
+	topic, err := existingTopic(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	if err := pubsub.DeleteTopic(topic); err != nil {
+		return nil, statusOf(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) WatchTopics(req *adminpb.WatchTopicsRequest, stream grpc.ServerStreamingServer[adminpb.TopicEvent]) error {
+	events, err := pubsub.WatchTopics(stream.Context())
+	if err != nil {
+		return statusOf(err)
+	}
+	for event := range events {
+		if err := stream.Send(&adminpb.TopicEvent{Type: string(event.Type), Topic: event.Topic}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) GetPresence(ctx context.Context, req *adminpb.GetPresenceRequest) (*adminpb.GetPresenceResponse, error) {
+	topic, err := existingTopic(req.Topic)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := pubsub.Presence(topic)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	resp := &adminpb.GetPresenceResponse{}
+	for _, info := range infos {
+		resp.Subscribers = append(resp.Subscribers, &adminpb.Subscriber{
+			SubscriberId: info.SubscriberID,
+			Group:        info.Group,
+			JoinedAt:     timestamppb.New(info.JoinedAt),
+			Labels:       info.Labels,
+			QueueDepth:   int64(pubsub.QueueDepth(info.SubscriberID, topic)),
+		})
+	}
+	return resp, nil
+}
+
+func (s *service) ListSubscriptions(ctx context.Context, req *adminpb.ListSubscriptionsRequest) (*adminpb.ListSubscriptionsResponse, error) {
+	topics, err := pubsub.ListSubscriptions(req.SubscriberId)
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return &adminpb.ListSubscriptionsResponse{Topics: topics}, nil
+}
+
+func (s *service) Pause(ctx context.Context, req *adminpb.SubscriptionRequest) (*emptypb.Empty, error) {
+	if err := pubsub.Pause(req.SubscriberId, req.Topic); err != nil {
+		return nil, statusOf(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) Resume(ctx context.Context, req *adminpb.SubscriptionRequest) (*emptypb.Empty, error) {
+	if err := pubsub.Resume(req.SubscriberId, req.Topic); err != nil {
+		return nil, statusOf(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) Evict(ctx context.Context, req *adminpb.EvictRequest) (*emptypb.Empty, error) {
+	if err := pubsub.Unsubscribe(req.SubscriberId, ""); err != nil {
+		return nil, statusOf(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) GetStats(ctx context.Context, req *adminpb.GetStatsRequest) (*adminpb.Stats, error) {
+	stats, err := pubsub.Stats()
+	if err != nil {
+		return nil, statusOf(err)
+	}
+	return statsMessage(stats), nil
+}
+
+func (s *service) WatchStats(req *adminpb.WatchStatsRequest, stream grpc.ServerStreamingServer[adminpb.Stats]) error {
+	interval := defaultStatsInterval
+	if req.Interval != nil {
+		interval = max(req.Interval.AsDuration(), minStatsInterval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		stats, err := pubsub.Stats()
+		if err != nil {
+			return statusOf(err)
+		}
+		if err := stream.Send(statsMessage(stats)); err != nil {
+			return err
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *service) GetQuotas(ctx context.Context, req *adminpb.GetQuotasRequest) (*adminpb.GetQuotasResponse, error) {
+	stats, err := pubsub.Stats()
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	resp := &adminpb.GetQuotasResponse{}
+	for _, usage := range stats.Quotas {
+		resp.Quotas = append(resp.Quotas, &adminpb.QuotaUsage{
+			Scope: string(usage.Scope),
+			Name:  usage.Name,
+			Quota: &adminpb.Quota{
+				MessagesPerSecond: usage.Quota.MessagesPerSecond,
+				BytesPerDay:       usage.Quota.BytesPerDay,
+				MaxRetainedBytes:  usage.Quota.MaxRetainedBytes,
+			},
+			BytesToday:    usage.BytesToday,
+			RetainedBytes: usage.RetainedBytes,
+			Rejected:      usage.Rejected,
+		})
+	}
+	return resp, nil
+}
+
+func (s *service) SetNamespaceQuota(ctx context.Context, req *adminpb.SetQuotaRequest) (*emptypb.Empty, error) {
+	if err := pubsub.SetNamespaceQuota(req.Name, quota(req.Quota)); err != nil {
+		return nil, statusOf(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) SetPublisherQuota(ctx context.Context, req *adminpb.SetQuotaRequest) (*emptypb.Empty, error) {
+	if err := pubsub.SetPublisherQuota(req.Name, quota(req.Quota)); err != nil {
+		return nil, statusOf(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) ListRoutes(ctx context.Context, req *adminpb.ListRoutesRequest) (*adminpb.ListRoutesResponse, error) {
+	templates, err := pubsub.TopicTemplates()
+	if err != nil {
+		return nil, statusOf(err)
+	}
+
+	resp := &adminpb.ListRoutesResponse{}
+	for alias, topic := range pubsub.TopicAliases() {
+		resp.Aliases = append(resp.Aliases, &adminpb.TopicAlias{Alias: alias, Topic: topic})
+	}
+	sort.Slice(resp.Aliases, func(i, j int) bool { return resp.Aliases[i].Alias < resp.Aliases[j].Alias })
+
+	for pattern, config := range templates {
+		template := &adminpb.TopicTemplate{
+			Pattern:        pattern,
+			Delivery:       adminpb.DeliveryMode(config.Delivery),
+			History:        int64(config.History),
+			CompactionKey:  config.CompactionKey,
+			MaxSubscribers: int64(config.MaxSubscribers),
+		}
+		if schema := config.Schema; schema != nil {
+			template.Schema = &adminpb.SchemaBinding{
+				Subject:      schema.Subject,
+				Version:      int64(schema.Version),
+				RejectsTopic: schema.RejectsTopic,
+			}
+		}
+		resp.Templates = append(resp.Templates, template)
+	}
+	sort.Slice(resp.Templates, func(i, j int) bool { return resp.Templates[i].Pattern < resp.Templates[j].Pattern })
+	return resp, nil
+}
+
+func (s *service) AliasTopic(ctx context.Context, req *adminpb.AliasTopicRequest) (*emptypb.Empty, error) {
+	if err := pubsub.AliasTopic(req.Alias, req.Topic); err != nil {
+		return nil, statusOf(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) RemoveTopicAlias(ctx context.Context, req *adminpb.RemoveTopicAliasRequest) (*emptypb.Empty, error) {
+	if err := pubsub.RemoveTopicAlias(req.Alias); err != nil {
+		return nil, statusOf(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) SetTopicTemplate(ctx context.Context, req *adminpb.SetTopicTemplateRequest) (*emptypb.Empty, error) {
+	template := req.Template
+	if template == nil {
+		return nil, status.Error(codes.InvalidArgument, "no template")
+	}
+
+	config := pubsub.TopicConfig{
+		Delivery:       pubsub.DeliveryMode(template.Delivery),
+		History:        int(template.History),
+		CompactionKey:  template.CompactionKey,
+		MaxSubscribers: int(template.MaxSubscribers),
+	}
+	if schema := template.Schema; schema != nil {
+		config.Schema = &pubsub.SchemaBinding{
+			Subject:      schema.Subject,
+			Version:      int(schema.Version),
+			RejectsTopic: schema.RejectsTopic,
+		}
+	}
+	if err := pubsub.SetTopicTemplate(template.Pattern, config); err != nil {
+		return nil, statusOf(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *service) RemoveTopicTemplate(ctx context.Context, req *adminpb.RemoveTopicTemplateRequest) (*emptypb.Empty, error) {
+	if err := pubsub.RemoveTopicTemplate(req.Pattern); err != nil {
+		return nil, statusOf(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// existingTopic resolves a topic's aliases, or fails with NOT_FOUND if there
+// is no such topic or it is reserved
+func existingTopic(topic string) (string, error) {
+	topic = pubsub.ResolveTopic(topic)
+	if strings.HasPrefix(topic, pubsub.ReservedPrefix) {
+		return "", statusOf(pubsub.ErrReservedTopic)
+	}
+	topics, err := pubsub.ListTopics()
+	if err != nil {
+		return "", statusOf(err)
+	}
+	if !slices.Contains(topics, topic) {
+		return "", statusOf(fmt.Errorf("topic '%s': %w", topic, pubsub.ErrNoTopic))
+	}
+	return topic, nil
+}
+
+// validationErrors are the errors of arguments the pubsub package refuses
+var validationErrors = []error{
+	pubsub.ErrReservedTopic,
+	pubsub.ErrReservedSubscriberID,
+	pubsub.ErrEmptyTopic,
+	pubsub.ErrEmptySubscriberID,
+	pubsub.ErrTopicTooLong,
+	pubsub.ErrSubscriberIDTooLong,
+	pubsub.ErrEmbeddedNUL,
+	pubsub.ErrControlCharacter,
+	pubsub.ErrInvalidUTF8,
+}
+
+// statusOf maps an error of the pubsub package onto a gRPC status
+func statusOf(err error) error {
+	var internal *pubsub.InternalError
+	switch {
+	case errors.Is(err, pubsub.ErrNoTopic):
+		return status.Error(codes.NotFound, err.Error())
+	case slices.ContainsFunc(validationErrors, func(target error) bool { return errors.Is(err, target) }):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &internal):
+		return status.Error(codes.Internal, err.Error())
+	default:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+}
+
+func quota(q *adminpb.Quota) pubsub.Quota {
+	return pubsub.Quota{
+		MessagesPerSecond: q.GetMessagesPerSecond(),
+		BytesPerDay:       q.GetBytesPerDay(),
+		MaxRetainedBytes:  q.GetMaxRetainedBytes(),
+	}
+}
+
+func statsMessage(stats *pubsub.BrokerStats) *adminpb.Stats {
+	msg := &adminpb.Stats{
+		Published:       stats.Published,
+		Delivered:       stats.Delivered,
+		Dropped:         stats.Dropped,
+		Topics:          int64(stats.Topics),
+		Subscribers:     int64(stats.Subscribers),
+		QueueDepth:      int64(stats.QueueDepth),
+		RetainedBytes:   stats.RetainedBytes,
+		SpilledBytes:    stats.SpilledBytes,
+		TopicsCollected: stats.TopicsCollected,
+	}
+	for _, s := range stats.Subscriptions {
+		msg.Subscriptions = append(msg.Subscriptions, &adminpb.SubscriptionStats{
+			SubscriberId: s.SubscriberID,
+			Topic:        s.Topic,
+			Lag:          latency(s.Lag),
+			Handler:      latency(s.Handler),
+		})
+	}
+	for _, p := range stats.Publishers {
+		msg.Publishers = append(msg.Publishers, &adminpb.PublisherStats{
+			PublisherId: p.PublisherID,
+			Labels:      p.Labels,
+			Published:   p.Published,
+			Bytes:       p.Bytes,
+		})
+	}
+	return msg
+}
+
+func latency(summary pubsub.LatencySummary) *adminpb.Latency {
+	return &adminpb.Latency{
+		Count: summary.Count,
+		Sum:   durationpb.New(summary.Sum),
+		P50:   durationpb.New(summary.P50),
+		P95:   durationpb.New(summary.P95),
+		P99:   durationpb.New(summary.P99),
+	}
+}
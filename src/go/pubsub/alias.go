@@ -0,0 +1,157 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"fmt"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// topicAliases maps alias names to the topics they stand for. The map is
+// replaced, never modified, so publishes can read it without locking.
+var topicAliases atomic.Pointer[map[string]string]
+
+// aliasMu serializes changes to the aliases
+var aliasMu sync.Mutex
+
+// AliasTopic makes alias another name for topic, so publishes, subscriptions
+// and queue reads on either name reach the same subscribers. Subscriptions
+// already made to alias, its queued messages and its history move to topic,
+// and messages are delivered with topic as their topic whichever name was
+// used. This lets a topic be renamed while producers and consumers move over:
+// alias the old name to the new one, and remove the alias once nothing uses
+// it. An alias of an alias stands for the same topic, and a topic that has
+// aliases can't become one. If both topics exist, they must have the same
+// delivery mode; a topic created by the merge takes the alias's.
+func AliasTopic(alias, topic string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Topic: alias, Details: map[string]string{"alias_of": topic}}, err)
+	}()
+
+	if err := validatePublishTopic(alias); err != nil {
+		return err
+	}
+	if err := validatePublishTopic(topic); err != nil {
+		return err
+	}
+
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+
+	current := loadTopicAliases()
+	if target, ok := current[topic]; ok {
+		topic = target
+	}
+	if alias == topic {
+		return fmt.Errorf("failed to alias topic '%s' to itself", alias)
+	}
+	for other, target := range current {
+		if target == alias {
+			return fmt.Errorf("failed to alias topic '%s': it is the topic of alias '%s'", alias, other)
+		}
+	}
+
+	// Route the alias to the topic first, so nothing reaches the alias topic
+	// once it has been merged
+	next := maps.Clone(current)
+	if next == nil {
+		next = make(map[string]string)
+	}
+	next[alias] = topic
+	topicAliases.Store(&next)
+
+	cAlias := C.CString(alias)
+	defer C.free(unsafe.Pointer(cAlias))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	if !C.merge_topic(cAlias, cTopic) {
+		topicAliases.Store(&current)
+		if from, to := TopicDeliveryMode(alias), TopicDeliveryMode(topic); from != to {
+			return fmt.Errorf("failed to merge %s topic '%s' into %s topic '%s': %w", from, alias, to, topic, ErrDeliveryMode)
+		}
+		return checkInternal(fmt.Errorf("failed to merge topic '%s' into '%s'", alias, topic))
+	}
+	mergeSubscriptionStates(alias, topic)
+	mergeTopicOrdering(alias, topic)
+
+	return nil
+}
+
+// RemoveTopicAlias stops alias standing for another topic. Later
+// subscriptions and publishes on it use a topic of that name again.
+func RemoveTopicAlias(alias string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Topic: alias, Details: map[string]string{"alias_of": ""}}, err)
+	}()
+
+	aliasMu.Lock()
+	defer aliasMu.Unlock()
+
+	current := loadTopicAliases()
+	if _, ok := current[alias]; !ok {
+		return fmt.Errorf("failed to remove alias '%s': no such alias", alias)
+	}
+	next := maps.Clone(current)
+	delete(next, alias)
+	topicAliases.Store(&next)
+
+	return nil
+}
+
+// TopicAliases returns the aliases and the topics they stand for
+func TopicAliases() map[string]string {
+	return maps.Clone(loadTopicAliases())
+}
+
+// ResolveTopic returns the topic a name stands for: the aliased topic if it
+// is an alias, or the name itself
+func ResolveTopic(name string) string {
+	if target, ok := loadTopicAliases()[name]; ok {
+		return target
+	}
+	return name
+}
+
+func loadTopicAliases() map[string]string {
+	if aliases := topicAliases.Load(); aliases != nil {
+		return *aliases
+	}
+	return nil
+}
+
+// mergeSubscriptionStates moves the delivery policies of subscriptions to
+// one topic to another, keeping those already set there
+func mergeSubscriptionStates(from, to string) {
+	for _, shard := range callbackRegistry {
+		shard.Lock()
+		for key, state := range shard.subscriptions {
+			if key.topic != from {
+				continue
+			}
+			delete(shard.subscriptions, key)
+			merged := subscriptionKey{key.subscriberID, to}
+			if _, exists := shard.subscriptions[merged]; !exists {
+				shard.subscriptions[merged] = state
+			}
+		}
+		shard.Unlock()
+	}
+}
+
+// mergeTopicOrdering gives a topic the ordering of the topic merged into it,
+// unless it has its own
+func mergeTopicOrdering(from, to string) {
+	topicOrdering.Lock()
+	defer topicOrdering.Unlock()
+
+	if ordering, ok := topicOrdering.topics[from]; ok {
+		delete(topicOrdering.topics, from)
+		if _, exists := topicOrdering.topics[to]; !exists {
+			topicOrdering.topics[to] = ordering
+		}
+	}
+}
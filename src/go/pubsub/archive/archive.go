@@ -0,0 +1,310 @@
+// Package archive offloads the messages of selected topics to object storage,
+// so the in-memory broker can keep long-term history without an external
+// system. Messages are batched into gzip-compressed JSON Lines objects, one
+// pubsub.RecordedPublish per line, which pubsub.Replay can play back once
+// decompressed.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+const (
+	// DefaultInterval is how often batches are uploaded when Config leaves it unset
+	DefaultInterval = time.Minute
+	// DefaultMaxBatchBytes is the payload size that uploads a batch early when
+	// Config leaves it unset
+	DefaultMaxBatchBytes = 8 << 20
+	// DefaultSubscriberID is the archiver's subscriber ID when Config leaves it unset
+	DefaultSubscriberID = "archiver"
+)
+
+// pendingSuffix marks an object in the spool directory waiting for upload
+const pendingSuffix = ".jsonl.gz.pending"
+
+// Config configures an Archiver
+type Config struct {
+	// Topics are the topics to archive
+	Topics []string
+	// Store receives the objects, such as an *S3Store
+	Store Store
+	// Dir holds the checkpoint and the objects not yet uploaded. Objects
+	// left there by an earlier run are uploaded on Start, so nothing batched
+	// is lost to a failed upload or a restart.
+	Dir string
+	// Prefix is prepended to every object key
+	Prefix string
+	// Interval is how often the current batch is uploaded
+	Interval time.Duration
+	// MaxBatchBytes uploads the batch early once its payloads reach this size
+	MaxBatchBytes int
+	// SubscriberID is the ID the archiver subscribes with
+	SubscriberID string
+	// OnError, if set, is called with each failed upload; it is retried on
+	// the next interval
+	OnError func(error)
+}
+
+// checkpoint is the archiver's persisted progress
+type checkpoint struct {
+	// NextSequence numbers the next object, so a restart never reuses a key
+	NextSequence uint64 `json:"next_sequence"`
+	// LastUploaded is the key of the last object uploaded
+	LastUploaded string `json:"last_uploaded,omitempty"`
+}
+
+// Archiver batches messages from its topics and uploads them on a schedule
+type Archiver struct {
+	config Config
+
+	mu         sync.Mutex
+	batch      []pubsub.RecordedPublish
+	batchBytes int
+
+	checkpoint checkpoint
+	full       chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+	close      sync.Once
+	err        error
+}
+
+// Start subscribes to the configured topics and starts uploading batches
+func Start(config Config) (*Archiver, error) {
+	if len(config.Topics) == 0 {
+		return nil, errors.New("archiver requires at least one topic")
+	}
+	if config.Store == nil {
+		return nil, errors.New("archiver requires a store")
+	}
+	if config.Dir == "" {
+		return nil, errors.New("archiver requires a directory for its checkpoint")
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.MaxBatchBytes <= 0 {
+		config.MaxBatchBytes = DefaultMaxBatchBytes
+	}
+	if config.SubscriberID == "" {
+		config.SubscriberID = DefaultSubscriberID
+	}
+
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory '%s': %w", config.Dir, err)
+	}
+
+	a := &Archiver{
+		config: config,
+		full:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if err := a.loadCheckpoint(); err != nil {
+		return nil, err
+	}
+
+	for _, topic := range config.Topics {
+		if err := pubsub.Subscribe(config.SubscriberID, topic, a.add); err != nil {
+			pubsub.Unsubscribe(config.SubscriberID, "")
+			return nil, fmt.Errorf("failed to subscribe archiver to '%s': %w", topic, err)
+		}
+	}
+
+	go a.run()
+	return a, nil
+}
+
+// Close unsubscribes, uploads the current batch and any objects still
+// waiting, and returns the error of the last upload that failed
+func (a *Archiver) Close() error {
+	a.close.Do(func() {
+		pubsub.Unsubscribe(a.config.SubscriberID, "")
+		close(a.stop)
+	})
+	<-a.done
+	return a.err
+}
+
+// add appends a message to the batch. It runs as a callback under the broker
+// lock, so it only signals the uploader when the batch is full.
+func (a *Archiver) add(topic, message string) {
+	a.mu.Lock()
+	a.batch = append(a.batch, pubsub.RecordedPublish{Time: time.Now().UTC(), Topic: topic, Payload: message})
+	a.batchBytes += len(message)
+	full := a.batchBytes >= a.config.MaxBatchBytes
+	a.mu.Unlock()
+
+	if full {
+		select {
+		case a.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (a *Archiver) run() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+
+	// Objects left over from an earlier run go first
+	a.upload()
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.full:
+		case <-a.stop:
+			a.spool()
+			a.err = a.upload()
+			return
+		}
+		a.spool()
+		a.upload()
+	}
+}
+
+// spool writes the current batch to the directory as a compressed object
+// waiting for upload, and advances the checkpoint past its sequence number
+func (a *Archiver) spool() {
+	a.mu.Lock()
+	batch := a.batch
+	a.batch, a.batchBytes = nil, 0
+	a.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	for _, record := range batch {
+		encoder.Encode(record)
+	}
+	gz.Close()
+
+	sequence := a.checkpoint.NextSequence
+	a.checkpoint.NextSequence++
+	name := fmt.Sprintf("%s-%012d%s", batch[0].Time.Format("20060102"), sequence, pendingSuffix)
+
+	// The checkpoint is saved first, so a crash can't reuse the sequence
+	if err := a.saveCheckpoint(); err != nil {
+		a.report(err)
+	}
+	if err := writeFileAtomic(filepath.Join(a.config.Dir, name), buf.Bytes()); err != nil {
+		a.report(fmt.Errorf("failed to spool archive batch: %w", err))
+	}
+}
+
+// upload uploads the waiting objects in order, stopping at the first failure
+func (a *Archiver) upload() error {
+	entries, err := os.ReadDir(a.config.Dir)
+	if err != nil {
+		a.report(err)
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), pendingSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(a.config.Dir, name)
+		body, err := os.ReadFile(path)
+		if err != nil {
+			a.report(err)
+			return err
+		}
+
+		key := a.objectKey(name)
+		ctx, cancel := context.WithTimeout(context.Background(), a.config.Interval)
+		err = a.config.Store.Put(ctx, key, body)
+		cancel()
+		if err != nil {
+			a.report(err)
+			return err
+		}
+
+		os.Remove(path)
+		a.checkpoint.LastUploaded = key
+		if err := a.saveCheckpoint(); err != nil {
+			a.report(err)
+		}
+	}
+	return nil
+}
+
+// objectKey turns a spooled file name such as 20240102-000000000007.jsonl.gz.pending
+// into a key such as <prefix>2024/01/02/000000000007.jsonl.gz
+func (a *Archiver) objectKey(name string) string {
+	base := strings.TrimSuffix(name, ".pending")
+	date, rest, ok := strings.Cut(base, "-")
+	if !ok || len(date) != 8 {
+		return a.config.Prefix + base
+	}
+	return fmt.Sprintf("%s%s/%s/%s/%s", a.config.Prefix, date[:4], date[4:6], date[6:], rest)
+}
+
+func (a *Archiver) report(err error) {
+	if a.config.OnError != nil {
+		a.config.OnError(err)
+	}
+}
+
+func (a *Archiver) checkpointPath() string {
+	return filepath.Join(a.config.Dir, "checkpoint.json")
+}
+
+func (a *Archiver) loadCheckpoint() error {
+	data, err := os.ReadFile(a.checkpointPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read archive checkpoint: %w", err)
+	}
+	if err := json.Unmarshal(data, &a.checkpoint); err != nil {
+		return fmt.Errorf("failed to parse archive checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (a *Archiver) saveCheckpoint() error {
+	data, err := json.Marshal(a.checkpoint)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(a.checkpointPath(), data); err != nil {
+		return fmt.Errorf("failed to save archive checkpoint: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file through a temporary one, so a crash leaves
+// either the old contents or the new
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,9 @@
+//go:build asan
+
+package pubsub
+
+// Builds with -asan link against the core built by `make rust-asan`, so the
+// sanitizer covers both sides of the boundary
+
+// #cgo LDFLAGS: -L${SRCDIR}/../../../target/asan
+import "C"
@@ -0,0 +1,84 @@
+package pubsub
+
+import (
+	"context"
+	"sync"
+)
+
+// asyncQueueSize is the number of async publishes that can wait for the
+// background publisher before PublishAsync blocks
+const asyncQueueSize = 1024
+
+// PublishResult is the pending outcome of a PublishAsync call
+type PublishResult struct {
+	done   chan struct{}
+	report DeliveryReport
+	err    error
+}
+
+// Done returns a channel that is closed once the publish has completed
+func (r *PublishResult) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait blocks until the publish completes or the context is done, and returns
+// its delivery report as PublishSync would
+func (r *PublishResult) Wait(ctx context.Context) (DeliveryReport, error) {
+	select {
+	case <-r.done:
+		return r.report, r.err
+	case <-ctx.Done():
+		return DeliveryReport{}, ctx.Err()
+	}
+}
+
+func (r *PublishResult) complete(report DeliveryReport, err error) {
+	r.report = report
+	r.err = err
+	close(r.done)
+}
+
+// asyncPublish is a publish waiting for the background publisher
+type asyncPublish struct {
+	topic   string
+	message string
+	opts    []PublishOption
+	result  *PublishResult
+}
+
+var asyncPublisher struct {
+	start sync.Once
+	queue chan asyncPublish
+}
+
+// PublishAsync sends a message to a topic from a background goroutine, so the
+// caller doesn't wait for the call into the core. Messages published from one
+// goroutine are delivered in order. PublishAsync only blocks when
+// asyncQueueSize publishes are already waiting. In deterministic mode the
+// message is published before PublishAsync returns.
+func PublishAsync(topic, message string, opts ...PublishOption) *PublishResult {
+	result := &PublishResult{done: make(chan struct{})}
+	if err := validatePublishTopic(topic); err != nil {
+		result.complete(DeliveryReport{}, err)
+		return result
+	}
+	topic = ResolveTopic(topic)
+	if deterministic.Load() {
+		result.complete(PublishSync(topic, message, opts...))
+		return result
+	}
+
+	asyncPublisher.start.Do(func() {
+		asyncPublisher.queue = make(chan asyncPublish, asyncQueueSize)
+		go runAsyncPublisher(asyncPublisher.queue)
+	})
+	asyncPublisher.queue <- asyncPublish{topic: topic, message: message, opts: opts, result: result}
+	return result
+}
+
+// runAsyncPublisher publishes queued messages in the order they were queued
+func runAsyncPublisher(queue <-chan asyncPublish) {
+	for p := range queue {
+		p.result.complete(PublishSync(p.topic, p.message, p.opts...))
+	}
+}
@@ -0,0 +1,234 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SysAudit is the topic TopicAuditSink publishes audit events to
+const SysAudit = "$SYS/audit"
+
+// Control-plane operations recorded in the audit stream
+const (
+	AuditSubscribe           = "subscribe"
+	AuditUnsubscribe         = "unsubscribe"
+	AuditPause               = "pause"
+	AuditResume              = "resume"
+	AuditPublisherRegister   = "publisher.register"
+	AuditPublisherUnregister = "publisher.unregister"
+	AuditTopicCreate         = "topic.create"
+	AuditTopicDelete         = "topic.delete"
+	AuditConfig              = "config"
+	AuditMessageRedeliver    = "message.redeliver"
+	AuditMessageDiscard      = "message.discard"
+)
+
+// AuditEvent records a single control-plane operation
+type AuditEvent struct {
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	// Caller is the function outside this package that made the call, when known
+	Caller       string            `json:"caller,omitempty"`
+	SubscriberID string            `json:"subscriber_id,omitempty"`
+	Topic        string            `json:"topic,omitempty"`
+	Details      map[string]string `json:"details,omitempty"`
+	// Error is set when the operation failed
+	Error string `json:"error,omitempty"`
+}
+
+// AuditSink receives audit events. Audit may be called while the broker is
+// locked and must not call back into the pubsub package.
+type AuditSink interface {
+	Audit(event AuditEvent)
+}
+
+// AuditSinkFunc adapts a function to an AuditSink
+type AuditSinkFunc func(event AuditEvent)
+
+// Audit calls f(event)
+func (f AuditSinkFunc) Audit(event AuditEvent) {
+	f(event)
+}
+
+// auditState holds the active sink and the subscription that turns topic
+// lifecycle events into audit events
+var auditState struct {
+	sync.RWMutex
+	sink        AuditSink
+	stopWatcher context.CancelFunc
+	// generation counts SetAuditSink calls so a replaced watcher stops recording
+	generation uint64
+}
+
+// SetAuditSink starts recording control-plane operations to sink, replacing any
+// previous sink. A nil sink stops auditing.
+func SetAuditSink(sink AuditSink) error {
+	auditState.Lock()
+	if auditState.stopWatcher != nil {
+		auditState.stopWatcher()
+		auditState.stopWatcher = nil
+	}
+	auditState.sink = sink
+	auditState.generation++
+	generation := auditState.generation
+	auditState.Unlock()
+
+	if sink == nil {
+		return nil
+	}
+
+	// Topics are created by the Rust core, so creations are picked up from the
+	// lifecycle events rather than from the calls that caused them
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := WatchTopics(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	go func() {
+		for event := range events {
+			if event.Type != TopicCreated {
+				continue
+			}
+			auditState.RLock()
+			current := auditState.generation == generation
+			auditState.RUnlock()
+			if current {
+				recordAudit(AuditEvent{Operation: AuditTopicCreate, Topic: event.Topic}, nil)
+			}
+		}
+	}()
+
+	auditState.Lock()
+	auditState.stopWatcher = cancel
+	auditState.Unlock()
+
+	recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"audit": "enabled"}}, nil)
+	return nil
+}
+
+// recordAudit sends an event to the active sink, if any
+func recordAudit(event AuditEvent, err error) {
+	auditState.RLock()
+	sink := auditState.sink
+	auditState.RUnlock()
+
+	if sink == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Caller == "" {
+		event.Caller = externalCaller()
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	sink.Audit(event)
+}
+
+// externalCaller returns the first function on the stack outside this package,
+// or "" if the call didn't come from application code
+func externalCaller() string {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	pkg := packagePath()
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "runtime.") {
+			return ""
+		}
+		if !strings.HasPrefix(frame.Function, pkg+".") {
+			return frame.Function
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// packagePath returns the import path of this package
+func packagePath() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name()
+	return name[:strings.LastIndex(name, ".")]
+}
+
+// NewSlogAuditSink writes audit events to a structured logger at Info level
+func NewSlogAuditSink(logger *slog.Logger) AuditSink {
+	return AuditSinkFunc(func(event AuditEvent) {
+		attrs := []slog.Attr{
+			slog.String("operation", event.Operation),
+		}
+		if event.Caller != "" {
+			attrs = append(attrs, slog.String("caller", event.Caller))
+		}
+		if event.SubscriberID != "" {
+			attrs = append(attrs, slog.String("subscriber_id", event.SubscriberID))
+		}
+		if event.Topic != "" {
+			attrs = append(attrs, slog.String("topic", event.Topic))
+		}
+		for key, value := range event.Details {
+			attrs = append(attrs, slog.String(key, value))
+		}
+		if event.Error != "" {
+			attrs = append(attrs, slog.String("error", event.Error))
+		}
+		logger.LogAttrs(context.Background(), slog.LevelInfo, "pubsub audit", attrs...)
+	})
+}
+
+// NewFileAuditSink writes audit events to w as JSON lines
+func NewFileAuditSink(w io.Writer) AuditSink {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+
+	return AuditSinkFunc(func(event AuditEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		encoder.Encode(event)
+	})
+}
+
+// TopicAuditSink publishes audit events as JSON to SysAudit
+type TopicAuditSink struct {
+	events chan AuditEvent
+}
+
+// NewTopicAuditSink returns a sink publishing to SysAudit. Events are published
+// in order from a background goroutine, since the sink may be called while the
+// broker is locked; up to buffer events are queued before new ones are dropped.
+func NewTopicAuditSink(buffer int) *TopicAuditSink {
+	sink := &TopicAuditSink{events: make(chan AuditEvent, buffer)}
+
+	go func() {
+		for event := range sink.events {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			publish(SysAudit, string(payload), nil, nil)
+		}
+	}()
+
+	return sink
+}
+
+// Audit queues the event for publishing
+func (s *TopicAuditSink) Audit(event AuditEvent) {
+	select {
+	case s.events <- event:
+	default:
+	}
+}
@@ -0,0 +1,26 @@
+package pubsub
+
+// #include "pubsub_core.h"
+//
+// // Gateway function for batch callbacks
+// size_t batchCallbackGateway(BatchMessage* messages, size_t count, void* user_data);
+import "C"
+import "unsafe"
+
+//export batchCallbackGateway
+func batchCallbackGateway(messages *C.BatchMessage, count C.size_t, userData unsafe.Pointer) C.size_t {
+	// Fan the batch out to the handler in order, as if each message had been
+	// delivered on its own
+	delivered := 0
+	for _, msg := range unsafe.Slice(messages, int(count)) {
+		if deliverCallback(msg.topic, msg.message, userData) {
+			delivered++
+		}
+	}
+	return C.size_t(delivered)
+}
+
+// batchGatewayCallback returns the batch callback gateway as a C function pointer
+func batchGatewayCallback() C.batch_callback {
+	return C.batch_callback(C.batchCallbackGateway)
+}
@@ -0,0 +1,9 @@
+package benchmarks
+
+import "testing"
+
+func BenchmarkBroker(b *testing.B) { Compare(b) }
+
+func BenchmarkQueues(b *testing.B) { Queues(b) }
+
+func BenchmarkContention(b *testing.B) { Contention(b) }
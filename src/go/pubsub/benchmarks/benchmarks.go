@@ -0,0 +1,173 @@
+// Package benchmarks measures what crossing into the Rust core costs. It runs
+// the same benchmarks against the FFI broker and against Memory, a broker in
+// plain Go with the same semantics, for message sizes up to the default
+// message size limit. bench_test.go wires them up:
+//
+//	func BenchmarkBroker(b *testing.B) { Compare(b) }
+//
+// and the output of go test is what benchstat reads, with the two brokers
+// side by side in the impl column:
+//
+//	go test -run='^$' -bench=Broker -count=10 ./... > bench.txt
+//	benchstat -col /impl bench.txt
+//
+// Queues measures the FFI broker alone, comparing unbounded subscriber queues
+// with bounded ones, and Contention measures both brokers as concurrent
+// publishers are added:
+//
+//	func BenchmarkQueues(b *testing.B) { Queues(b) }
+//	func BenchmarkContention(b *testing.B) { Contention(b) }
+//
+// Nothing is injected: run them without chaos, faults or deterministic mode
+// configured, since those change what is measured.
+package benchmarks
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Broker is the part of a broker the benchmarks measure. FFI and Memory
+// implement it, along with the Fetch and Ack the conformance checks need.
+type Broker interface {
+	Subscribe(subscriberID, topic string, callback func(topic, message string)) error
+	Unsubscribe(subscriberID, topic string) error
+	Publish(topic, message string) error
+	// GetMessage returns the next queued message for the subscriber, from
+	// any topic if topic is empty, and false if there is none
+	GetMessage(subscriberID, topic string) (msgTopic, message string, ok bool, err error)
+}
+
+// Sizes are the message sizes in bytes each benchmark runs with
+var Sizes = []int{16, 256, 4096}
+
+// runCount numbers the topics and subscribers of each benchmark run, so runs
+// don't see each other's messages
+var runCount atomic.Uint64
+
+// Compare runs every benchmark against the FFI broker and Memory, as the
+// impl=ffi and impl=go sub-benchmarks
+func Compare(b *testing.B) {
+	b.Run("impl=ffi", func(b *testing.B) { Run(b, NewFFI()) })
+	b.Run("impl=go", func(b *testing.B) { Run(b, NewMemory()) })
+}
+
+// Run runs every benchmark against the broker, for each of Sizes
+func Run(b *testing.B, broker Broker) {
+	benchmarks := []struct {
+		name string
+		run  func(*testing.B, Broker, string)
+	}{
+		{"Publish", publishLatency},
+		{"EndToEnd", endToEndLatency},
+		{"Throughput", throughput},
+	}
+
+	for _, bm := range benchmarks {
+		for _, size := range Sizes {
+			b.Run(fmt.Sprintf("%s/size=%d", bm.name, size), func(b *testing.B) {
+				bm.run(b, broker, strings.Repeat("x", size))
+			})
+		}
+	}
+}
+
+// names returns a fresh subscriber ID and topic for one run
+func names() (subscriberID, topic string) {
+	n := runCount.Add(1)
+	return fmt.Sprintf("bench-sub-%d", n), fmt.Sprintf("bench/%d", n)
+}
+
+// publishLatency measures Publish to a topic with one callback subscriber,
+// which returns at once
+func publishLatency(b *testing.B, broker Broker, message string) {
+	subscriberID, topic := names()
+	if err := broker.Subscribe(subscriberID, topic, func(string, string) {}); err != nil {
+		b.Fatal(err)
+	}
+	defer broker.Unsubscribe(subscriberID, "")
+
+	b.SetBytes(int64(len(message)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := broker.Publish(topic, message); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// endToEndLatency measures from Publish until a queued subscriber has the
+// message back from GetMessage, reporting percentiles as well as the mean
+func endToEndLatency(b *testing.B, broker Broker, message string) {
+	subscriberID, topic := names()
+	if err := broker.Subscribe(subscriberID, topic, nil); err != nil {
+		b.Fatal(err)
+	}
+	defer broker.Unsubscribe(subscriberID, "")
+
+	latencies := make([]time.Duration, b.N)
+	b.SetBytes(int64(len(message)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		if err := broker.Publish(topic, message); err != nil {
+			b.Fatal(err)
+		}
+		_, got, ok, err := broker.GetMessage(subscriberID, topic)
+		if err != nil {
+			b.Fatal(err)
+		}
+		latencies[i] = time.Since(start)
+		if !ok || len(got) != len(message) {
+			b.Fatalf("message %d didn't come back intact", i)
+		}
+	}
+	b.StopTimer()
+	reportPercentiles(b, latencies)
+}
+
+// reportPercentiles reports the median, 99th and 99.9th percentile of the
+// latencies
+func reportPercentiles(b *testing.B, latencies []time.Duration) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(latencies[len(latencies)/2].Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Nanoseconds()), "p99-ns")
+	b.ReportMetric(float64(latencies[len(latencies)*999/1000].Nanoseconds()), "p99.9-ns")
+}
+
+// throughput publishes from GOMAXPROCS goroutines at once to a topic with one
+// callback subscriber, reporting messages delivered per second
+func throughput(b *testing.B, broker Broker, message string) {
+	subscriberID, topic := names()
+	var delivered atomic.Int64
+	if err := broker.Subscribe(subscriberID, topic, func(string, string) { delivered.Add(1) }); err != nil {
+		b.Fatal(err)
+	}
+	defer broker.Unsubscribe(subscriberID, "")
+
+	b.SetBytes(int64(len(message)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := broker.Publish(topic, message); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	if got := delivered.Load(); got != int64(b.N) {
+		b.Fatalf("delivered %d of %d messages", got, b.N)
+	}
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "msgs/s")
+}
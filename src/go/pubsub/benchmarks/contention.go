@@ -0,0 +1,76 @@
+package benchmarks
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Goroutines are the numbers of concurrent publishers Contention runs with
+var Goroutines = []int{1, 8, 32, 64}
+
+// Contention measures how throughput holds up as publishers are added, each
+// publishing to a topic of its own with a callback subscriber of its own.
+// Nothing is shared between the publishers but the broker, so any drop in
+// messages per second as they are added comes from its locks. It runs against
+// the FFI broker and Memory, as the impl=ffi and impl=go sub-benchmarks.
+func Contention(b *testing.B) {
+	for _, impl := range []struct {
+		name   string
+		broker Broker
+	}{
+		{"ffi", NewFFI()},
+		{"go", NewMemory()},
+	} {
+		for _, n := range Goroutines {
+			b.Run(fmt.Sprintf("impl=%s/goroutines=%d", impl.name, n), func(b *testing.B) {
+				contention(b, impl.broker, n, strings.Repeat("x", Sizes[0]))
+			})
+		}
+	}
+}
+
+// contention publishes b.N messages in total from n goroutines, reporting
+// messages delivered per second
+func contention(b *testing.B, broker Broker, n int, message string) {
+	var delivered atomic.Int64
+	topics := make([]string, n)
+	for i := range topics {
+		subscriberID, topic := names()
+		if err := broker.Subscribe(subscriberID, topic, func(string, string) { delivered.Add(1) }); err != nil {
+			b.Fatal(err)
+		}
+		defer broker.Unsubscribe(subscriberID, "")
+		topics[i] = topic
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	b.SetBytes(int64(len(message)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	start := time.Now()
+	for _, topic := range topics {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(b.N) {
+				if err := broker.Publish(topic, message); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	b.StopTimer()
+
+	if got := delivered.Load(); got != int64(b.N) {
+		b.Fatalf("delivered %d of %d messages", got, b.N)
+	}
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "msgs/s")
+}
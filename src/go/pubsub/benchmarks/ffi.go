@@ -0,0 +1,85 @@
+package benchmarks
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// FFI is the Broker backed by the pubsub package and its Rust core
+type FFI struct {
+	mu sync.Mutex
+	// inFlight holds the deliveries taken by Fetch, since a Delivery is what
+	// acks its message
+	inFlight map[inFlightKey]*pubsub.Delivery
+}
+
+type inFlightKey struct {
+	subscriberID string
+	tag          uint64
+}
+
+// NewFFI returns the broker of the pubsub package
+func NewFFI() *FFI {
+	return &FFI{inFlight: make(map[inFlightKey]*pubsub.Delivery)}
+}
+
+func (*FFI) Subscribe(subscriberID, topic string, callback func(topic, message string)) error {
+	return pubsub.Subscribe(subscriberID, topic, callback)
+}
+
+func (f *FFI) Unsubscribe(subscriberID, topic string) error {
+	if topic == "" {
+		f.mu.Lock()
+		for key := range f.inFlight {
+			if key.subscriberID == subscriberID {
+				delete(f.inFlight, key)
+			}
+		}
+		f.mu.Unlock()
+	}
+	return pubsub.Unsubscribe(subscriberID, topic)
+}
+
+func (*FFI) Publish(topic, message string) error {
+	return pubsub.Publish(topic, message)
+}
+
+func (*FFI) GetMessage(subscriberID, topic string) (string, string, bool, error) {
+	if !pubsub.HasMessages(subscriberID, topic) {
+		return "", "", false, nil
+	}
+	msg, err := pubsub.GetMessage(subscriberID, topic)
+	if err != nil {
+		return "", "", false, err
+	}
+	return msg.Topic, msg.Content, true, nil
+}
+
+// Fetch takes the next queued message with pubsub.Fetch, holding it in flight
+// until Ack
+func (f *FFI) Fetch(subscriberID, topic string) (uint64, string, bool, error) {
+	deliveries, err := pubsub.Fetch(subscriberID, topic, 1)
+	if err != nil || len(deliveries) == 0 {
+		return 0, "", false, err
+	}
+	d := &deliveries[0]
+
+	f.mu.Lock()
+	f.inFlight[inFlightKey{subscriberID, d.Tag}] = d
+	f.mu.Unlock()
+	return d.Tag, d.Content, true, nil
+}
+
+// Ack acknowledges a delivery taken by Fetch. The delivery is remembered after
+// it is acked, so that acking it again reaches the core and fails there.
+func (f *FFI) Ack(subscriberID string, tag uint64) error {
+	f.mu.Lock()
+	d, ok := f.inFlight[inFlightKey{subscriberID, tag}]
+	f.mu.Unlock()
+	if !ok {
+		return errors.New("failed to ack message: not fetched")
+	}
+	return d.Ack()
+}
@@ -0,0 +1,165 @@
+package benchmarks
+
+import (
+	"errors"
+	"sync"
+)
+
+// Memory is a broker written in plain Go, the baseline the FFI broker is
+// measured against. It does what conformance.Broker asks and no more: exact
+// topics, callbacks run synchronously by Publish, unbounded queues and
+// deliveries held in flight by Fetch until they are acked, all behind one
+// mutex as the Rust core is. It passes conformance.Run. Messages
+// are handed to callbacks and queues as they are, where the core copies them
+// across the boundary, so the difference includes that copy.
+type Memory struct {
+	mu sync.Mutex
+	// subscriptions holds each topic's subscribers in subscription order.
+	// Topics outlive their subscribers, as in the core.
+	subscriptions map[string][]*memorySubscriber
+	subscribers   map[string]*memorySubscriber
+	nextTag       uint64
+}
+
+type memorySubscriber struct {
+	id       string
+	callback func(topic, message string)
+	topics   map[string]bool
+	queue    []memoryMessage
+	// inFlight holds the messages taken by Fetch until they are acked
+	inFlight map[uint64]memoryMessage
+}
+
+type memoryMessage struct {
+	topic, message string
+}
+
+// NewMemory returns an empty broker
+func NewMemory() *Memory {
+	return &Memory{
+		subscriptions: make(map[string][]*memorySubscriber),
+		subscribers:   make(map[string]*memorySubscriber),
+	}
+}
+
+func (m *Memory) Subscribe(subscriberID, topic string, callback func(topic, message string)) error {
+	if subscriberID == "" || topic == "" {
+		return errors.New("failed to subscribe: empty subscriber ID or topic")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.subscribers[subscriberID]
+	if !ok {
+		s = &memorySubscriber{id: subscriberID, topics: make(map[string]bool), inFlight: make(map[uint64]memoryMessage)}
+		m.subscribers[subscriberID] = s
+	}
+	s.callback = callback
+	if !s.topics[topic] {
+		s.topics[topic] = true
+		m.subscriptions[topic] = append(m.subscriptions[topic], s)
+	}
+	return nil
+}
+
+// Unsubscribe removes a subscription, or every subscription of the
+// subscriber, its queue and its messages in flight if topic is empty
+func (m *Memory) Unsubscribe(subscriberID, topic string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.subscribers[subscriberID]
+	if !ok {
+		return errors.New("failed to unsubscribe: unknown subscriber")
+	}
+	for t := range s.topics {
+		if topic != "" && t != topic {
+			continue
+		}
+		delete(s.topics, t)
+		subscribers := m.subscriptions[t]
+		for i, other := range subscribers {
+			if other == s {
+				m.subscriptions[t] = append(subscribers[:i:i], subscribers[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(s.topics) == 0 {
+		delete(m.subscribers, subscriberID)
+	}
+	return nil
+}
+
+func (m *Memory) Publish(topic, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	subscribers, ok := m.subscriptions[topic]
+	if !ok {
+		return errors.New("failed to publish: topic does not exist")
+	}
+	for _, s := range subscribers {
+		if s.callback != nil {
+			s.callback(topic, message)
+		} else {
+			s.queue = append(s.queue, memoryMessage{topic, message})
+		}
+	}
+	return nil
+}
+
+func (m *Memory) GetMessage(subscriberID, topic string) (string, string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.subscribers[subscriberID]
+	if !ok {
+		return "", "", false, nil
+	}
+	for i, msg := range s.queue {
+		if topic == "" || msg.topic == topic {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return msg.topic, msg.message, true, nil
+		}
+	}
+	return "", "", false, nil
+}
+
+// Fetch takes the next queued message for the subscriber, from any topic if
+// topic is empty, and holds it in flight until it is acked
+func (m *Memory) Fetch(subscriberID, topic string) (uint64, string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.subscribers[subscriberID]
+	if !ok {
+		return 0, "", false, nil
+	}
+	for i, msg := range s.queue {
+		if topic == "" || msg.topic == topic {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			m.nextTag++
+			s.inFlight[m.nextTag] = msg
+			return m.nextTag, msg.message, true, nil
+		}
+	}
+	return 0, "", false, nil
+}
+
+// Ack removes a message in flight for good
+func (m *Memory) Ack(subscriberID string, tag uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.subscribers[subscriberID]
+	if !ok {
+		return errors.New("failed to ack message: unknown subscriber")
+	}
+	if _, ok := s.inFlight[tag]; !ok {
+		return errors.New("failed to ack message: delivery is not in flight")
+	}
+	delete(s.inFlight, tag)
+	return nil
+}
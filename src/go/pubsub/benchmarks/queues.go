@@ -0,0 +1,83 @@
+package benchmarks
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// queueCapacity bounds the queue in the queue=bounded runs of Queues. Queues
+// are drained whenever they are half this full, in both kinds of run, so the
+// bounded queue never drops a message.
+const queueCapacity = 1024
+
+// Queues measures Publish to a subscriber without a callback, whose queue is
+// unbounded in the queue=unbounded runs, as all queues were before
+// WithQueueCapacity, and preallocated by WithQueueCapacity in the
+// queue=bounded runs. It reports latency percentiles, since avoiding the
+// queue's reallocations shows up in the tail rather than the mean.
+func Queues(b *testing.B) {
+	for _, capacity := range []int{0, queueCapacity} {
+		kind := "unbounded"
+		if capacity > 0 {
+			kind = "bounded"
+		}
+		for _, size := range Sizes {
+			b.Run(fmt.Sprintf("queue=%s/size=%d", kind, size), func(b *testing.B) {
+				queueLatency(b, capacity, strings.Repeat("x", size))
+			})
+		}
+	}
+}
+
+// queueLatency measures Publish to a queue subscriber with the given queue
+// capacity, 0 meaning unbounded
+func queueLatency(b *testing.B, capacity int, message string) {
+	subscriberID, topic := names()
+	var opts []pubsub.SubscribeOption
+	if capacity > 0 {
+		opts = append(opts, pubsub.WithQueueCapacity(capacity))
+	}
+	if err := pubsub.Subscribe(subscriberID, topic, nil, opts...); err != nil {
+		b.Fatal(err)
+	}
+	defer pubsub.Unsubscribe(subscriberID, "")
+
+	latencies := make([]time.Duration, b.N)
+	drained := 0
+	b.SetBytes(int64(len(message)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i > 0 && i%(queueCapacity/2) == 0 {
+			b.StopTimer()
+			drained += drain(b, subscriberID)
+			b.StartTimer()
+		}
+		start := time.Now()
+		if err := pubsub.Publish(topic, message); err != nil {
+			b.Fatal(err)
+		}
+		latencies[i] = time.Since(start)
+	}
+	b.StopTimer()
+
+	if got := drained + drain(b, subscriberID); got != b.N {
+		b.Fatalf("queued %d of %d messages", got, b.N)
+	}
+	reportPercentiles(b, latencies)
+}
+
+// drain empties a subscriber's queue, returning how many messages it held
+func drain(b *testing.B, subscriberID string) int {
+	n := 0
+	for ; pubsub.HasMessages(subscriberID, ""); n++ {
+		if _, err := pubsub.GetMessage(subscriberID, ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return n
+}
@@ -0,0 +1,142 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a subscription's circuit breaker
+type CircuitState int
+
+const (
+	// CircuitClosed delivers every message to the callback
+	CircuitClosed CircuitState = iota
+	// CircuitOpen drops messages until the probe interval has elapsed
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe message through to test the callback
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreakerConfig configures the circuit breaker around a subscription's callback.
+// A callback fails when its handler returns an error or it panics; a panic is
+// recovered and counted instead of crashing the process.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the circuit
+	FailureThreshold int
+	// ProbeInterval is how long the circuit stays open before a probe message is let through
+	ProbeInterval time.Duration
+	// OnStateChange is called whenever the circuit changes state. It runs on the
+	// delivering goroutine and must not call back into the pubsub package.
+	OnStateChange func(subscriberID, topic string, from, to CircuitState)
+}
+
+// Default circuit breaker settings used when the config leaves them unset
+const (
+	DefaultFailureThreshold = 5
+	DefaultProbeInterval    = 5 * time.Second
+)
+
+// circuitBreaker tracks consecutive callback failures for a single subscription
+type circuitBreaker struct {
+	subscriberID string
+	topic        string
+	config       CircuitBreakerConfig
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func newCircuitBreaker(subscriberID, topic string, config CircuitBreakerConfig) *circuitBreaker {
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = DefaultFailureThreshold
+	}
+	if config.ProbeInterval <= 0 {
+		config.ProbeInterval = DefaultProbeInterval
+	}
+
+	return &circuitBreaker{
+		subscriberID: subscriberID,
+		topic:        topic,
+		config:       config,
+	}
+}
+
+// allow reports whether the next message may be delivered to the callback
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+
+	switch b.state {
+	case CircuitClosed:
+		b.mu.Unlock()
+		return true
+	case CircuitOpen:
+		if clockNow().Sub(b.openedAt) < b.config.ProbeInterval {
+			b.mu.Unlock()
+			return false
+		}
+		// Probe interval elapsed, let this message through as a probe
+		b.state = CircuitHalfOpen
+		b.probing = true
+		b.mu.Unlock()
+		b.notify(CircuitOpen, CircuitHalfOpen)
+		return true
+	default:
+		// Only one probe at a time while half-open
+		if b.probing {
+			b.mu.Unlock()
+			return false
+		}
+		b.probing = true
+		b.mu.Unlock()
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a delivery that allow permitted
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+
+	from := b.state
+	b.probing = false
+
+	if success {
+		b.failures = 0
+		b.state = CircuitClosed
+	} else {
+		b.failures++
+		if from == CircuitHalfOpen || b.failures >= b.config.FailureThreshold {
+			b.state = CircuitOpen
+			b.openedAt = clockNow()
+		}
+	}
+
+	to := b.state
+	b.mu.Unlock()
+
+	if from != to {
+		b.notify(from, to)
+	}
+}
+
+func (b *circuitBreaker) notify(from, to CircuitState) {
+	if b.config.OnStateChange != nil {
+		b.config.OnStateChange(b.subscriberID, b.topic, from, to)
+	}
+}
@@ -0,0 +1,143 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// PublishBytes sends a binary payload, such as a FlatBuffers or Cap'n Proto
+// message, to a topic. The payload is copied once into the broker and shared by
+// every subscriber it is queued for. Subscribers with a callback and taps
+// receive messages as C strings, so a payload containing a NUL byte is dropped
+// for them; read it with GetBuffer instead.
+func PublishBytes(topic string, payload []byte, opts ...PublishOption) error {
+	if err := validatePublishTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+	if len(payload) > GetLimits().MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+	if hooksRegistered() {
+		if err := hookPublish(topic, string(payload)); err != nil {
+			return err
+		}
+	}
+
+	if err := injectedFault("publish_bytes"); err != nil {
+		return err
+	}
+
+	var options publishOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	cTopic := internCString(topic)
+	defer cTopic.release()
+
+	cOptions := options.toC()
+	defer freePublishOptions(&cOptions)
+
+	// The core copies the payload before returning, so it can be passed in place
+	var cReport C.DeliveryReport
+	started := time.Now()
+	done := timeCgo("publish_bytes")
+	success := C.publish_bytes(
+		cTopic.ptr,
+		(*C.uint8_t)(unsafe.Pointer(unsafe.SliceData(payload))),
+		C.size_t(len(payload)),
+		&cOptions,
+		&cReport,
+	)
+	done()
+	err := publishResult(topic, bool(success), &cReport)
+	tracePublish(topic, options, started, &cReport, err)
+	if err != nil {
+		return err
+	}
+	hookDrop(topic, int(cReport.dropped))
+	recordPublishBytes(topic, payload, options)
+	if topic == ControlTopic {
+		drainControl()
+	}
+	return nil
+}
+
+// Buffer is a queued message read without copying its payload out of the
+// broker. The payload stays in broker memory, and counts against retained-bytes
+// quotas, until Release is called.
+type Buffer struct {
+	Topic    string
+	id       uint64
+	data     []byte
+	released atomic.Bool
+}
+
+// Bytes returns the payload in place. It must not be modified, and must not be
+// used after Release.
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+// Release returns the payload's memory to the broker
+func (b *Buffer) Release() error {
+	if b.released.Swap(true) {
+		return errors.New("buffer already released")
+	}
+	b.data = nil
+
+	done := timeCgo("release_buffer")
+	success := C.release_buffer(C.uint64_t(b.id))
+	done()
+	if !success {
+		return errors.New("failed to release buffer")
+	}
+	return nil
+}
+
+// GetBuffer takes the next message for a subscriber like GetMessage, but
+// without copying its payload. The caller must Release the buffer.
+// If topic is empty, gets the next message from any topic
+func GetBuffer(subscriberID string, topic string) (*Buffer, error) {
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return nil, err
+	}
+	if topic != "" {
+		if err := validateTopic(topic); err != nil {
+			return nil, err
+		}
+		topic = ResolveTopic(topic)
+	}
+
+	if err := injectedFault("get_next_buffer"); err != nil {
+		return nil, err
+	}
+
+	cSubscriberID := internCString(subscriberID)
+	defer cSubscriberID.release()
+
+	cTopic := internOptional(topic)
+	defer cTopic.release()
+
+	var cBuffer C.PayloadBuffer
+	done := timeCgo("get_next_buffer")
+	success := C.get_next_buffer(cSubscriberID.ptr, cTopic.ptr, &cBuffer)
+	done()
+	if !success {
+		return nil, checkInternal(errors.New("no messages available"))
+	}
+
+	buffer := &Buffer{
+		Topic: C.GoStringN(cBuffer.topic, C.int(cBuffer.topic_len)),
+		id:    uint64(cBuffer.id),
+	}
+	if cBuffer.len > 0 {
+		buffer.data = unsafe.Slice((*byte)(unsafe.Pointer(cBuffer.data)), int(cBuffer.len))
+	}
+	return buffer, nil
+}
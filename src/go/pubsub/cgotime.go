@@ -0,0 +1,78 @@
+package pubsub
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CgoCallStats is the time spent inside calls into the core library by one of
+// its C functions, measured while cgo timing is enabled
+type CgoCallStats struct {
+	// Function is the name of the C function
+	Function string
+	Calls    uint64
+	Total    time.Duration
+}
+
+// cgoTimingEnabled switches the timing of cgo calls on
+var cgoTimingEnabled atomic.Bool
+
+// cgoTimers holds a *cgoTimer per C function
+var cgoTimers sync.Map
+
+type cgoTimer struct {
+	calls atomic.Uint64
+	nanos atomic.Int64
+}
+
+// SetCgoTiming turns timing of calls into the core library on or off. While
+// it is on, Stats reports the number of calls and the time spent in each C
+// function the package calls, which shows how much of a profile's cgo time
+// each API accounts for. Callback handlers run inside the publish that
+// delivers to them, so their time counts toward publishing. Turning timing on
+// resets the totals.
+func SetCgoTiming(enabled bool) {
+	if enabled && !cgoTimingEnabled.Load() {
+		cgoTimers.Range(func(key, _ any) bool {
+			cgoTimers.Delete(key)
+			return true
+		})
+	}
+	cgoTimingEnabled.Store(enabled)
+}
+
+// timeCgo starts timing a call to a C function, returning the function that
+// stops it. It costs a single atomic load while timing is off.
+func timeCgo(function string) func() {
+	if !cgoTimingEnabled.Load() {
+		return func() {}
+	}
+
+	started := time.Now()
+	return func() {
+		timer, ok := cgoTimers.Load(function)
+		if !ok {
+			timer, _ = cgoTimers.LoadOrStore(function, &cgoTimer{})
+		}
+		timer.(*cgoTimer).calls.Add(1)
+		timer.(*cgoTimer).nanos.Add(int64(time.Since(started)))
+	}
+}
+
+// cgoCallStats returns the cgo call totals, sorted by function
+func cgoCallStats() []CgoCallStats {
+	var stats []CgoCallStats
+	cgoTimers.Range(func(key, value any) bool {
+		timer := value.(*cgoTimer)
+		stats = append(stats, CgoCallStats{
+			Function: key.(string),
+			Calls:    timer.calls.Load(),
+			Total:    time.Duration(timer.nanos.Load()),
+		})
+		return true
+	})
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Function < stats[j].Function })
+	return stats
+}
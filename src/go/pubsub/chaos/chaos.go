@@ -0,0 +1,106 @@
+// Package chaos injects random failures into the broker so tests can exercise
+// an application's retry, timeout and quarantine handling. It is meant for
+// tests only: enabling it makes a healthy broker misbehave on purpose.
+package chaos
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// Config sets how often each kind of failure is injected. Rates are fractions
+// between 0 and 1; a rate of 0 disables that failure.
+type Config struct {
+	// DropRate is the fraction of deliveries dropped, whether to a callback
+	// or a queue
+	DropRate float64
+	// QueueFullRate is the fraction of messages for subscribers without a
+	// callback that are rejected as if the subscriber's queue were full
+	QueueFullRate float64
+	// DelayRate is the fraction of callback deliveries held back for a
+	// random time of up to MaxDelay
+	DelayRate float64
+	MaxDelay  time.Duration
+	// CallFailureRate is the fraction of calls into the core that fail with
+	// pubsub.ErrInjectedFault instead of being made
+	CallFailureRate float64
+	// Calls limits call failures to these C functions, such as
+	// "publish_with_options" or "get_next_message". Empty means all of them.
+	Calls []string
+	// Seed makes the injected failures repeatable; 0 picks a random seed
+	Seed int64
+}
+
+// Enable starts injecting failures as configured, replacing any earlier
+// configuration
+func Enable(config Config) error {
+	for _, rate := range []float64{config.DropRate, config.QueueFullRate, config.DelayRate, config.CallFailureRate} {
+		if rate < 0 || rate > 1 {
+			return errors.New("failed to enable chaos: rates must be between 0 and 1")
+		}
+	}
+	if config.DelayRate > 0 && config.MaxDelay <= 0 {
+		return errors.New("failed to enable chaos: a delay rate needs a positive MaxDelay")
+	}
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := &random{rng: rand.New(rand.NewSource(seed))}
+
+	faults := pubsub.Faults{
+		DropRate:      config.DropRate,
+		QueueFullRate: config.QueueFullRate,
+		Seed:          uint64(seed),
+	}
+	if config.DelayRate > 0 {
+		faults.CallbackDelay = func(subscriberID, topic string) time.Duration {
+			if !r.chance(config.DelayRate) {
+				return 0
+			}
+			return r.duration(config.MaxDelay)
+		}
+	}
+	if config.CallFailureRate > 0 {
+		calls := make(map[string]bool, len(config.Calls))
+		for _, call := range config.Calls {
+			calls[call] = true
+		}
+		faults.FailCall = func(function string) bool {
+			if len(calls) > 0 && !calls[function] {
+				return false
+			}
+			return r.chance(config.CallFailureRate)
+		}
+	}
+
+	return pubsub.SetFaults(faults)
+}
+
+// Disable stops injecting failures
+func Disable() error {
+	return pubsub.SetFaults(pubsub.Faults{})
+}
+
+// random is a seeded source shared by the goroutines making deliveries and calls
+type random struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func (r *random) chance(rate float64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rng.Float64() < rate
+}
+
+func (r *random) duration(limit time.Duration) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.rng.Int63n(int64(limit))) + 1
+}
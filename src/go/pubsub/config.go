@@ -0,0 +1,412 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Config is the broker configuration passed to Init, or read by ReloadConfig
+// from a JSON file:
+//
+//	{
+//	  "limits": {"max_topic_size": 256, "max_message_size": 65536},
+//	  "dedup_window": "1m",
+//	  "subscriber_ttl": "30s",
+//	  "topic_idle_ttl": "10m",
+//	  "memory_limit": {"bytes": 67108864, "policy": "evict_oldest"},
+//	  "sys_topics_interval": "5s",
+//	  "deterministic": false,
+//	  "allocator_stats": true,
+//	  "namespace_quotas": {"orders": {"messages_per_sec": 100}},
+//	  "publisher_quotas": {"billing": {"bytes_per_day": 1048576}},
+//	  "namespace_dispatchers": {"metrics": {"workers": 4, "queue_size": 1024}},
+//	  "topic_ordering": {"metrics/cpu": "relaxed"},
+//	  "topic_delivery": {"jobs/resize": "queue", "orders/new": "keyed"},
+//	  "topic_templates": {"devices/*/state": {"history": 1, "max_subscribers": 16}},
+//	  "schema_bindings": {"orders/new": {"subject": "order", "rejects_topic": "orders/rejects"}},
+//	  "topic_aliases": {"orders/old": "orders/new"},
+//	  "storage": {"engine": "wal", "path": "/var/lib/pubsub", "sync": true}
+//	}
+//
+// Settings missing from the file are left as they are.
+type Config struct {
+	Limits            *ConfigLimits `json:"limits,omitempty"`
+	DedupWindow       *Duration     `json:"dedup_window,omitempty"`
+	SubscriberTTL     *Duration     `json:"subscriber_ttl,omitempty"`
+	TopicIdleTTL      *Duration     `json:"topic_idle_ttl,omitempty"`
+	MemoryLimit       *ConfigMemory `json:"memory_limit,omitempty"`
+	SysTopicsInterval *Duration     `json:"sys_topics_interval,omitempty"`
+	Deterministic     *bool         `json:"deterministic,omitempty"`
+	AllocatorStats    *bool         `json:"allocator_stats,omitempty"`
+	// NamespaceQuotas and PublisherQuotas map a namespace or publisher ID to its quota
+	NamespaceQuotas map[string]ConfigQuota `json:"namespace_quotas,omitempty"`
+	PublisherQuotas map[string]ConfigQuota `json:"publisher_quotas,omitempty"`
+	// NamespaceDispatchers maps a namespace to the worker pool delivering its
+	// topics with relaxed ordering
+	NamespaceDispatchers map[string]ConfigDispatcher `json:"namespace_dispatchers,omitempty"`
+	// TopicOrdering maps a topic to "strict" or "relaxed"
+	TopicOrdering map[string]string `json:"topic_ordering,omitempty"`
+	// TopicDelivery maps a topic to create to its delivery mode: "fanout",
+	// "queue" or "keyed". A topic keeps its mode when a later config leaves
+	// it out.
+	TopicDelivery map[string]string `json:"topic_delivery,omitempty"`
+	// TopicTemplates maps a pattern to the configuration of the topics
+	// matching it, as with SetTopicTemplate
+	TopicTemplates map[string]ConfigTopicTemplate `json:"topic_templates,omitempty"`
+	// SchemaBindings maps a topic to its schema and the topic its rejects go to
+	SchemaBindings map[string]ConfigSchemaBinding `json:"schema_bindings,omitempty"`
+	// TopicAliases maps an alias to the topic it stands for
+	TopicAliases map[string]string `json:"topic_aliases,omitempty"`
+
+	// Storage opens a storage engine, as with OpenStorage, once the other
+	// settings are applied. Only Init reads it.
+	Storage *ConfigStorage `json:"storage,omitempty"`
+
+	// KeyProvider encrypts what the core writes to disk, as with
+	// SetEncryption, before Storage is opened. Only Init reads it.
+	KeyProvider KeyProvider `json:"-"`
+	// Logger receives the core library's log events, as with SetLogger. Only
+	// Init reads it.
+	Logger *slog.Logger `json:"-"`
+	// PanicHook is called with each panic caught in the core, before the call
+	// that panicked returns its InternalError. It may run with the broker
+	// locked, so it must not call the package. Only Init reads it.
+	PanicHook func(*InternalError) `json:"-"`
+}
+
+// ConfigLimits is the limits section of a Config. A size left out or 0 keeps
+// the default.
+type ConfigLimits struct {
+	MaxTopicSize           int `json:"max_topic_size"`
+	MaxMessageSize         int `json:"max_message_size"`
+	MaxTopics              int `json:"max_topics"`
+	MaxSubscribersPerTopic int `json:"max_subscribers_per_topic"`
+}
+
+// ConfigMemory is the memory limit section of a Config
+type ConfigMemory struct {
+	Bytes uint64 `json:"bytes"`
+	// Policy is "reject", "evict_oldest" or "drop_largest"
+	Policy string `json:"policy"`
+}
+
+// ConfigStorage is the storage section of a Config
+type ConfigStorage struct {
+	// Engine is "memory", "wal", "sqlite" or "rocksdb"
+	Engine string `json:"engine"`
+	Path   string `json:"path"`
+	Sync   bool   `json:"sync"`
+}
+
+// open opens the storage engine of the section
+func (s *ConfigStorage) open() error {
+	engine, err := storageEngineByName(s.Engine)
+	if err != nil {
+		return err
+	}
+	return OpenStorage(engine, StorageOptions{Path: s.Path, Sync: s.Sync})
+}
+
+// ConfigQuota is a quota in a Config
+type ConfigQuota struct {
+	MessagesPerSec   float64 `json:"messages_per_sec"`
+	BytesPerDay      uint64  `json:"bytes_per_day"`
+	MaxRetainedBytes uint64  `json:"max_retained_bytes"`
+}
+
+// ConfigDispatcher is a namespace dispatcher in a Config
+type ConfigDispatcher struct {
+	Workers   int `json:"workers"`
+	QueueSize int `json:"queue_size"`
+}
+
+// ConfigTopicTemplate is a topic template in a Config
+type ConfigTopicTemplate struct {
+	// Delivery is "fanout", "queue" or "keyed", or empty to leave the mode
+	Delivery       string               `json:"delivery"`
+	History        int                  `json:"history"`
+	CompactionKey  string               `json:"compaction_key"`
+	MaxSubscribers int                  `json:"max_subscribers"`
+	Schema         *ConfigSchemaBinding `json:"schema"`
+}
+
+// topicConfig converts the template to a TopicConfig
+func (t ConfigTopicTemplate) topicConfig() (TopicConfig, error) {
+	config := TopicConfig{
+		History:        t.History,
+		CompactionKey:  t.CompactionKey,
+		MaxSubscribers: t.MaxSubscribers,
+	}
+	if t.Delivery != "" {
+		mode, err := deliveryModeByName(t.Delivery)
+		if err != nil {
+			return TopicConfig{}, err
+		}
+		config.Delivery = mode
+	}
+	if t.Schema != nil {
+		config.Schema = &SchemaBinding{
+			Subject:      t.Schema.Subject,
+			Version:      t.Schema.Version,
+			RejectsTopic: t.Schema.RejectsTopic,
+		}
+	}
+	return config, nil
+}
+
+// ConfigSchemaBinding is a schema binding in a Config
+type ConfigSchemaBinding struct {
+	Subject      string `json:"subject"`
+	Version      int    `json:"version"`
+	RejectsTopic string `json:"rejects_topic"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in a Config
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// appliedConfig is the last configuration applied by ReloadConfig. The mutex
+// also keeps reloads from interleaving.
+var appliedConfig struct {
+	sync.Mutex
+	config *Config
+}
+
+// ReloadConfig reads a Config from a JSON file and applies it to the running
+// broker. Subscriptions and queued messages are kept. Quotas, topic orderings
+// and schema bindings that the previously loaded file had but this one
+// doesn't are removed. A file that can't be read or parsed changes nothing;
+// otherwise every setting is applied, and the errors of any that are rejected
+// are returned together.
+func ReloadConfig(path string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"config": path}}, err)
+	}()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config '%s': %w", path, err)
+	}
+
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return fmt.Errorf("failed to parse config '%s': %w", path, err)
+	}
+
+	appliedConfig.Lock()
+	defer appliedConfig.Unlock()
+
+	err = config.apply(appliedConfig.config)
+	appliedConfig.config = &config
+	if err != nil {
+		return fmt.Errorf("failed to apply config '%s': %w", path, err)
+	}
+	return nil
+}
+
+// ReloadConfigOnSIGHUP calls ReloadConfig with path each time the process
+// receives SIGHUP, until the context is cancelled. Each result is passed to
+// report, if it isn't nil.
+func ReloadConfigOnSIGHUP(ctx context.Context, path string, report func(error)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				err := ReloadConfig(path)
+				if report != nil {
+					report(err)
+				}
+			}
+		}
+	}()
+}
+
+// apply applies the configuration, removing the map entries of the previous
+// one that it no longer has
+func (c *Config) apply(previous *Config) error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if c.Limits != nil {
+		limits := GetLimits()
+		if c.Limits.MaxTopicSize > 0 {
+			limits.MaxTopicSize = c.Limits.MaxTopicSize
+		}
+		if c.Limits.MaxMessageSize > 0 {
+			limits.MaxMessageSize = c.Limits.MaxMessageSize
+		}
+		limits.MaxTopics = c.Limits.MaxTopics
+		limits.MaxSubscribersPerTopic = c.Limits.MaxSubscribersPerTopic
+		check(SetLimits(limits))
+	}
+	if c.DedupWindow != nil {
+		check(SetDedupWindow(time.Duration(*c.DedupWindow)))
+	}
+	if c.SubscriberTTL != nil {
+		check(SetSubscriberTTL(time.Duration(*c.SubscriberTTL)))
+	}
+	if c.TopicIdleTTL != nil {
+		check(SetTopicIdleTTL(time.Duration(*c.TopicIdleTTL)))
+	}
+	if c.MemoryLimit != nil {
+		policy, err := memoryPolicyByName(c.MemoryLimit.Policy)
+		check(err)
+		if err == nil {
+			check(SetMemoryLimit(c.MemoryLimit.Bytes, policy))
+		}
+	}
+	if c.SysTopicsInterval != nil {
+		if interval := time.Duration(*c.SysTopicsInterval); interval > 0 {
+			check(EnableSysTopics(interval))
+		} else {
+			check(DisableSysTopics())
+		}
+	}
+
+	if c.Deterministic != nil {
+		check(SetDeterministic(*c.Deterministic))
+	}
+	if c.AllocatorStats != nil {
+		SetAllocatorStats(*c.AllocatorStats)
+	}
+
+	var prev Config
+	if previous != nil {
+		prev = *previous
+	}
+
+	for namespace := range prev.NamespaceQuotas {
+		if _, ok := c.NamespaceQuotas[namespace]; !ok {
+			check(SetNamespaceQuota(namespace, Quota{}))
+		}
+	}
+	for namespace, quota := range c.NamespaceQuotas {
+		check(SetNamespaceQuota(namespace, quota.quota()))
+	}
+	for publisherID := range prev.PublisherQuotas {
+		if _, ok := c.PublisherQuotas[publisherID]; !ok {
+			check(SetPublisherQuota(publisherID, Quota{}))
+		}
+	}
+	for publisherID, quota := range c.PublisherQuotas {
+		check(SetPublisherQuota(publisherID, quota.quota()))
+	}
+	for namespace := range prev.NamespaceDispatchers {
+		if _, ok := c.NamespaceDispatchers[namespace]; !ok {
+			check(SetNamespaceDispatcher(namespace, DispatcherConfig{}))
+		}
+	}
+	for namespace, dispatcher := range c.NamespaceDispatchers {
+		if prev.NamespaceDispatchers[namespace] != dispatcher {
+			check(SetNamespaceDispatcher(namespace, DispatcherConfig(dispatcher)))
+		}
+	}
+
+	for topic := range prev.TopicOrdering {
+		if _, ok := c.TopicOrdering[topic]; !ok {
+			check(SetTopicOrdering(topic, OrderingStrict))
+		}
+	}
+	for topic, name := range c.TopicOrdering {
+		switch name {
+		case OrderingStrict.String():
+			check(SetTopicOrdering(topic, OrderingStrict))
+		case OrderingRelaxed.String():
+			check(SetTopicOrdering(topic, OrderingRelaxed))
+		default:
+			check(fmt.Errorf("unknown ordering '%s' for topic '%s'", name, topic))
+		}
+	}
+
+	for pattern := range prev.TopicTemplates {
+		if _, ok := c.TopicTemplates[pattern]; !ok {
+			check(RemoveTopicTemplate(pattern))
+		}
+	}
+	for pattern, template := range c.TopicTemplates {
+		config, err := template.topicConfig()
+		check(err)
+		if err == nil {
+			check(SetTopicTemplate(pattern, config))
+		}
+	}
+
+	for topic, name := range c.TopicDelivery {
+		mode, err := deliveryModeByName(name)
+		check(err)
+		if err == nil {
+			check(CreateTopic(topic, mode))
+		}
+	}
+
+	for topic := range prev.SchemaBindings {
+		if _, ok := c.SchemaBindings[topic]; !ok {
+			check(UnbindSchema(topic))
+		}
+	}
+	for topic, binding := range c.SchemaBindings {
+		check(BindSchema(topic, SchemaBinding{
+			Subject:      binding.Subject,
+			Version:      binding.Version,
+			RejectsTopic: binding.RejectsTopic,
+		}))
+	}
+
+	for alias := range prev.TopicAliases {
+		if _, ok := c.TopicAliases[alias]; !ok {
+			check(RemoveTopicAlias(alias))
+		}
+	}
+	for alias, topic := range c.TopicAliases {
+		if ResolveTopic(alias) != ResolveTopic(topic) {
+			check(AliasTopic(alias, topic))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (q ConfigQuota) quota() Quota {
+	return Quota{
+		MessagesPerSecond: q.MessagesPerSec,
+		BytesPerDay:       q.BytesPerDay,
+		MaxRetainedBytes:  q.MaxRetainedBytes,
+	}
+}
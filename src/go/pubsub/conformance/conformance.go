@@ -0,0 +1,319 @@
+// Package conformance checks the invariants every broker implementation must
+// keep, as rapid state-machine tests. conformance_test.go runs them against
+// benchmarks.FFI, the broker backed by the Rust core, benchmarks.Memory, the
+// broker in plain Go, and mock.Broker, and other implementations run them the
+// same way:
+//
+//	func TestConformance(t *testing.T) {
+//		conformance.Run(t, benchmarks.NewFFI())
+//	}
+//
+// Each check drives the broker through a random sequence of subscribes,
+// unsubscribes, publishes, reads, fetches and acks, comparing it with a model
+// after every step. The model expects:
+//
+//   - no delivery to a callback after it unsubscribes from the topic,
+//   - every subscriber of a topic to receive each of its messages, in the
+//     order they were published,
+//   - a queued message to be read exactly once, by GetMessage or Fetch,
+//   - a fetched message to be acked exactly once, after which acking it
+//     again fails and it is never delivered again.
+//
+// rapid shrinks a failing sequence to a short one before reporting it.
+//
+// The package is a module of its own, so that the pubsub module keeps to the
+// standard library. The checks assume that Publish returns once every
+// callback subscriber has run and every queued subscriber has the message,
+// which the pubsub package guarantees for strictly ordered topics.
+package conformance
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"pgregory.net/rapid"
+)
+
+// Broker is the part of a broker the checks exercise
+type Broker interface {
+	Subscribe(subscriberID, topic string, callback func(topic, message string)) error
+	Unsubscribe(subscriberID, topic string) error
+	Publish(topic, message string) error
+	// GetMessage returns the next queued message for the subscriber, from
+	// any topic if topic is empty, and false if there is none
+	GetMessage(subscriberID, topic string) (msgTopic, message string, ok bool, err error)
+	// Fetch takes the next queued message like GetMessage, but holds it in
+	// flight until it is acked, returning the tag that acks it
+	Fetch(subscriberID, topic string) (tag uint64, message string, ok bool, err error)
+	// Ack removes a message in flight for good, and fails if it isn't in
+	// flight
+	Ack(subscriberID string, tag uint64) error
+}
+
+const (
+	// callbackSubscribers subscribe and unsubscribe with a callback
+	callbackSubscribers = 3
+	// queueSubscribers are subscribed to every topic without a callback, which
+	// also keeps the topics in existence
+	queueSubscribers = 2
+	topics           = 3
+)
+
+// runCount numbers the topics and subscribers of each check, so checks don't
+// see each other's messages
+var runCount atomic.Uint64
+
+// Run checks the broker against the model
+func Run(t *testing.T, b Broker) {
+	rapid.Check(t, func(t *rapid.T) {
+		m := newMachine(t, b)
+		defer m.cleanup()
+		t.Repeat(map[string]func(*rapid.T){
+			"Subscribe":   m.subscribe,
+			"Unsubscribe": m.unsubscribe,
+			"Publish":     m.publish,
+			"GetMessage":  m.getMessage,
+			"Fetch":       m.fetch,
+			"Ack":         m.ack,
+			"AckAgain":    m.ackAgain,
+			"":            m.checkCallbacks,
+		})
+		m.drain(t)
+	})
+}
+
+// machine is the model of a broker driven by one check
+type machine struct {
+	broker    Broker
+	callbacks []string
+	queues    []string
+	topics    []string
+	published int
+
+	// subscribed holds the topics each callback subscriber is subscribed to,
+	// and expected what it should have received, as "topic message"
+	subscribed map[string]map[string]bool
+	expected   map[string][]string
+	// mu guards received, which the callbacks fill in
+	mu       sync.Mutex
+	received map[string][]string
+
+	// pending holds the messages queued for each queue subscriber by topic,
+	// inFlight the tags and messages fetched and not acked, and acked the tags
+	// acked
+	pending  map[string]map[string][]string
+	inFlight map[string][]delivery
+	acked    map[string][]uint64
+}
+
+type delivery struct {
+	tag     uint64
+	message string
+}
+
+// newMachine names the subscribers and topics of a check and subscribes the
+// queue subscribers to every topic
+func newMachine(t *rapid.T, b Broker) *machine {
+	n := runCount.Add(1)
+	m := &machine{
+		broker:     b,
+		subscribed: make(map[string]map[string]bool),
+		expected:   make(map[string][]string),
+		received:   make(map[string][]string),
+		pending:    make(map[string]map[string][]string),
+		inFlight:   make(map[string][]delivery),
+		acked:      make(map[string][]uint64),
+	}
+	for i := 0; i < topics; i++ {
+		m.topics = append(m.topics, fmt.Sprintf("conformance/%d/%d", n, i))
+	}
+	for i := 0; i < callbackSubscribers; i++ {
+		id := fmt.Sprintf("conformance-%d-callback-%d", n, i)
+		m.callbacks = append(m.callbacks, id)
+		m.subscribed[id] = make(map[string]bool)
+	}
+	for i := 0; i < queueSubscribers; i++ {
+		id := fmt.Sprintf("conformance-%d-queue-%d", n, i)
+		m.queues = append(m.queues, id)
+		m.pending[id] = make(map[string][]string)
+		for _, topic := range m.topics {
+			if err := b.Subscribe(id, topic, nil); err != nil {
+				t.Fatalf("subscribing queue %s to %s: %v", id, topic, err)
+			}
+		}
+	}
+	return m
+}
+
+// cleanup removes every subscriber of the check
+func (m *machine) cleanup() {
+	for _, id := range append(slices.Clone(m.callbacks), m.queues...) {
+		m.broker.Unsubscribe(id, "")
+	}
+}
+
+// callback returns the callback of a subscriber, recording what it receives
+func (m *machine) callback(subscriberID string) func(topic, message string) {
+	return func(topic, message string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.received[subscriberID] = append(m.received[subscriberID], topic+" "+message)
+	}
+}
+
+func (m *machine) subscribe(t *rapid.T) {
+	id := rapid.SampledFrom(m.callbacks).Draw(t, "subscriber")
+	var unsubscribed []string
+	for _, topic := range m.topics {
+		if !m.subscribed[id][topic] {
+			unsubscribed = append(unsubscribed, topic)
+		}
+	}
+	if len(unsubscribed) == 0 {
+		t.Skip("subscribed to every topic")
+	}
+	topic := rapid.SampledFrom(unsubscribed).Draw(t, "topic")
+
+	if err := m.broker.Subscribe(id, topic, m.callback(id)); err != nil {
+		t.Fatalf("subscribing %s to %s: %v", id, topic, err)
+	}
+	m.subscribed[id][topic] = true
+}
+
+func (m *machine) unsubscribe(t *rapid.T) {
+	id := rapid.SampledFrom(m.callbacks).Draw(t, "subscriber")
+	var subscribed []string
+	for _, topic := range m.topics {
+		if m.subscribed[id][topic] {
+			subscribed = append(subscribed, topic)
+		}
+	}
+	if len(subscribed) == 0 {
+		t.Skip("subscribed to no topic")
+	}
+	topic := rapid.SampledFrom(subscribed).Draw(t, "topic")
+
+	if err := m.broker.Unsubscribe(id, topic); err != nil {
+		t.Fatalf("unsubscribing %s from %s: %v", id, topic, err)
+	}
+	delete(m.subscribed[id], topic)
+}
+
+func (m *machine) publish(t *rapid.T) {
+	topic := rapid.SampledFrom(m.topics).Draw(t, "topic")
+	m.published++
+	message := fmt.Sprintf("m%d", m.published)
+
+	if err := m.broker.Publish(topic, message); err != nil {
+		t.Fatalf("publishing %s to %s: %v", message, topic, err)
+	}
+	for _, id := range m.callbacks {
+		if m.subscribed[id][topic] {
+			m.expected[id] = append(m.expected[id], topic+" "+message)
+		}
+	}
+	for _, id := range m.queues {
+		m.pending[id][topic] = append(m.pending[id][topic], message)
+	}
+}
+
+func (m *machine) getMessage(t *rapid.T) {
+	id := rapid.SampledFrom(m.queues).Draw(t, "subscriber")
+	topic := rapid.SampledFrom(m.topics).Draw(t, "topic")
+
+	msgTopic, message, ok, err := m.broker.GetMessage(id, topic)
+	if err != nil {
+		t.Fatalf("reading %s from %s: %v", id, topic, err)
+	}
+	if want, wantOK := m.next(id, topic); ok != wantOK || message != want || (ok && msgTopic != topic) {
+		t.Fatalf("reading %s from %s got %q from %q (present: %v), want %q (present: %v)", id, topic, message, msgTopic, ok, want, wantOK)
+	}
+}
+
+func (m *machine) fetch(t *rapid.T) {
+	id := rapid.SampledFrom(m.queues).Draw(t, "subscriber")
+	topic := rapid.SampledFrom(m.topics).Draw(t, "topic")
+
+	tag, message, ok, err := m.broker.Fetch(id, topic)
+	if err != nil {
+		t.Fatalf("fetching %s from %s: %v", id, topic, err)
+	}
+	if want, wantOK := m.next(id, topic); ok != wantOK || message != want {
+		t.Fatalf("fetching %s from %s got %q (present: %v), want %q (present: %v)", id, topic, message, ok, want, wantOK)
+	}
+	if ok {
+		m.inFlight[id] = append(m.inFlight[id], delivery{tag, message})
+	}
+}
+
+func (m *machine) ack(t *rapid.T) {
+	id := rapid.SampledFrom(m.queues).Draw(t, "subscriber")
+	if len(m.inFlight[id]) == 0 {
+		t.Skip("nothing in flight")
+	}
+	i := rapid.IntRange(0, len(m.inFlight[id])-1).Draw(t, "delivery")
+	d := m.inFlight[id][i]
+
+	if err := m.broker.Ack(id, d.tag); err != nil {
+		t.Fatalf("acking %s of %s: %v", d.message, id, err)
+	}
+	m.inFlight[id] = slices.Delete(m.inFlight[id], i, i+1)
+	m.acked[id] = append(m.acked[id], d.tag)
+}
+
+func (m *machine) ackAgain(t *rapid.T) {
+	id := rapid.SampledFrom(m.queues).Draw(t, "subscriber")
+	if len(m.acked[id]) == 0 {
+		t.Skip("nothing acked")
+	}
+	tag := rapid.SampledFrom(m.acked[id]).Draw(t, "tag")
+
+	if err := m.broker.Ack(id, tag); err == nil {
+		t.Fatalf("acking delivery %d of %s a second time succeeded", tag, id)
+	}
+}
+
+// checkCallbacks runs after every step, expecting each callback subscriber to
+// have received exactly what was published to it while it was subscribed
+func (m *machine) checkCallbacks(t *rapid.T) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range m.callbacks {
+		if !slices.Equal(m.received[id], m.expected[id]) {
+			t.Fatalf("%s received %q, want %q", id, m.received[id], m.expected[id])
+		}
+	}
+}
+
+// drain reads what is left of every queue at the end of a check, expecting
+// the messages not yet read and then nothing, not even those in flight
+func (m *machine) drain(t *rapid.T) {
+	for _, id := range m.queues {
+		for _, topic := range m.topics {
+			for len(m.pending[id][topic]) > 0 {
+				want, _ := m.next(id, topic)
+				_, message, ok, err := m.broker.GetMessage(id, topic)
+				if err != nil || !ok || message != want {
+					t.Fatalf("draining %s from %s got %q (present: %v, error: %v), want %q", id, topic, message, ok, err, want)
+				}
+			}
+		}
+		if _, message, ok, err := m.broker.GetMessage(id, ""); err != nil || ok {
+			t.Fatalf("%s got %q (error: %v) after every message was read", id, message, err)
+		}
+	}
+}
+
+// next removes and returns the message the model expects a queue subscriber
+// to read next from a topic, and false if there is none
+func (m *machine) next(subscriberID, topic string) (string, bool) {
+	queue := m.pending[subscriberID][topic]
+	if len(queue) == 0 {
+		return "", false
+	}
+	m.pending[subscriberID][topic] = queue[1:]
+	return queue[0], true
+}
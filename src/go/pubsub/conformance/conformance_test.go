@@ -0,0 +1,21 @@
+package conformance_test
+
+import (
+	"testing"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/benchmarks"
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/conformance"
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/mock"
+)
+
+func TestFFI(t *testing.T) {
+	conformance.Run(t, benchmarks.NewFFI())
+}
+
+func TestMemory(t *testing.T) {
+	conformance.Run(t, benchmarks.NewMemory())
+}
+
+func TestMock(t *testing.T) {
+	conformance.Run(t, mock.New())
+}
@@ -0,0 +1,10 @@
+module github.com/jbrinkman/go-rust-ffi/go/pubsub/conformance
+
+go 1.23.6
+
+require (
+	github.com/jbrinkman/go-rust-ffi/go v0.0.0
+	pgregory.net/rapid v1.2.0
+)
+
+replace github.com/jbrinkman/go-rust-ffi/go => ../..
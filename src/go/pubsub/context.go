@@ -0,0 +1,65 @@
+package pubsub
+
+import "context"
+
+// ScopedPublisher publishes with a fixed set of headers, such as the
+// correlation IDs of the request being served
+type ScopedPublisher struct {
+	headers map[string]string
+}
+
+type scopedPublisherKey struct{}
+
+// NewContext returns a context carrying a publisher that stamps headers on
+// everything it publishes. Headers already on ctx's publisher are kept unless
+// headers replaces them.
+func NewContext(ctx context.Context, headers map[string]string) context.Context {
+	merged := make(map[string]string)
+	if parent, ok := ctx.Value(scopedPublisherKey{}).(*ScopedPublisher); ok {
+		for name, value := range parent.headers {
+			merged[name] = value
+		}
+	}
+	for name, value := range headers {
+		merged[name] = value
+	}
+	return context.WithValue(ctx, scopedPublisherKey{}, &ScopedPublisher{headers: merged})
+}
+
+// FromContext returns the publisher of a context made with NewContext, or one
+// without headers
+func FromContext(ctx context.Context) *ScopedPublisher {
+	if p, ok := ctx.Value(scopedPublisherKey{}).(*ScopedPublisher); ok {
+		return p
+	}
+	return &ScopedPublisher{}
+}
+
+// Header returns the value of one of the publisher's headers
+func (p *ScopedPublisher) Header(name string) string {
+	return p.headers[name]
+}
+
+// Publish publishes a message like Publish, with the publisher's headers.
+// Headers set with WithHeader in opts take precedence.
+func (p *ScopedPublisher) Publish(topic, message string, opts ...PublishOption) error {
+	return Publish(topic, message, p.options(opts)...)
+}
+
+// PublishBytes publishes a binary payload like PublishBytes, with the
+// publisher's headers
+func (p *ScopedPublisher) PublishBytes(topic string, payload []byte, opts ...PublishOption) error {
+	return PublishBytes(topic, payload, p.options(opts)...)
+}
+
+// options prepends the publisher's headers to opts
+func (p *ScopedPublisher) options(opts []PublishOption) []PublishOption {
+	if len(p.headers) == 0 {
+		return opts
+	}
+	withHeaders := make([]PublishOption, 0, len(p.headers)+len(opts))
+	for name, value := range p.headers {
+		withHeaders = append(withHeaders, WithHeader(name, value))
+	}
+	return append(withHeaders, opts...)
+}
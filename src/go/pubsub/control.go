@@ -0,0 +1,355 @@
+package pubsub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ControlTopic accepts signed administrative commands once EnableControl is
+// called, so a broker can be administered through any gateway that can
+// publish to it. It is the one reserved topic callers may publish to.
+const ControlTopic = "$control"
+
+// SysControl receives the ControlResult of every command published to
+// ControlTopic, as JSON
+const SysControl = "$SYS/control"
+
+// Commands accepted on ControlTopic
+const (
+	// ControlPause and ControlResume pause or resume every current
+	// subscription to Topic, or only SubscriberID's if it is set
+	ControlPause  = "pause"
+	ControlResume = "resume"
+	// ControlEvict unsubscribes SubscriberID from every topic
+	ControlEvict = "evict"
+	// ControlSetLimits changes the limits set in Limits, keeping the others
+	ControlSetLimits = "set_limits"
+)
+
+// controlWindow is how far a command's IssuedAt may be from the broker's
+// clock, and so how long command IDs are remembered to refuse replays
+const controlWindow = time.Minute
+
+// maxPendingControl is how many signed commands may wait to be applied. Those
+// arriving while it is full are dropped.
+const maxPendingControl = 64
+
+// ControlCommand is an administrative command published to ControlTopic,
+// signed with SignControlCommand
+type ControlCommand struct {
+	Command      string        `json:"command"`
+	Topic        string        `json:"topic,omitempty"`
+	SubscriberID string        `json:"subscriber_id,omitempty"`
+	Limits       *ConfigLimits `json:"limits,omitempty"`
+	// ID identifies the command in its ControlResult. A command with an ID
+	// already seen is refused, so a captured command can't be replayed.
+	ID string `json:"id"`
+	// IssuedAt must be within a minute of the broker's clock
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+// ControlResult reports what became of a command published to ControlTopic
+type ControlResult struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	// Error is why the command was refused or failed, empty if it was applied
+	Error string `json:"error,omitempty"`
+}
+
+// signedCommand is the message published to ControlTopic: a command and the
+// hex HMAC-SHA256 of its JSON, which is kept as sent so the signature covers
+// exactly the bytes signed
+type signedCommand struct {
+	Command   json.RawMessage `json:"command"`
+	Signature string          `json:"signature"`
+}
+
+// SignControlCommand returns the message to publish to ControlTopic for a
+// command, signed with the key given to EnableControl. A command without an
+// IssuedAt is issued now.
+func SignControlCommand(key []byte, command ControlCommand) (string, error) {
+	if command.IssuedAt.IsZero() {
+		command.IssuedAt = time.Now()
+	}
+	encoded, err := json.Marshal(command)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode control command: %w", err)
+	}
+	signed, err := json.Marshal(signedCommand{Command: encoded, Signature: controlSignature(key, encoded)})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode control command: %w", err)
+	}
+	return string(signed), nil
+}
+
+func controlSignature(key, command []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(command)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var control struct {
+	sync.Mutex
+	key     []byte
+	enabled bool
+	// seen holds the IDs of recent commands and when they were accepted
+	// under seenKey. It outlives DisableControl, so disabling and enabling
+	// again with the same key doesn't reopen a window for replays.
+	seen    map[string]time.Time
+	seenKey []byte
+	// pending holds commands whose signature was checked, waiting to be
+	// applied, up to maxPendingControl
+	pending []signedCommand
+	wake    chan struct{}
+}
+
+// EnableControl starts accepting commands on ControlTopic signed with key,
+// which replaces any key given before. Commands are applied in order on a
+// goroutine of their own, or in deterministic mode before the publish
+// returns, through the same functions a caller would use, so they are audited
+// as usual, and each one's result is published on SysControl. Messages that
+// aren't signed with the key are dropped with a warning and get no result.
+// The IDs of applied commands are remembered across DisableControl until the
+// key changes.
+func EnableControl(key []byte) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"control": "enabled"}}, err)
+	}()
+
+	if len(key) == 0 {
+		return errors.New("failed to enable control topic: key is empty")
+	}
+
+	control.Lock()
+	defer control.Unlock()
+
+	control.key = append([]byte(nil), key...)
+	// Commands seen under another key can't be replayed under this one
+	if control.seen == nil || !hmac.Equal(control.seenKey, key) {
+		control.seen = make(map[string]time.Time)
+		control.seenKey = control.key
+	}
+	if control.enabled {
+		return nil
+	}
+	if err := subscribe("$control", ControlTopic, FromCallback(queueControl), nil); err != nil {
+		return fmt.Errorf("failed to enable control topic: %w", err)
+	}
+	control.enabled = true
+	return nil
+}
+
+// DisableControl stops accepting commands on ControlTopic
+func DisableControl() (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"control": "disabled"}}, err)
+	}()
+
+	control.Lock()
+	defer control.Unlock()
+
+	if !control.enabled {
+		return nil
+	}
+	if err := unsubscribe("$control", ""); err != nil {
+		return fmt.Errorf("failed to disable control topic: %w", err)
+	}
+	control.enabled = false
+	control.key = nil
+	control.pending = nil
+	return nil
+}
+
+// queueControl queues a message from ControlTopic if it is signed with the
+// key. It runs under the broker lock, which commands need, so they are
+// applied from runControl, or in deterministic mode by the publish once the
+// core has returned.
+func queueControl(topic, message string) {
+	var signed signedCommand
+	if err := json.Unmarshal([]byte(message), &signed); err != nil {
+		warnControl("malformed control message")
+		return
+	}
+
+	control.Lock()
+	switch {
+	case !control.enabled:
+		control.Unlock()
+		return
+	case !hmac.Equal([]byte(controlSignature(control.key, signed.Command)), []byte(signed.Signature)):
+		control.Unlock()
+		warnControl("invalid signature")
+		return
+	case len(control.pending) >= maxPendingControl:
+		control.Unlock()
+		warnControl("too many commands pending")
+		return
+	}
+	control.pending = append(control.pending, signed)
+	if deterministic.Load() {
+		control.Unlock()
+		return
+	}
+	if control.wake == nil {
+		control.wake = make(chan struct{}, 1)
+		go runControl()
+	}
+	control.Unlock()
+	select {
+	case control.wake <- struct{}{}:
+	default:
+	}
+}
+
+// warnControl logs a control message dropped before it was queued
+func warnControl(reason string) {
+	if l := logger.Load(); l != nil {
+		l.Warn("dropped control message", "reason", reason)
+	}
+}
+
+// runControl applies queued commands in order
+func runControl() {
+	for range control.wake {
+		applyPendingControl()
+	}
+}
+
+// drainControl applies the commands a publish to ControlTopic queued in
+// deterministic mode, where no goroutine does. Publishes call it once the core
+// has returned and released its lock.
+func drainControl() {
+	if deterministic.Load() {
+		applyPendingControl()
+	}
+}
+
+// applyPendingControl applies the queued commands in order
+func applyPendingControl() {
+	control.Lock()
+	commands := control.pending
+	control.pending = nil
+	control.Unlock()
+
+	for _, signed := range commands {
+		applyControl(signed)
+	}
+}
+
+// applyControl checks a command's signature, age and ID, applies it and
+// publishes its result
+func applyControl(signed signedCommand) {
+	var command ControlCommand
+	if err := json.Unmarshal(signed.Command, &command); err != nil {
+		publishControlResult(ControlResult{Error: "malformed control command"})
+		return
+	}
+	result := ControlResult{ID: command.ID, Command: command.Command}
+	if err := acceptControl(signed, command); err != nil {
+		result.Error = err.Error()
+		if l := logger.Load(); l != nil {
+			l.Warn("refused control command", "id", command.ID, "command", command.Command, "error", err)
+		}
+		publishControlResult(result)
+		return
+	}
+
+	if err := runControlCommand(command); err != nil {
+		result.Error = err.Error()
+	}
+	publishControlResult(result)
+}
+
+// acceptControl checks that a command is signed with the current key, recent
+// and not seen before, and remembers its ID
+func acceptControl(signed signedCommand, command ControlCommand) error {
+	control.Lock()
+	defer control.Unlock()
+
+	if !control.enabled {
+		return errors.New("control topic is disabled")
+	}
+	expected := controlSignature(control.key, signed.Command)
+	if !hmac.Equal([]byte(expected), []byte(signed.Signature)) {
+		return errors.New("invalid signature")
+	}
+	now := clockNow()
+	if command.IssuedAt.Before(now.Add(-controlWindow)) || command.IssuedAt.After(now.Add(controlWindow)) {
+		return errors.New("command was not issued within the last minute")
+	}
+	if command.ID == "" {
+		return errors.New("command has no ID")
+	}
+	for id, accepted := range control.seen {
+		if now.Sub(accepted) > 2*controlWindow {
+			delete(control.seen, id)
+		}
+	}
+	if _, ok := control.seen[command.ID]; ok {
+		return fmt.Errorf("command '%s' was already applied", command.ID)
+	}
+	control.seen[command.ID] = now
+	return nil
+}
+
+// runControlCommand applies an accepted command
+func runControlCommand(command ControlCommand) error {
+	switch command.Command {
+	case ControlPause, ControlResume:
+		apply := Pause
+		if command.Command == ControlResume {
+			apply = Resume
+		}
+		if command.SubscriberID != "" {
+			return apply(command.SubscriberID, command.Topic)
+		}
+		subscribers, err := Presence(command.Topic)
+		if err != nil {
+			return err
+		}
+		var errs []error
+		for _, subscriber := range subscribers {
+			if subscriber.Group == "" {
+				errs = append(errs, apply(subscriber.SubscriberID, command.Topic))
+			}
+		}
+		return errors.Join(errs...)
+	case ControlEvict:
+		return Unsubscribe(command.SubscriberID, "")
+	case ControlSetLimits:
+		if command.Limits == nil {
+			return errors.New("set_limits needs limits")
+		}
+		limits := GetLimits()
+		if command.Limits.MaxTopicSize > 0 {
+			limits.MaxTopicSize = command.Limits.MaxTopicSize
+		}
+		if command.Limits.MaxMessageSize > 0 {
+			limits.MaxMessageSize = command.Limits.MaxMessageSize
+		}
+		if command.Limits.MaxTopics > 0 {
+			limits.MaxTopics = command.Limits.MaxTopics
+		}
+		if command.Limits.MaxSubscribersPerTopic > 0 {
+			limits.MaxSubscribersPerTopic = command.Limits.MaxSubscribersPerTopic
+		}
+		return SetLimits(limits)
+	default:
+		return fmt.Errorf("unknown command '%s'", command.Command)
+	}
+}
+
+// publishControlResult publishes a command's result on SysControl
+func publishControlResult(result ControlResult) {
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	publish(SysControl, string(encoded), nil, nil)
+}
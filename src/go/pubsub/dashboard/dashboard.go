@@ -0,0 +1,100 @@
+// Package dashboard serves a single-page web dashboard for the broker: its
+// topics as they come and go, throughput charts, subscriber lag and a peek at
+// the messages queued for each subscriber. The page is embedded in the
+// binary, so serving it needs no files:
+//
+//	http.Handle("/dashboard/", http.StripPrefix("/dashboard", dashboard.Handler()))
+//
+// Like the admin API it is built on, which it serves under api/, the
+// dashboard lets anyone who reaches it delete topics and read messages.
+// Serve it only behind middleware that authenticates operators.
+package dashboard
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/admin"
+)
+
+//go:embed static
+var static embed.FS
+
+// statsInterval is how often the event stream sends the broker's stats
+const statsInterval = time.Second
+
+// Handler serves the dashboard at /, the admin API it reads under /api/, and
+// the server-sent event stream that keeps it live at /events. Mount it at a
+// path ending in a slash, since the page refers to the others relative to
+// itself.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServerFS(files))
+	mux.Handle("/api/", http.StripPrefix("/api", admin.Handler()))
+	mux.HandleFunc("GET /events", events)
+	return mux
+}
+
+// events streams the broker's stats every statsInterval as "stats" events,
+// and the lifecycle of topics other than reserved ones as "topic" events,
+// until the client goes away
+func events(w http.ResponseWriter, r *http.Request) {
+	topics, err := pubsub.WatchTopics(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	rc := http.NewResponseController(w)
+
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+
+	writeStats(w)
+	for {
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+			writeStats(w)
+		case event, ok := <-topics:
+			// Closed once the request's context is done
+			if !ok {
+				return
+			}
+			if !strings.HasPrefix(event.Topic, pubsub.ReservedPrefix) {
+				writeEvent(w, "topic", event)
+			}
+		}
+	}
+}
+
+func writeStats(w http.ResponseWriter) {
+	stats, err := admin.GetStats()
+	if err != nil {
+		return
+	}
+	writeEvent(w, "stats", stats)
+}
+
+func writeEvent(w http.ResponseWriter, name string, data any) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded)
+}
@@ -0,0 +1,270 @@
+// Dashboard page: live stats from the event stream, topics and subscribers
+// from the admin API. Everything taken from the broker is set as text, never
+// as HTML, since topic names and message contents come from publishers.
+"use strict";
+
+// Points kept in the throughput chart, one per stats event
+const chartPoints = 60;
+
+const state = {
+  previous: null,
+  series: { published: [], delivered: [], dropped: [] },
+  selectedTopic: null,
+  topicsRefresh: null,
+};
+
+const $ = (id) => document.getElementById(id);
+
+async function api(method, path) {
+  const response = await fetch("api" + path, { method });
+  if (!response.ok) {
+    const body = await response.json().catch(() => ({}));
+    throw new Error(body.error || response.statusText);
+  }
+  return response.status === 204 ? null : response.json();
+}
+
+function topicPath(topic) {
+  return "/topics/" + encodeURIComponent(topic);
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text;
+  if (className) {
+    td.className = className;
+  }
+  return td;
+}
+
+function button(td, label, onClick, className) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  if (className) {
+    b.className = className;
+  }
+  b.addEventListener("click", onClick);
+  td.appendChild(b);
+}
+
+// Stats
+
+function onStats(stats) {
+  $("total-subscribers").textContent = stats.subscribers;
+  $("total-queued").textContent = stats.queue_depth;
+  $("total-published").textContent = stats.published;
+  $("total-delivered").textContent = stats.delivered;
+  $("total-dropped").textContent = stats.dropped;
+
+  const now = performance.now();
+  if (state.previous) {
+    const seconds = (now - state.previous.at) / 1000;
+    for (const name of Object.keys(state.series)) {
+      const rate = Math.max(0, stats[name] - state.previous.stats[name]) / seconds;
+      const series = state.series[name];
+      series.push(rate);
+      if (series.length > chartPoints) {
+        series.shift();
+      }
+    }
+    drawChart();
+  }
+  state.previous = { stats, at: now };
+
+  renderLag(stats.subscriptions);
+}
+
+function renderLag(subscriptions) {
+  const body = $("lag").tBodies[0];
+  body.replaceChildren();
+  const sorted = [...subscriptions].sort((a, b) => b.lag.p95_us - a.lag.p95_us);
+  for (const s of sorted) {
+    if (s.topic.startsWith("$")) {
+      continue;
+    }
+    const row = body.insertRow();
+    cell(row, s.subscriber_id);
+    cell(row, s.topic);
+    cell(row, s.lag.p50_us, "number");
+    cell(row, s.lag.p95_us, "number");
+    cell(row, s.lag.p99_us, "number");
+  }
+}
+
+function drawChart() {
+  const canvas = $("throughput");
+  const ratio = window.devicePixelRatio || 1;
+  const width = canvas.clientWidth;
+  const height = canvas.clientHeight;
+  canvas.width = width * ratio;
+  canvas.height = height * ratio;
+
+  const ctx = canvas.getContext("2d");
+  ctx.scale(ratio, ratio);
+  ctx.clearRect(0, 0, width, height);
+
+  const styles = getComputedStyle(document.documentElement);
+  const all = Object.values(state.series).flat();
+  const max = Math.max(1, ...all) * 1.1;
+  const step = width / (chartPoints - 1);
+
+  ctx.fillStyle = styles.getPropertyValue("--muted");
+  ctx.font = "11px system-ui, sans-serif";
+  ctx.fillText(max.toFixed(max < 10 ? 1 : 0), 4, 12);
+
+  for (const [name, series] of Object.entries(state.series)) {
+    ctx.strokeStyle = styles.getPropertyValue("--" + name);
+    ctx.lineWidth = 2;
+    ctx.beginPath();
+    const offset = chartPoints - series.length;
+    series.forEach((rate, i) => {
+      const x = (offset + i) * step;
+      const y = height - (rate / max) * height;
+      if (i === 0) {
+        ctx.moveTo(x, y);
+      } else {
+        ctx.lineTo(x, y);
+      }
+    });
+    ctx.stroke();
+  }
+}
+
+// Topics
+
+async function refreshTopics() {
+  let topics;
+  try {
+    topics = await api("GET", "/topics");
+  } catch (err) {
+    return;
+  }
+
+  // Counted here rather than from the stats, which count reserved topics
+  $("total-topics").textContent = topics.length;
+  const body = $("topics").tBodies[0];
+  body.replaceChildren();
+  for (const topic of topics) {
+    const row = body.insertRow();
+    if (topic.name === state.selectedTopic) {
+      row.className = "selected";
+    }
+    const name = cell(row, "");
+    const link = document.createElement("a");
+    link.textContent = topic.name;
+    link.addEventListener("click", () => selectTopic(topic.name));
+    name.appendChild(link);
+    cell(row, topic.delivery);
+    cell(row, topic.subscribers, "number");
+    button(cell(row, ""), "Delete", () => deleteTopic(topic.name), "danger");
+  }
+
+  if (state.selectedTopic && !topics.some((t) => t.name === state.selectedTopic)) {
+    state.selectedTopic = null;
+    $("topic").hidden = true;
+  }
+}
+
+// Coalesces the refreshes asked for by bursts of topic events
+function scheduleTopicsRefresh() {
+  if (state.topicsRefresh === null) {
+    state.topicsRefresh = setTimeout(() => {
+      state.topicsRefresh = null;
+      refreshTopics();
+    }, 250);
+  }
+}
+
+async function deleteTopic(topic) {
+  if (!confirm(`Delete topic "${topic}" and its subscriptions?`)) {
+    return;
+  }
+  try {
+    await api("DELETE", topicPath(topic));
+  } catch (err) {
+    alert(`Failed to delete "${topic}": ${err.message}`);
+  }
+  refreshTopics();
+}
+
+async function selectTopic(topic) {
+  state.selectedTopic = topic;
+  $("topic-name").textContent = topic;
+  $("topic").hidden = false;
+  $("peek").hidden = true;
+  await refreshSubscribers();
+  refreshTopics();
+}
+
+async function refreshSubscribers() {
+  const topic = state.selectedTopic;
+  if (topic === null) {
+    return;
+  }
+  let subscribers;
+  try {
+    subscribers = await api("GET", topicPath(topic) + "/subscribers");
+  } catch (err) {
+    return;
+  }
+
+  const body = $("subscribers").tBodies[0];
+  body.replaceChildren();
+  for (const s of subscribers) {
+    const row = body.insertRow();
+    cell(row, s.subscriber_id);
+    cell(row, s.group || "");
+    cell(row, new Date(s.joined_at).toLocaleString());
+    cell(row, s.queue_depth, "number");
+    button(cell(row, ""), "Peek", () => peek(topic, s.subscriber_id));
+  }
+}
+
+async function peek(topic, subscriberID) {
+  $("peek-subscriber").textContent = subscriberID;
+  $("peek").hidden = false;
+  try {
+    const result = await api("GET", topicPath(topic) + "/subscribers/" + encodeURIComponent(subscriberID) + "/peek");
+    $("peek-content").textContent = result.content === null
+      ? "(queue is empty)"
+      : formatContent(result.content);
+  } catch (err) {
+    $("peek-content").textContent = `(${err.message})`;
+  }
+}
+
+// Indents JSON messages, leaving others as they are
+function formatContent(content) {
+  try {
+    return JSON.stringify(JSON.parse(content), null, 2);
+  } catch (err) {
+    return content;
+  }
+}
+
+// Event stream
+
+function connect() {
+  const source = new EventSource("events");
+  source.addEventListener("open", () => {
+    $("connection").textContent = "live";
+    $("connection").className = "status live";
+    refreshTopics();
+  });
+  source.addEventListener("error", () => {
+    // EventSource reconnects by itself
+    $("connection").textContent = "reconnecting";
+    $("connection").className = "status lost";
+    state.previous = null;
+  });
+  source.addEventListener("stats", (event) => {
+    onStats(JSON.parse(event.data));
+    // Subscriber counts and queue depths change without topic events
+    refreshSubscribers();
+    scheduleTopicsRefresh();
+  });
+  source.addEventListener("topic", scheduleTopicsRefresh);
+}
+
+window.addEventListener("resize", drawChart);
+connect();
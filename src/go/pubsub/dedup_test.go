@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"errors"
+	"testing"
+)
+
+// A retried publish of a message ID already seen is dropped before it is
+// charged to a quota or evicts anything to make room
+
+func TestDuplicatePublishUnderQuota(t *testing.T) {
+	if err := Subscribe("dup-quota-test", "dupquota/events", nil); err != nil {
+		t.Fatal(err)
+	}
+	defer Unsubscribe("dup-quota-test", "")
+	if err := SetNamespaceQuota("dupquota", Quota{BytesPerDay: 5}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetNamespaceQuota("dupquota", Quota{})
+
+	if err := Publish("dupquota/events", "hello", WithMessageID("dup-quota-1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Publish("dupquota/events", "hello", WithMessageID("dup-quota-1")); !errors.Is(err, ErrDuplicateMessage) {
+		t.Fatalf("retry returned %v, want ErrDuplicateMessage", err)
+	}
+
+	stats, err := Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, usage := range stats.Quotas {
+		if usage.Scope == QuotaNamespace && usage.Name == "dupquota" && (usage.BytesToday != 5 || usage.Rejected != 0) {
+			t.Fatalf("got quota usage %+v, want the retry neither charged nor rejected", usage)
+		}
+	}
+}
+
+func TestDuplicatePublishUnderMemoryLimit(t *testing.T) {
+	if err := Subscribe("dup-memory-test", "test/dup-memory", nil); err != nil {
+		t.Fatal(err)
+	}
+	defer Unsubscribe("dup-memory-test", "")
+
+	if err := Publish("test/dup-memory", "hello", WithMessageID("dup-memory-1")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetMemoryLimit(stats.Memory.TotalBytes, MemoryPolicyEvictOldest); err != nil {
+		t.Fatal(err)
+	}
+	defer SetMemoryLimit(0, MemoryPolicyReject)
+
+	if err := Publish("test/dup-memory", "hello", WithMessageID("dup-memory-1")); !errors.Is(err, ErrDuplicateMessage) {
+		t.Fatalf("retry returned %v, want ErrDuplicateMessage", err)
+	}
+	if depth := QueueDepth("dup-memory-test", "test/dup-memory"); depth != 1 {
+		t.Fatalf("got %d messages queued, want the first kept", depth)
+	}
+}
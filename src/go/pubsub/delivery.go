@@ -0,0 +1,111 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrDeliveryMode is returned when a subscription or topic doesn't fit the
+// delivery mode of a topic, such as joining a consumer group on a fanout
+// topic or creating a queue topic that exists as a fanout one
+var ErrDeliveryMode = errors.New("delivery mode conflict")
+
+// ErrOrderingKeyRequired is returned when publishing to a keyed topic without
+// WithOrderingKey
+var ErrOrderingKeyRequired = errors.New("topic requires an ordering key")
+
+// DeliveryMode is how a topic delivers its messages to its subscribers. The
+// core enforces it, so consumers can't mix ways of consuming a topic by
+// accident.
+type DeliveryMode int
+
+const (
+	// DeliveryAny is the mode of topics created by subscribing. Subscribers
+	// each get every message, and consumer groups joined WithGroup share
+	// them, side by side.
+	DeliveryAny DeliveryMode = C.DELIVERY_ANY
+	// DeliveryFanout gives every subscriber every message. Joining a
+	// consumer group fails with ErrDeliveryMode.
+	DeliveryFanout DeliveryMode = C.DELIVERY_FANOUT
+	// DeliveryQueue gives each message to one subscriber. Subscribers share
+	// the messages as if they had all joined one consumer group, balanced
+	// round-robin unless a message has an ordering key. Joining a named
+	// group fails with ErrDeliveryMode, and WithBackfill has no effect.
+	DeliveryQueue DeliveryMode = C.DELIVERY_QUEUE
+	// DeliveryKeyed gives each message to the subscriber owning its ordering
+	// key's partition, like DeliveryQueue, and fails publishes without
+	// WithOrderingKey with ErrOrderingKeyRequired.
+	DeliveryKeyed DeliveryMode = C.DELIVERY_KEYED
+)
+
+func (m DeliveryMode) String() string {
+	switch m {
+	case DeliveryAny:
+		return "any"
+	case DeliveryFanout:
+		return "fanout"
+	case DeliveryQueue:
+		return "queue"
+	case DeliveryKeyed:
+		return "keyed"
+	default:
+		return fmt.Sprintf("DeliveryMode(%d)", int(m))
+	}
+}
+
+// deliveryModeByName returns the delivery mode with a name, as in a Config
+func deliveryModeByName(name string) (DeliveryMode, error) {
+	for _, mode := range []DeliveryMode{DeliveryAny, DeliveryFanout, DeliveryQueue, DeliveryKeyed} {
+		if mode.String() == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown delivery mode '%s'", name)
+}
+
+// CreateTopic creates a topic with a delivery mode, which it keeps until it
+// is deleted or collected after SetTopicIdleTTL. Topics are otherwise created
+// by their first subscription, with DeliveryAny. Creating a topic that exists
+// succeeds if it has the same mode, so every process using a topic can
+// declare it.
+func CreateTopic(topic string, mode DeliveryMode) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Topic: topic, Details: map[string]string{"delivery": mode.String()}}, err)
+	}()
+
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+
+	switch mode {
+	case DeliveryAny, DeliveryFanout, DeliveryQueue, DeliveryKeyed:
+	default:
+		return fmt.Errorf("failed to create topic '%s': unknown delivery mode %d", topic, int(mode))
+	}
+
+	if !coreCreateTopic(topic, uint32(mode)) {
+		if current := TopicDeliveryMode(topic); current != DeliveryAny {
+			return fmt.Errorf("failed to create topic '%s' as %s: %w; it exists as %s", topic, mode, ErrDeliveryMode, current)
+		}
+		return checkInternal(fmt.Errorf("failed to create topic '%s': it exists without a delivery mode, or the topic limit is reached", topic))
+	}
+	return nil
+}
+
+// TopicDeliveryMode returns the delivery mode of a topic, DeliveryAny if it
+// was created without one or doesn't exist
+func TopicDeliveryMode(topic string) DeliveryMode {
+	return DeliveryMode(coreTopicDeliveryMode(ResolveTopic(topic)))
+}
+
+// checkDeliveryMode returns an error wrapping ErrDeliveryMode if a
+// subscription to a topic, in a consumer group or not, doesn't fit its mode
+func checkDeliveryMode(topic, group string) error {
+	if mode := TopicDeliveryMode(topic); group != "" && mode != DeliveryAny {
+		return fmt.Errorf("failed to join group '%s' on %s topic '%s': %w", group, mode, topic, ErrDeliveryMode)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// deterministic is set while the broker runs in deterministic mode
+var deterministic atomic.Bool
+
+// manualClock is the time seen by the Go side of the broker in deterministic mode
+var manualClock struct {
+	sync.Mutex
+	now time.Time
+}
+
+// SetDeterministic switches the broker to a mode meant for tests. Publish
+// delivers to every subscriber, including relaxed topics, before it returns,
+// PublishAsync publishes before it returns, handler timeouts are not enforced
+// and no background goroutines or threads run. Subscriber and topic TTLs,
+// batch delays, $SYS stats and circuit breaker probes run on a manual clock
+// that only moves with AdvanceClock, so tests don't need to sleep. Turning
+// the mode off goes back to the system clock and restarts the background work
+// the current settings need.
+func SetDeterministic(enabled bool) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"deterministic": strconv.FormatBool(enabled)}}, err)
+	}()
+
+	success := C.set_deterministic(C.bool(enabled))
+	if !success {
+		return checkInternal(errors.New("failed to set deterministic mode"))
+	}
+
+	manualClock.Lock()
+	if enabled && !deterministic.Load() {
+		manualClock.now = time.Now()
+	}
+	deterministic.Store(enabled)
+	manualClock.Unlock()
+
+	return nil
+}
+
+// AdvanceClock moves the manual clock of deterministic mode forward by d and
+// runs the work that came due before returning: expiring subscribers and
+// topics, flushing batches and publishing $SYS stats.
+func AdvanceClock(d time.Duration) error {
+	if d < 0 {
+		return errors.New("failed to advance clock: duration must not be negative")
+	}
+
+	manualClock.Lock()
+	if !deterministic.Load() {
+		manualClock.Unlock()
+		return errors.New("failed to advance clock: broker is not in deterministic mode")
+	}
+	manualClock.now = manualClock.now.Add(d)
+	manualClock.Unlock()
+
+	// Deliveries run handlers that may read the clock, so it isn't held here
+	success := C.advance_clock(C.uint64_t(d.Nanoseconds()))
+	if !success {
+		return checkInternal(errors.New("failed to advance clock"))
+	}
+
+	return nil
+}
+
+// clockNow is time.Now, or the manual clock in deterministic mode
+func clockNow() time.Time {
+	if !deterministic.Load() {
+		return time.Now()
+	}
+
+	manualClock.Lock()
+	defer manualClock.Unlock()
+	return manualClock.now
+}
@@ -0,0 +1,140 @@
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DispatcherConfig sizes the worker pool delivering the messages of a
+// namespace's topics with relaxed ordering
+type DispatcherConfig struct {
+	// Workers is the number of goroutines running the namespace's handlers,
+	// and so the most that run at once
+	Workers int
+	// QueueSize is the number of deliveries that may wait for a worker. It
+	// defaults to 64 per worker.
+	QueueSize int
+}
+
+// dispatchPool is a pool of worker goroutines running deliveries
+type dispatchPool struct {
+	work chan func()
+}
+
+func newDispatchPool(workers, queueSize int) *dispatchPool {
+	p := &dispatchPool{work: make(chan func(), queueSize)}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for deliver := range p.work {
+				runDelivery(deliver)
+			}
+		}()
+	}
+	return p
+}
+
+// runDelivery runs a delivery on a worker. Handlers are already guarded, so
+// a panic here comes from a hook; it is logged rather than allowed to kill
+// the worker and the process.
+func runDelivery(deliver func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if l := logger.Load(); l != nil {
+				l.Error("delivery panicked", "panic", r)
+			}
+		}
+	}()
+	deliver()
+}
+
+// offer queues a delivery for a worker, or returns false if the queue is full
+func (p *dispatchPool) offer(deliver func()) bool {
+	select {
+	case p.work <- deliver:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the workers once they have run the deliveries already queued
+func (p *dispatchPool) close() {
+	close(p.work)
+}
+
+// namespaceDispatchers holds the pools of the namespaces given their own with
+// SetNamespaceDispatcher. Pools are offered work under the read lock, so one
+// is never closed while a delivery is being queued on it.
+var namespaceDispatchers = struct {
+	sync.RWMutex
+	pools map[string]*dispatchPool
+}{
+	pools: make(map[string]*dispatchPool),
+}
+
+// SetNamespaceDispatcher gives the topics of a namespace with relaxed ordering
+// a worker pool of their own, so that slow handlers in one namespace can't
+// starve deliveries in another. A delivery arriving while the namespace's
+// workers are busy and its queue is full is dropped and counted as such,
+// rather than run by the publisher as the shared pool does. A config with no
+// Workers returns the namespace to the shared pool. Deliveries already queued
+// on a replaced pool still run.
+//
+// Topics with strict ordering are delivered on the publisher's goroutine
+// whatever their namespace; combine the dispatcher with SetNamespaceQuota to
+// bound what a namespace publishes as well.
+func SetNamespaceDispatcher(namespace string, config DispatcherConfig) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{
+			"dispatcher_namespace": namespace,
+			"workers":              fmt.Sprint(config.Workers),
+			"queue_size":           fmt.Sprint(config.QueueSize),
+		}}, err)
+	}()
+
+	if namespace == "" || strings.Contains(namespace, "/") {
+		return fmt.Errorf("failed to set dispatcher: invalid namespace '%s'", namespace)
+	}
+	if strings.HasPrefix(namespace, ReservedPrefix) {
+		return fmt.Errorf("failed to set dispatcher for namespace '%s': %w", namespace, ErrReservedTopic)
+	}
+	if config.Workers < 0 || config.QueueSize < 0 {
+		return fmt.Errorf("failed to set dispatcher for namespace '%s': sizes can't be negative", namespace)
+	}
+
+	var pool *dispatchPool
+	if config.Workers > 0 {
+		queueSize := config.QueueSize
+		if queueSize == 0 {
+			queueSize = config.Workers * 64
+		}
+		pool = newDispatchPool(config.Workers, queueSize)
+	}
+
+	namespaceDispatchers.Lock()
+	defer namespaceDispatchers.Unlock()
+
+	if old, ok := namespaceDispatchers.pools[namespace]; ok {
+		old.close()
+	}
+	if pool != nil {
+		namespaceDispatchers.pools[namespace] = pool
+	} else {
+		delete(namespaceDispatchers.pools, namespace)
+	}
+	return nil
+}
+
+// dispatchNamespace offers a delivery to a namespace's own pool. isolated is
+// false if the namespace has none.
+func dispatchNamespace(namespace string, deliver func()) (accepted, isolated bool) {
+	namespaceDispatchers.RLock()
+	defer namespaceDispatchers.RUnlock()
+
+	pool, ok := namespaceDispatchers.pools[namespace]
+	if !ok {
+		return false, false
+	}
+	return pool.offer(deliver), true
+}
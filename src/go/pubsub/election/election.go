@@ -0,0 +1,354 @@
+// Package election elects a leader among the candidates subscribed to a
+// coordination topic, for work that only one worker should do at a time.
+// Leadership is a lease: the leader renews it in the background, and if it
+// stops, another candidate can take over once the lease runs out.
+//
+//	e, err := election.New(election.Config{OnChange: func(name, leader string) { ... }})
+//	if err := e.Campaign(ctx, "invoice-run"); err == nil {
+//		// this candidate leads "invoice-run" until it resigns or closes
+//	}
+//
+// Every candidate applies the claims on the topic in publish order, so all of
+// them agree on the leader of each election. The topic keeps no history, so a
+// new candidate spends half a lease learning the current leaders before it
+// makes claims of its own. Meanwhile it takes a claim for an election it knows
+// no leader of at its word, and a renewal as the word of the current leader,
+// since every live leader renews within that time.
+package election
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+const (
+	// DefaultTopic is the coordination topic when Config leaves it unset
+	DefaultTopic = "_election"
+	// DefaultLease is how long leadership lasts without renewal when Config
+	// leaves it unset
+	DefaultLease = 5 * time.Second
+)
+
+// ErrClosed is returned by Campaign once the candidate is closed
+var ErrClosed = errors.New("election candidate closed")
+
+// Config configures a candidate
+type Config struct {
+	// ID identifies the candidate; empty picks a random one
+	ID string
+	// Topic is the coordination topic shared by all candidates
+	Topic string
+	// Lease is how long leadership lasts without renewal. The leader renews
+	// it every third of a lease.
+	Lease time.Duration
+	// OnChange, if set, is called whenever the leader of an election changes,
+	// with an empty leader when an election has none. It is called from a
+	// single goroutine, in order.
+	OnChange func(name, leader string)
+}
+
+// claim is a message on the coordination topic
+type claim struct {
+	Election  string `json:"election"`
+	Candidate string `json:"candidate"`
+	Kind      string `json:"kind"`
+}
+
+const (
+	claimLead   = "lead"
+	claimRenew  = "renew"
+	claimResign = "resign"
+)
+
+// term is a candidate's view of an election's current leader
+type term struct {
+	leader  string
+	expires time.Time
+	// claimed is set on a term recorded from a lead claim while syncing, and
+	// cleared by the leader's renewal
+	claimed bool
+}
+
+// change is a leadership change waiting for OnChange
+type change struct {
+	name   string
+	leader string
+}
+
+// Candidate takes part in elections on a coordination topic
+type Candidate struct {
+	config Config
+
+	mu      sync.Mutex
+	terms   map[string]term
+	changed chan struct{} // closed and replaced on every change
+	pending []change
+	// synced is when the candidate has seen a renewal from every live leader
+	synced time.Time
+
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	close  sync.Once
+}
+
+// New subscribes a candidate to the coordination topic
+func New(config Config) (*Candidate, error) {
+	if config.ID == "" {
+		id := make([]byte, 8)
+		rand.Read(id)
+		config.ID = hex.EncodeToString(id)
+	}
+	if config.Topic == "" {
+		config.Topic = DefaultTopic
+	}
+	if config.Lease <= 0 {
+		config.Lease = DefaultLease
+	}
+
+	c := &Candidate{
+		config:  config,
+		terms:   make(map[string]term),
+		changed: make(chan struct{}),
+		synced:  time.Now().Add(config.Lease / 2),
+		notify:  make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if err := pubsub.Subscribe(c.subscriberID(), config.Topic, c.apply); err != nil {
+		return nil, fmt.Errorf("failed to join elections on '%s': %w", config.Topic, err)
+	}
+
+	go c.run()
+	return c, nil
+}
+
+// ID returns the candidate's ID
+func (c *Candidate) ID() string {
+	return c.config.ID
+}
+
+// Campaign blocks until the candidate leads the named election, ctx is done,
+// or the candidate is closed. Leadership then lasts until Resign or Close.
+func (c *Candidate) Campaign(ctx context.Context, name string) error {
+	if name == "" {
+		return errors.New("election requires a name")
+	}
+
+	for {
+		c.mu.Lock()
+		current, ok := c.terms[name]
+		changed := c.changed
+		c.mu.Unlock()
+
+		if ok && current.leader == c.config.ID {
+			return nil
+		}
+
+		// Claim only a vacant election; a live lease is waited out
+		wait := time.Until(current.expires)
+		if syncing := time.Until(c.synced); syncing > 0 {
+			wait = syncing
+		} else if !ok || wait < 0 {
+			if err := c.publish(name, claimLead); err != nil {
+				return err
+			}
+			wait = c.config.Lease / 3
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-c.stop:
+			timer.Stop()
+			return ErrClosed
+		}
+		timer.Stop()
+	}
+}
+
+// Resign gives up leadership of the named election, if the candidate has it
+func (c *Candidate) Resign(name string) error {
+	if !c.IsLeader(name) {
+		return nil
+	}
+	return c.publish(name, claimResign)
+}
+
+// Leader returns the current leader of the named election, or an empty
+// string if it has none
+func (c *Candidate) Leader(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, ok := c.terms[name]
+	if !ok || time.Now().After(current.expires) {
+		return ""
+	}
+	return current.leader
+}
+
+// IsLeader reports whether the candidate leads the named election
+func (c *Candidate) IsLeader(name string) bool {
+	return c.Leader(name) == c.config.ID
+}
+
+// Close resigns every election the candidate leads and leaves the topic
+func (c *Candidate) Close() error {
+	c.close.Do(func() {
+		c.mu.Lock()
+		var leading []string
+		for name, current := range c.terms {
+			if current.leader == c.config.ID {
+				leading = append(leading, name)
+			}
+		}
+		c.mu.Unlock()
+
+		for _, name := range leading {
+			c.publish(name, claimResign)
+		}
+		pubsub.Unsubscribe(c.subscriberID(), c.config.Topic)
+		close(c.stop)
+	})
+	<-c.done
+	return nil
+}
+
+func (c *Candidate) subscriberID() string {
+	return "election/" + c.config.ID
+}
+
+func (c *Candidate) publish(name, kind string) error {
+	data, _ := json.Marshal(claim{Election: name, Candidate: c.config.ID, Kind: kind})
+	if err := pubsub.Publish(c.config.Topic, string(data)); err != nil {
+		return fmt.Errorf("failed to publish %s claim for election '%s': %w", kind, name, err)
+	}
+	return nil
+}
+
+// apply updates the candidate's view from a claim. It runs as a callback
+// under the broker lock, so OnChange is left to the run goroutine.
+func (c *Candidate) apply(topic, message string) {
+	var m claim
+	if err := json.Unmarshal([]byte(message), &m); err != nil || m.Election == "" || m.Candidate == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	current, ok := c.terms[m.Election]
+	vacant := !ok || now.After(current.expires)
+	syncing := now.Before(c.synced)
+
+	switch m.Kind {
+	case claimLead:
+		// A claim seen while syncing is recorded too: the leader's first
+		// renewal may only come after the window closes
+		if vacant {
+			c.setLeader(m.Election, term{leader: m.Candidate, expires: now.Add(c.config.Lease), claimed: syncing})
+		}
+	case claimRenew:
+		// While syncing, a renewal also overrides a claim recorded before the
+		// current leader was seen, which the other candidates ignored
+		if syncing && (vacant || current.claimed) && current.leader != m.Candidate {
+			c.setLeader(m.Election, term{leader: m.Candidate, expires: now.Add(c.config.Lease)})
+		} else if !vacant && current.leader == m.Candidate {
+			c.terms[m.Election] = term{leader: m.Candidate, expires: now.Add(c.config.Lease)}
+		}
+	case claimResign:
+		if !vacant && current.leader == m.Candidate {
+			c.setLeader(m.Election, term{})
+		}
+	}
+}
+
+// setLeader records a change of leader; c.mu must be held
+func (c *Candidate) setLeader(name string, next term) {
+	previous := c.terms[name]
+	if next.leader == "" {
+		delete(c.terms, name)
+	} else {
+		c.terms[name] = next
+	}
+	if previous.leader == next.leader {
+		return
+	}
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+	if c.config.OnChange != nil {
+		c.pending = append(c.pending, change{name, next.leader})
+		select {
+		case c.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run renews the candidate's leases, expires the leases of leaders that have
+// stopped renewing, and calls OnChange
+func (c *Candidate) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.config.Lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.tick()
+		case <-c.notify:
+		case <-c.stop:
+			c.flush()
+			return
+		}
+		c.flush()
+	}
+}
+
+func (c *Candidate) tick() {
+	c.mu.Lock()
+	now := time.Now()
+	var renew []string
+	for name, current := range c.terms {
+		switch {
+		case now.After(current.expires):
+			c.setLeader(name, term{})
+		case current.leader == c.config.ID:
+			renew = append(renew, name)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, name := range renew {
+		c.publish(name, claimRenew)
+	}
+}
+
+// flush calls OnChange with the changes recorded so far
+func (c *Candidate) flush() {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	for _, change := range pending {
+		c.config.OnChange(change.name, change.leader)
+	}
+}
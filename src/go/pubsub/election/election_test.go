@@ -0,0 +1,46 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// A leader elected near the end of a new candidate's sync window renews only
+// after the window closes. The new candidate must still follow it rather than
+// claim the election itself.
+func TestLeadClaimDuringSync(t *testing.T) {
+	const lease = 600 * time.Millisecond
+	topic := "_election_test_sync"
+
+	// The leader renews every 200ms from its start, at 200, 400, 600, 800...
+	leader, err := New(Config{ID: "leader", Topic: topic, Lease: lease})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+	time.Sleep(400 * time.Millisecond)
+
+	// The follower syncs until 700ms
+	follower, err := New(Config{ID: "follower", Topic: topic, Lease: lease})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+
+	// The leader claims at 650ms and first renews at 800ms
+	time.Sleep(250 * time.Millisecond)
+	if err := leader.Campaign(context.Background(), "job"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lease)
+	defer cancel()
+	if err := follower.Campaign(ctx, "job"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("follower campaign returned %v, want it to wait out the leader", err)
+	}
+	if got := follower.Leader("job"); got != "leader" {
+		t.Fatalf("follower sees leader %q, want %q", got, "leader")
+	}
+}
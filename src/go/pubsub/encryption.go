@@ -0,0 +1,178 @@
+package pubsub
+
+// #include <stdlib.h>
+// #include "pubsub_core.h"
+//
+// // Gateway function for the core's storage cipher
+// uint8_t* storageCipherGateway(uint32_t op, uint8_t* data, size_t len, size_t* out_len);
+import "C"
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// KeyProvider supplies the keys that data the core writes to disk is
+// encrypted with: the records of a storage engine opened with OpenStorage and
+// the messages spilled WithSpill. Keys are 16, 24 or 32 bytes long, for
+// AES-128, AES-192 or AES-256 in GCM mode.
+//
+// Every key has an ID, stored with the data it encrypted, that must not be
+// reused for another key. The provider is called on the thread writing or
+// reading, possibly with the broker locked, so it must not call the package.
+// Keys are cached by ID once fetched.
+type KeyProvider interface {
+	// CurrentKey returns the key to encrypt new data with and its ID, of at
+	// most 255 bytes. It is called for every write, so a provider that
+	// fetches keys from elsewhere should cache the current one.
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key with an ID, to decrypt data encrypted with it
+	Key(id string) ([]byte, error)
+}
+
+// encryption is the KeyProvider set with SetEncryption and the ciphers of the
+// keys it has returned, by ID
+var encryption struct {
+	sync.Mutex
+	provider KeyProvider
+	ciphers  map[string]cipher.AEAD
+}
+
+// SetEncryption encrypts what the core writes to disk from now on with keys
+// from provider, or stops encrypting new data if provider is nil. Set it
+// before OpenStorage, which fails if the stored records are encrypted and no
+// provider can decrypt them.
+//
+// Rotating keys is lazy: once CurrentKey returns a new key, new data is
+// encrypted with it while older data stays readable through Key. Storage
+// records are re-encrypted with the current key when OpenStorage next opens
+// the storage, and spilled messages are deleted once read, so old keys can
+// be retired after that.
+func SetEncryption(provider KeyProvider) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"encryption": fmt.Sprint(provider != nil)}}, err)
+	}()
+
+	encryption.Lock()
+	encryption.provider = provider
+	encryption.ciphers = make(map[string]cipher.AEAD)
+	encryption.Unlock()
+
+	if provider == nil {
+		C.set_storage_cipher(nil)
+		return nil
+	}
+	C.set_storage_cipher(C.storage_cipher_callback(C.storageCipherGateway))
+	return nil
+}
+
+//export storageCipherGateway
+func storageCipherGateway(op C.uint32_t, data *C.uint8_t, length C.size_t, outLen *C.size_t) *C.uint8_t {
+	input := unsafe.Slice((*byte)(unsafe.Pointer(data)), int(length))
+
+	var output []byte
+	var err error
+	if op == C.CIPHER_SEAL {
+		output, err = sealStored(input)
+	} else {
+		output, err = openStored(input)
+	}
+	if err != nil {
+		if l := logger.Load(); l != nil {
+			l.Error("failed to encrypt or decrypt stored data", "error", err)
+		}
+		return nil
+	}
+
+	*outLen = C.size_t(len(output))
+	return (*C.uint8_t)(C.CBytes(output))
+}
+
+// sealStored encrypts data with the current key. The result is the length of
+// the key's ID, the ID, the nonce and the ciphertext.
+func sealStored(data []byte) ([]byte, error) {
+	encryption.Lock()
+	defer encryption.Unlock()
+
+	if encryption.provider == nil {
+		return nil, errors.New("no key provider is set")
+	}
+	id, key, err := encryption.provider.CurrentKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the current key: %w", err)
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("key ID '%s' is longer than 255 bytes", id)
+	}
+	aead, err := cachedCipher(id, key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, 1+len(id)+aead.NonceSize()+len(data)+aead.Overhead())
+	out = append(out, byte(len(id)))
+	out = append(out, id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate a nonce: %w", err)
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, data, nil), nil
+}
+
+// openStored decrypts data sealed by sealStored, with whichever key it names
+func openStored(data []byte) ([]byte, error) {
+	encryption.Lock()
+	defer encryption.Unlock()
+
+	if encryption.provider == nil {
+		return nil, errors.New("no key provider is set")
+	}
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	id := string(data[1 : 1+data[0]])
+	data = data[1+len(id):]
+
+	aead, ok := encryption.ciphers[id]
+	if !ok {
+		key, err := encryption.provider.Key(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get key '%s': %w", id, err)
+		}
+		if aead, err = cachedCipher(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data with key '%s': %w", id, err)
+	}
+	return plaintext, nil
+}
+
+// cachedCipher returns the cipher of a key, creating and caching it by ID the
+// first time. The caller holds the encryption lock.
+func cachedCipher(id string, key []byte) (cipher.AEAD, error) {
+	if aead, ok := encryption.ciphers[id]; ok {
+		return aead, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key '%s': %w", id, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key '%s': %w", id, err)
+	}
+	encryption.ciphers[id] = aead
+	return aead, nil
+}
@@ -0,0 +1,311 @@
+// Package eventstore keeps append-only event streams, one per aggregate, for
+// event-sourced services on the broker. Appends are checked against the
+// version the caller expects, journaled to disk, then published to the
+// aggregate's topic, so subscribers follow a stream live and Load replays it
+// from any version.
+//
+//	s, err := eventstore.Open(eventstore.Config{Dir: "data/events"})
+//	version, err := s.Append("order-42", 0, eventstore.Event{Type: "created", Data: `{"total":10}`})
+//
+// Streams are rebuilt from the journal on Open. Snapshots save replaying a
+// long stream: set Config.Snapshot to take one every Config.SnapshotEvery
+// events, and start from LoadSnapshot.
+package eventstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// DefaultTopicPrefix is prepended to aggregate IDs to name their topics when
+// Config leaves it unset
+const DefaultTopicPrefix = "events/"
+
+// ErrVersionConflict is returned by Append when the stream has moved past
+// the expected version
+var ErrVersionConflict = errors.New("event stream version conflict")
+
+// Event is an event in an aggregate's stream
+type Event struct {
+	Aggregate string `json:"aggregate"`
+	// Version numbers the aggregate's events from 1
+	Version uint64    `json:"version"`
+	Type    string    `json:"type"`
+	Data    string    `json:"data,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// Snapshot is an aggregate's state as of a version
+type Snapshot struct {
+	Aggregate string    `json:"aggregate"`
+	Version   uint64    `json:"version"`
+	State     []byte    `json:"state"`
+	Time      time.Time `json:"time"`
+}
+
+// Config configures a Store
+type Config struct {
+	// Dir holds the event journal and snapshots
+	Dir string
+	// TopicPrefix is prepended to aggregate IDs to name their topics
+	TopicPrefix string
+	// SnapshotEvery takes a snapshot once this many events have been
+	// appended to a stream since its last one; 0 disables snapshots
+	SnapshotEvery int
+	// Snapshot builds an aggregate's state from its previous snapshot, nil
+	// for the first, and the events since. It is called after the append
+	// that crosses SnapshotEvery, outside the store's lock.
+	Snapshot func(previous *Snapshot, events []Event) ([]byte, error)
+	// OnError, if set, is called with snapshots that fail
+	OnError func(error)
+}
+
+// Store is a set of event streams
+type Store struct {
+	config Config
+
+	mu        sync.RWMutex
+	streams   map[string][]Event
+	snapshots map[string]Snapshot
+	journal   *os.File
+	snapshot  *os.File
+}
+
+// Open loads the streams journaled in the directory
+func Open(config Config) (*Store, error) {
+	if config.Dir == "" {
+		return nil, errors.New("event store requires a directory")
+	}
+	if config.TopicPrefix == "" {
+		config.TopicPrefix = DefaultTopicPrefix
+	}
+	if config.SnapshotEvery > 0 && config.Snapshot == nil {
+		return nil, errors.New("event store snapshots require a Snapshot function")
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create event store directory '%s': %w", config.Dir, err)
+	}
+
+	s := &Store{
+		config:    config,
+		streams:   make(map[string][]Event),
+		snapshots: make(map[string]Snapshot),
+	}
+
+	var err error
+	if s.journal, err = openJournal(filepath.Join(config.Dir, "events.jsonl"), func(decoder *json.Decoder) error {
+		var e Event
+		if err := decoder.Decode(&e); err != nil {
+			return err
+		}
+		s.streams[e.Aggregate] = append(s.streams[e.Aggregate], e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if s.snapshot, err = openJournal(filepath.Join(config.Dir, "snapshots.jsonl"), func(decoder *json.Decoder) error {
+		var snap Snapshot
+		if err := decoder.Decode(&snap); err != nil {
+			return err
+		}
+		s.snapshots[snap.Aggregate] = snap
+		return nil
+	}); err != nil {
+		s.journal.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// openJournal reads a JSON Lines file with decode and opens it for appending
+func openJournal(path string, decode func(*json.Decoder) error) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event journal '%s': %w", path, err)
+	}
+
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for decoder.More() {
+		if err := decode(decoder); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read event journal '%s': %w", path, err)
+		}
+	}
+	return f, nil
+}
+
+// Close closes the journal
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.snapshot.Close()
+	return s.journal.Close()
+}
+
+// Version returns the version of an aggregate's latest event, 0 for an
+// empty stream
+func (s *Store) Version(aggregate string) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return uint64(len(s.streams[aggregate]))
+}
+
+// Topic returns the topic an aggregate's events are published to
+func (s *Store) Topic(aggregate string) string {
+	return s.config.TopicPrefix + aggregate
+}
+
+// Append adds events to an aggregate's stream if its version is still
+// expectedVersion, returning the new version. The events are journaled before
+// they are published as JSON to the aggregate's topic; a failed publish
+// doesn't undo the append, and subscribers can catch up with Load. Like
+// other subscription callbacks, theirs must not call back into the store.
+func (s *Store) Append(aggregate string, expectedVersion uint64, events ...Event) (uint64, error) {
+	if aggregate == "" {
+		return 0, errors.New("event store requires an aggregate")
+	}
+
+	s.mu.Lock()
+	stream := s.streams[aggregate]
+	if uint64(len(stream)) != expectedVersion {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("%w: '%s' is at version %d, not %d", ErrVersionConflict, aggregate, len(stream), expectedVersion)
+	}
+
+	now := time.Now().UTC()
+	var journal []byte
+	for i := range events {
+		events[i].Aggregate = aggregate
+		events[i].Version = expectedVersion + uint64(i) + 1
+		if events[i].Time.IsZero() {
+			events[i].Time = now
+		}
+		line, err := json.Marshal(events[i])
+		if err != nil {
+			s.mu.Unlock()
+			return 0, fmt.Errorf("failed to encode event for '%s': %w", aggregate, err)
+		}
+		journal = append(append(journal, line...), '\n')
+	}
+	if _, err := s.journal.Write(journal); err != nil {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("failed to journal events for '%s': %w", aggregate, err)
+	}
+	if err := s.journal.Sync(); err != nil {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("failed to journal events for '%s': %w", aggregate, err)
+	}
+
+	s.streams[aggregate] = append(stream, events...)
+	version := uint64(len(s.streams[aggregate]))
+
+	// Publishing under the lock keeps the topic in version order
+	var publishErr error
+	for _, e := range events {
+		data, _ := json.Marshal(e)
+		err := pubsub.Publish(s.Topic(aggregate), string(data))
+		if err != nil && !errors.Is(err, pubsub.ErrNoTopic) && publishErr == nil {
+			publishErr = fmt.Errorf("failed to publish event %d for '%s': %w", e.Version, aggregate, err)
+		}
+	}
+
+	snapshot := s.dueSnapshot(aggregate, version)
+	s.mu.Unlock()
+
+	if snapshot {
+		s.takeSnapshot(aggregate, version)
+	}
+	return version, publishErr
+}
+
+// dueSnapshot reports whether a stream needs a snapshot; s.mu must be held
+func (s *Store) dueSnapshot(aggregate string, version uint64) bool {
+	if s.config.SnapshotEvery <= 0 {
+		return false
+	}
+	return version-s.snapshots[aggregate].Version >= uint64(s.config.SnapshotEvery)
+}
+
+func (s *Store) takeSnapshot(aggregate string, version uint64) {
+	previous, ok := s.LoadSnapshot(aggregate)
+	if ok && previous.Version >= version {
+		return
+	}
+	var from uint64
+	var prev *Snapshot
+	if ok {
+		from, prev = previous.Version, &previous
+	}
+
+	events, err := s.Load(aggregate, from+1)
+	if err == nil {
+		events = events[:version-from]
+		var state []byte
+		if state, err = s.config.Snapshot(prev, events); err == nil {
+			err = s.SaveSnapshot(Snapshot{Aggregate: aggregate, Version: version, State: state})
+		}
+	}
+	if err != nil && s.config.OnError != nil {
+		s.config.OnError(fmt.Errorf("failed to snapshot '%s' at version %d: %w", aggregate, version, err))
+	}
+}
+
+// Load returns an aggregate's events from a version on
+func (s *Store) Load(aggregate string, fromVersion uint64) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stream := s.streams[aggregate]
+	if fromVersion == 0 {
+		fromVersion = 1
+	}
+	if fromVersion > uint64(len(stream))+1 {
+		return nil, fmt.Errorf("'%s' has no version %d", aggregate, fromVersion)
+	}
+	return append([]Event(nil), stream[fromVersion-1:]...), nil
+}
+
+// SaveSnapshot stores a snapshot, replacing an older one of the aggregate
+func (s *Store) SaveSnapshot(snapshot Snapshot) error {
+	if snapshot.Time.IsZero() {
+		snapshot.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if snapshot.Version > uint64(len(s.streams[snapshot.Aggregate])) {
+		return fmt.Errorf("'%s' has no version %d to snapshot", snapshot.Aggregate, snapshot.Version)
+	}
+	if current, ok := s.snapshots[snapshot.Aggregate]; ok && current.Version >= snapshot.Version {
+		return nil
+	}
+	if _, err := s.snapshot.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to save snapshot of '%s': %w", snapshot.Aggregate, err)
+	}
+	s.snapshots[snapshot.Aggregate] = snapshot
+	return nil
+}
+
+// LoadSnapshot returns an aggregate's latest snapshot and whether it has one.
+// Replay from its version plus one to rebuild the current state.
+func (s *Store) LoadSnapshot(aggregate string) (Snapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot, ok := s.snapshots[aggregate]
+	return snapshot, ok
+}
@@ -0,0 +1,123 @@
+// Package exactlyonce lets the members of a consumer group process each
+// message once, even when it is delivered more than once. Publishers give
+// messages IDs with pubsub.WithMessageID, which the broker already uses to
+// drop publishes repeated within its dedup window; the group's handler then
+// records the IDs it has processed in a Store and skips those it has seen, so
+// repeats from outside the window, or after a handler failed partway, are
+// caught too.
+//
+//	store := exactlyonce.NewMemoryStore(24 * time.Hour)
+//	err := exactlyonce.Subscribe(store, "worker-1", "orders", "billing", handle)
+//
+// A message is recorded after its handler succeeds, so one interrupted
+// between the two is processed again: handlers should still tolerate the
+// rare repeat. Messages published without an ID are passed through.
+package exactlyonce
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// Store records the IDs of the messages each consumer group has processed.
+// Members of a group on different hosts need a store they share, such as an
+// SQLStore.
+type Store interface {
+	// Processed reports whether the group has processed the message ID
+	Processed(ctx context.Context, group, messageID string) (bool, error)
+	// MarkProcessed records that the group has processed the message ID
+	MarkProcessed(ctx context.Context, group, messageID string) error
+}
+
+// Subscribe joins subscriberID to a consumer group on topic with a handler
+// that processes each message ID once across the group's members
+func Subscribe(store Store, subscriberID, topic, group string, handler pubsub.HandlerFunc, opts ...pubsub.SubscribeOption) error {
+	opts = append(opts, pubsub.WithGroup(group))
+	return pubsub.SubscribeHandler(subscriberID, topic, Handler(store, group, handler), opts...)
+}
+
+// Handler wraps handler to skip the messages the group has processed and to
+// record those it processes. If recording fails, the error is returned and a
+// retry processes the message again.
+func Handler(store Store, group string, handler pubsub.HandlerFunc) pubsub.HandlerFunc {
+	return func(ctx context.Context, msg *pubsub.Message) error {
+		if msg.ID == "" {
+			return handler(ctx, msg)
+		}
+
+		processed, err := store.Processed(ctx, group, msg.ID)
+		if err != nil {
+			return fmt.Errorf("failed to check message '%s': %w", msg.ID, err)
+		}
+		if processed {
+			return nil
+		}
+
+		if err := handler(ctx, msg); err != nil {
+			return err
+		}
+		if err := store.MarkProcessed(ctx, group, msg.ID); err != nil {
+			return fmt.Errorf("failed to record message '%s' processed: %w", msg.ID, err)
+		}
+		return nil
+	}
+}
+
+// MemoryStore keeps processed IDs in memory, for groups whose members share
+// a process. IDs are forgotten after the retention period, or kept for good
+// if it is 0.
+type MemoryStore struct {
+	retention time.Duration
+
+	mu        sync.Mutex
+	processed map[[2]string]time.Time
+	// pruned is when expired IDs were last removed
+	pruned time.Time
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore(retention time.Duration) *MemoryStore {
+	return &MemoryStore{
+		retention: retention,
+		processed: make(map[[2]string]time.Time),
+		pruned:    time.Now(),
+	}
+}
+
+// Processed reports whether the group has processed the message ID within the
+// retention period
+func (s *MemoryStore) Processed(ctx context.Context, group, messageID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at, ok := s.processed[[2]string{group, messageID}]
+	return ok && !s.expired(at, time.Now()), nil
+}
+
+// MarkProcessed records that the group has processed the message ID
+func (s *MemoryStore) MarkProcessed(ctx context.Context, group, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.processed[[2]string{group, messageID}] = now
+
+	// Sweep expired IDs once per retention period
+	if s.retention > 0 && now.Sub(s.pruned) >= s.retention {
+		for key, at := range s.processed {
+			if s.expired(at, now) {
+				delete(s.processed, key)
+			}
+		}
+		s.pruned = now
+	}
+	return nil
+}
+
+func (s *MemoryStore) expired(at, now time.Time) bool {
+	return s.retention > 0 && now.Sub(at) >= s.retention
+}
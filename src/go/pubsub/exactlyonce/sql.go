@@ -0,0 +1,118 @@
+package exactlyonce
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Dialect is the SQL flavour of the database holding the processed table
+type Dialect int
+
+const (
+	// SQLite uses ? placeholders
+	SQLite Dialect = iota
+	// Postgres uses $1 placeholders
+	Postgres
+	// MySQL uses ? placeholders
+	MySQL
+)
+
+// DefaultTable is the processed table name when SQLConfig leaves it unset
+const DefaultTable = "processed_messages"
+
+// tableName limits table names to plain identifiers, optionally schema
+// qualified, since they can't be passed as query parameters
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQLConfig configures an SQLStore
+type SQLConfig struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Table   string
+}
+
+// SQLStore keeps processed IDs in a database table, so that members of a
+// group on different hosts, or restarted ones, share them. The table needs at
+// least these columns:
+//
+//	CREATE TABLE processed_messages (
+//		consumer_group VARCHAR(255) NOT NULL,
+//		message_id     VARCHAR(255) NOT NULL,
+//		processed_at   TIMESTAMP NOT NULL,
+//		PRIMARY KEY (consumer_group, message_id)
+//	);
+//
+// It works with any database/sql driver for SQLite, Postgres or MySQL.
+type SQLStore struct {
+	db *sql.DB
+	// queries are the dialect's statements for the table
+	selectRow string
+	insertRow string
+	pruneRows string
+}
+
+// NewSQLStore returns a store using the table described by config
+func NewSQLStore(config SQLConfig) (*SQLStore, error) {
+	if config.DB == nil {
+		return nil, errors.New("exactly-once store requires a database")
+	}
+	if config.Table == "" {
+		config.Table = DefaultTable
+	}
+	if !tableName.MatchString(config.Table) {
+		return nil, fmt.Errorf("invalid processed table name '%s'", config.Table)
+	}
+
+	s := &SQLStore{db: config.DB}
+	switch config.Dialect {
+	case SQLite:
+		s.selectRow = fmt.Sprintf("SELECT 1 FROM %s WHERE consumer_group = ? AND message_id = ?", config.Table)
+		s.insertRow = fmt.Sprintf("INSERT INTO %s (consumer_group, message_id, processed_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING", config.Table)
+		s.pruneRows = fmt.Sprintf("DELETE FROM %s WHERE processed_at < ?", config.Table)
+	case Postgres:
+		s.selectRow = fmt.Sprintf("SELECT 1 FROM %s WHERE consumer_group = $1 AND message_id = $2", config.Table)
+		s.insertRow = fmt.Sprintf("INSERT INTO %s (consumer_group, message_id, processed_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING", config.Table)
+		s.pruneRows = fmt.Sprintf("DELETE FROM %s WHERE processed_at < $1", config.Table)
+	case MySQL:
+		s.selectRow = fmt.Sprintf("SELECT 1 FROM %s WHERE consumer_group = ? AND message_id = ?", config.Table)
+		s.insertRow = fmt.Sprintf("INSERT IGNORE INTO %s (consumer_group, message_id, processed_at) VALUES (?, ?, ?)", config.Table)
+		s.pruneRows = fmt.Sprintf("DELETE FROM %s WHERE processed_at < ?", config.Table)
+	default:
+		return nil, fmt.Errorf("unknown exactly-once dialect %d", config.Dialect)
+	}
+	return s, nil
+}
+
+// Processed reports whether the group has processed the message ID
+func (s *SQLStore) Processed(ctx context.Context, group, messageID string) (bool, error) {
+	var one int
+	err := s.db.QueryRowContext(ctx, s.selectRow, group, messageID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MarkProcessed records that the group has processed the message ID
+func (s *SQLStore) MarkProcessed(ctx context.Context, group, messageID string) error {
+	_, err := s.db.ExecContext(ctx, s.insertRow, group, messageID, time.Now().UTC())
+	return err
+}
+
+// Prune deletes the IDs processed before the given time, returning how many
+// it deleted. A repeat of a pruned message is processed again, so keep IDs
+// for longer than a message can be redelivered or republished.
+func (s *SQLStore) Prune(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.pruneRows, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,153 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+	"unsafe"
+)
+
+// ExportedMessage is a message of a topic export, written as one line of JSON
+type ExportedMessage struct {
+	// SubscriberID is the subscriber the message was waiting for. ImportTopic
+	// publishes messages without one to the topic instead.
+	SubscriberID string `json:"subscriber_id,omitempty"`
+	// Payload is a message that is valid UTF-8, and Binary any other
+	// payload, base64 encoded in the file
+	Payload     string    `json:"payload,omitempty"`
+	Binary      []byte    `json:"binary,omitempty"`
+	PublishedAt time.Time `json:"published_at"`
+	PublisherID string    `json:"publisher_id,omitempty"`
+}
+
+// ExportTopic writes the messages on a topic that are waiting for each
+// subscriber to w as JSON Lines, without removing them. That covers messages
+// queued for subscribers without a callback, including spilled ones, and
+// messages held while a subscription is paused. Messages are grouped by
+// subscriber, each in the order it would receive them.
+func ExportTopic(topic string, w io.Writer) error {
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	cExport := C.export_topic(cTopic)
+	if cExport == nil {
+		return checkInternal(fmt.Errorf("failed to export topic '%s'", topic))
+	}
+	defer C.free_string(cExport)
+
+	var raw []struct {
+		SubscriberID  string `json:"subscriber_id"`
+		Payload       string `json:"payload"`
+		Bytes         []byte `json:"bytes"`
+		PublishedAtMs int64  `json:"published_at_ms"`
+		PublisherID   string `json:"publisher_id"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(cExport)), &raw); err != nil {
+		return fmt.Errorf("failed to parse export of topic '%s': %w", topic, err)
+	}
+
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	for _, m := range raw {
+		err := encoder.Encode(ExportedMessage{
+			SubscriberID: m.SubscriberID,
+			Payload:      m.Payload,
+			Binary:       m.Bytes,
+			PublishedAt:  time.UnixMilli(m.PublishedAtMs).UTC(),
+			PublisherID:  m.PublisherID,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to export topic '%s': %w", topic, err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("failed to export topic '%s': %w", topic, err)
+	}
+	return nil
+}
+
+// ImportTopic reads messages written by ExportTopic from r and adds them to
+// a topic, which need not be the one they were exported from. A message with
+// a subscriber ID goes straight to that subscriber, keeping its original
+// publish time, so the subscriber must already be subscribed to the topic.
+// Messages without one are published to the topic. ImportTopic stops at the
+// first message it can't import; the ones before it stay imported.
+func ImportTopic(topic string, r io.Reader) error {
+	if err := validatePublishTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var m ExportedMessage
+		if err := decoder.Decode(&m); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read import for topic '%s' at line %d: %w", topic, line, err)
+		}
+
+		payload := m.Binary
+		if payload == nil {
+			payload = []byte(m.Payload)
+		}
+
+		var err error
+		if m.SubscriberID == "" {
+			var opts []PublishOption
+			if m.PublisherID != "" {
+				opts = append(opts, WithPublisher(m.PublisherID))
+			}
+			err = PublishBytes(topic, payload, opts...)
+		} else {
+			err = importMessage(topic, m, payload)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to import line %d for topic '%s': %w", line, topic, err)
+		}
+	}
+}
+
+// importMessage delivers an exported message to its subscriber
+func importMessage(topic string, m ExportedMessage, payload []byte) error {
+	if err := validateSubscriberID(m.SubscriberID); err != nil {
+		return err
+	}
+	if len(payload) > GetLimits().MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+
+	cSubscriberID := C.CString(m.SubscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	var cPublisherID *C.char
+	if m.PublisherID != "" {
+		cPublisherID = C.CString(m.PublisherID)
+		defer C.free(unsafe.Pointer(cPublisherID))
+	}
+
+	success := C.import_message(
+		cSubscriberID,
+		cTopic,
+		(*C.uint8_t)(unsafe.Pointer(unsafe.SliceData(payload))),
+		C.size_t(len(payload)),
+		C.uint64_t(m.PublishedAt.UnixMilli()),
+		cPublisherID,
+	)
+	if !success {
+		return checkInternal(fmt.Errorf("failed to import message for subscriber '%s': not subscribed, or over a limit", m.SubscriberID))
+	}
+	return nil
+}
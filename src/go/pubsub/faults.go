@@ -0,0 +1,80 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInjectedFault is returned by a call that Faults.FailCall chose to fail
+var ErrInjectedFault = errors.New("injected fault")
+
+// Faults are failures injected into the broker to test how an application
+// copes with them, such as its retry and quarantine handling. The chaos
+// package sets them from a configuration of rates.
+type Faults struct {
+	// DropRate is the fraction of deliveries the core drops
+	DropRate float64
+	// QueueFullRate is the fraction of messages for subscribers without a
+	// callback that are rejected as if the subscriber's queue were full
+	QueueFullRate float64
+	// Seed makes the core's choice of dropped and rejected messages repeatable
+	Seed uint64
+	// CallbackDelay, if set, is called before each callback delivery and
+	// returns how long to hold the delivery back
+	CallbackDelay func(subscriberID, topic string) time.Duration
+	// FailCall, if set, is called before each call into the core made by
+	// publishing, subscribing, unsubscribing, sending and fetching messages,
+	// with the name of the C function. If it returns true the call is not
+	// made and fails with ErrInjectedFault.
+	FailCall func(function string) bool
+}
+
+// activeFaults holds the Go side of the injected faults, nil when there are none
+var activeFaults atomic.Pointer[Faults]
+
+// SetFaults replaces the injected faults. The zero Faults turns injection off.
+func SetFaults(faults Faults) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{
+			"drop_rate":       fmt.Sprint(faults.DropRate),
+			"queue_full_rate": fmt.Sprint(faults.QueueFullRate),
+		}}, err)
+	}()
+
+	success := C.set_chaos(C.double(faults.DropRate), C.double(faults.QueueFullRate), C.uint64_t(faults.Seed))
+	if !success {
+		return checkInternal(errors.New("failed to set faults: rates must be between 0 and 1"))
+	}
+
+	if faults.CallbackDelay == nil && faults.FailCall == nil {
+		activeFaults.Store(nil)
+	} else {
+		activeFaults.Store(&faults)
+	}
+
+	return nil
+}
+
+// injectedFault returns ErrInjectedFault if the call to a C function should fail
+func injectedFault(function string) error {
+	faults := activeFaults.Load()
+	if faults == nil || faults.FailCall == nil || !faults.FailCall(function) {
+		return nil
+	}
+	return fmt.Errorf("failed to call %s: %w", function, ErrInjectedFault)
+}
+
+// delayCallback holds back a callback delivery as long as the faults ask for
+func delayCallback(subscriberID, topic string) {
+	faults := activeFaults.Load()
+	if faults == nil || faults.CallbackDelay == nil {
+		return
+	}
+	if delay := faults.CallbackDelay(subscriberID, topic); delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,128 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Feature is a capability a build of the core library may have
+type Feature uint64
+
+const (
+	// FeatureAck is fetching messages to acknowledge, with Nack and
+	// visibility timeouts
+	FeatureAck Feature = C.FEATURE_ACK
+	// FeaturePersistence is spilling queues to disk with WithSpill
+	FeaturePersistence Feature = C.FEATURE_PERSISTENCE
+	// FeatureWildcards is subscribing to topic patterns. No build of the
+	// core has it yet.
+	FeatureWildcards Feature = C.FEATURE_WILDCARDS
+	// FeatureGroups is consumer groups
+	FeatureGroups Feature = C.FEATURE_GROUPS
+	// FeatureTransactions is publishing in transactions
+	FeatureTransactions Feature = C.FEATURE_TRANSACTIONS
+	// FeatureSchemas is validating publishes against schemas
+	FeatureSchemas Feature = C.FEATURE_SCHEMAS
+	// FeatureZeroCopy is reading payloads with GetBuffer without copying
+	FeatureZeroCopy Feature = C.FEATURE_ZERO_COPY
+	// FeatureTracing is message tracing with SetMessageTracing
+	FeatureTracing Feature = C.FEATURE_TRACING
+	// FeatureChaos is injecting faults with SetChaos
+	FeatureChaos Feature = C.FEATURE_CHAOS
+	// FeatureStorageWAL is the StorageWAL storage engine
+	FeatureStorageWAL Feature = C.FEATURE_STORAGE_WAL
+	// FeatureStorageSQLite is the StorageSQLite storage engine, in builds of
+	// the core with the sqlite feature
+	FeatureStorageSQLite Feature = C.FEATURE_STORAGE_SQLITE
+	// FeatureStorageRocksDB is the StorageRocksDB storage engine. No build of
+	// the core has it: the engine is descoped, see docs/rocksdb-storage.md.
+	FeatureStorageRocksDB Feature = C.FEATURE_STORAGE_ROCKSDB
+	// FeatureTransforms is transforming messages with WebAssembly modules
+	// attached by AttachTransform
+	FeatureTransforms Feature = C.FEATURE_TRANSFORMS
+)
+
+// features lists the known features in bit order, with their names
+var features = []struct {
+	feature Feature
+	name    string
+}{
+	{FeatureAck, "ack"},
+	{FeaturePersistence, "persistence"},
+	{FeatureWildcards, "wildcards"},
+	{FeatureGroups, "groups"},
+	{FeatureTransactions, "transactions"},
+	{FeatureSchemas, "schemas"},
+	{FeatureZeroCopy, "zero_copy"},
+	{FeatureTracing, "tracing"},
+	{FeatureChaos, "chaos"},
+	{FeatureStorageWAL, "storage_wal"},
+	{FeatureStorageSQLite, "storage_sqlite"},
+	{FeatureStorageRocksDB, "storage_rocksdb"},
+	{FeatureTransforms, "transforms"},
+}
+
+func (f Feature) String() string {
+	for _, known := range features {
+		if known.feature == f {
+			return known.name
+		}
+	}
+	return fmt.Sprintf("Feature(%#x)", uint64(f))
+}
+
+// FeatureSet is a set of features
+type FeatureSet uint64
+
+// Features returns the features of the linked core library. Code that works
+// with several builds of the core checks them before relying on one:
+//
+//	if pubsub.Features().Has(pubsub.FeaturePersistence) {
+//		opts = append(opts, pubsub.WithSpill(dir, budget))
+//	}
+func Features() FeatureSet {
+	return FeatureSet(C.get_features())
+}
+
+// Has reports whether the set holds the feature
+func (s FeatureSet) Has(f Feature) bool {
+	return uint64(s)&uint64(f) == uint64(f)
+}
+
+// List returns the features in the set, in bit order. Features of a newer
+// core that this package doesn't know are listed by value.
+func (s FeatureSet) List() []Feature {
+	var list []Feature
+	for bit := Feature(1); bit != 0; bit <<= 1 {
+		if s.Has(bit) {
+			list = append(list, bit)
+		}
+	}
+	return list
+}
+
+// Require returns an error wrapping errors.ErrUnsupported that names the
+// features missing from the set, or nil if it has them all
+func (s FeatureSet) Require(features ...Feature) error {
+	var missing []string
+	for _, f := range features {
+		if !s.Has(f) {
+			missing = append(missing, f.String())
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: core library lacks %s", errors.ErrUnsupported, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (s FeatureSet) String() string {
+	names := make([]string, 0, len(features))
+	for _, f := range s.List() {
+		names = append(names, f.String())
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,641 @@
+// Code generated by ffigen from pubsub_core.h; DO NOT EDIT.
+
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+
+import "unsafe"
+
+const (
+	// Version of this interface, returned by abi_version
+	coreABIVersion = C.ABI_VERSION
+
+	// DeliveryReport status codes
+	corePublishOK               = C.PUBLISH_OK
+	corePublishNoTopic          = C.PUBLISH_NO_TOPIC
+	corePublishTooLarge         = C.PUBLISH_TOO_LARGE
+	corePublishUnknownPublisher = C.PUBLISH_UNKNOWN_PUBLISHER
+	corePublishQuotaExceeded    = C.PUBLISH_QUOTA_EXCEEDED
+	corePublishSchemaInvalid    = C.PUBLISH_SCHEMA_INVALID
+	corePublishMemoryLimit      = C.PUBLISH_MEMORY_LIMIT
+	corePublishKeyRequired      = C.PUBLISH_KEY_REQUIRED
+	corePublishRejected         = C.PUBLISH_REJECTED
+
+	// Policies for set_memory_limit
+	coreMemoryPolicyReject      = C.MEMORY_POLICY_REJECT
+	coreMemoryPolicyEvictOldest = C.MEMORY_POLICY_EVICT_OLDEST
+	coreMemoryPolicyDropLargest = C.MEMORY_POLICY_DROP_LARGEST
+
+	// Balancing strategies for set_group_balance
+	coreBalanceRoundRobin   = C.BALANCE_ROUND_ROBIN
+	coreBalanceLeastPending = C.BALANCE_LEAST_PENDING
+	coreBalanceWeighted     = C.BALANCE_WEIGHTED
+
+	// Number of partitions ordering keys are hashed into within a consumer group
+	coreGroupPartitions = C.GROUP_PARTITIONS
+
+	// Schema types for register_schema
+	coreSchemaJSON = C.SCHEMA_JSON
+
+	// Delivery modes of create_topic
+	coreDeliveryAny    = C.DELIVERY_ANY
+	coreDeliveryFanout = C.DELIVERY_FANOUT
+	coreDeliveryQueue  = C.DELIVERY_QUEUE
+	coreDeliveryKeyed  = C.DELIVERY_KEYED
+
+	// Feature bits reported by get_features
+	coreFeatureAck            = C.FEATURE_ACK
+	coreFeaturePersistence    = C.FEATURE_PERSISTENCE
+	coreFeatureWildcards      = C.FEATURE_WILDCARDS
+	coreFeatureGroups         = C.FEATURE_GROUPS
+	coreFeatureTransactions   = C.FEATURE_TRANSACTIONS
+	coreFeatureSchemas        = C.FEATURE_SCHEMAS
+	coreFeatureZeroCopy       = C.FEATURE_ZERO_COPY
+	coreFeatureTracing        = C.FEATURE_TRACING
+	coreFeatureChaos          = C.FEATURE_CHAOS
+	coreFeatureStorageWal     = C.FEATURE_STORAGE_WAL
+	coreFeatureStorageSqlite  = C.FEATURE_STORAGE_SQLITE
+	coreFeatureStorageRocksdb = C.FEATURE_STORAGE_ROCKSDB
+	coreFeatureTransforms     = C.FEATURE_TRANSFORMS
+
+	// Storage engines of set_storage_engine
+	coreStorageMemory  = C.STORAGE_MEMORY
+	coreStorageWal     = C.STORAGE_WAL
+	coreStorageSqlite  = C.STORAGE_SQLITE
+	coreStorageRocksdb = C.STORAGE_ROCKSDB
+
+	// Operations of a storage_cipher_callback
+	coreCipherSeal = C.CIPHER_SEAL
+	coreCipherOpen = C.CIPHER_OPEN
+
+	// Log levels of set_log_callback, most severe first
+	coreLogError = C.LOG_ERROR
+	coreLogWarn  = C.LOG_WARN
+	coreLogInfo  = C.LOG_INFO
+	coreLogDebug = C.LOG_DEBUG
+	coreLogTrace = C.LOG_TRACE
+)
+
+// corePublishName returns the name of a PUBLISH_ code
+func corePublishName(code uint32) string {
+	switch code {
+	case C.PUBLISH_OK:
+		return "PUBLISH_OK"
+	case C.PUBLISH_NO_TOPIC:
+		return "PUBLISH_NO_TOPIC"
+	case C.PUBLISH_TOO_LARGE:
+		return "PUBLISH_TOO_LARGE"
+	case C.PUBLISH_UNKNOWN_PUBLISHER:
+		return "PUBLISH_UNKNOWN_PUBLISHER"
+	case C.PUBLISH_QUOTA_EXCEEDED:
+		return "PUBLISH_QUOTA_EXCEEDED"
+	case C.PUBLISH_SCHEMA_INVALID:
+		return "PUBLISH_SCHEMA_INVALID"
+	case C.PUBLISH_MEMORY_LIMIT:
+		return "PUBLISH_MEMORY_LIMIT"
+	case C.PUBLISH_KEY_REQUIRED:
+		return "PUBLISH_KEY_REQUIRED"
+	case C.PUBLISH_REJECTED:
+		return "PUBLISH_REJECTED"
+	default:
+		return "unknown PUBLISH_ code"
+	}
+}
+
+// coreSetGroupBalance calls set_group_balance
+func coreSetGroupBalance(topic string, group string, balance uint32) bool {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	cGroup := C.CString(group)
+	defer C.free(unsafe.Pointer(cGroup))
+	return bool(C.set_group_balance(cTopic, cGroup, C.uint32_t(balance)))
+}
+
+// coreSetGroupWeight calls set_group_weight
+func coreSetGroupWeight(subscriberID string, topic string, group string, weight uint32) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	cGroup := C.CString(group)
+	defer C.free(unsafe.Pointer(cGroup))
+	return bool(C.set_group_weight(cSubscriberID, cTopic, cGroup, C.uint32_t(weight)))
+}
+
+// coreKeyPartition calls key_partition
+func coreKeyPartition(key string) uint32 {
+	cKey := C.CString(key)
+	defer C.free(unsafe.Pointer(cKey))
+	return uint32(C.key_partition(cKey))
+}
+
+// coreUnsubscribe calls unsubscribe
+func coreUnsubscribe(subscriberID string, topic string) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.unsubscribe(cSubscriberID, cTopic))
+}
+
+// coreUnsubscribePrefix calls unsubscribe_prefix
+func coreUnsubscribePrefix(subscriberID string, prefix string) (string, bool) {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cPrefix := C.CString(prefix)
+	defer C.free(unsafe.Pointer(cPrefix))
+	result := C.unsubscribe_prefix(cSubscriberID, cPrefix)
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreListSubscriptions calls list_subscriptions
+func coreListSubscriptions(subscriberID string) (string, bool) {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	result := C.list_subscriptions(cSubscriberID)
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreCreateTopic calls create_topic
+func coreCreateTopic(topic string, mode uint32) bool {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.create_topic(cTopic, C.uint32_t(mode)))
+}
+
+// coreTopicDeliveryMode calls topic_delivery_mode
+func coreTopicDeliveryMode(topic string) uint32 {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return uint32(C.topic_delivery_mode(cTopic))
+}
+
+// coreSetTopicTemplate calls set_topic_template
+func coreSetTopicTemplate(pattern string, templateJSON string) bool {
+	cPattern := C.CString(pattern)
+	defer C.free(unsafe.Pointer(cPattern))
+	cTemplateJSON := C.CString(templateJSON)
+	defer C.free(unsafe.Pointer(cTemplateJSON))
+	return bool(C.set_topic_template(cPattern, cTemplateJSON))
+}
+
+// coreTopicTemplates calls topic_templates
+func coreTopicTemplates() (string, bool) {
+	result := C.topic_templates()
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreDeleteTopic calls delete_topic
+func coreDeleteTopic(topic string) bool {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.delete_topic(cTopic))
+}
+
+// coreListTopics calls list_topics
+func coreListTopics() (string, bool) {
+	result := C.list_topics()
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// corePauseSubscription calls pause_subscription
+func corePauseSubscription(subscriberID string, topic string) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.pause_subscription(cSubscriberID, cTopic))
+}
+
+// coreSetSubscriptionSampling calls set_subscription_sampling
+func coreSetSubscriptionSampling(subscriberID string, topic string, rate float64, every uint64) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.set_subscription_sampling(cSubscriberID, cTopic, C.double(rate), C.uint64_t(every)))
+}
+
+// coreSetSubscriptionThrottle calls set_subscription_throttle
+func coreSetSubscriptionThrottle(subscriberID string, topic string, rate float64) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.set_subscription_throttle(cSubscriberID, cTopic, C.double(rate)))
+}
+
+// coreResumeSubscription calls resume_subscription
+func coreResumeSubscription(subscriberID string, topic string) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.resume_subscription(cSubscriberID, cTopic))
+}
+
+// coreTapUnsubscribe calls tap_unsubscribe
+func coreTapUnsubscribe(tapID string) bool {
+	cTapID := C.CString(tapID)
+	defer C.free(unsafe.Pointer(cTapID))
+	return bool(C.tap_unsubscribe(cTapID))
+}
+
+// corePublish calls publish
+func corePublish(topic string, message string) bool {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	cMessage := C.CString(message)
+	defer C.free(unsafe.Pointer(cMessage))
+	return bool(C.publish(cTopic, cMessage))
+}
+
+// coreSendTo calls send_to
+func coreSendTo(subscriberID string, message string) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cMessage := C.CString(message)
+	defer C.free(unsafe.Pointer(cMessage))
+	return bool(C.send_to(cSubscriberID, cMessage))
+}
+
+// coreExportTopic calls export_topic
+func coreExportTopic(topic string) (string, bool) {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	result := C.export_topic(cTopic)
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreStartSysTopics calls start_sys_topics
+func coreStartSysTopics(intervalMs uint64) bool {
+	return bool(C.start_sys_topics(C.uint64_t(intervalMs)))
+}
+
+// coreStopSysTopics calls stop_sys_topics
+func coreStopSysTopics() bool {
+	return bool(C.stop_sys_topics())
+}
+
+// coreSetDedupWindow calls set_dedup_window
+func coreSetDedupWindow(windowMs uint64) bool {
+	return bool(C.set_dedup_window(C.uint64_t(windowMs)))
+}
+
+// coreSetSubscriberTTL calls set_subscriber_ttl
+func coreSetSubscriberTTL(ttlMs uint64) bool {
+	return bool(C.set_subscriber_ttl(C.uint64_t(ttlMs)))
+}
+
+// coreSetTopicIdleTTL calls set_topic_idle_ttl
+func coreSetTopicIdleTTL(ttlMs uint64) bool {
+	return bool(C.set_topic_idle_ttl(C.uint64_t(ttlMs)))
+}
+
+// coreSetGroupCooldown calls set_group_cooldown
+func coreSetGroupCooldown(cooldownMs uint64) bool {
+	return bool(C.set_group_cooldown(C.uint64_t(cooldownMs)))
+}
+
+// coreSetMessageTracing calls set_message_tracing
+func coreSetMessageTracing(enabled bool) bool {
+	return bool(C.set_message_tracing(C.bool(enabled)))
+}
+
+// coreSetTopicHistory calls set_topic_history
+func coreSetTopicHistory(topic string, limit int) bool {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.set_topic_history(cTopic, C.size_t(limit)))
+}
+
+// coreSetTopicCompaction calls set_topic_compaction
+func coreSetTopicCompaction(topic string, keyHeader string) bool {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	cKeyHeader := C.CString(keyHeader)
+	defer C.free(unsafe.Pointer(cKeyHeader))
+	return bool(C.set_topic_compaction(cTopic, cKeyHeader))
+}
+
+// coreSetCompactionInterval calls set_compaction_interval
+func coreSetCompactionInterval(intervalMs uint64) bool {
+	return bool(C.set_compaction_interval(C.uint64_t(intervalMs)))
+}
+
+// coreMergeTopic calls merge_topic
+func coreMergeTopic(from string, to string) bool {
+	cFrom := C.CString(from)
+	defer C.free(unsafe.Pointer(cFrom))
+	cTo := C.CString(to)
+	defer C.free(unsafe.Pointer(cTo))
+	return bool(C.merge_topic(cFrom, cTo))
+}
+
+// coreSetDeterministic calls set_deterministic
+func coreSetDeterministic(enabled bool) bool {
+	return bool(C.set_deterministic(C.bool(enabled)))
+}
+
+// coreAdvanceClock calls advance_clock
+func coreAdvanceClock(ns uint64) bool {
+	return bool(C.advance_clock(C.uint64_t(ns)))
+}
+
+// coreTouchSubscriber calls touch_subscriber
+func coreTouchSubscriber(subscriberID string) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	return bool(C.touch_subscriber(cSubscriberID))
+}
+
+// coreQueueDepth calls queue_depth
+func coreQueueDepth(subscriberID string, topic string) int {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return int(C.queue_depth(cSubscriberID, cTopic))
+}
+
+// coreAckMessage calls ack_message
+func coreAckMessage(subscriberID string, deliveryTag uint64) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	return bool(C.ack_message(cSubscriberID, C.uint64_t(deliveryTag)))
+}
+
+// coreNackMessage calls nack_message
+func coreNackMessage(subscriberID string, deliveryTag uint64) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	return bool(C.nack_message(cSubscriberID, C.uint64_t(deliveryTag)))
+}
+
+// coreSetVisibilityTimeout calls set_visibility_timeout
+func coreSetVisibilityTimeout(subscriberID string, topic string, timeoutMs uint64) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.set_visibility_timeout(cSubscriberID, cTopic, C.uint64_t(timeoutMs)))
+}
+
+// coreExtendVisibility calls extend_visibility
+func coreExtendVisibility(subscriberID string, deliveryTag uint64, timeoutMs uint64) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	return bool(C.extend_visibility(cSubscriberID, C.uint64_t(deliveryTag), C.uint64_t(timeoutMs)))
+}
+
+// coreDiscardMessage calls discard_message
+func coreDiscardMessage(subscriberID string, deliveryTag uint64) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	return bool(C.discard_message(cSubscriberID, C.uint64_t(deliveryTag)))
+}
+
+// coreListInFlight calls list_in_flight
+func coreListInFlight(subscriberID string, topic string) (string, bool) {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	result := C.list_in_flight(cSubscriberID, cTopic)
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreSetAckTimeout calls set_ack_timeout
+func coreSetAckTimeout(timeoutMs uint64) bool {
+	return bool(C.set_ack_timeout(C.uint64_t(timeoutMs)))
+}
+
+// coreReleaseBuffer calls release_buffer
+func coreReleaseBuffer(bufferID uint64) bool {
+	return bool(C.release_buffer(C.uint64_t(bufferID)))
+}
+
+// coreHasMessages calls has_messages
+func coreHasMessages(subscriberID string, topic string) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.has_messages(cSubscriberID, cTopic))
+}
+
+// coreGetStats calls get_stats
+func coreGetStats() (string, bool) {
+	result := C.get_stats()
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreGetPresence calls get_presence
+func coreGetPresence(topic string) (string, bool) {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	result := C.get_presence(cTopic)
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreRegisterPublisher calls register_publisher
+func coreRegisterPublisher(publisherID string, labelsJSON string) bool {
+	cPublisherID := C.CString(publisherID)
+	defer C.free(unsafe.Pointer(cPublisherID))
+	cLabelsJSON := C.CString(labelsJSON)
+	defer C.free(unsafe.Pointer(cLabelsJSON))
+	return bool(C.register_publisher(cPublisherID, cLabelsJSON))
+}
+
+// coreUnregisterPublisher calls unregister_publisher
+func coreUnregisterPublisher(publisherID string) bool {
+	cPublisherID := C.CString(publisherID)
+	defer C.free(unsafe.Pointer(cPublisherID))
+	return bool(C.unregister_publisher(cPublisherID))
+}
+
+// coreBindSchema calls bind_schema
+func coreBindSchema(topic string, subject string, version uint32, rejectsTopic string) bool {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	cSubject := C.CString(subject)
+	defer C.free(unsafe.Pointer(cSubject))
+	cRejectsTopic := C.CString(rejectsTopic)
+	defer C.free(unsafe.Pointer(cRejectsTopic))
+	return bool(C.bind_schema(cTopic, cSubject, C.uint32_t(version), cRejectsTopic))
+}
+
+// coreUnbindSchema calls unbind_schema
+func coreUnbindSchema(topic string) bool {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.unbind_schema(cTopic))
+}
+
+// coreGetTopicSchema calls get_topic_schema
+func coreGetTopicSchema(topic string) (string, bool) {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	result := C.get_topic_schema(cTopic)
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreDetachTransform calls detach_transform
+func coreDetachTransform(topic string, id uint64) bool {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	return bool(C.detach_transform(cTopic, C.uint64_t(id)))
+}
+
+// coreTopicTransforms calls topic_transforms
+func coreTopicTransforms(topic string) (string, bool) {
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+	result := C.topic_transforms(cTopic)
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreSetSubscriberLabels calls set_subscriber_labels
+func coreSetSubscriberLabels(subscriberID string, labelsJSON string) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cLabelsJSON := C.CString(labelsJSON)
+	defer C.free(unsafe.Pointer(cLabelsJSON))
+	return bool(C.set_subscriber_labels(cSubscriberID, cLabelsJSON))
+}
+
+// coreSetQueueCapacity calls set_queue_capacity
+func coreSetQueueCapacity(subscriberID string, capacity int) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	return bool(C.set_queue_capacity(cSubscriberID, C.size_t(capacity)))
+}
+
+// coreSetQueueWatermarks calls set_queue_watermarks
+func coreSetQueueWatermarks(subscriberID string, high float64, low float64) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	return bool(C.set_queue_watermarks(cSubscriberID, C.double(high), C.double(low)))
+}
+
+// coreSetQueueSpill calls set_queue_spill
+func coreSetQueueSpill(subscriberID string, directory string, memoryBudget int) bool {
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cDirectory := C.CString(directory)
+	defer C.free(unsafe.Pointer(cDirectory))
+	return bool(C.set_queue_spill(cSubscriberID, cDirectory, C.size_t(memoryBudget)))
+}
+
+// coreSetMemoryLimit calls set_memory_limit
+func coreSetMemoryLimit(limitBytes uint64, policy uint32) bool {
+	return bool(C.set_memory_limit(C.uint64_t(limitBytes), C.uint32_t(policy)))
+}
+
+// coreSetStorageEngine calls set_storage_engine
+func coreSetStorageEngine(engine uint32, options string) bool {
+	cOptions := C.CString(options)
+	defer C.free(unsafe.Pointer(cOptions))
+	return bool(C.set_storage_engine(C.uint32_t(engine), cOptions))
+}
+
+// coreLastRecoveryReport calls last_recovery_report
+func coreLastRecoveryReport() (string, bool) {
+	result := C.last_recovery_report()
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreSetChaos calls set_chaos
+func coreSetChaos(dropRate float64, queueFullRate float64, seed uint64) bool {
+	return bool(C.set_chaos(C.double(dropRate), C.double(queueFullRate), C.uint64_t(seed)))
+}
+
+// coreGetFeatures calls get_features
+func coreGetFeatures() uint64 {
+	return uint64(C.get_features())
+}
+
+// coreSetAllocatorStats calls set_allocator_stats
+func coreSetAllocatorStats(enabled bool) {
+	C.set_allocator_stats(C.bool(enabled))
+}
+
+// coreInitCore calls init_core
+func coreInitCore() bool {
+	return bool(C.init_core())
+}
+
+// coreShutdownCore calls shutdown_core
+func coreShutdownCore() bool {
+	return bool(C.shutdown_core())
+}
+
+// coreTakeLastPanic calls take_last_panic
+func coreTakeLastPanic() (string, bool) {
+	result := C.take_last_panic()
+	if result == nil {
+		return "", false
+	}
+	defer C.free_string(result)
+	return C.GoString(result), true
+}
+
+// coreTxBegin calls tx_begin
+func coreTxBegin() uint64 {
+	return uint64(C.tx_begin())
+}
+
+// coreTxCommit calls tx_commit
+func coreTxCommit(txID uint64) bool {
+	return bool(C.tx_commit(C.uint64_t(txID)))
+}
+
+// coreTxRollback calls tx_rollback
+func coreTxRollback(txID uint64) bool {
+	return bool(C.tx_rollback(C.uint64_t(txID)))
+}
@@ -0,0 +1,13 @@
+package fuzzing
+
+import "testing"
+
+func FuzzPublish(f *testing.F) { Publish(f) }
+
+func FuzzPublishBytes(f *testing.F) { PublishBytes(f) }
+
+func FuzzIdentifiers(f *testing.F) { Identifiers(f) }
+
+func FuzzUnsubscribeDuringCallback(f *testing.F) { UnsubscribeDuringCallback(f) }
+
+func FuzzTransform(f *testing.F) { Transform(f) }
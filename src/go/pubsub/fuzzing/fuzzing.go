@@ -0,0 +1,255 @@
+// Package fuzzing holds fuzz targets for the boundary between Go and the Rust
+// core. They feed adversarial input through Subscribe, Publish, GetMessage and
+// friends: strings larger than the limits, invalid UTF-8, embedded NULs,
+// subscriptions removed while their callback runs, and WebAssembly modules the
+// core's interpreter has to refuse or contain. fuzz_test.go wires each up
+// as a fuzz test named after it:
+//
+//	func FuzzPublish(f *testing.F) { fuzzing.Publish(f) }
+//
+// go test runs their seeds, and -fuzz runs one of them with generated input.
+//
+// They are most useful against a core built with AddressSanitizer, which
+// reports memory errors at the boundary as they happen rather than when they
+// corrupt something later:
+//
+//	make rust-asan
+//	LD_LIBRARY_PATH=$PWD/target/asan go test -asan -run='^$' -fuzz='^FuzzPublish$' ./pubsub/fuzzing
+//
+// The asan build tag, which -asan sets, links pubsub against target/asan.
+package fuzzing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// runCount numbers the subscribers and topics of each fuzz input
+var runCount atomic.Uint64
+
+// seeds are inputs known to be awkward at the boundary
+var seeds = []string{
+	"",
+	"plain",
+	"nul\x00inside",
+	"\x00",
+	"\xff\xfe\xfd",
+	"\xc3\x28",
+	"$SYS/reserved",
+	"emoji \U0001F600",
+	strings.Repeat("x", 300),
+	strings.Repeat("y", 70000),
+}
+
+// Publish publishes the input to a queued subscriber and checks that a
+// publish that succeeds comes back unchanged from GetMessage
+func Publish(f *testing.F) {
+	for _, seed := range seeds {
+		f.Add(seed, seed)
+	}
+
+	f.Fuzz(func(t *testing.T, topicSuffix, message string) {
+		subscriberID := fmt.Sprintf("fuzz-publish-%d", runCount.Add(1))
+		topic := "fuzz/" + topicSuffix
+		if err := pubsub.Subscribe(subscriberID, topic, nil); err != nil {
+			return
+		}
+		defer pubsub.Unsubscribe(subscriberID, "")
+
+		if err := pubsub.Publish(topic, message); err != nil {
+			return
+		}
+
+		msg, err := pubsub.GetMessage(subscriberID, topic)
+		if err != nil {
+			var truncated *pubsub.ErrMessageTruncated
+			if errors.As(err, &truncated) {
+				return
+			}
+			t.Fatalf("published message not queued: %v", err)
+		}
+		if msg.Topic != topic || msg.Content != message {
+			t.Fatalf("got %q on %q, want %q on %q", msg.Content, msg.Topic, message, topic)
+		}
+	})
+}
+
+// PublishBytes publishes the input as a binary payload and checks that a
+// publish that succeeds comes back unchanged from GetBuffer
+func PublishBytes(f *testing.F) {
+	for _, seed := range seeds {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		subscriberID := fmt.Sprintf("fuzz-bytes-%d", runCount.Add(1))
+		topic := "fuzz/bytes"
+		if err := pubsub.Subscribe(subscriberID, topic, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer pubsub.Unsubscribe(subscriberID, "")
+
+		if err := pubsub.PublishBytes(topic, payload); err != nil {
+			return
+		}
+
+		buffer, err := pubsub.GetBuffer(subscriberID, topic)
+		if err != nil {
+			t.Fatalf("published payload not queued: %v", err)
+		}
+		defer buffer.Release()
+		if string(buffer.Bytes()) != string(payload) {
+			t.Fatalf("got %q, want %q", buffer.Bytes(), payload)
+		}
+	})
+}
+
+// Identifiers passes the input as subscriber ID and topic to every call
+// taking them, which must fail cleanly rather than crash
+func Identifiers(f *testing.F) {
+	for _, seed := range seeds {
+		f.Add(seed, seed)
+	}
+
+	f.Fuzz(func(t *testing.T, subscriberID, topic string) {
+		pubsub.Subscribe(subscriberID, topic, func(string, string) {})
+		pubsub.Publish(topic, "message")
+		pubsub.SendTo(subscriberID, "message")
+		pubsub.HasMessages(subscriberID, topic)
+		pubsub.GetMessage(subscriberID, topic)
+		pubsub.PollMessages(subscriberID, topic, 4)
+		pubsub.Pause(subscriberID, topic)
+		pubsub.Resume(subscriberID, topic)
+		pubsub.Unsubscribe(subscriberID, topic)
+		pubsub.Unsubscribe(subscriberID, "")
+
+		if !utf8.ValidString(subscriberID) || !utf8.ValidString(topic) {
+			return
+		}
+		if err := pubsub.Subscribe(subscriberID, topic, nil); err == nil {
+			pubsub.Unsubscribe(subscriberID, "")
+		}
+	})
+}
+
+// UnsubscribeDuringCallback publishes the input to callback subscribers while
+// another goroutine unsubscribes them, so subscriptions go away while their
+// callbacks are being looked up and run. Callbacks can't call back into the
+// broker, so the unsubscribing has to come from elsewhere.
+func UnsubscribeDuringCallback(f *testing.F) {
+	for _, seed := range seeds {
+		f.Add(seed, uint8(3))
+	}
+
+	f.Fuzz(func(t *testing.T, message string, subscribers uint8) {
+		run := runCount.Add(1)
+		topic := fmt.Sprintf("fuzz/unsubscribe/%d", run)
+
+		var ids []string
+		for i := 0; i < int(subscribers%8)+1; i++ {
+			subscriberID := fmt.Sprintf("fuzz-unsubscribe-%d-%d", run, i)
+			err := pubsub.Subscribe(subscriberID, topic, func(_, received string) {
+				if received != message {
+					t.Errorf("callback got %q, want %q", received, message)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, subscriberID)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range 4 * len(ids) {
+				pubsub.Publish(topic, message)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for _, subscriberID := range ids {
+				pubsub.Unsubscribe(subscriberID, "")
+			}
+		}()
+		wg.Wait()
+
+		for _, subscriberID := range ids {
+			pubsub.Unsubscribe(subscriberID, "")
+		}
+	})
+}
+
+// identityModule is a transform module that passes every message on
+// unchanged, the seed Transform mutates into modules that aren't
+const identityModule = "\x00asm\x01\x00\x00\x00\x01\x12\x03`\x01\x7f\x01\x7f`\x04\x7f\x7f\x7f\x7f\x01~`\x00\x01~" +
+	"\x03\x04\x03\x00\x01\x02\x05\x03\x01\x00\x01\x06\a\x01\x7f\x01A\x80\b\v" +
+	"\a'\x04\x06memory\x02\x00\x05alloc\x00\x00\ttransform\x00\x01\x06reason\x00\x02" +
+	"\n$\x03\v\x00#\x00#\x00 \x00j$\x00\v\x11\x00A\x80\b$\x00 \x02\xadB \x86 \x03\xad\x84\v\x04\x00B\t\v" +
+	"\v\x0f\x01\x00A\x00\v\tno thanks"
+
+// Transform attaches the input as a WASM transform and publishes through it
+// from two goroutines at once, exercising the core's decoder and interpreter
+// and the per-transform lock they run under. Attaching may fail and a publish
+// may be dropped or rejected, but every publish reported delivered must be
+// queued.
+func Transform(f *testing.F) {
+	f.Add([]byte(identityModule), "message")
+	f.Add([]byte(identityModule[:len(identityModule)-1]), "message")
+	f.Add([]byte("\x00asm\x01\x00\x00\x00"), "")
+	for _, seed := range seeds {
+		f.Add([]byte(seed), seed)
+	}
+
+	f.Fuzz(func(t *testing.T, module []byte, message string) {
+		run := runCount.Add(1)
+		subscriberID := fmt.Sprintf("fuzz-transform-%d", run)
+		topic := fmt.Sprintf("fuzz/transform/%d", run)
+		if err := pubsub.Subscribe(subscriberID, topic, nil); err != nil {
+			t.Fatal(err)
+		}
+		defer pubsub.Unsubscribe(subscriberID, "")
+
+		id, err := pubsub.AttachTransform(topic, module)
+		if err != nil {
+			return
+		}
+		defer pubsub.DetachTransform(topic, id)
+
+		var wg sync.WaitGroup
+		var delivered atomic.Int64
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 2 {
+					report, err := pubsub.PublishSync(topic, message)
+					if err == nil && !report.Filtered {
+						delivered.Add(int64(report.Delivered))
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		for i := range delivered.Load() {
+			if _, err := pubsub.GetMessage(subscriberID, topic); err != nil {
+				var truncated *pubsub.ErrMessageTruncated
+				if !errors.As(err, &truncated) {
+					t.Fatalf("message %d of %d reported delivered not queued: %v", i+1, delivered.Load(), err)
+				}
+			}
+		}
+		if pubsub.HasMessages(subscriberID, topic) {
+			t.Fatal("more messages queued than reported delivered")
+		}
+	})
+}
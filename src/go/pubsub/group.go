@@ -0,0 +1,211 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// SysGroups carries consumer group rebalance events as JSON objects
+const SysGroups = "$SYS/groups"
+
+// GroupPartitions is the number of partitions ordering keys are hashed into
+// within a consumer group. Each partition belongs to one member at a time.
+const GroupPartitions = C.GROUP_PARTITIONS
+
+// BalanceStrategy is how a consumer group picks the member that receives a
+// message published without an ordering key
+type BalanceStrategy int
+
+const (
+	// BalanceRoundRobin gives the members messages in turn
+	BalanceRoundRobin BalanceStrategy = C.BALANCE_ROUND_ROBIN
+	// BalanceLeastPending gives each message to the member with the fewest
+	// messages from the topic queued, held or fetched and not acked, so slow
+	// members fall behind less. Callback members take their messages at once
+	// and so never have any pending.
+	BalanceLeastPending BalanceStrategy = C.BALANCE_LEAST_PENDING
+	// BalanceWeighted gives the members messages in proportion to the weights
+	// they subscribed WithWeight, interleaved
+	BalanceWeighted BalanceStrategy = C.BALANCE_WEIGHTED
+)
+
+func (s BalanceStrategy) String() string {
+	switch s {
+	case BalanceRoundRobin:
+		return "round_robin"
+	case BalanceLeastPending:
+		return "least_pending"
+	case BalanceWeighted:
+		return "weighted"
+	default:
+		return fmt.Sprintf("BalanceStrategy(%d)", int(s))
+	}
+}
+
+// SetGroupCooldown keeps the partitions of a member that leaves a consumer
+// group its own for cooldown, so a member that restarts or briefly
+// disconnects rejoins with the same keys instead of having them moved away
+// and back. Messages for its partitions are parked meanwhile and delivered,
+// in order, when it rejoins or once the cooldown passes and the partitions go
+// to the other members; those of a group left without members are dropped.
+// Partitions are assigned stickily either way: a rebalance only moves the
+// partitions of departed members and those needed to even out the shares. A
+// cooldown of 0 reassigns partitions at once, which is the default.
+func SetGroupCooldown(cooldown time.Duration) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"group_cooldown": cooldown.String()}}, err)
+	}()
+
+	if cooldown < 0 {
+		return errors.New("failed to set group cooldown: cooldown must not be negative")
+	}
+
+	if !coreSetGroupCooldown(uint64(cooldown.Milliseconds())) {
+		return checkInternal(errors.New("failed to set group cooldown"))
+	}
+	return nil
+}
+
+// RebalanceEvent tells a member of a consumer group that the group's members
+// changed, and which partitions, and so which ordering keys, it gained and
+// lost. Every member gets one when another joins or leaves, since its share
+// of unkeyed messages changes too, and a member that leaves gets one with all
+// its partitions revoked, even while SetGroupCooldown holds them for it.
+type RebalanceEvent struct {
+	Topic        string `json:"topic"`
+	Group        string `json:"group"`
+	SubscriberID string `json:"subscriber_id"`
+	// Assigned and Revoked are the partitions the member gained and lost
+	Assigned []int `json:"assigned"`
+	Revoked  []int `json:"revoked"`
+	// Partitions are the partitions the member now owns
+	Partitions []int `json:"partitions"`
+	// Members are the group's members after the change, in join order
+	Members []string `json:"members"`
+}
+
+// Left reports whether the event is the member's last, sent as it left the
+// group
+func (e RebalanceEvent) Left() bool {
+	return !slices.Contains(e.Members, e.SubscriberID)
+}
+
+// KeyPartition returns the partition of an ordering key, to match keys
+// against the partitions of a RebalanceEvent
+func KeyPartition(key string) int {
+	return int(coreKeyPartition(key))
+}
+
+// WithRebalance calls handler with each rebalance event for the subscriber in
+// the consumer group joined WithGroup, starting with the one for joining it.
+// Events are handled in order on a goroutine of their own, so the handler may
+// call the broker, for instance to flush state for revoked keys before
+// unsubscribing. It has no effect without WithGroup.
+func WithRebalance(handler func(RebalanceEvent)) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.onRebalance = handler
+	}
+}
+
+// groupMember identifies a subscriber's membership of a group
+type groupMember struct {
+	subscriberID string
+	topic        string
+	group        string
+}
+
+// rebalanceCall is a rebalance event waiting for its handler
+type rebalanceCall struct {
+	handler func(RebalanceEvent)
+	event   RebalanceEvent
+}
+
+var rebalances struct {
+	start sync.Once
+	sync.Mutex
+	handlers map[groupMember]func(RebalanceEvent)
+	pending  []rebalanceCall
+	wake     chan struct{}
+}
+
+// watchRebalances sets the rebalance handler of a group member, or removes it
+// if handler is nil. It must be set before the member joins to see the
+// event for joining.
+func watchRebalances(subscriberID, topic, group string, handler func(RebalanceEvent)) error {
+	member := groupMember{subscriberID, topic, group}
+	if handler == nil {
+		rebalances.Lock()
+		delete(rebalances.handlers, member)
+		rebalances.Unlock()
+		return nil
+	}
+
+	var err error
+	rebalances.start.Do(func() {
+		rebalances.handlers = make(map[groupMember]func(RebalanceEvent))
+		rebalances.wake = make(chan struct{}, 1)
+		go runRebalances()
+		err = subscribe("$rebalance", SysGroups, FromCallback(queueRebalance), nil)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch rebalances: %w", err)
+	}
+
+	rebalances.Lock()
+	rebalances.handlers[member] = handler
+	rebalances.Unlock()
+	return nil
+}
+
+// queueRebalance queues an event from SysGroups for its member's handler. It
+// runs under the broker lock, so handlers are called from runRebalances, or
+// inline in deterministic mode.
+func queueRebalance(topic, message string) {
+	var event RebalanceEvent
+	if err := json.Unmarshal([]byte(message), &event); err != nil {
+		return
+	}
+
+	rebalances.Lock()
+	member := groupMember{event.SubscriberID, event.Topic, event.Group}
+	handler, ok := rebalances.handlers[member]
+	if ok && event.Left() {
+		delete(rebalances.handlers, member)
+	}
+	if ok && !deterministic.Load() {
+		rebalances.pending = append(rebalances.pending, rebalanceCall{handler, event})
+	}
+	rebalances.Unlock()
+
+	if !ok {
+		return
+	}
+	if deterministic.Load() {
+		handler(event)
+		return
+	}
+	select {
+	case rebalances.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runRebalances calls the handlers of queued rebalance events in order
+func runRebalances() {
+	for range rebalances.wake {
+		rebalances.Lock()
+		calls := rebalances.pending
+		rebalances.pending = nil
+		rebalances.Unlock()
+
+		for _, call := range calls {
+			call.handler(call.event)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrHandlerTimeout is the failure recorded for a delivery attempt whose
+// handler ran past the timeout set with WithHandlerTimeout
+var ErrHandlerTimeout = errors.New("handler exceeded its timeout")
+
+// HandlerFunc handles a message delivered to a subscription. The context
+// carries the delivery metadata, available through DeliveryInfoFromContext, and
+// the deadline set with WithHandlerTimeout. A returned error, a panic or an
+// overrun timeout counts as a failed delivery: the message is retried and
+// quarantined as configured with WithQuarantine, and the failure is recorded
+// by the circuit breaker.
+type HandlerFunc func(ctx context.Context, msg *Message) error
+
+// FromCallback adapts a MessageCallback to a HandlerFunc that always succeeds.
+// A nil callback gives a nil handler.
+func FromCallback(callback MessageCallback) HandlerFunc {
+	if callback == nil {
+		return nil
+	}
+	return func(ctx context.Context, msg *Message) error {
+		callback(msg.Topic, msg.Content)
+		return nil
+	}
+}
+
+// DeliveryInfo describes the delivery a handler is called for
+type DeliveryInfo struct {
+	// SubscriberID is the subscriber the message is delivered to
+	SubscriberID string
+	// Topic is the topic the message was published to
+	Topic string
+	// Attempt is the delivery attempt, starting at 1
+	Attempt int
+	// MaxAttempts is the number of attempts before the message is quarantined,
+	// or 0 if failed messages are dropped
+	MaxAttempts int
+}
+
+type deliveryInfoKey struct{}
+
+// DeliveryInfoFromContext returns the delivery metadata of a handler's context
+func DeliveryInfoFromContext(ctx context.Context) (DeliveryInfo, bool) {
+	info, ok := ctx.Value(deliveryInfoKey{}).(DeliveryInfo)
+	return info, ok
+}
+
+// newDeliveryContext returns the context for one handler invocation
+func newDeliveryContext(info DeliveryInfo, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(context.Background(), deliveryInfoKey{}, info)
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// invokeWithDeadline calls the handler like invokeGuarded, but stops waiting
+// for it when the context's deadline passes. The handler keeps running in the
+// background with a cancelled context. In deterministic mode it always waits.
+func invokeWithDeadline(handler HandlerFunc, ctx context.Context, msg *Message) error {
+	if _, ok := ctx.Deadline(); !ok || deterministic.Load() {
+		return invokeGuarded(handler, ctx, msg)
+	}
+
+	// The handler may outlive this call, so it is counted as in flight on its own
+	done := make(chan error, 1)
+	inflight.add()
+	go func() {
+		defer inflight.done()
+		done <- invokeGuarded(handler, ctx, msg)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ErrHandlerTimeout
+	}
+}
+
+// invokeGuarded calls the handler, converting a panic into an error
+func invokeGuarded(handler HandlerFunc, ctx context.Context, msg *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+
+	return handler(ctx, msg)
+}
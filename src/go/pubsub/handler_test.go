@@ -0,0 +1,72 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandlerPanicFailsDelivery(t *testing.T) {
+	if err := Subscribe("panic-test", "test/panic", func(topic, message string) {
+		panic("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer Unsubscribe("panic-test", "")
+
+	report, err := PublishSync("test/panic", "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Delivered != 0 || report.Dropped != 1 {
+		t.Fatalf("got %+v, want the delivery dropped", report)
+	}
+}
+
+func TestHandlerPanicRelaxedOrdering(t *testing.T) {
+	panicked := make(chan struct{}, 1)
+	if err := Subscribe("panic-relaxed-test", "test/panic-relaxed", func(topic, message string) {
+		defer func() { panicked <- struct{}{} }()
+		panic("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer Unsubscribe("panic-relaxed-test", "")
+	if err := SetTopicOrdering("test/panic-relaxed", OrderingRelaxed); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second delivery shows the worker survived the first
+	for i := 0; i < 2; i++ {
+		if err := Publish("test/panic-relaxed", "message"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-panicked:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("delivery %d never ran", i+1)
+		}
+	}
+}
+
+func TestPublishSyncWaitsForRelaxedOrdering(t *testing.T) {
+	var ran bool
+	if err := Subscribe("sync-relaxed-test", "test/sync-relaxed", func(topic, message string) {
+		ran = true
+		panic("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer Unsubscribe("sync-relaxed-test", "")
+	if err := SetTopicOrdering("test/sync-relaxed", OrderingRelaxed); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTopicOrdering("test/sync-relaxed", OrderingStrict)
+
+	report, err := PublishSync("test/sync-relaxed", "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ran || report.Delivered != 0 || report.Dropped != 1 {
+		t.Fatalf("got %+v with the handler run %v, want it run and the delivery dropped", report, ran)
+	}
+}
@@ -0,0 +1,143 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+//go:generate go run ../internal/ffigen -codes PUBLISH_
+
+// ABIVersion is the version of the core's C interface this package was built
+// against. Health reports a mismatch with the loaded library as failing.
+const ABIVersion = coreABIVersion
+
+// healthCheckTimeout bounds each check run by Health
+const healthCheckTimeout = time.Second
+
+// HealthStatus is the outcome of a health check
+type HealthStatus string
+
+const (
+	HealthOK      HealthStatus = "ok"
+	HealthFailing HealthStatus = "failing"
+)
+
+// HealthCheck is the result of one check in a HealthReport
+type HealthCheck struct {
+	Name   string       `json:"name"`
+	Status HealthStatus `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+}
+
+// HealthReport describes whether the broker can serve traffic
+type HealthReport struct {
+	// Status is failing if any check is failing
+	Status HealthStatus `json:"status"`
+	// ABIVersion is the C interface version of the loaded core library
+	ABIVersion int `json:"abi_version"`
+	// RetainedBytes is the size of the message payloads held by the broker
+	RetainedBytes uint64        `json:"retained_bytes"`
+	Checks        []HealthCheck `json:"checks"`
+}
+
+// healthChecks holds the checks added with RegisterHealthCheck
+var healthChecks = struct {
+	sync.RWMutex
+	checks map[string]func(context.Context) error
+}{
+	checks: make(map[string]func(context.Context) error),
+}
+
+// RegisterHealthCheck adds a check to Health, such as the connection state of
+// a persistence backend or bridge. A check that returns an error, or doesn't
+// return within a second, fails the report. Registering a name again replaces
+// its check, and a nil check removes it.
+func RegisterHealthCheck(name string, check func(context.Context) error) {
+	healthChecks.Lock()
+	defer healthChecks.Unlock()
+
+	if check == nil {
+		delete(healthChecks.checks, name)
+		return
+	}
+	healthChecks.checks[name] = check
+}
+
+// Health checks the core library and the broker, and runs the registered checks
+func Health() HealthReport {
+	report := HealthReport{Status: HealthOK}
+
+	// Being able to call into the library at all means it is loaded
+	report.ABIVersion = int(C.abi_version())
+	report.add("library", nil)
+	if report.ABIVersion != ABIVersion {
+		report.add("abi", fmt.Errorf("core library has ABI version %d, expected %d", report.ABIVersion, ABIVersion))
+	} else {
+		report.add("abi", nil)
+	}
+
+	// The broker is failing if its lock is held long enough to stall a stats
+	// snapshot, e.g. by a hung callback
+	var retained uint64
+	err := runHealthCheck(func(ctx context.Context) error {
+		stats, err := Stats()
+		if err == nil {
+			retained = stats.RetainedBytes
+		}
+		return err
+	})
+	if err == nil {
+		report.RetainedBytes = retained
+	}
+	report.add("broker", err)
+
+	healthChecks.RLock()
+	checks := make(map[string]func(context.Context) error, len(healthChecks.checks))
+	names := make([]string, 0, len(healthChecks.checks))
+	for name, check := range healthChecks.checks {
+		checks[name] = check
+		names = append(names, name)
+	}
+	healthChecks.RUnlock()
+
+	sort.Strings(names)
+	for _, name := range names {
+		report.add(name, runHealthCheck(checks[name]))
+	}
+
+	return report
+}
+
+// add records the result of a check
+func (r *HealthReport) add(name string, err error) {
+	check := HealthCheck{Name: name, Status: HealthOK}
+	if err != nil {
+		check.Status = HealthFailing
+		check.Detail = err.Error()
+		r.Status = HealthFailing
+	}
+	r.Checks = append(r.Checks, check)
+}
+
+// runHealthCheck runs a check, giving up on it after healthCheckTimeout
+func runHealthCheck(check func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("check did not finish within %v", healthCheckTimeout)
+	}
+}
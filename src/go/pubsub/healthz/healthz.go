@@ -0,0 +1,23 @@
+// Package healthz serves the broker health report for liveness and readiness probes
+package healthz
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// Handler serves pubsub.Health as JSON, with status 503 when it is failing
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := pubsub.Health()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != pubsub.HealthOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
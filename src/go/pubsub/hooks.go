@@ -0,0 +1,175 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrVetoed is returned, wrapping the hook's error, when an OnSubscribe or
+// OnPublish hook refuses a subscription or a message
+var ErrVetoed = errors.New("vetoed by a hook")
+
+// Hooks observe, and for subscriptions and publishes decide on, what callers
+// of the package do, for metrics, auditing or policies of the embedding
+// application. Any field may be nil. Hooks see the subscribers and topics of
+// callers only, not the ones the package uses internally, such as $SYS topics.
+//
+// Hooks may be called while the broker is locked and must not call back into
+// the pubsub package.
+type Hooks struct {
+	// OnSubscribe is called before a subscriber subscribes to a topic, once
+	// per topic for SubscribeMany. An error refuses the subscription, and the
+	// Subscribe call returns it wrapped with ErrVetoed.
+	OnSubscribe func(subscriberID, topic string) error
+	// OnUnsubscribe is called after a subscriber unsubscribed from a topic,
+	// or from all of them if topic is empty
+	OnUnsubscribe func(subscriberID, topic string)
+	// OnPublish is called before a message is published with Publish,
+	// PublishSync, PublishBytes or the functions built on them. An error
+	// refuses the message, and the call returns it wrapped with ErrVetoed.
+	OnPublish func(topic, message string) error
+	// OnDrop is called after a message was published with the number of
+	// subscribers that dropped it, when there were any, for instance because
+	// their queue was full or their callback failed
+	OnDrop func(topic string, dropped int)
+	// OnDeliver is called after a subscriber's handler accepted a message
+	OnDeliver func(subscriberID string, msg *Message)
+}
+
+// registeredHooks is every Hooks registered and not yet unregistered, in the
+// order they were registered. It is replaced rather than modified, so calls
+// read it without locking.
+var registeredHooks atomic.Pointer[[]*Hooks]
+
+// hooksMu serializes changes to registeredHooks
+var hooksMu sync.Mutex
+
+// RegisterHooks adds hooks to those called by the package, after the ones
+// registered before, and returns a function that removes them again. The
+// first OnSubscribe or OnPublish hook to return an error vetoes the call, and
+// later ones are not called.
+func RegisterHooks(hooks Hooks) (unregister func()) {
+	h := &hooks
+	hooksMu.Lock()
+	updateHooks(func(list []*Hooks) []*Hooks { return append(list, h) })
+	hooksMu.Unlock()
+	recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"hooks": "registered"}}, nil)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			hooksMu.Lock()
+			updateHooks(func(list []*Hooks) []*Hooks {
+				kept := make([]*Hooks, 0, len(list))
+				for _, other := range list {
+					if other != h {
+						kept = append(kept, other)
+					}
+				}
+				return kept
+			})
+			hooksMu.Unlock()
+			recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"hooks": "unregistered"}}, nil)
+		})
+	}
+}
+
+// updateHooks replaces the registered hooks with a changed copy. The caller
+// holds hooksMu.
+func updateHooks(change func([]*Hooks) []*Hooks) {
+	var list []*Hooks
+	if current := registeredHooks.Load(); current != nil {
+		list = append(list, *current...)
+	}
+	list = change(list)
+	registeredHooks.Store(&list)
+}
+
+// eachHook calls f with every registered Hooks until it returns an error
+func eachHook(f func(*Hooks) error) error {
+	list := registeredHooks.Load()
+	if list == nil {
+		return nil
+	}
+	for _, hooks := range *list {
+		if err := f(hooks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hookSubscribe runs the OnSubscribe hooks for a subscription
+func hookSubscribe(subscriberID, topic string) error {
+	err := eachHook(func(h *Hooks) error {
+		if h.OnSubscribe == nil {
+			return nil
+		}
+		return h.OnSubscribe(subscriberID, topic)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe '%s' to topic '%s': %w: %w", subscriberID, topic, ErrVetoed, err)
+	}
+	return nil
+}
+
+// hookUnsubscribe runs the OnUnsubscribe hooks for a subscription removed
+func hookUnsubscribe(subscriberID, topic string) {
+	eachHook(func(h *Hooks) error {
+		if h.OnUnsubscribe != nil {
+			h.OnUnsubscribe(subscriberID, topic)
+		}
+		return nil
+	})
+}
+
+// hooksRegistered reports whether any hooks are registered, so callers can
+// skip preparing their arguments
+func hooksRegistered() bool {
+	list := registeredHooks.Load()
+	return list != nil && len(*list) > 0
+}
+
+// hookPublish runs the OnPublish hooks for a message
+func hookPublish(topic, message string) error {
+	err := eachHook(func(h *Hooks) error {
+		if h.OnPublish == nil {
+			return nil
+		}
+		return h.OnPublish(topic, message)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish message to topic '%s': %w: %w", topic, ErrVetoed, err)
+	}
+	return nil
+}
+
+// hookDrop runs the OnDrop hooks for a message published, if any subscriber
+// dropped it
+func hookDrop(topic string, dropped int) {
+	if dropped == 0 || strings.HasPrefix(topic, ReservedPrefix) {
+		return
+	}
+	eachHook(func(h *Hooks) error {
+		if h.OnDrop != nil {
+			h.OnDrop(topic, dropped)
+		}
+		return nil
+	})
+}
+
+// hookDeliver runs the OnDeliver hooks for a message a handler accepted
+func hookDeliver(subscriberID string, msg *Message) {
+	if strings.HasPrefix(subscriberID, ReservedPrefix) {
+		return
+	}
+	eachHook(func(h *Hooks) error {
+		if h.OnDeliver != nil {
+			h.OnDeliver(subscriberID, msg)
+		}
+		return nil
+	})
+}
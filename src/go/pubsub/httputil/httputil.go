@@ -0,0 +1,76 @@
+// Package httputil carries the trace and correlation IDs of HTTP requests
+// into the messages published while serving them. Middleware puts a publisher
+// on each request's context that stamps the IDs as message headers:
+//
+//	http.Handle("/orders", httputil.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+//		pubsub.FromContext(r.Context()).Publish("orders", body)
+//	})))
+package httputil
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// Message headers stamped by Middleware
+const (
+	// HeaderCorrelationID is the request's correlation ID
+	HeaderCorrelationID = "correlation_id"
+	// HeaderTraceParent is the request's W3C traceparent
+	HeaderTraceParent = "traceparent"
+	// HeaderTraceState is the request's W3C tracestate
+	HeaderTraceState = "tracestate"
+)
+
+// maxCorrelationIDLength bounds the correlation IDs taken from requests
+const maxCorrelationIDLength = 128
+
+// Middleware reads the correlation ID from a request's X-Correlation-ID or
+// X-Request-ID header, generating one if both are missing or the ID is too
+// long, and its W3C trace context. It echoes the correlation ID in the
+// X-Correlation-ID response header and makes them the headers of the
+// context's publisher.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		correlationID := firstHeader(r, "X-Correlation-ID", "X-Request-ID")
+		if correlationID == "" || len(correlationID) > maxCorrelationIDLength {
+			correlationID = newCorrelationID()
+		}
+		w.Header().Set("X-Correlation-ID", correlationID)
+
+		headers := map[string]string{HeaderCorrelationID: correlationID}
+		if traceParent := firstHeader(r, "Traceparent"); traceParent != "" {
+			headers[HeaderTraceParent] = traceParent
+			if traceState := firstHeader(r, "Tracestate"); traceState != "" {
+				headers[HeaderTraceState] = traceState
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(pubsub.NewContext(r.Context(), headers)))
+	})
+}
+
+// CorrelationID returns the correlation ID of a message delivered to a
+// handler, for carrying into the work it starts
+func CorrelationID(msg *pubsub.Message) string {
+	return msg.Headers[HeaderCorrelationID]
+}
+
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := strings.TrimSpace(r.Header.Get(name)); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func newCorrelationID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,49 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"fmt"
+	"unsafe"
+)
+
+// InboxPrefix is the prefix of every subscriber's implicit inbox topic
+const InboxPrefix = "$inbox/"
+
+// InboxTopic returns the topic under which messages sent with SendTo are
+// delivered to a subscriber
+func InboxTopic(subscriberID string) string {
+	return InboxPrefix + subscriberID
+}
+
+// SendTo delivers a message directly to a subscriber's inbox without a named
+// topic. The subscriber receives it through its callback, or its queue if it
+// has none, with InboxTopic(subscriberID) as the topic. The subscriber must
+// have at least one subscription.
+func SendTo(subscriberID, message string) error {
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return err
+	}
+	if err := checkMessage(message); err != nil {
+		return err
+	}
+
+	if err := injectedFault("send_to"); err != nil {
+		return err
+	}
+
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	cMessage := C.CString(message)
+	defer C.free(unsafe.Pointer(cMessage))
+
+	done := timeCgo("send_to")
+	success := C.send_to(cSubscriberID, cMessage)
+	done()
+	if !success {
+		return fmt.Errorf("failed to send message to subscriber '%s'", subscriberID)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"sync"
+	"unsafe"
+)
+
+// maxInternedStrings caps the number of cached C strings. Strings beyond it
+// are converted per call as before.
+const maxInternedStrings = 4096
+
+// internedString is a C copy of a topic or subscriber ID shared by concurrent
+// calls. It is freed once it has left the cache and no call still holds it.
+type internedString struct {
+	ptr     *C.char
+	refs    int
+	evicted bool
+}
+
+// cStrings caches C copies of the topics and subscriber IDs passed to the core,
+// so steady-state publish and consume loops don't allocate them on every call
+var cStrings = struct {
+	sync.Mutex
+	interned map[string]*internedString
+}{
+	interned: make(map[string]*internedString),
+}
+
+// internCString returns the C copy of s, which must be released after use
+func internCString(s string) *internedString {
+	cStrings.Lock()
+	defer cStrings.Unlock()
+
+	str, exists := cStrings.interned[s]
+	if !exists {
+		str = &internedString{ptr: C.CString(s)}
+		if len(cStrings.interned) < maxInternedStrings {
+			cStrings.interned[s] = str
+		} else {
+			str.evicted = true
+		}
+	}
+	str.refs++
+	return str
+}
+
+// internOptional is internCString for an optional argument, where the empty
+// string is passed to the core as NULL
+func internOptional(s string) *internedString {
+	if s == "" {
+		return &internedString{}
+	}
+	return internCString(s)
+}
+
+// release gives back a string returned by internCString
+func (s *internedString) release() {
+	if s.ptr == nil {
+		return
+	}
+
+	cStrings.Lock()
+	defer cStrings.Unlock()
+
+	s.refs--
+	if s.evicted && s.refs == 0 {
+		C.free(unsafe.Pointer(s.ptr))
+	}
+}
+
+// forgetCString drops a string from the cache, e.g. a subscriber ID once the
+// subscriber is gone. Calls still holding it keep a valid pointer.
+func forgetCString(s string) {
+	cStrings.Lock()
+	defer cStrings.Unlock()
+
+	str, exists := cStrings.interned[s]
+	if !exists {
+		return
+	}
+	delete(cStrings.interned, s)
+	str.evicted = true
+	if str.refs == 0 {
+		C.free(unsafe.Pointer(str.ptr))
+	}
+}
@@ -0,0 +1,65 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// SysSubscribers is the topic receiving subscriber events as JSON
+const SysSubscribers = "$SYS/subscribers"
+
+// SubscriberEventType is the kind of a subscriber event
+type SubscriberEventType string
+
+// SubscriberExpired is emitted when an idle subscriber is unsubscribed
+const SubscriberExpired SubscriberEventType = "expired"
+
+// SubscriberEvent is the payload published to SysSubscribers
+type SubscriberEvent struct {
+	Event        SubscriberEventType `json:"event"`
+	SubscriberID string              `json:"subscriber_id"`
+}
+
+// SetSubscriberTTL makes subscribers without a callback expire when they make
+// no call for longer than ttl. Subscribing, GetMessage, HasMessages, Pause,
+// Resume and Touch all count as activity. An expired subscriber is
+// unsubscribed from every topic, its queued messages are discarded and a
+// SubscriberExpired event is published to SysSubscribers. A ttl of 0 disables
+// expiry, which is the default.
+func SetSubscriberTTL(ttl time.Duration) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"subscriber_ttl": ttl.String()}}, err)
+	}()
+
+	if ttl < 0 {
+		return errors.New("failed to set subscriber TTL: TTL must not be negative")
+	}
+
+	success := C.set_subscriber_ttl(C.uint64_t(ttl.Milliseconds()))
+	if !success {
+		return errors.New("failed to set subscriber TTL")
+	}
+
+	return nil
+}
+
+// Touch records activity for a subscriber so it doesn't expire
+func Touch(subscriberID string) error {
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return err
+	}
+
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	success := C.touch_subscriber(cSubscriberID)
+	if !success {
+		return fmt.Errorf("failed to touch unknown subscriber '%s'", subscriberID)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+// Package kv is a small key-value store on the broker, for dynamic
+// configuration. Writes are published to a reserved topic, where the store
+// keeps the latest value of each key and watchers are notified of changes
+// through their own subscriptions.
+//
+//	kv.Put("limits/orders", "100")
+//	w, err := kv.Watch("limits/", func(e kv.Event) { ... })
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// Topic is the reserved topic carrying writes
+const Topic = "_kv"
+
+// storeSubscriberID is the subscription keeping the latest values
+const storeSubscriberID = "_kv/store"
+
+// Event is a change to a key
+type Event struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	// Deleted is set when the key was deleted
+	Deleted bool `json:"deleted,omitempty"`
+	// Revision orders the changes to all keys, starting from 1
+	Revision uint64 `json:"revision"`
+}
+
+var store = struct {
+	once sync.Once
+	err  error
+
+	// write serializes publishes, so revisions follow publish order
+	write    sync.Mutex
+	revision uint64
+
+	mu     sync.RWMutex
+	values map[string]string
+}{
+	values: make(map[string]string),
+}
+
+// open subscribes the store to the topic on first use
+func open() error {
+	store.once.Do(func() {
+		err := pubsub.Subscribe(storeSubscriberID, Topic, func(topic, message string) {
+			var e Event
+			if json.Unmarshal([]byte(message), &e) != nil {
+				return
+			}
+			store.mu.Lock()
+			if e.Deleted {
+				delete(store.values, e.Key)
+			} else {
+				store.values[e.Key] = e.Value
+			}
+			store.mu.Unlock()
+		})
+		if err != nil {
+			store.err = fmt.Errorf("failed to open key-value store: %w", err)
+		}
+	})
+	return store.err
+}
+
+// Put sets a key to a value
+func Put(key, value string) error {
+	return write(Event{Key: key, Value: value})
+}
+
+// Delete removes a key; deleting a missing key still notifies watchers
+func Delete(key string) error {
+	return write(Event{Key: key, Deleted: true})
+}
+
+func write(e Event) error {
+	if e.Key == "" {
+		return errors.New("key-value store requires a key")
+	}
+	if err := open(); err != nil {
+		return err
+	}
+
+	store.write.Lock()
+	defer store.write.Unlock()
+
+	e.Revision = store.revision + 1
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := pubsub.Publish(Topic, string(data)); err != nil {
+		return fmt.Errorf("failed to write key '%s': %w", e.Key, err)
+	}
+	store.revision = e.Revision
+	return nil
+}
+
+// Get returns the value of a key and whether it is set
+func Get(key string) (string, bool, error) {
+	if err := open(); err != nil {
+		return "", false, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	value, ok := store.values[key]
+	return value, ok, nil
+}
+
+// List returns the keys and values whose keys start with prefix
+func List(prefix string) (map[string]string, error) {
+	if err := open(); err != nil {
+		return nil, err
+	}
+
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	values := make(map[string]string)
+	for key, value := range store.values {
+		if strings.HasPrefix(key, prefix) {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// Watcher is a subscription to changes of keys with a prefix
+type Watcher struct {
+	subscriberID string
+}
+
+var watchers struct {
+	sync.Mutex
+	next uint64
+}
+
+// Watch calls fn with every change to a key starting with prefix made after
+// Watch returns, in revision order. Read the current values with List first.
+// Like a subscription callback, fn must not call back into the pubsub or kv
+// packages.
+func Watch(prefix string, fn func(Event)) (*Watcher, error) {
+	if err := open(); err != nil {
+		return nil, err
+	}
+
+	watchers.Lock()
+	watchers.next++
+	w := &Watcher{subscriberID: fmt.Sprintf("_kv/watch/%d", watchers.next)}
+	watchers.Unlock()
+
+	err := pubsub.Subscribe(w.subscriberID, Topic, func(topic, message string) {
+		var e Event
+		if json.Unmarshal([]byte(message), &e) != nil || !strings.HasPrefix(e.Key, prefix) {
+			return
+		}
+		fn(e)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch prefix '%s': %w", prefix, err)
+	}
+	return w, nil
+}
+
+// Close stops the watcher
+func (w *Watcher) Close() error {
+	return pubsub.Unsubscribe(w.subscriberID, Topic)
+}
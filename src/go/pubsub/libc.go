@@ -0,0 +1,21 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"fmt"
+	"runtime"
+)
+
+// The core and the binary loading it must agree on their C library: a core
+// built for glibc calls symbols musl doesn't have, and one built for musl
+// allocates with an allocator the binary's libc doesn't know. Build with the
+// musl tag on Alpine and other musl systems.
+func init() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if core := C.GoString(C.target_libc()); core != "" && core != buildLibc {
+		panic(fmt.Sprintf("pubsub: core library was built for %s libc but this binary for %s; %s", core, buildLibc, libcHint))
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !musl
+
+package pubsub
+
+// buildLibc is the C library this binary is built for
+const buildLibc = "gnu"
+
+const libcHint = "build with -tags musl, or use a core built for glibc"
@@ -0,0 +1,8 @@
+//go:build musl
+
+package pubsub
+
+// buildLibc is the C library this binary is built for
+const buildLibc = "musl"
+
+const libcHint = "build without the musl tag, or use a core built for musl"
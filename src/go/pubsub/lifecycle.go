@@ -0,0 +1,120 @@
+package pubsub
+
+// #include "pubsub_core.h"
+//
+// // Gateway function for panics caught in the core
+// void panicCallbackGateway(char* message);
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrInitialized is returned by Init when the package is already initialized
+var ErrInitialized = errors.New("pubsub is already initialized")
+
+// lifecycle tracks whether Init has run without a Shutdown since
+var lifecycle struct {
+	sync.Mutex
+	initialized bool
+}
+
+// panicHook is the Config.PanicHook Init was given, if any
+var panicHook atomic.Pointer[func(*InternalError)]
+
+//export panicCallbackGateway
+func panicCallbackGateway(message *C.char) {
+	if hook := panicHook.Load(); hook != nil {
+		(*hook)(&InternalError{Panic: C.GoString(message)})
+	}
+}
+
+// Init initializes the package with a configuration: its threading model
+// (Deterministic), a logger, allocator stats, a panic hook, encryption and a
+// storage engine, along with the broker settings a config file can hold. Call it before any other function
+// of the package, so that nothing runs before the configuration is in place.
+//
+// Init is optional. Without it the package initializes itself on first use
+// with the default settings, as it always has, and has no logger or panic
+// hook. Init returns ErrInitialized if it already ran, unless Shutdown was
+// called since. A later ReloadConfig treats the configuration given to Init
+// as the one previously loaded.
+//
+// If a setting is rejected, Init returns the errors of every rejected setting
+// and leaves the package uninitialized, so it can be called again with a
+// corrected configuration. The settings that were accepted stay applied.
+func Init(config Config) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"config": "init"}}, err)
+	}()
+
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	if lifecycle.initialized {
+		return ErrInitialized
+	}
+
+	SetLogger(config.Logger)
+	if config.PanicHook != nil {
+		panicHook.Store(&config.PanicHook)
+	} else {
+		panicHook.Store(nil)
+	}
+	C.set_panic_callback(C.panic_callback(C.panicCallbackGateway))
+
+	appliedConfig.Lock()
+	err = config.apply(appliedConfig.config)
+	appliedConfig.config = &config
+	appliedConfig.Unlock()
+
+	if err == nil && !coreInitCore() {
+		err = checkInternal(errors.New("failed to initialize the core"))
+	}
+	if err == nil && config.KeyProvider != nil {
+		err = SetEncryption(config.KeyProvider)
+	}
+	// Topic delivery modes are in place, so recovered subscribers of queue
+	// topics share their messages again
+	if err == nil && config.Storage != nil {
+		err = config.Storage.open()
+	}
+	if err != nil {
+		clearHooks()
+		return fmt.Errorf("failed to initialize pubsub: %w", err)
+	}
+
+	lifecycle.initialized = true
+	return nil
+}
+
+// Shutdown calls Close, then stops the core's background threads and removes
+// the logger and panic hook given to Init, after which Init may be called
+// again. Topics, queued messages and settings are kept; a setter that needs a
+// background thread starts it again. Shutdown returns the error of Close
+// along with any of its own, but shuts the core down either way.
+func Shutdown(ctx context.Context) error {
+	lifecycle.Lock()
+	defer lifecycle.Unlock()
+
+	closeErr := Close(ctx)
+
+	var err error
+	if !coreShutdownCore() {
+		err = checkInternal(errors.New("failed to shut down the core"))
+	}
+	clearHooks()
+	lifecycle.initialized = false
+
+	return errors.Join(closeErr, err)
+}
+
+// clearHooks removes the logger and panic hook set by Init
+func clearHooks() {
+	C.set_panic_callback(nil)
+	SetLogger(nil)
+	panicHook.Store(nil)
+}
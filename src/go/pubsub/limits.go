@@ -0,0 +1,103 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Limits are the broker's size and capacity limits. Sizes are in bytes; a zero
+// MaxTopics or MaxSubscribersPerTopic means no limit.
+type Limits struct {
+	// MaxTopicSize is the maximum length of a topic name
+	MaxTopicSize int
+	// MaxMessageSize is the maximum length of a message
+	MaxMessageSize int
+	// MaxTopics is the maximum number of topics the broker holds
+	MaxTopics int
+	// MaxSubscribersPerTopic is the maximum number of subscribers to a single topic
+	MaxSubscribersPerTopic int
+}
+
+var (
+	// ErrTopicTooLong is returned when a topic exceeds Limits.MaxTopicSize
+	ErrTopicTooLong = errors.New("topic exceeds the maximum topic size")
+	// ErrMessageTooLarge is returned when a message exceeds Limits.MaxMessageSize
+	ErrMessageTooLarge = errors.New("message exceeds the maximum message size")
+)
+
+// currentLimits caches the broker limits so size checks don't cross the FFI boundary
+var currentLimits atomic.Pointer[Limits]
+
+func init() {
+	var cLimits C.Limits
+	if !C.get_limits(&cLimits) {
+		panic("pubsub: failed to read broker limits")
+	}
+
+	currentLimits.Store(&Limits{
+		MaxTopicSize:           int(cLimits.max_topic_size),
+		MaxMessageSize:         int(cLimits.max_message_size),
+		MaxTopics:              int(cLimits.max_topics),
+		MaxSubscribersPerTopic: int(cLimits.max_subscribers_per_topic),
+	})
+}
+
+// GetLimits returns the current broker limits
+func GetLimits() Limits {
+	return *currentLimits.Load()
+}
+
+// SetLimits replaces the broker limits. Existing topics, subscriptions and queued
+// messages are kept; the new limits apply to subsequent calls.
+func SetLimits(limits Limits) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{
+			"max_topic_size":            strconv.Itoa(limits.MaxTopicSize),
+			"max_message_size":          strconv.Itoa(limits.MaxMessageSize),
+			"max_topics":                strconv.Itoa(limits.MaxTopics),
+			"max_subscribers_per_topic": strconv.Itoa(limits.MaxSubscribersPerTopic),
+		}}, err)
+	}()
+
+	if limits.MaxTopicSize <= 0 || limits.MaxMessageSize <= 0 {
+		return errors.New("failed to set limits: topic and message sizes must be positive")
+	}
+	if limits.MaxTopics < 0 || limits.MaxSubscribersPerTopic < 0 {
+		return errors.New("failed to set limits: topic and subscriber counts must not be negative")
+	}
+
+	cLimits := C.Limits{
+		max_topic_size:            C.size_t(limits.MaxTopicSize),
+		max_message_size:          C.size_t(limits.MaxMessageSize),
+		max_topics:                C.size_t(limits.MaxTopics),
+		max_subscribers_per_topic: C.size_t(limits.MaxSubscribersPerTopic),
+	}
+	if !C.set_limits(&cLimits) {
+		return errors.New("failed to set limits")
+	}
+
+	currentLimits.Store(&limits)
+	return nil
+}
+
+// checkMessage validates a message against the current size limit. A message
+// passed as a C string can't hold a NUL byte or invalid UTF-8 without being
+// altered on the way, so those belong in PublishBytes instead.
+func checkMessage(message string) error {
+	if len(message) > GetLimits().MaxMessageSize {
+		return ErrMessageTooLarge
+	}
+	if strings.IndexByte(message, 0) >= 0 {
+		return fmt.Errorf("invalid message: %w", ErrEmbeddedNUL)
+	}
+	if !utf8.ValidString(message) {
+		return fmt.Errorf("invalid message: %w", ErrInvalidUTF8)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !pubsub_static
+
+package pubsub
+
+// Builds link against libpubsub_core.so unless the pubsub_static tag asks for
+// the static library
+
+// #cgo LDFLAGS: -L../../target/release -lpubsub_core
+import "C"
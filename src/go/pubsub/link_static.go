@@ -0,0 +1,18 @@
+//go:build pubsub_static && !musl
+
+package pubsub
+
+// Builds with the pubsub_static tag link libpubsub_core.a into the binary, so
+// it runs without the shared library. `make rust-static` builds the archive
+// into target/static/GOOS_GOARCH; a cross build needs one built with
+// RUST_TARGET set to its Rust target. The system libraries are those rustc
+// reports with --print native-static-libs. An archive built with the sqlite
+// feature also needs CGO_LDFLAGS=-lsqlite3.
+
+// #cgo linux,amd64 LDFLAGS: ${SRCDIR}/../../../target/static/linux_amd64/libpubsub_core.a
+// #cgo linux,arm64 LDFLAGS: ${SRCDIR}/../../../target/static/linux_arm64/libpubsub_core.a
+// #cgo darwin,amd64 LDFLAGS: ${SRCDIR}/../../../target/static/darwin_amd64/libpubsub_core.a
+// #cgo darwin,arm64 LDFLAGS: ${SRCDIR}/../../../target/static/darwin_arm64/libpubsub_core.a
+// #cgo linux LDFLAGS: -lgcc_s -lutil -lrt -lpthread -lm -ldl -lc
+// #cgo darwin LDFLAGS: -lSystem -lc -lm
+import "C"
@@ -0,0 +1,12 @@
+//go:build pubsub_static && musl
+
+package pubsub
+
+// Static builds for musl link the archive `make rust-static-musl` builds into
+// target/static/linux_GOARCH_musl. Rust's musl targets bundle libunwind, so
+// musl's libc is the only system library.
+
+// #cgo linux,amd64 LDFLAGS: ${SRCDIR}/../../../target/static/linux_amd64_musl/libpubsub_core.a
+// #cgo linux,arm64 LDFLAGS: ${SRCDIR}/../../../target/static/linux_arm64_musl/libpubsub_core.a
+// #cgo linux LDFLAGS: -lc
+import "C"
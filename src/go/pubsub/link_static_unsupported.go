@@ -0,0 +1,7 @@
+//go:build pubsub_static && (!((linux || darwin) && (amd64 || arm64)) || (musl && !linux))
+
+package pubsub
+
+// There is no static library layout for other platforms yet, so fail the
+// build here rather than at link time with undefined symbols
+var _ = pubsub_static_is_not_supported_on_this_platform
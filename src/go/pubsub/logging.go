@@ -0,0 +1,105 @@
+package pubsub
+
+// #include "pubsub_core.h"
+//
+// // Gateway function for the core's log events
+// void logCallbackGateway(uint32_t level, char* message, char* fields);
+import "C"
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// LevelTrace is the slog level of the core's most verbose log events, below
+// slog.LevelDebug
+const LevelTrace = slog.LevelDebug - 4
+
+// logger receives the core's log events, if set with SetLogger or Init
+var logger atomic.Pointer[slog.Logger]
+
+// coreLogLevels are the core's log levels, most verbose first
+var coreLogLevels = []uint32{coreLogTrace, coreLogDebug, coreLogInfo, coreLogWarn, coreLogError}
+
+// SetLogger forwards the log events of the core library to logger, with their
+// fields as attributes, or stops forwarding them if logger is nil. The core
+// logs spill failures, messages dropped for the memory limit, expired
+// subscribers, collected topics, consumer group rebalances and, with where
+// they happened, panics, which it otherwise prints to stderr. Its most verbose
+// events are logged at LevelTrace.
+//
+// Only the levels logger has enabled when SetLogger is called are sent across
+// from the core, so call it again after changing them. The logger is called
+// on the thread the event happened on, possibly with the broker locked, so
+// its handler must not call the package.
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+	if l == nil {
+		C.set_log_callback(nil, 0)
+		return
+	}
+
+	maxLevel := uint32(0)
+	for _, level := range coreLogLevels {
+		if l.Enabled(context.Background(), slogLevel(level)) {
+			maxLevel = level
+			break
+		}
+	}
+	C.set_log_callback(C.log_callback(C.logCallbackGateway), C.uint32_t(maxLevel))
+}
+
+// slogLevel converts a core log level to a slog level
+func slogLevel(level uint32) slog.Level {
+	switch level {
+	case coreLogError:
+		return slog.LevelError
+	case coreLogWarn:
+		return slog.LevelWarn
+	case coreLogInfo:
+		return slog.LevelInfo
+	case coreLogDebug:
+		return slog.LevelDebug
+	default:
+		return LevelTrace
+	}
+}
+
+//export logCallbackGateway
+func logCallbackGateway(level C.uint32_t, message *C.char, fields *C.char) {
+	l := logger.Load()
+	if l == nil {
+		return
+	}
+
+	var values map[string]any
+	decoder := json.NewDecoder(strings.NewReader(C.GoString(fields)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		values = map[string]any{"fields": C.GoString(fields)}
+	}
+
+	attrs := make([]slog.Attr, 0, len(values))
+	for key, value := range values {
+		attrs = append(attrs, logAttr(key, value))
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+
+	l.LogAttrs(context.Background(), slogLevel(uint32(level)), C.GoString(message), attrs...)
+}
+
+// logAttr makes an attribute of a field, keeping numbers as numbers
+func logAttr(key string, value any) slog.Attr {
+	if number, ok := value.(json.Number); ok {
+		if i, err := number.Int64(); err == nil {
+			return slog.Int64(key, i)
+		}
+		if f, err := number.Float64(); err == nil {
+			return slog.Float64(key, f)
+		}
+	}
+	return slog.Any(key, value)
+}
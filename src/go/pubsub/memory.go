@@ -0,0 +1,133 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrMemoryLimit is returned when a publish would take the broker over its
+// memory limit and the policy doesn't allow making room
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// MemoryPolicy is what a publish does when the broker is at its memory limit
+type MemoryPolicy int
+
+const (
+	// MemoryPolicyReject rejects the publish with ErrMemoryLimit
+	MemoryPolicyReject MemoryPolicy = C.MEMORY_POLICY_REJECT
+	// MemoryPolicyEvictOldest drops the oldest queued messages across all
+	// subscribers until the new message fits
+	MemoryPolicyEvictOldest MemoryPolicy = C.MEMORY_POLICY_EVICT_OLDEST
+	// MemoryPolicyDropLargest drops the oldest messages of whichever queue
+	// holds the most bytes until the new message fits
+	MemoryPolicyDropLargest MemoryPolicy = C.MEMORY_POLICY_DROP_LARGEST
+)
+
+func (p MemoryPolicy) String() string {
+	switch p {
+	case MemoryPolicyReject:
+		return "reject"
+	case MemoryPolicyEvictOldest:
+		return "evict_oldest"
+	case MemoryPolicyDropLargest:
+		return "drop_largest"
+	default:
+		return fmt.Sprintf("MemoryPolicy(%d)", int(p))
+	}
+}
+
+// parseMemoryPolicy converts a policy name reported by get_stats
+func parseMemoryPolicy(name string) MemoryPolicy {
+	switch name {
+	case "evict_oldest":
+		return MemoryPolicyEvictOldest
+	case "drop_largest":
+		return MemoryPolicyDropLargest
+	default:
+		return MemoryPolicyReject
+	}
+}
+
+// memoryPolicyByName looks up a memory policy by its String name, defaulting
+// to MemoryPolicyReject
+func memoryPolicyByName(name string) (MemoryPolicy, error) {
+	if name == "" {
+		return MemoryPolicyReject, nil
+	}
+	for _, policy := range []MemoryPolicy{MemoryPolicyReject, MemoryPolicyEvictOldest, MemoryPolicyDropLargest} {
+		if policy.String() == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown memory policy '%s'", name)
+}
+
+// MemoryUsage is the message data held by the core library, in bytes. This
+// memory is allocated outside the Go heap, so it doesn't show up in
+// runtime.MemStats or heap profiles. A payload queued for several subscribers
+// is counted once per queue.
+type MemoryUsage struct {
+	// QueuedBytes is held in subscriber queues
+	QueuedBytes uint64
+	// PausedBytes is held for paused subscriptions
+	PausedBytes uint64
+	// ThrottledBytes is held for subscriptions over their WithRateLimit rate
+	ThrottledBytes uint64
+	// LeasedBytes is held by buffers from GetBuffer that haven't been released
+	// and by deliveries from Fetch that haven't been acked
+	LeasedBytes uint64
+	// BatchedBytes is waiting in delivery batches
+	BatchedBytes uint64
+	// StagedBytes is staged in open transactions
+	StagedBytes uint64
+	TotalBytes  uint64
+	// LimitBytes is the memory limit, or 0 if there is none
+	LimitBytes uint64
+	Policy     MemoryPolicy
+}
+
+// SetMemoryLimit limits the message data held by the broker across queues,
+// leased buffers, batches and transactions. A publish, direct message or
+// transaction publish that would exceed the limit is handled by the policy;
+// messages dropped to make room count as dropped in Stats. A limit of 0
+// removes it.
+func SetMemoryLimit(limitBytes uint64, policy MemoryPolicy) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{
+			"memory_limit_bytes": strconv.FormatUint(limitBytes, 10),
+			"memory_policy":      policy.String(),
+		}}, err)
+	}()
+
+	switch policy {
+	case MemoryPolicyReject, MemoryPolicyEvictOldest, MemoryPolicyDropLargest:
+	default:
+		return fmt.Errorf("failed to set memory limit: unknown policy %v", policy)
+	}
+
+	if !C.set_memory_limit(C.uint64_t(limitBytes), C.uint32_t(policy)) {
+		return checkInternal(errors.New("failed to set memory limit"))
+	}
+	return nil
+}
+
+// AllocatorStats is what the core library's allocator has handed out since
+// SetAllocatorStats turned counting on. Unlike MemoryUsage it covers every
+// allocation of the core, not only message data.
+type AllocatorStats struct {
+	// AllocatedBytes is allocated and not yet freed
+	AllocatedBytes uint64
+	// Allocations counts allocations, including reallocations
+	Allocations uint64
+}
+
+// SetAllocatorStats counts the core library's allocations, reported in
+// BrokerStats.Allocator, or stops counting. Counting restarts from zero each
+// time it is turned on, and memory allocated before then isn't counted. It
+// costs two atomic operations per allocation and is off by default.
+func SetAllocatorStats(enabled bool) {
+	coreSetAllocatorStats(enabled)
+}
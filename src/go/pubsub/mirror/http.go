@@ -0,0 +1,72 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxEnvelopeBytes bounds the request body Handler reads
+const maxEnvelopeBytes = 16 << 20
+
+// HTTPTarget sends envelopes to another broker's Handler as JSON POST requests
+type HTTPTarget struct {
+	URL string
+	// Client makes the requests; nil uses http.DefaultClient
+	Client *http.Client
+}
+
+// Send posts one envelope
+func (t *HTTPTarget) Send(ctx context.Context, envelope Envelope) error {
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(detail))
+	}
+	return nil
+}
+
+// Handler applies envelopes POSTed by an HTTPTarget to the broker
+func Handler(m *Mirror) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var envelope Envelope
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxEnvelopeBytes)).Decode(&envelope); err != nil {
+			http.Error(w, "invalid envelope: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := m.Apply(envelope); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,217 @@
+// Package mirror replicates selected topics from this process's broker to
+// another, for simple active/passive setups. Messages travel as envelopes
+// listing the brokers they came through, so a message is never sent back to a
+// broker it has already been on, even when two brokers mirror each other.
+//
+// On the sending side, Start mirrors topics to a Target such as an
+// HTTPTarget. On the receiving side, a Mirror with the same topics and a
+// target pointing back makes the setup active/active, and Handler accepts
+// the envelopes:
+//
+//	m, err := mirror.Start(mirror.Config{Name: "east", Topics: []string{"orders"}, Target: &mirror.HTTPTarget{URL: "http://west:8080/mirror"}})
+//	http.Handle("/mirror", mirror.Handler(m))
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+const (
+	// DefaultBufferSize is how many envelopes wait to be sent when Config leaves it unset
+	DefaultBufferSize = 1024
+	// DefaultSubscriberID is the mirror's subscriber ID when Config leaves it unset
+	DefaultSubscriberID = "mirror"
+	// sendTimeout bounds each send to the target
+	sendTimeout = 10 * time.Second
+)
+
+// Envelope is a mirrored message
+type Envelope struct {
+	Topic   string `json:"topic"`
+	Payload string `json:"payload"`
+	// Origins names the brokers the message has been on, starting with the
+	// one it was published to
+	Origins []string `json:"origins"`
+}
+
+// Target is where a mirror sends its envelopes
+type Target interface {
+	Send(ctx context.Context, envelope Envelope) error
+}
+
+// Config configures a Mirror
+type Config struct {
+	// Name identifies this broker in envelope origins. Every broker in a
+	// mirroring setup needs a different name.
+	Name string
+	// Topics are the topics to mirror to Target; none makes a receive-only mirror
+	Topics []string
+	Target Target
+	// SubscriberID is the ID the mirror subscribes with
+	SubscriberID string
+	// BufferSize is how many envelopes may wait to be sent. Messages arriving
+	// while the buffer is full are dropped and counted.
+	BufferSize int
+	// OnError, if set, is called with each envelope that fails to send
+	OnError func(error)
+}
+
+// Mirror sends the messages of its topics to a target and applies envelopes
+// from other brokers
+type Mirror struct {
+	config   Config
+	envelope chan Envelope
+	dropped  atomic.Uint64
+
+	// applied holds the origins of envelopes being published by Apply, by
+	// topic and payload, so the subscription that sees them forwards them
+	// with their origins rather than as new messages from this broker
+	mu      sync.Mutex
+	applied map[appliedKey][][]string
+
+	done  chan struct{}
+	close sync.Once
+}
+
+type appliedKey struct {
+	topic   string
+	payload string
+}
+
+// Start subscribes to the topics and starts sending their messages
+func Start(config Config) (*Mirror, error) {
+	if config.Name == "" {
+		return nil, errors.New("mirror requires a name")
+	}
+	if len(config.Topics) > 0 && config.Target == nil {
+		return nil, errors.New("mirror requires a target to mirror topics to")
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+	if config.SubscriberID == "" {
+		config.SubscriberID = DefaultSubscriberID
+	}
+
+	m := &Mirror{
+		config:   config,
+		envelope: make(chan Envelope, config.BufferSize),
+		applied:  make(map[appliedKey][][]string),
+		done:     make(chan struct{}),
+	}
+
+	for _, topic := range config.Topics {
+		if err := pubsub.Subscribe(config.SubscriberID, topic, m.forward); err != nil {
+			pubsub.Unsubscribe(config.SubscriberID, "")
+			return nil, fmt.Errorf("failed to subscribe mirror to '%s': %w", topic, err)
+		}
+	}
+
+	go m.send()
+	return m, nil
+}
+
+// Close stops mirroring and waits for the buffered envelopes to be sent
+func (m *Mirror) Close() error {
+	m.close.Do(func() {
+		// Callbacks run under the broker lock, so none are in flight once
+		// Unsubscribe returns and the channel can be closed
+		if len(m.config.Topics) > 0 {
+			pubsub.Unsubscribe(m.config.SubscriberID, "")
+		}
+		close(m.envelope)
+	})
+	<-m.done
+	return nil
+}
+
+// Dropped returns the number of messages dropped because the buffer was full
+func (m *Mirror) Dropped() uint64 {
+	return m.dropped.Load()
+}
+
+// Apply publishes an envelope from another broker to this one. Envelopes
+// that have already been on this broker are ignored.
+func (m *Mirror) Apply(envelope Envelope) error {
+	if len(envelope.Origins) == 0 {
+		return errors.New("failed to apply mirrored message: envelope has no origin")
+	}
+	if slices.Contains(envelope.Origins, m.config.Name) {
+		return nil
+	}
+
+	key := appliedKey{envelope.Topic, envelope.Payload}
+	mirrored := slices.Contains(m.config.Topics, envelope.Topic)
+	if mirrored {
+		m.mu.Lock()
+		m.applied[key] = append(m.applied[key], envelope.Origins)
+		m.mu.Unlock()
+	}
+
+	err := pubsub.Publish(envelope.Topic, envelope.Payload)
+	if mirrored {
+		// Normally forward has taken the origins; this covers publishes
+		// that didn't reach the subscription
+		m.takeOrigins(key)
+	}
+	if err != nil && !errors.Is(err, pubsub.ErrNoTopic) {
+		return fmt.Errorf("failed to apply mirrored message: %w", err)
+	}
+	return nil
+}
+
+// forward queues a message of a mirrored topic for sending
+func (m *Mirror) forward(topic, payload string) {
+	origins := m.takeOrigins(appliedKey{topic, payload})
+	envelope := Envelope{
+		Topic:   topic,
+		Payload: payload,
+		Origins: append(slices.Clip(origins), m.config.Name),
+	}
+
+	select {
+	case m.envelope <- envelope:
+	default:
+		m.dropped.Add(1)
+	}
+}
+
+// takeOrigins removes and returns the origins Apply recorded for a message,
+// or nil if it was published on this broker
+func (m *Mirror) takeOrigins(key appliedKey) []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pending := m.applied[key]
+	if len(pending) == 0 {
+		return nil
+	}
+	origins := pending[0]
+	if len(pending) == 1 {
+		delete(m.applied, key)
+	} else {
+		m.applied[key] = pending[1:]
+	}
+	return origins
+}
+
+func (m *Mirror) send() {
+	defer close(m.done)
+
+	for envelope := range m.envelope {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := m.config.Target.Send(ctx, envelope)
+		cancel()
+		if err != nil && m.config.OnError != nil {
+			m.config.OnError(fmt.Errorf("failed to mirror message on '%s': %w", envelope.Topic, err))
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Package mock is a broker in memory for the unit tests of code that reaches
+// the broker through an interface, such as conformance.Broker, so they run
+// without the core library. It keeps the broker's delivery semantics, which
+// the conformance checks hold it to, and adds what a test double needs: it
+// records every message published, and can be told to fail the next call of
+// a method.
+//
+//	broker := mock.New()
+//	broker.FailNext("Publish", errors.New("broker unavailable"))
+//	err := service.Handle(broker, request)
+//	...
+//	published := broker.Published()
+package mock
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrNoTopic is returned when publishing to a topic nothing ever subscribed to
+var ErrNoTopic = errors.New("topic does not exist")
+
+// ErrUnknownDelivery is returned when acking a delivery that isn't in flight
+var ErrUnknownDelivery = errors.New("delivery is not in flight")
+
+// Message is a message published to the broker
+type Message struct {
+	Topic   string
+	Content string
+}
+
+// Broker is a broker in memory. Publish runs callbacks before it returns and
+// queues messages for subscribers without one, which read them with
+// GetMessage, or with Fetch and Ack. Topics exist from their first
+// subscription on, as in the core.
+type Broker struct {
+	mu          sync.Mutex
+	topics      map[string][]*subscriber
+	subscribers map[string]*subscriber
+	nextTag     uint64
+	published   []Message
+	failures    map[string]error
+}
+
+type subscriber struct {
+	callback func(topic, message string)
+	topics   map[string]bool
+	queue    []Message
+	inFlight map[uint64]Message
+}
+
+// New returns an empty broker
+func New() *Broker {
+	return &Broker{
+		topics:      make(map[string][]*subscriber),
+		subscribers: make(map[string]*subscriber),
+		failures:    make(map[string]error),
+	}
+}
+
+// FailNext makes the next call of the method, such as "Publish", return err
+// without doing anything
+func (b *Broker) FailNext(method string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures[method] = err
+}
+
+// Published returns the messages published so far, in order, including those
+// no subscriber received
+func (b *Broker) Published() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.published...)
+}
+
+// failure returns the error FailNext set for a method, once. The caller
+// holds the lock.
+func (b *Broker) failure(method string) error {
+	err := b.failures[method]
+	delete(b.failures, method)
+	return err
+}
+
+// Subscribe subscribes to a topic with a callback, or a queue if it is nil
+func (b *Broker) Subscribe(subscriberID, topic string, callback func(topic, message string)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.failure("Subscribe"); err != nil {
+		return err
+	}
+	if subscriberID == "" || topic == "" {
+		return errors.New("failed to subscribe: empty subscriber ID or topic")
+	}
+
+	s, ok := b.subscribers[subscriberID]
+	if !ok {
+		s = &subscriber{topics: make(map[string]bool), inFlight: make(map[uint64]Message)}
+		b.subscribers[subscriberID] = s
+	}
+	s.callback = callback
+	if !s.topics[topic] {
+		s.topics[topic] = true
+		b.topics[topic] = append(b.topics[topic], s)
+	}
+	return nil
+}
+
+// Unsubscribe removes a subscription, or every subscription of the
+// subscriber, its queue and its messages in flight if topic is empty
+func (b *Broker) Unsubscribe(subscriberID, topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.failure("Unsubscribe"); err != nil {
+		return err
+	}
+	s, ok := b.subscribers[subscriberID]
+	if !ok {
+		return errors.New("failed to unsubscribe: unknown subscriber")
+	}
+	for t := range s.topics {
+		if topic != "" && t != topic {
+			continue
+		}
+		delete(s.topics, t)
+		subscribers := b.topics[t]
+		for i, other := range subscribers {
+			if other == s {
+				b.topics[t] = append(subscribers[:i:i], subscribers[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(s.topics) == 0 {
+		delete(b.subscribers, subscriberID)
+	}
+	return nil
+}
+
+// Publish records the message and delivers it to the topic's subscribers
+func (b *Broker) Publish(topic, message string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.failure("Publish"); err != nil {
+		return err
+	}
+	subscribers, ok := b.topics[topic]
+	if !ok {
+		return ErrNoTopic
+	}
+	b.published = append(b.published, Message{topic, message})
+	for _, s := range subscribers {
+		if s.callback != nil {
+			s.callback(topic, message)
+		} else {
+			s.queue = append(s.queue, Message{topic, message})
+		}
+	}
+	return nil
+}
+
+// GetMessage removes and returns the next queued message for the subscriber,
+// from any topic if topic is empty, and false if there is none
+func (b *Broker) GetMessage(subscriberID, topic string) (string, string, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.failure("GetMessage"); err != nil {
+		return "", "", false, err
+	}
+	msg, ok := b.take(subscriberID, topic)
+	return msg.Topic, msg.Content, ok, nil
+}
+
+// Fetch takes the next queued message for the subscriber, from any topic if
+// topic is empty, and holds it in flight until it is acked
+func (b *Broker) Fetch(subscriberID, topic string) (uint64, string, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.failure("Fetch"); err != nil {
+		return 0, "", false, err
+	}
+	msg, ok := b.take(subscriberID, topic)
+	if !ok {
+		return 0, "", false, nil
+	}
+	b.nextTag++
+	b.subscribers[subscriberID].inFlight[b.nextTag] = msg
+	return b.nextTag, msg.Content, true, nil
+}
+
+// Ack removes a message in flight for good
+func (b *Broker) Ack(subscriberID string, tag uint64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.failure("Ack"); err != nil {
+		return err
+	}
+	s, ok := b.subscribers[subscriberID]
+	if !ok {
+		return ErrUnknownDelivery
+	}
+	if _, ok := s.inFlight[tag]; !ok {
+		return ErrUnknownDelivery
+	}
+	delete(s.inFlight, tag)
+	return nil
+}
+
+// take removes the next queued message for the subscriber. The caller holds
+// the lock.
+func (b *Broker) take(subscriberID, topic string) (Message, bool) {
+	s, ok := b.subscribers[subscriberID]
+	if !ok {
+		return Message{}, false
+	}
+	for i, msg := range s.queue {
+		if topic == "" || msg.Topic == topic {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
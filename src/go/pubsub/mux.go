@@ -0,0 +1,95 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+)
+
+// Mux routes the messages of a single subscriber to several handlers by topic
+// pattern. It registers one callback for the subscriber, so each topic is a
+// single subscription in the Rust core however many handlers match it.
+//
+// Patterns use path.Match syntax, where '*' matches within one '/'-separated
+// segment, e.g. "orders/*" matches "orders/created" but not "orders/eu/created".
+type Mux struct {
+	subscriberID string
+
+	mu     sync.RWMutex
+	routes []route
+}
+
+type route struct {
+	pattern string
+	handler HandlerFunc
+}
+
+// NewMux returns a mux delivering the messages of the given subscriber
+func NewMux(subscriberID string) (*Mux, error) {
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return nil, err
+	}
+	return &Mux{subscriberID: subscriberID}, nil
+}
+
+// SubscriberID returns the subscriber the mux delivers for
+func (m *Mux) SubscriberID() string {
+	return m.subscriberID
+}
+
+// Handle registers a callback for topics matching the pattern
+func (m *Mux) Handle(pattern string, callback MessageCallback) error {
+	return m.HandleFunc(pattern, FromCallback(callback))
+}
+
+// HandleFunc registers a handler for topics matching the pattern. A message is
+// passed to every matching handler in registration order, and the delivery
+// fails if any of them returns an error.
+func (m *Mux) HandleFunc(pattern string, handler HandlerFunc) error {
+	if handler == nil {
+		return fmt.Errorf("failed to register handler for '%s': handler is nil", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("failed to register handler for '%s': %w", pattern, err)
+	}
+
+	m.mu.Lock()
+	m.routes = append(m.routes, route{pattern: pattern, handler: handler})
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Subscribe subscribes the mux's subscriber to a topic, delivering its messages
+// to the matching handlers
+func (m *Mux) Subscribe(topic string, opts ...SubscribeOption) error {
+	return SubscribeHandler(m.subscriberID, topic, m.dispatch, opts...)
+}
+
+// Unsubscribe removes the subscription to a topic. The handlers stay registered.
+func (m *Mux) Unsubscribe(topic string) error {
+	return Unsubscribe(m.subscriberID, topic)
+}
+
+// Close unsubscribes the mux's subscriber from all topics
+func (m *Mux) Close() error {
+	return Unsubscribe(m.subscriberID, "")
+}
+
+// dispatch passes a message to every handler whose pattern matches its topic.
+// Messages no handler matches are discarded.
+func (m *Mux) dispatch(ctx context.Context, msg *Message) error {
+	m.mu.RLock()
+	routes := m.routes
+	m.mu.RUnlock()
+
+	var errs []error
+	for _, r := range routes {
+		if matched, _ := path.Match(r.pattern, msg.Topic); matched {
+			errs = append(errs, r.handler(ctx, msg))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,268 @@
+package pubsub
+
+import (
+	"strings"
+	"time"
+)
+
+// SubscribeOption configures a subscription
+type SubscribeOption func(*subscribeOptions)
+
+type subscribeOptions struct {
+	circuitBreaker    *CircuitBreakerConfig
+	maxAttempts       int
+	group             string
+	balance           *BalanceStrategy
+	weight            int
+	onRebalance       func(RebalanceEvent)
+	handlerTimeout    time.Duration
+	labels            map[string]string
+	queueCapacity     int
+	watermarkHigh     float64
+	watermarkLow      float64
+	spillDir          string
+	spillBudget       int
+	maxBatch          int
+	maxBatchDelay     time.Duration
+	backfill          int
+	visibilityTimeout time.Duration
+	sampleRate        float64
+	sampleEvery       int
+	rateLimit         float64
+}
+
+// WithCircuitBreaker pauses delivery to the callback after consecutive failures
+// and probes periodically before resuming. It has no effect without a callback.
+func WithCircuitBreaker(config CircuitBreakerConfig) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.circuitBreaker = &config
+	}
+}
+
+// WithQuarantine retries a failing callback up to maxAttempts times per message
+// and then moves the message to QuarantineTopic. It has no effect without a callback.
+func WithQuarantine(maxAttempts int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		if maxAttempts < 1 {
+			maxAttempts = 1
+		}
+		o.maxAttempts = maxAttempts
+	}
+}
+
+// WithGroup joins the subscriber to a consumer group on the topic. Each message
+// is delivered to one member of the group; messages published with the same
+// ordering key always go to the same member, in order.
+func WithGroup(group string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.group = group
+	}
+}
+
+// WithBalance sets how the consumer group joined WithGroup shares messages
+// without an ordering key between its members. The group keeps the strategy
+// of the member that set it last; it is BalanceRoundRobin until one does. It
+// has no effect without WithGroup.
+func WithBalance(strategy BalanceStrategy) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.balance = &strategy
+	}
+}
+
+// WithWeight sets the subscriber's share of the messages of a consumer group
+// balanced by BalanceWeighted, relative to the other members' weights. Members
+// that don't set one weigh 1. It has no effect without WithGroup.
+func WithWeight(weight int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.weight = weight
+	}
+}
+
+// WithHandlerTimeout bounds each delivery attempt. Once the timeout passes,
+// the handler's context is cancelled and the attempt counts as failed for
+// retries and the circuit breaker, with ErrHandlerTimeout, and delivery
+// continues without waiting for the handler to return. It has no effect
+// without a callback.
+func WithHandlerTimeout(timeout time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.handlerTimeout = timeout
+	}
+}
+
+// WithLabels attaches labels to the subscriber, reported by Presence. Labels
+// replace any set by an earlier subscription of the same subscriber.
+func WithLabels(labels map[string]string) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.labels = labels
+	}
+}
+
+// WithQueueCapacity bounds the subscriber's message queue. Its space is
+// allocated up front, and messages arriving while it is full are dropped. It
+// has no effect with a callback.
+func WithQueueCapacity(capacity int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.queueCapacity = capacity
+	}
+}
+
+// WithSpill spills the subscriber's queue to segment files in dir once the
+// messages held in memory exceed memoryBudget bytes, and reads them back as
+// the subscriber catches up, so a slow consumer doesn't force drops. The files
+// are removed when the subscriber goes away and don't survive a restart. It
+// has no effect with a callback, and Subscribe fails with an error wrapping
+// errors.ErrUnsupported on a core without FeaturePersistence.
+func WithSpill(dir string, memoryBudget int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.spillDir = dir
+		o.spillBudget = memoryBudget
+	}
+}
+
+// WithBatchDelivery has the core collect the subscriber's messages and hand
+// them over in one call once maxBatch are pending or the oldest has waited
+// maxDelay, cutting the number of cgo crossings on busy topics. The handler is
+// still called once per message, in order. Batching applies to all of the
+// subscriber's topics, and a batched message counts as delivered when it joins
+// the batch. It has no effect without a callback.
+func WithBatchDelivery(maxBatch int, maxDelay time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.maxBatch = maxBatch
+		o.maxBatchDelay = maxDelay
+	}
+}
+
+// WithBackfill delivers up to n of the messages kept by SetTopicHistory to
+// the new subscription, oldest first, before any message published after it.
+// A callback receives them before Subscribe returns, with Message.Backfill
+// set, so the first message without it is live. A queue gets them ahead of
+// live messages, unmarked. It has no effect with WithGroup.
+func WithBackfill(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.backfill = n
+	}
+}
+
+// WithVisibilityTimeout sets how long a message taken by Fetch from this
+// subscription may go unacked before it is redelivered, in place of the
+// broker-wide SetAckTimeout, so quick and slow consumers can share a broker.
+// Delivery.ExtendVisibility gives a single message more time. It has no
+// effect with a callback.
+func WithVisibilityTimeout(timeout time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.visibilityTimeout = timeout
+	}
+}
+
+// WithSampleRate delivers only a fraction of the messages published to the
+// subscription, in (0, 1], spread evenly rather than at random, so diagnostic
+// consumers can watch a busy topic without receiving all of it. The core
+// skips the other messages before queueing or calling across, and they count
+// as neither delivered nor dropped. In a consumer group, the skipped messages
+// are the subscriber's share, which no other member gets. Backfilled messages
+// and those sent with SendTo aren't sampled.
+func WithSampleRate(rate float64) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.sampleRate = rate
+	}
+}
+
+// WithSampleEvery delivers only every nth message published to the
+// subscription, skipped the same way as with WithSampleRate. With both, the
+// rate applies to every nth message.
+func WithSampleEvery(n int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.sampleEvery = n
+	}
+}
+
+// WithRateLimit caps the messages delivered to the subscription at perSecond,
+// holding the rest in order until the rate allows them, as with SetRateLimit
+func WithRateLimit(perSecond float64) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.rateLimit = perSecond
+	}
+}
+
+// state builds the delivery policy for a subscription, or nil if no option needs one
+func (o subscribeOptions) state(subscriberID, topic string) *subscriptionState {
+	if o.circuitBreaker == nil && o.maxAttempts == 0 && o.handlerTimeout <= 0 {
+		return nil
+	}
+
+	state := &subscriptionState{maxAttempts: o.maxAttempts, handlerTimeout: o.handlerTimeout}
+	if o.circuitBreaker != nil {
+		state.breaker = newCircuitBreaker(subscriberID, topic, *o.circuitBreaker)
+	}
+	return state
+}
+
+// PublishOption configures a single publish
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	orderingKey  string
+	messageID    string
+	publisherID  string
+	headers      map[string]string
+	receiptTopic string
+	// sync is set by PublishSync, which waits for the callbacks to return
+	sync bool
+}
+
+// WithOrderingKey routes the message by key within consumer groups, so messages
+// sharing a key are delivered in order to the same group member
+func WithOrderingKey(key string) PublishOption {
+	return func(o *publishOptions) {
+		o.orderingKey = key
+	}
+}
+
+// WithMessageID attaches a unique message ID. A publish repeating an ID seen
+// within the dedup window is not delivered and returns ErrDuplicateMessage,
+// which makes retries safe.
+func WithMessageID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.messageID = id
+	}
+}
+
+// WithHeader attaches a header to the message. Headers reach the handlers and
+// callbacks the message is delivered to while it is published, as
+// Message.Headers, and are kept in recordings; messages read from a queue
+// don't carry them. The names message_id, ordering_key and publisher_id are
+// reserved for the other options in recordings, and names starting with '$'
+// are reserved for the broker and ignored.
+func WithHeader(name, value string) PublishOption {
+	return func(o *publishOptions) {
+		if strings.HasPrefix(name, "$") {
+			return
+		}
+		if o.headers == nil {
+			o.headers = make(map[string]string)
+		}
+		o.headers[name] = value
+	}
+}
+
+// WithPublisher attributes the message to a publisher registered with
+// RegisterPublisher. Publishing as an unregistered publisher fails with
+// ErrUnknownPublisher.
+func WithPublisher(publisherID string) PublishOption {
+	return func(o *publishOptions) {
+		o.publisherID = publisherID
+	}
+}
+
+// WithReceipts publishes receipt events for the message to topic as JSON,
+// parsed with ParseReceipt, so the publisher can tell whether anyone consumed
+// it: one per subscriber when a callback accepts it or a queued copy is read
+// or acked, when it is dead lettered to QuarantineTopic, and when its
+// subscriber expires before reading it. Receipts are keyed by the message ID,
+// so the option has no effect without WithMessageID. Receipts of messages a
+// queue spilled to disk are lost.
+func WithReceipts(topic string) PublishOption {
+	return func(o *publishOptions) {
+		o.receiptTopic = topic
+	}
+}
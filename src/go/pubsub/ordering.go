@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// Ordering is the delivery ordering guarantee of a topic's callback subscribers
+type Ordering int
+
+const (
+	// OrderingStrict delivers a topic's messages to each subscriber one at a
+	// time, in publish order. It is the default.
+	OrderingStrict Ordering = iota
+	// OrderingRelaxed hands messages to a pool of worker goroutines, so a
+	// subscriber's handler may run for several messages at once and in any
+	// order. A message counts as delivered once a worker accepts it, except
+	// that PublishSync runs the handlers itself and waits for them.
+	OrderingRelaxed
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case OrderingStrict:
+		return "strict"
+	case OrderingRelaxed:
+		return "relaxed"
+	default:
+		return fmt.Sprintf("Ordering(%d)", int(o))
+	}
+}
+
+// topicOrdering holds the topics configured with a non-default ordering
+var topicOrdering = struct {
+	sync.RWMutex
+	topics map[string]Ordering
+}{
+	topics: make(map[string]Ordering),
+}
+
+// SetTopicOrdering sets the ordering guarantee of a topic's callback
+// subscribers. Queued messages read with GetMessage are always in publish order.
+func SetTopicOrdering(topic string, ordering Ordering) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Topic: topic, Details: map[string]string{"ordering": ordering.String()}}, err)
+	}()
+
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+
+	topicOrdering.Lock()
+	defer topicOrdering.Unlock()
+
+	switch ordering {
+	case OrderingStrict:
+		delete(topicOrdering.topics, topic)
+	case OrderingRelaxed:
+		topicOrdering.topics[topic] = ordering
+	default:
+		return fmt.Errorf("failed to set ordering of topic '%s': unknown ordering %d", topic, int(ordering))
+	}
+	return nil
+}
+
+// TopicOrdering returns the ordering guarantee of a topic
+func TopicOrdering(topic string) Ordering {
+	topic = ResolveTopic(topic)
+
+	topicOrdering.RLock()
+	defer topicOrdering.RUnlock()
+
+	return topicOrdering.topics[topic]
+}
+
+// relaxedPool runs deliveries for topics with relaxed ordering in namespaces
+// without a dispatcher of their own
+var relaxedPool struct {
+	start sync.Once
+	pool  *dispatchPool
+}
+
+// dispatchRelaxed runs a delivery of a message on a topic on the worker pool of
+// the topic's namespace, or on the shared pool if it has none, and reports
+// whether it was accepted. If every worker of the shared pool is busy and its
+// queue is full, it runs the delivery on the caller instead: blocking would
+// hold the broker lock, which a busy handler may be waiting for. A namespace's
+// own pool drops the delivery instead, so that a namespace that can't keep up
+// doesn't hold up the others. In deterministic mode every delivery runs on the
+// caller.
+func dispatchRelaxed(topic string, deliver func()) bool {
+	if deterministic.Load() {
+		deliver()
+		return true
+	}
+
+	if accepted, isolated := dispatchNamespace(Namespace(topic), deliver); isolated {
+		return accepted
+	}
+
+	relaxedPool.start.Do(func() {
+		workers := runtime.GOMAXPROCS(0)
+		relaxedPool.pool = newDispatchPool(workers, workers*64)
+	})
+	if !relaxedPool.pool.offer(deliver) {
+		deliver()
+	}
+	return true
+}
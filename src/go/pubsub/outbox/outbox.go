@@ -0,0 +1,217 @@
+// Package outbox publishes the rows of a transactional outbox table to the
+// broker. An application inserts a row in the same database transaction as
+// the change it describes; the relay tails the table and publishes each row
+// once the transaction has committed, then marks it processed. The table
+// needs at least these columns:
+//
+//	CREATE TABLE outbox (
+//		id           BIGSERIAL PRIMARY KEY,  -- BIGINT AUTO_INCREMENT on MySQL
+//		topic        VARCHAR(255) NOT NULL,
+//		payload      TEXT NOT NULL,
+//		processed_at TIMESTAMP NULL
+//	);
+//
+// The relay works with any database/sql driver for Postgres or MySQL 8.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// Dialect is the SQL flavour of the database holding the outbox table
+type Dialect int
+
+const (
+	// Postgres uses $1 placeholders
+	Postgres Dialect = iota
+	// MySQL uses ? placeholders and needs MySQL 8 for SKIP LOCKED
+	MySQL
+)
+
+const (
+	// DefaultTable is the outbox table name when Config leaves it unset
+	DefaultTable = "outbox"
+	// DefaultPollInterval is how often the table is read when Config leaves it unset
+	DefaultPollInterval = time.Second
+	// DefaultBatchSize is how many rows are published per poll when Config leaves it unset
+	DefaultBatchSize = 100
+)
+
+// tableName limits table names to plain identifiers, optionally schema
+// qualified, since they can't be passed as query parameters
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// Config configures a Relay
+type Config struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Table   string
+	// PollInterval is how long the relay waits after finding no rows
+	PollInterval time.Duration
+	// BatchSize is the most rows published in one database transaction
+	BatchSize int
+	// OnError, if set, is called with each failed poll; its rows are retried
+	OnError func(error)
+}
+
+// Relay publishes outbox rows in the order of their IDs. Each row is
+// published with the message ID "outbox/<table>/<id>", so if the relay stops
+// between publishing a row and marking it processed, the broker's dedup
+// window (see pubsub.SetDedupWindow) drops the second publish after a restart.
+// Rows are locked with SKIP LOCKED, so several relays can share a table.
+type Relay struct {
+	config Config
+	// queries are the dialect's statements for the table
+	selectRows string
+	markRow    string
+
+	stop  chan struct{}
+	done  chan struct{}
+	close sync.Once
+}
+
+// Start starts a relay polling the outbox table in the background
+func Start(config Config) (*Relay, error) {
+	if config.DB == nil {
+		return nil, errors.New("outbox relay requires a database")
+	}
+	if config.Table == "" {
+		config.Table = DefaultTable
+	}
+	if !tableName.MatchString(config.Table) {
+		return nil, fmt.Errorf("invalid outbox table name '%s'", config.Table)
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultBatchSize
+	}
+
+	r := &Relay{
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	switch config.Dialect {
+	case Postgres:
+		r.selectRows = fmt.Sprintf("SELECT id, topic, payload FROM %s WHERE processed_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED", config.Table)
+		r.markRow = fmt.Sprintf("UPDATE %s SET processed_at = CURRENT_TIMESTAMP WHERE id = $1", config.Table)
+	case MySQL:
+		r.selectRows = fmt.Sprintf("SELECT id, topic, payload FROM %s WHERE processed_at IS NULL ORDER BY id LIMIT ? FOR UPDATE SKIP LOCKED", config.Table)
+		r.markRow = fmt.Sprintf("UPDATE %s SET processed_at = CURRENT_TIMESTAMP WHERE id = ?", config.Table)
+	default:
+		return nil, fmt.Errorf("unknown outbox dialect %d", config.Dialect)
+	}
+
+	go r.run()
+	return r, nil
+}
+
+// Close stops the relay, waiting for the poll in progress to finish
+func (r *Relay) Close() error {
+	r.close.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+	return nil
+}
+
+func (r *Relay) run() {
+	defer close(r.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-r.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		published, err := r.Poll(ctx)
+		if err != nil && ctx.Err() == nil && r.config.OnError != nil {
+			r.config.OnError(err)
+		}
+
+		// A full batch suggests more rows are waiting
+		wait := r.config.PollInterval
+		if err == nil && published == r.config.BatchSize {
+			wait = 0
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Poll publishes one batch of unprocessed rows and marks them processed,
+// returning how many it published. Rows for topics without subscribers count
+// as published. Poll stops at the first row that fails to publish; the rows
+// before it are still marked.
+func (r *Relay) Poll(ctx context.Context) (int, error) {
+	tx, err := r.config.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to poll outbox: %w", err)
+	}
+	defer tx.Rollback()
+
+	type row struct {
+		id      int64
+		topic   string
+		payload string
+	}
+	var batch []row
+
+	rows, err := tx.QueryContext(ctx, r.selectRows, r.config.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	for rows.Next() {
+		var next row
+		if err := rows.Scan(&next.id, &next.topic, &next.payload); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to read outbox: %w", err)
+		}
+		batch = append(batch, next)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	published := 0
+	var publishErr error
+	for _, row := range batch {
+		messageID := "outbox/" + r.config.Table + "/" + strconv.FormatInt(row.id, 10)
+		err := pubsub.Publish(row.topic, row.payload, pubsub.WithMessageID(messageID))
+		if err != nil && !errors.Is(err, pubsub.ErrDuplicateMessage) && !errors.Is(err, pubsub.ErrNoTopic) {
+			publishErr = fmt.Errorf("failed to publish outbox row %d: %w", row.id, err)
+			break
+		}
+
+		if _, err := tx.ExecContext(ctx, r.markRow, row.id); err != nil {
+			return 0, fmt.Errorf("failed to mark outbox row %d processed: %w", row.id, err)
+		}
+		published++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to mark outbox rows processed: %w", err)
+	}
+	return published, publishErr
+}
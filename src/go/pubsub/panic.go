@@ -0,0 +1,36 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import "errors"
+
+// ErrInternal is matched by errors.Is for every InternalError
+var ErrInternal = errors.New("internal error in the pubsub core")
+
+// InternalError is returned when a call into the Rust core panicked. The core
+// catches the panic instead of letting it abort the process, but the broker
+// state may be left inconsistent.
+type InternalError struct {
+	// Panic is the panic message
+	Panic string
+}
+
+func (e *InternalError) Error() string {
+	return "internal error in the pubsub core: " + e.Panic
+}
+
+func (e *InternalError) Unwrap() error {
+	return ErrInternal
+}
+
+// checkInternal returns an *InternalError for a panic the core caught since the
+// last check, or err if there was none. Call it when the core reports failure.
+func checkInternal(err error) error {
+	cPanic := C.take_last_panic()
+	if cPanic == nil {
+		return err
+	}
+	defer C.free_string(cPanic)
+
+	return &InternalError{Panic: C.GoString(cPanic)}
+}
@@ -0,0 +1,109 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"fmt"
+	"math"
+	"unsafe"
+)
+
+// Pause stops delivering messages of a topic to a subscriber. The broker keeps
+// the messages published meanwhile, and Resume delivers them in order. For a
+// subscriber without a callback, the held messages are not visible to
+// GetMessage until the subscription is resumed. Held messages count against
+// WithQueueCapacity like queued ones, and those arriving while it is full are
+// dropped.
+func Pause(subscriberID, topic string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditPause, SubscriberID: subscriberID, Topic: topic}, err)
+	}()
+
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return err
+	}
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	success := C.pause_subscription(cSubscriberID, cTopic)
+	if !success {
+		return checkInternal(fmt.Errorf("failed to pause subscription of '%s' to topic '%s'", subscriberID, topic))
+	}
+
+	return nil
+}
+
+// Resume restarts delivery to a paused subscription, first delivering the
+// messages held while it was paused
+func Resume(subscriberID, topic string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditResume, SubscriberID: subscriberID, Topic: topic}, err)
+	}()
+
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return err
+	}
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	success := C.resume_subscription(cSubscriberID, cTopic)
+	if !success {
+		return checkInternal(fmt.Errorf("failed to resume subscription of '%s' to topic '%s'", subscriberID, topic))
+	}
+
+	return nil
+}
+
+// SetRateLimit caps the messages delivered to a subscription at perSecond,
+// spaced evenly, for consumers feeding systems with a hard rate limit. The
+// core holds the messages over the rate in order and delivers them as the
+// rate allows: a callback is called from a background thread of the core,
+// and a queue receives them for GetMessage. Held messages count against
+// WithQueueCapacity and the memory limit like queued ones. A perSecond of 0
+// removes the cap and delivers the held messages at once.
+func SetRateLimit(subscriberID, topic string, perSecond float64) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, SubscriberID: subscriberID, Topic: topic, Details: map[string]string{"rate_limit": fmt.Sprint(perSecond)}}, err)
+	}()
+
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return err
+	}
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+
+	if perSecond < 0 || math.IsInf(perSecond, 0) || math.IsNaN(perSecond) {
+		return fmt.Errorf("failed to set rate limit of '%s' on topic '%s': invalid rate %v", subscriberID, topic, perSecond)
+	}
+
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	success := C.set_subscription_throttle(cSubscriberID, cTopic, C.double(perSecond))
+	if !success {
+		return checkInternal(fmt.Errorf("failed to set rate limit of '%s' on topic '%s'", subscriberID, topic))
+	}
+
+	return nil
+}
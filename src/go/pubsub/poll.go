@@ -0,0 +1,149 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// pollBufferSize is the smallest buffer a batch of polled messages is copied into
+const pollBufferSize = 64 << 10
+
+// pollBuffers holds the C output buffers of one PollMessages call
+type pollBuffers struct {
+	entries cBuffer
+	data    cBuffer
+}
+
+func (b *pollBuffers) free() {
+	b.entries.free()
+	b.data.free()
+}
+
+// pollBufferPool reuses PollMessages buffers between calls
+var pollBufferPool = sync.Pool{
+	New: func() any {
+		buffers := new(pollBuffers)
+		runtime.SetFinalizer(buffers, (*pollBuffers).free)
+		return buffers
+	},
+}
+
+// PollMessages takes up to maxMessages queued messages for a subscriber in a single
+// call into the core and reports how many matching messages remain queued. It
+// replaces a HasMessages and GetMessage loop, which crosses into the core two
+// or three times per message.
+// If topic is empty, takes messages from any topic
+// If the next message is larger than the receive buffer, which holds at least
+// one message of the current maximum size, it is left queued and an
+// *ErrMessageTruncated is returned
+func PollMessages(subscriberID string, topic string, maxMessages int) ([]Message, int, error) {
+	deliveries, remaining, err := fetchMessages(subscriberID, topic, maxMessages, false)
+	if err != nil {
+		return nil, remaining, err
+	}
+
+	messages := make([]Message, len(deliveries))
+	for i := range deliveries {
+		messages[i] = deliveries[i].Message
+	}
+	return messages, remaining, nil
+}
+
+// fetchMessages takes up to maxMessages queued messages in one call into the
+// core. With ack set they are held in flight until acked, and carry their
+// delivery tags and attempts.
+func fetchMessages(subscriberID string, topic string, maxMessages int, ack bool) ([]Delivery, int, error) {
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return nil, 0, err
+	}
+	if topic != "" {
+		if err := validateTopic(topic); err != nil {
+			return nil, 0, err
+		}
+		topic = ResolveTopic(topic)
+	}
+	if maxMessages <= 0 {
+		return nil, 0, errors.New("poll requires a positive message count")
+	}
+
+	operation := "poll_and_fetch"
+	if ack {
+		operation = "fetch_messages"
+	}
+	if err := injectedFault(operation); err != nil {
+		return nil, 0, err
+	}
+
+	cSubscriberID := internCString(subscriberID)
+	defer cSubscriberID.release()
+
+	cTopic := internOptional(topic)
+	defer cTopic.release()
+
+	buffers := pollBufferPool.Get().(*pollBuffers)
+	defer pollBufferPool.Put(buffers)
+
+	// The buffer holds at least one message of the largest size the limits allow
+	limits := GetLimits()
+	dataSize := max(pollBufferSize, limits.MaxTopicSize+limits.MaxMessageSize)
+	cData := buffers.data.reserve(dataSize)
+	cEntries := (*C.FetchedMessage)(unsafe.Pointer(buffers.entries.reserve(maxMessages * C.sizeof_FetchedMessage)))
+
+	var remaining, needed C.size_t
+	var count int
+	done := timeCgo(operation)
+	if ack {
+		count = int(C.fetch_messages(
+			cSubscriberID.ptr,
+			cTopic.ptr,
+			C.size_t(maxMessages),
+			cEntries,
+			cData,
+			C.size_t(dataSize),
+			&remaining,
+			&needed,
+		))
+	} else {
+		count = int(C.poll_and_fetch(
+			cSubscriberID.ptr,
+			cTopic.ptr,
+			C.size_t(maxMessages),
+			cEntries,
+			cData,
+			C.size_t(dataSize),
+			&remaining,
+			&needed,
+		))
+	}
+	done()
+
+	if count == 0 {
+		if err := checkInternal(nil); err != nil {
+			return nil, 0, err
+		}
+	}
+	if count == 0 && needed > 0 {
+		return nil, int(remaining), &ErrMessageTruncated{Needed: int(needed)}
+	}
+
+	entries := unsafe.Slice(cEntries, count)
+	data := unsafe.Slice((*byte)(unsafe.Pointer(cData)), dataSize)
+	deliveries := make([]Delivery, count)
+	for i, entry := range entries {
+		deliveries[i] = Delivery{
+			Message: Message{
+				Topic:   string(data[entry.topic_offset : entry.topic_offset+entry.topic_len]),
+				Content: string(data[entry.message_offset : entry.message_offset+entry.message_len]),
+			},
+			Tag:          uint64(entry.delivery_tag),
+			Attempt:      int(entry.attempt),
+			subscriberID: subscriberID,
+		}
+	}
+
+	return deliveries, int(remaining), nil
+}
@@ -0,0 +1,53 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// cBuffer is C memory that is reused across calls instead of being allocated
+// and freed each time
+type cBuffer struct {
+	ptr  *C.char
+	size int
+}
+
+// reserve returns the buffer, grown to at least size bytes
+func (b *cBuffer) reserve(size int) *C.char {
+	if size > b.size {
+		C.free(unsafe.Pointer(b.ptr))
+		b.ptr = (*C.char)(C.malloc(C.size_t(size)))
+		b.size = size
+	}
+	return b.ptr
+}
+
+func (b *cBuffer) free() {
+	C.free(unsafe.Pointer(b.ptr))
+	b.ptr = nil
+	b.size = 0
+}
+
+// messageBuffers holds the output buffers of one GetMessage call
+type messageBuffers struct {
+	outTopic   cBuffer
+	outMessage cBuffer
+}
+
+func (b *messageBuffers) free() {
+	b.outTopic.free()
+	b.outMessage.free()
+}
+
+// messageBufferPool reuses GetMessage buffers between calls. The C memory is
+// freed by a finalizer once the pool lets go of a set of buffers.
+var messageBufferPool = sync.Pool{
+	New: func() any {
+		buffers := new(messageBuffers)
+		runtime.SetFinalizer(buffers, (*messageBuffers).free)
+		return buffers
+	},
+}
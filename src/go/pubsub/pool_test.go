@@ -0,0 +1,52 @@
+package pubsub
+
+import "testing"
+
+// BenchmarkMessageBuffers compares taking GetMessage's output buffers from
+// messageBufferPool with allocating and freeing them on every call, as
+// GetMessage did before the pool
+func BenchmarkMessageBuffers(b *testing.B) {
+	limits := GetLimits()
+	topicSize := limits.MaxTopicSize + 1
+	messageSize := limits.MaxMessageSize + 1
+
+	b.Run("pool=on", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buffers := messageBufferPool.Get().(*messageBuffers)
+			buffers.outTopic.reserve(topicSize)
+			buffers.outMessage.reserve(messageSize)
+			messageBufferPool.Put(buffers)
+		}
+	})
+	b.Run("pool=off", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var buffers messageBuffers
+			buffers.outTopic.reserve(topicSize)
+			buffers.outMessage.reserve(messageSize)
+			buffers.free()
+		}
+	})
+}
+
+// BenchmarkGetMessage measures a consume loop of GetMessage, which reads into
+// pooled buffers
+func BenchmarkGetMessage(b *testing.B) {
+	if err := Subscribe("pool-bench", "bench/pool", nil); err != nil {
+		b.Fatal(err)
+	}
+	defer Unsubscribe("pool-bench", "")
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		if err := Publish("bench/pool", "message"); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		if _, err := GetMessage("pool-bench", "bench/pool"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
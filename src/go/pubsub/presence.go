@@ -0,0 +1,104 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// SysPresence is the topic receiving presence events as JSON
+const SysPresence = "$SYS/presence"
+
+// PresenceEventType is the kind of a presence event
+type PresenceEventType string
+
+// Presence event types
+const (
+	PresenceJoin  PresenceEventType = "join"
+	PresenceLeave PresenceEventType = "leave"
+)
+
+// PresenceEvent is the payload published to SysPresence when a subscriber
+// joins or leaves a topic. Events for $SYS topics are not published.
+type PresenceEvent struct {
+	Event        PresenceEventType `json:"event"`
+	Topic        string            `json:"topic"`
+	SubscriberID string            `json:"subscriber_id"`
+}
+
+// SubscriberInfo describes a subscriber of a topic
+type SubscriberInfo struct {
+	SubscriberID string
+	// Group is the consumer group the subscriber joined the topic through, if any
+	Group    string
+	JoinedAt time.Time
+	Labels   map[string]string
+}
+
+type subscriberInfoJSON struct {
+	SubscriberID string            `json:"subscriber_id"`
+	Group        string            `json:"group"`
+	JoinedAt     int64             `json:"joined_at"`
+	Labels       map[string]string `json:"labels"`
+}
+
+// Presence returns the subscribers of a topic, ordered by subscriber ID
+func Presence(topic string) ([]SubscriberInfo, error) {
+	if err := validateTopic(topic); err != nil {
+		return nil, err
+	}
+	topic = ResolveTopic(topic)
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	cPresence := C.get_presence(cTopic)
+	if cPresence == nil {
+		return nil, fmt.Errorf("failed to get presence for topic '%s'", topic)
+	}
+	defer C.free_string(cPresence)
+
+	var raw []subscriberInfoJSON
+	if err := json.Unmarshal([]byte(C.GoString(cPresence)), &raw); err != nil {
+		return nil, err
+	}
+
+	infos := make([]SubscriberInfo, 0, len(raw))
+	for _, r := range raw {
+		infos = append(infos, SubscriberInfo{
+			SubscriberID: r.SubscriberID,
+			Group:        r.Group,
+			JoinedAt:     time.UnixMilli(r.JoinedAt),
+			Labels:       r.Labels,
+		})
+	}
+	return infos, nil
+}
+
+// SetSubscriberLabels replaces the labels of a subscriber, reported by Presence
+func SetSubscriberLabels(subscriberID string, labels map[string]string) error {
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	cLabels := C.CString(string(payload))
+	defer C.free(unsafe.Pointer(cLabels))
+
+	success := C.set_subscriber_labels(cSubscriberID, cLabels)
+	if !success {
+		return fmt.Errorf("failed to set labels for subscriber '%s'", subscriberID)
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Package prometheus exports broker stats in the Prometheus text exposition format
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// Handler serves the current broker stats for a Prometheus scrape
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats, err := pubsub.Stats()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		Write(bw, stats)
+		bw.Flush()
+	})
+}
+
+// Write writes stats in the Prometheus text exposition format
+func Write(w io.Writer, stats *pubsub.BrokerStats) {
+	counter(w, "pubsub_messages_published_total", "Messages published, excluding $SYS topics.", stats.Published)
+	counter(w, "pubsub_messages_delivered_total", "Deliveries to callbacks or queues.", stats.Delivered)
+	counter(w, "pubsub_messages_dropped_total", "Deliveries dropped by subscribers.", stats.Dropped)
+	gauge(w, "pubsub_topics", "Number of topics.", stats.Topics)
+	counter(w, "pubsub_topics_collected_total", "Idle empty topics removed.", stats.TopicsCollected)
+	gauge(w, "pubsub_subscribers", "Number of subscribers.", stats.Subscribers)
+	gauge(w, "pubsub_queue_depth", "Messages waiting in subscriber queues.", stats.QueueDepth)
+	gauge(w, "pubsub_retained_bytes", "Bytes of message payloads held in queues and leased buffers.", int(stats.RetainedBytes))
+	memoryGauge(w, "pubsub_memory_bytes", "Bytes of message data held by the core library.", stats.Memory)
+	gauge(w, "pubsub_memory_limit_bytes", "Memory limit of the core library, or 0 if there is none.", int(stats.Memory.LimitBytes))
+	gauge(w, "pubsub_spilled_bytes", "Bytes of message payloads spilled to disk.", int(stats.SpilledBytes))
+	gauge(w, "pubsub_compacted_topics", "Topics whose history is compacted.", stats.Compaction.Topics)
+	counter(w, "pubsub_compaction_runs_total", "Compaction passes over compacted topic histories.", stats.Compaction.Runs)
+	counter(w, "pubsub_compaction_removed_total", "Messages dropped from topic histories by compaction.", stats.Compaction.Removed)
+	counter(w, "pubsub_compaction_removed_bytes_total", "Bytes of message payloads dropped from topic histories by compaction.", stats.Compaction.RemovedBytes)
+
+	publisherCounter(w, "pubsub_publisher_messages_total", "Messages published by each registered publisher.",
+		stats.Publishers, func(p pubsub.PublisherStats) uint64 { return p.Published })
+	publisherCounter(w, "pubsub_publisher_bytes_total", "Message bytes published by each registered publisher.",
+		stats.Publishers, func(p pubsub.PublisherStats) uint64 { return p.Bytes })
+
+	cgoCounters(w, stats.CgoCalls)
+
+	summary(w, "pubsub_delivery_lag_seconds", "Time from publish until delivery to the subscriber.",
+		stats.Subscriptions, func(s pubsub.SubscriptionStats) pubsub.LatencySummary { return s.Lag })
+	summary(w, "pubsub_handler_duration_seconds", "Time spent in subscriber callbacks.",
+		stats.Subscriptions, func(s pubsub.SubscriptionStats) pubsub.LatencySummary { return s.Handler })
+}
+
+func counter(w io.Writer, name, help string, value uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+func gauge(w io.Writer, name, help string, value int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+}
+
+func memoryGauge(w io.Writer, name, help string, usage pubsub.MemoryUsage) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, kind := range []struct {
+		name  string
+		bytes uint64
+	}{
+		{"queued", usage.QueuedBytes},
+		{"paused", usage.PausedBytes},
+		{"throttled", usage.ThrottledBytes},
+		{"leased", usage.LeasedBytes},
+		{"batched", usage.BatchedBytes},
+		{"staged", usage.StagedBytes},
+	} {
+		fmt.Fprintf(w, "%s{kind=\"%s\"} %d\n", name, kind.name, kind.bytes)
+	}
+}
+
+func cgoCounters(w io.Writer, calls []pubsub.CgoCallStats) {
+	if len(calls) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "# HELP pubsub_cgo_calls_total Calls into the core library by C function.\n# TYPE pubsub_cgo_calls_total counter\n")
+	for _, c := range calls {
+		fmt.Fprintf(w, "pubsub_cgo_calls_total{function=\"%s\"} %d\n", c.Function, c.Calls)
+	}
+	fmt.Fprintf(w, "# HELP pubsub_cgo_seconds_total Time spent in calls into the core library by C function.\n# TYPE pubsub_cgo_seconds_total counter\n")
+	for _, c := range calls {
+		fmt.Fprintf(w, "pubsub_cgo_seconds_total{function=\"%s\"} %g\n", c.Function, c.Total.Seconds())
+	}
+}
+
+func publisherCounter(w io.Writer, name, help string, pubs []pubsub.PublisherStats, pick func(pubsub.PublisherStats) uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, pub := range pubs {
+		fmt.Fprintf(w, "%s{publisher=\"%s\"} %d\n", name, escape(pub.PublisherID), pick(pub))
+	}
+}
+
+func summary(w io.Writer, name, help string, subs []pubsub.SubscriptionStats, pick func(pubsub.SubscriptionStats) pubsub.LatencySummary) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s summary\n", name, help, name)
+	for _, sub := range subs {
+		l := pick(sub)
+		if l.Count == 0 {
+			continue
+		}
+		labels := fmt.Sprintf(`subscriber="%s",topic="%s"`, escape(sub.SubscriberID), escape(sub.Topic))
+		fmt.Fprintf(w, "%s{%s,quantile=\"0.5\"} %g\n", name, labels, l.P50.Seconds())
+		fmt.Fprintf(w, "%s{%s,quantile=\"0.95\"} %g\n", name, labels, l.P95.Seconds())
+		fmt.Fprintf(w, "%s{%s,quantile=\"0.99\"} %g\n", name, labels, l.P99.Seconds())
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, l.Sum.Seconds())
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, l.Count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escape escapes a label value
+func escape(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,66 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// ErrUnknownPublisher is returned when publishing as a publisher that isn't registered
+var ErrUnknownPublisher = errors.New("publisher is not registered")
+
+// RegisterPublisher registers a publisher identity. Messages published with
+// PublishAs or WithPublisher are attributed to it and counted in its
+// PublisherStats. Registering again replaces the labels and keeps the totals.
+func RegisterPublisher(publisherID string, labels map[string]string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditPublisherRegister, Details: map[string]string{"publisher_id": publisherID}}, err)
+	}()
+
+	if err := validateSubscriberID(publisherID); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(labels)
+	if err != nil {
+		return err
+	}
+
+	cPublisherID := C.CString(publisherID)
+	defer C.free(unsafe.Pointer(cPublisherID))
+
+	cLabels := C.CString(string(payload))
+	defer C.free(unsafe.Pointer(cLabels))
+
+	success := C.register_publisher(cPublisherID, cLabels)
+	if !success {
+		return fmt.Errorf("failed to register publisher '%s'", publisherID)
+	}
+
+	return nil
+}
+
+// UnregisterPublisher removes a publisher identity and its totals
+func UnregisterPublisher(publisherID string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditPublisherUnregister, Details: map[string]string{"publisher_id": publisherID}}, err)
+	}()
+
+	cPublisherID := C.CString(publisherID)
+	defer C.free(unsafe.Pointer(cPublisherID))
+
+	success := C.unregister_publisher(cPublisherID)
+	if !success {
+		return fmt.Errorf("failed to unregister unknown publisher '%s'", publisherID)
+	}
+
+	return nil
+}
+
+// PublishAs sends a message to a topic on behalf of a registered publisher
+func PublishAs(publisherID, topic, message string, opts ...PublishOption) error {
+	return Publish(topic, message, append(opts, WithPublisher(publisherID))...)
+}
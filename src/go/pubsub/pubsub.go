@@ -1,59 +1,270 @@
 package pubsub
 
-// #cgo LDFLAGS: -L../../target/release -lpubsub_core
-// #include <stdlib.h>
-// #include <stdbool.h>
-//
-// typedef void (*message_callback)(const char* topic, const char* message, void* user_data);
-//
-// extern bool subscribe(const char* subscriber_id, const char* topic, message_callback callback, void* user_data);
-// extern bool unsubscribe(const char* subscriber_id, const char* topic);
-// extern bool publish(const char* topic, const char* message);
-// extern bool get_next_message(const char* subscriber_id, const char* topic, char* out_topic, size_t out_topic_size, char* out_message, size_t out_message_size);
-// extern bool has_messages(const char* subscriber_id, const char* topic);
+// #include "pubsub_core.h"
 //
 // // Gateway function for the callback
-// void callbackGateway(const char* topic, const char* message, void* user_data);
+// bool callbackGateway(char* topic, char* message, void* user_data);
 import "C"
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/maphash"
+	"runtime/pprof"
 	"sync"
+	"time"
 	"unsafe"
 )
 
-// Maximum buffer size for messages
-const (
-	MaxTopicSize    = 256
-	MaxMessageSize  = 4096
-)
-
-// MessageCallback is the Go type for message callbacks
+// MessageCallback is the Go type for message callbacks. HandlerFunc is
+// preferred for new code; FromCallback adapts one to the other.
 type MessageCallback func(topic, message string)
 
-// callbackRegistry keeps track of Go callbacks by subscriber ID
-var callbackRegistry = struct {
+// subscriptionKey identifies a single subscriber/topic pair
+type subscriptionKey struct {
+	subscriberID string
+	topic        string
+}
+
+// callbackEntry is a registered Go handler and the C copy of the subscriber ID
+// handed to Rust as user data. The C string lives until the subscriber
+// unsubscribes from all topics, since Rust holds on to the pointer.
+type callbackEntry struct {
+	handler      HandlerFunc
+	subscriberID string
+	userData     *C.char
+	// tap is set for the entries of taps, which are closed with Tap.Close
+	tap bool
+}
+
+// subscriptionState holds the delivery policy of a callback subscription
+// created with options
+type subscriptionState struct {
+	breaker        *circuitBreaker
+	maxAttempts    int
+	handlerTimeout time.Duration
+}
+
+// registryShards is the number of independently locked parts of the callback registry
+const registryShards = 32
+
+// registryShard holds the Go callbacks and delivery policies of the subscribers
+// whose ID hashes to it
+type registryShard struct {
 	sync.RWMutex
-	callbacks map[string]MessageCallback
-}{
-	callbacks: make(map[string]MessageCallback),
+	callbacks     map[string]*callbackEntry
+	subscriptions map[subscriptionKey]*subscriptionState
+	// lifecycle serializes subscribe and unsubscribe calls for the shard's
+	// subscribers, including their calls into the core, so a subscriber's C user
+	// data is never freed while a concurrent subscribe hands it to the core.
+	// Deliveries don't take it.
+	lifecycle sync.Mutex
+}
+
+// callbackRegistry keeps track of Go callbacks by subscriber ID and delivery
+// policies by subscription. It is sharded by subscriber ID so that deliveries
+// to different subscribers don't contend on one lock.
+var callbackRegistry = func() []*registryShard {
+	shards := make([]*registryShard, registryShards)
+	for i := range shards {
+		shards[i] = &registryShard{
+			callbacks:     make(map[string]*callbackEntry),
+			subscriptions: make(map[subscriptionKey]*subscriptionState),
+		}
+	}
+	return shards
+}()
+
+var registrySeed = maphash.MakeSeed()
+
+// registryShardFor returns the registry shard of a subscriber
+func registryShardFor(subscriberID string) *registryShard {
+	return callbackRegistry[maphash.String(registrySeed, subscriberID)%registryShards]
 }
 
 //export callbackGateway
-func callbackGateway(topic *C.char, message *C.char, userData unsafe.Pointer) {
-	subscriberID := C.GoString((*C.char)(userData))
-	
-	callbackRegistry.RLock()
-	callback, exists := callbackRegistry.callbacks[subscriberID]
-	callbackRegistry.RUnlock()
-	
+func callbackGateway(topic *C.char, message *C.char, userData unsafe.Pointer) C.bool {
+	return C.bool(deliverCallback(topic, message, userData))
+}
+
+// gatewayCallback returns the callback gateway as a C function pointer
+func gatewayCallback() C.message_callback {
+	return C.message_callback(C.callbackGateway)
+}
+
+// deliverCallback hands a message to the registered Go callback and reports
+// whether it was delivered rather than dropped
+func deliverCallback(topic *C.char, message *C.char, userData unsafe.Pointer) bool {
+	goTopic := C.GoString(topic)
+
+	// Look the subscriber up by a view of the C string rather than a copy; the
+	// entry holds its own copy of the ID
+	cSubscriberID := unsafe.String((*byte)(userData), C.strlen((*C.char)(userData)))
+
+	shard := registryShardFor(cSubscriberID)
+	shard.RLock()
+	entry, exists := shard.callbacks[cSubscriberID]
+	var state *subscriptionState
 	if exists {
-		callback(C.GoString(topic), C.GoString(message))
+		state = shard.subscriptions[subscriptionKey{entry.subscriberID, goTopic}]
 	}
+	shard.RUnlock()
+
+	if !exists {
+		return false
+	}
+
+	msg := &Message{Topic: goTopic, Content: C.GoString(message)}
+	var synchronous bool
+	if headers := C.current_headers(); headers != nil {
+		// Headers that aren't a JSON object of strings are dropped whole
+		// rather than delivered in part
+		if err := json.Unmarshal([]byte(C.GoString(headers)), &msg.Headers); err != nil {
+			msg.Headers = nil
+			if l := logger.Load(); l != nil {
+				l.Warn("dropped malformed message headers", "topic", goTopic, "subscriber_id", entry.subscriberID, "error", err)
+			}
+		}
+		if _, ok := msg.Headers[backfillHeader]; ok {
+			msg.Backfill = true
+			delete(msg.Headers, backfillHeader)
+		}
+		msg.ID = msg.Headers[messageIDHeader]
+		if topic, ok := msg.Headers[receiptTopicHeader]; ok {
+			msg.receipt = &receiptTarget{messageID: msg.ID, topic: topic}
+		}
+		_, synchronous = msg.Headers[syncHeader]
+		delete(msg.Headers, messageIDHeader)
+		delete(msg.Headers, receiptTopicHeader)
+		delete(msg.Headers, syncHeader)
+		if len(msg.Headers) == 0 {
+			msg.Headers = nil
+		}
+	}
+
+	inflight.add()
+	// Taps run on the caller, since Tap.Close relies on no copy being in
+	// flight once the core stops calling it, and so do the callbacks of a
+	// PublishSync, which waits for them
+	if !entry.tap && !synchronous && TopicOrdering(goTopic) == OrderingRelaxed {
+		accepted := dispatchRelaxed(goTopic, func() {
+			defer inflight.done()
+			invokeHandler(entry, state, msg)
+		})
+		if !accepted {
+			inflight.done()
+		}
+		return accepted
+	}
+	defer inflight.done()
+	return invokeHandler(entry, state, msg)
+}
+
+// invokeHandler calls a subscriber's handler with the subscription's retry,
+// circuit breaker and quarantine policy, and reports whether the message was
+// delivered. The goroutine carries subscriber and topic pprof labels meanwhile,
+// so profiles attribute handler time to the subscription.
+func invokeHandler(entry *callbackEntry, state *subscriptionState, msg *Message) (delivered bool) {
+	labels := pprof.Labels("subscriber", entry.subscriberID, "topic", msg.Topic)
+	pprof.Do(context.Background(), labels, func(context.Context) {
+		delayCallback(entry.subscriberID, msg.Topic)
+		delivered = invokeWithPolicy(entry, state, msg)
+	})
+	if delivered {
+		hookDeliver(entry.subscriberID, msg)
+	}
+	return delivered
+}
+
+// invokeWithPolicy does the work of invokeHandler
+func invokeWithPolicy(entry *callbackEntry, state *subscriptionState, msg *Message) bool {
+	subscriberID := entry.subscriberID
+	info := DeliveryInfo{SubscriberID: subscriberID, Topic: msg.Topic, Attempt: 1}
+
+	if state == nil {
+		ctx, cancel := newDeliveryContext(info, 0)
+		defer cancel()
+		return invokeGuarded(entry.handler, ctx, msg) == nil
+	}
+
+	if state.breaker != nil && !state.breaker.allow() {
+		return false
+	}
+
+	info.MaxAttempts = state.maxAttempts
+	invoke := func() error {
+		ctx, cancel := newDeliveryContext(info, state.handlerTimeout)
+		defer cancel()
+		return invokeWithDeadline(entry.handler, ctx, msg)
+	}
+
+	err := invoke()
+	for err != nil && info.Attempt < state.maxAttempts {
+		info.Attempt++
+		err = invoke()
+	}
+
+	if state.breaker != nil {
+		state.breaker.record(err == nil)
+	}
+	if err != nil && state.maxAttempts > 0 {
+		quarantine(subscriberID, msg.Topic, msg.Content, info.Attempt, err)
+		msg.receipt.send(ReceiptDeadLettered, subscriberID, msg.Topic)
+		traceDeadLetter(subscriberID, msg)
+	}
+	return err == nil
 }
 
 // Subscribe registers a subscription to a topic with an optional callback
-func Subscribe(subscriberID, topic string, callback MessageCallback) error {
+func Subscribe(subscriberID, topic string, callback MessageCallback, opts ...SubscribeOption) error {
+	return SubscribeHandler(subscriberID, topic, FromCallback(callback), opts...)
+}
+
+// SubscribeHandler registers a subscription to a topic with an optional handler.
+// Without a handler, messages are queued for GetMessage.
+func SubscribeHandler(subscriberID, topic string, handler HandlerFunc, opts ...SubscribeOption) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditSubscribe, SubscriberID: subscriberID, Topic: topic}, err)
+	}()
+
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return err
+	}
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+	if err := hookSubscribe(subscriberID, topic); err != nil {
+		return err
+	}
+
+	return subscribe(subscriberID, topic, handler, opts)
+}
+
+// subscribe registers a subscription without validating the subscriber ID, so
+// the package can use reserved IDs for internal subscribers
+func subscribe(subscriberID, topic string, handler HandlerFunc, opts []SubscribeOption) error {
+	if err := injectedFault("subscribe"); err != nil {
+		return err
+	}
+
+	var options subscribeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	// Set the rebalance handler first so it sees the event for joining
+	if options.group != "" {
+		if err := watchRebalances(subscriberID, topic, options.group, options.onRebalance); err != nil {
+			return err
+		}
+	}
+
+	shard := registryShardFor(subscriberID)
+	shard.lifecycle.Lock()
+	defer shard.lifecycle.Unlock()
+
 	cSubscriberID := C.CString(subscriberID)
 	defer C.free(unsafe.Pointer(cSubscriberID))
 	
@@ -62,29 +273,291 @@ func Subscribe(subscriberID, topic string, callback MessageCallback) error {
 	
 	var cCallback C.message_callback
 	var userData unsafe.Pointer
+	restore := func() {}
 	
-	if callback != nil {
-		// Register the callback
-		callbackRegistry.Lock()
-		callbackRegistry.callbacks[subscriberID] = callback
-		callbackRegistry.Unlock()
-		
-		// Set the C callback and user data
-		cCallback = C.message_callback(C.callbackGateway)
-		userData = unsafe.Pointer(cSubscriberID)
+	if handler != nil {
+		userData, restore = registerHandler(shard, subscriberID, []string{topic}, handler, options)
+		cCallback = gatewayCallback()
 	}
 	
-	success := C.subscribe(cSubscriberID, cTopic, cCallback, userData)
+	var success C.bool
+	done := timeCgo("subscribe")
+	if options.group != "" {
+		cGroup := C.CString(options.group)
+		defer C.free(unsafe.Pointer(cGroup))
+
+		success = C.subscribe_group(cSubscriberID, cTopic, cGroup, cCallback, userData)
+	} else if options.backfill > 0 {
+		success = C.subscribe_backfill(cSubscriberID, cTopic, cCallback, userData, C.size_t(options.backfill))
+	} else {
+		success = C.subscribe(cSubscriberID, cTopic, cCallback, userData)
+	}
+	done()
 	if !success {
-		return errors.New("failed to subscribe")
+		restore()
+		if options.group != "" {
+			watchRebalances(subscriberID, topic, options.group, nil)
+		}
+		if err := checkDeliveryMode(topic, options.group); err != nil {
+			return err
+		}
+		return checkInternal(errors.New("failed to subscribe"))
+	}
+	return configureSubscriber(cSubscriberID, subscriberID, []string{topic}, handler, options)
+}
+
+// registerHandler sets a subscriber's handler and the delivery policies of its
+// subscriptions to topics, reusing the subscriber's C user data if it has one.
+// It returns the user data to hand the core, and a function putting back what
+// it replaced for when the core refuses the subscriptions. The caller holds
+// the shard's lifecycle lock.
+func registerHandler(shard *registryShard, subscriberID string, topics []string, handler HandlerFunc, options subscribeOptions) (unsafe.Pointer, func()) {
+	shard.Lock()
+	defer shard.Unlock()
+
+	entry, exists := shard.callbacks[subscriberID]
+	if !exists {
+		entry = &callbackEntry{subscriberID: subscriberID, userData: C.CString(subscriberID)}
+		shard.callbacks[subscriberID] = entry
+	}
+	previousHandler := entry.handler
+	entry.handler = handler
+
+	previousStates := make(map[subscriptionKey]*subscriptionState)
+	for _, topic := range topics {
+		key := subscriptionKey{subscriberID, topic}
+		if state, ok := shard.subscriptions[key]; ok {
+			previousStates[key] = state
+		}
+		if state := options.state(subscriberID, topic); state != nil {
+			shard.subscriptions[key] = state
+		} else {
+			delete(shard.subscriptions, key)
+		}
+	}
+
+	restore := func() {
+		shard.Lock()
+		defer shard.Unlock()
+		for _, topic := range topics {
+			key := subscriptionKey{subscriberID, topic}
+			if state, ok := previousStates[key]; ok {
+				shard.subscriptions[key] = state
+			} else {
+				delete(shard.subscriptions, key)
+			}
+		}
+		if exists {
+			entry.handler = previousHandler
+		} else {
+			C.free(unsafe.Pointer(entry.userData))
+			delete(shard.callbacks, subscriberID)
+		}
+	}
+	return unsafe.Pointer(entry.userData), restore
+}
+
+// configureSubscriber applies the options the core keeps for a subscriber or
+// for its subscriptions to topics, once it is subscribed
+func configureSubscriber(cSubscriberID *C.char, subscriberID string, topics []string, handler HandlerFunc, options subscribeOptions) error {
+	if handler != nil && options.maxBatch > 0 {
+		if !C.set_batch_delivery(cSubscriberID, C.size_t(options.maxBatch), C.uint64_t(options.maxBatchDelay.Milliseconds()), batchGatewayCallback()) {
+			return fmt.Errorf("failed to set batch delivery of subscriber '%s'", subscriberID)
+		}
+	}
+	if handler == nil && options.queueCapacity > 0 {
+		if !C.set_queue_capacity(cSubscriberID, C.size_t(options.queueCapacity)) {
+			return fmt.Errorf("failed to set queue capacity of subscriber '%s'", subscriberID)
+		}
+	}
+	if handler == nil && options.watermarkHigh > 0 {
+		if !coreSetQueueWatermarks(subscriberID, options.watermarkHigh, options.watermarkLow) {
+			return fmt.Errorf("failed to set queue watermarks of subscriber '%s': need 0 <= low < high <= 1", subscriberID)
+		}
+	}
+	if handler == nil && options.spillDir != "" {
+		if err := Features().Require(FeaturePersistence); err != nil {
+			return fmt.Errorf("failed to set up spilling of subscriber '%s': %w", subscriberID, err)
+		}
+		cDir := C.CString(options.spillDir)
+		defer C.free(unsafe.Pointer(cDir))
+
+		if !C.set_queue_spill(cSubscriberID, cDir, C.size_t(max(options.spillBudget, 0))) {
+			return checkInternal(fmt.Errorf("failed to set up spilling of subscriber '%s' to '%s'", subscriberID, options.spillDir))
+		}
+	}
+	if handler == nil && options.visibilityTimeout > 0 {
+		for _, topic := range topics {
+			cTopic := C.CString(topic)
+			success := C.set_visibility_timeout(cSubscriberID, cTopic, C.uint64_t(options.visibilityTimeout.Milliseconds()))
+			C.free(unsafe.Pointer(cTopic))
+			if !success {
+				return checkInternal(fmt.Errorf("failed to set visibility timeout of subscriber '%s' on topic '%s'", subscriberID, topic))
+			}
+		}
+	}
+	if options.group != "" && (options.balance != nil || options.weight > 0) {
+		cGroup := C.CString(options.group)
+		defer C.free(unsafe.Pointer(cGroup))
+
+		for _, topic := range topics {
+			cTopic := C.CString(topic)
+			balanced := options.balance == nil || bool(C.set_group_balance(cTopic, cGroup, C.uint32_t(*options.balance)))
+			weighted := options.weight <= 0 || bool(C.set_group_weight(cSubscriberID, cTopic, cGroup, C.uint32_t(options.weight)))
+			C.free(unsafe.Pointer(cTopic))
+
+			if !balanced {
+				return checkInternal(fmt.Errorf("failed to set balance of group '%s' on topic '%s'", options.group, topic))
+			}
+			if !weighted {
+				return checkInternal(fmt.Errorf("failed to set weight of subscriber '%s' in group '%s'", subscriberID, options.group))
+			}
+		}
+	}
+	if options.sampleRate != 0 || options.sampleEvery > 1 {
+		rate := options.sampleRate
+		if rate == 0 {
+			rate = 1
+		}
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("sample rate %v of subscriber '%s' is outside (0, 1]", rate, subscriberID)
+		}
+		for _, topic := range topics {
+			cTopic := C.CString(topic)
+			success := C.set_subscription_sampling(cSubscriberID, cTopic, C.double(rate), C.uint64_t(max(options.sampleEvery, 1)))
+			C.free(unsafe.Pointer(cTopic))
+			if !success {
+				return checkInternal(fmt.Errorf("failed to set sampling of subscriber '%s' on topic '%s'", subscriberID, topic))
+			}
+		}
+	}
+	if options.rateLimit > 0 {
+		for _, topic := range topics {
+			if err := SetRateLimit(subscriberID, topic, options.rateLimit); err != nil {
+				return err
+			}
+		}
+	}
+	if options.labels != nil {
+		return SetSubscriberLabels(subscriberID, options.labels)
 	}
 	
 	return nil
 }
 
+// SubscribeMany subscribes a handler, or a queue if handler is nil, to
+// several topics in one call to the core. Either every subscription is made
+// or, if any topic is invalid or over a limit, none is. Options apply to each
+// subscription, except WithGroup and WithBackfill, which need one
+// subscription per topic.
+func SubscribeMany(subscriberID string, topics []string, handler HandlerFunc, opts ...SubscribeOption) (err error) {
+	defer func() {
+		for _, topic := range topics {
+			recordAudit(AuditEvent{Operation: AuditSubscribe, SubscriberID: subscriberID, Topic: topic}, err)
+		}
+	}()
+
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return err
+	}
+	if len(topics) == 0 {
+		return errors.New("failed to subscribe: no topics given")
+	}
+	resolved := make([]string, len(topics))
+	for i, topic := range topics {
+		if err := validateTopic(topic); err != nil {
+			return err
+		}
+		resolved[i] = ResolveTopic(topic)
+	}
+	for _, topic := range resolved {
+		if err := hookSubscribe(subscriberID, topic); err != nil {
+			return err
+		}
+	}
+
+	return subscribeMany(subscriberID, resolved, handler, opts)
+}
+
+// subscribeMany registers the subscriptions of SubscribeMany, restoring the
+// subscriber's handler and delivery policies if the core refuses them
+func subscribeMany(subscriberID string, topics []string, handler HandlerFunc, opts []SubscribeOption) error {
+	if err := injectedFault("subscribe"); err != nil {
+		return err
+	}
+
+	var options subscribeOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.group != "" || options.backfill > 0 {
+		return errors.New("failed to subscribe: groups and backfill need one subscription per topic")
+	}
+
+	shard := registryShardFor(subscriberID)
+	shard.lifecycle.Lock()
+	defer shard.lifecycle.Unlock()
+
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	encoded, _ := json.Marshal(topics)
+	cTopics := C.CString(string(encoded))
+	defer C.free(unsafe.Pointer(cTopics))
+
+	var cCallback C.message_callback
+	var userData unsafe.Pointer
+	restore := func() {}
+
+	if handler != nil {
+		userData, restore = registerHandler(shard, subscriberID, topics, handler, options)
+		cCallback = gatewayCallback()
+	}
+
+	done := timeCgo("subscribe_many")
+	success := C.subscribe_many(cSubscriberID, cTopics, cCallback, userData)
+	done()
+	if !success {
+		restore()
+		return checkInternal(fmt.Errorf("failed to subscribe to %d topics", len(topics)))
+	}
+	return configureSubscriber(cSubscriberID, subscriberID, topics, handler, options)
+}
+
 // Unsubscribe removes a subscription from a topic
 // If topic is empty, unsubscribes from all topics
-func Unsubscribe(subscriberID string, topic string) error {
+func Unsubscribe(subscriberID string, topic string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditUnsubscribe, SubscriberID: subscriberID, Topic: topic}, err)
+	}()
+
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return err
+	}
+	if topic != "" {
+		if err := validateTopic(topic); err != nil {
+			return err
+		}
+		topic = ResolveTopic(topic)
+	}
+
+	if err := unsubscribe(subscriberID, topic); err != nil {
+		return err
+	}
+	hookUnsubscribe(subscriberID, topic)
+	return nil
+}
+
+// unsubscribe removes a subscription without validating the subscriber ID
+func unsubscribe(subscriberID string, topic string) error {
+	if err := injectedFault("unsubscribe"); err != nil {
+		return err
+	}
+
+	shard := registryShardFor(subscriberID)
+	shard.lifecycle.Lock()
+	defer shard.lifecycle.Unlock()
+
 	cSubscriberID := C.CString(subscriberID)
 	defer C.free(unsafe.Pointer(cSubscriberID))
 	
@@ -94,92 +567,487 @@ func Unsubscribe(subscriberID string, topic string) error {
 		defer C.free(unsafe.Pointer(cTopic))
 	}
 	
+	done := timeCgo("unsubscribe")
 	success := C.unsubscribe(cSubscriberID, cTopic)
+	done()
 	if !success {
-		return errors.New("failed to unsubscribe")
+		return checkInternal(errors.New("failed to unsubscribe"))
 	}
 	
-	// If unsubscribing from all topics, remove the callback
+	// The core no longer calls this subscriber: callbacks run under the broker
+	// lock, which unsubscribe took after any delivery in progress
+	shard.Lock()
 	if topic == "" {
-		callbackRegistry.Lock()
-		delete(callbackRegistry.callbacks, subscriberID)
-		callbackRegistry.Unlock()
+		// If unsubscribing from all topics, remove the callback and delivery policies
+		forgetCString(subscriberID)
+		if entry, exists := shard.callbacks[subscriberID]; exists {
+			C.free(unsafe.Pointer(entry.userData))
+			delete(shard.callbacks, subscriberID)
+		}
+		for key := range shard.subscriptions {
+			if key.subscriberID == subscriberID {
+				delete(shard.subscriptions, key)
+			}
+		}
+	} else {
+		delete(shard.subscriptions, subscriptionKey{subscriberID, topic})
 	}
+	shard.Unlock()
 	
 	return nil
 }
 
+// UnsubscribePrefix removes every subscription of a subscriber to a topic
+// starting with prefix, including group memberships, and returns the topics
+// it was removed from. They are removed at once, so no message published
+// afterwards reaches any of them. Unlike Unsubscribe with no topic, the
+// subscriber's callback stays registered for its other subscriptions.
+func UnsubscribePrefix(subscriberID, prefix string) (topics []string, err error) {
+	defer func() {
+		if err != nil {
+			recordAudit(AuditEvent{Operation: AuditUnsubscribe, SubscriberID: subscriberID, Details: map[string]string{"prefix": prefix}}, err)
+			return
+		}
+		for _, topic := range topics {
+			recordAudit(AuditEvent{Operation: AuditUnsubscribe, SubscriberID: subscriberID, Topic: topic}, nil)
+		}
+	}()
+
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		return nil, errors.New("failed to unsubscribe: prefix cannot be empty")
+	}
+	if err := injectedFault("unsubscribe"); err != nil {
+		return nil, err
+	}
+
+	shard := registryShardFor(subscriberID)
+	shard.lifecycle.Lock()
+	defer shard.lifecycle.Unlock()
+
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+	cPrefix := C.CString(prefix)
+	defer C.free(unsafe.Pointer(cPrefix))
+
+	done := timeCgo("unsubscribe_prefix")
+	cTopics := C.unsubscribe_prefix(cSubscriberID, cPrefix)
+	done()
+	if cTopics == nil {
+		return nil, checkInternal(errors.New("failed to unsubscribe"))
+	}
+	defer C.free_string(cTopics)
+
+	if err := json.Unmarshal([]byte(C.GoString(cTopics)), &topics); err != nil {
+		return nil, err
+	}
+
+	shard.Lock()
+	for _, topic := range topics {
+		delete(shard.subscriptions, subscriptionKey{subscriberID, topic})
+	}
+	shard.Unlock()
+
+	for _, topic := range topics {
+		hookUnsubscribe(subscriberID, topic)
+	}
+	return topics, nil
+}
+
+// ListSubscriptions returns the topics a subscriber is subscribed to,
+// directly or through a consumer group, sorted by name
+func ListSubscriptions(subscriberID string) ([]string, error) {
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return nil, err
+	}
+
+	cSubscriberID := C.CString(subscriberID)
+	defer C.free(unsafe.Pointer(cSubscriberID))
+
+	cTopics := C.list_subscriptions(cSubscriberID)
+	if cTopics == nil {
+		return nil, checkInternal(errors.New("failed to list subscriptions"))
+	}
+	defer C.free_string(cTopics)
+
+	var topics []string
+	if err := json.Unmarshal([]byte(C.GoString(cTopics)), &topics); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+// DeliveryReport describes the outcome of a publish
+type DeliveryReport struct {
+	// Subscribers is the number of subscribers the message was routed to
+	Subscribers int
+	// Delivered is the number of subscribers whose callback accepted the message
+	// or whose queue received it
+	Delivered int
+	// Dropped is the number of subscribers that dropped the message, e.g.
+	// because their circuit breaker was open or their callback failed
+	Dropped int
+	// Duplicate is set when the message ID was already published within the
+	// dedup window and the message was not delivered
+	Duplicate bool
+	// Filtered is set when one of the topic's transforms dropped the message,
+	// so it was not delivered
+	Filtered bool
+}
+
+// ErrNoTopic is returned when publishing to a topic that has no subscribers,
+// so the broker has never created it or has removed it
+var ErrNoTopic = errors.New("topic does not exist")
+
+// ErrDuplicateMessage is returned when a message ID was already published within
+// the dedup window. The message is not delivered again.
+var ErrDuplicateMessage = errors.New("duplicate message ID")
+
 // Publish sends a message to a topic
-func Publish(topic, message string) error {
-	cTopic := C.CString(topic)
-	defer C.free(unsafe.Pointer(cTopic))
+func Publish(topic, message string, opts ...PublishOption) error {
+	if err := validatePublishTopic(topic); err != nil {
+		return err
+	}
+	if err := hookPublish(ResolveTopic(topic), message); err != nil {
+		return err
+	}
+
+	return publish(topic, message, opts, nil)
+}
+
+// PublishSync sends a message to a topic and blocks until every current
+// subscriber has received it, either by its callback returning or by the
+// message being queued, then reports how many subscribers were reached.
+// Callbacks on topics with relaxed ordering run on the caller rather than a
+// worker, so they too have returned and only those that succeeded count as
+// delivered.
+// A duplicate message ID returns the report with Duplicate set and
+// ErrDuplicateMessage.
+func PublishSync(topic, message string, opts ...PublishOption) (DeliveryReport, error) {
+	if err := validatePublishTopic(topic); err != nil {
+		return DeliveryReport{}, err
+	}
+	if err := hookPublish(ResolveTopic(topic), message); err != nil {
+		return DeliveryReport{}, err
+	}
+
+	var cReport C.DeliveryReport
+	opts = append(opts[:len(opts):len(opts)], func(o *publishOptions) { o.sync = true })
+	err := publish(topic, message, opts, &cReport)
+	if err != nil && !errors.Is(err, ErrDuplicateMessage) {
+		return DeliveryReport{}, err
+	}
+
+	return DeliveryReport{
+		Subscribers: int(cReport.subscribers),
+		Delivered:   int(cReport.delivered),
+		Dropped:     int(cReport.dropped),
+		Duplicate:   bool(cReport.duplicate),
+		Filtered:    bool(cReport.filtered),
+	}, err
+}
+
+// SetDedupWindow sets how long message IDs are remembered for deduplication
+func SetDedupWindow(window time.Duration) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"dedup_window": window.String()}}, err)
+	}()
+
+	success := C.set_dedup_window(C.uint64_t(window.Milliseconds()))
+	if !success {
+		return errors.New("failed to set dedup window")
+	}
+
+	return nil
+}
+
+// publish sends a message to any valid topic, including reserved ones
+func publish(topic, message string, opts []PublishOption, cReport *C.DeliveryReport) error {
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+	if err := checkMessage(message); err != nil {
+		return err
+	}
+
+	if err := injectedFault("publish_with_options"); err != nil {
+		return err
+	}
+
+	var options publishOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if cReport == nil {
+		cReport = new(C.DeliveryReport)
+	}
+
+	cTopic := internCString(topic)
+	defer cTopic.release()
 	
 	cMessage := C.CString(message)
 	defer C.free(unsafe.Pointer(cMessage))
 	
-	success := C.publish(cTopic, cMessage)
+	cOptions := options.toC()
+	defer freePublishOptions(&cOptions)
+
+	started := time.Now()
+	done := timeCgo("publish_with_options")
+	success := C.publish_with_options(cTopic.ptr, cMessage, &cOptions, cReport)
+	done()
+	err := publishResult(topic, bool(success), cReport)
+	tracePublish(topic, options, started, cReport, err)
+	if err != nil {
+		return err
+	}
+	hookDrop(topic, int(cReport.dropped))
+	recordPublish(topic, message, options)
+	if topic == ControlTopic {
+		drainControl()
+	}
+	return nil
+}
+
+// publishResult converts the outcome of a publish call to an error
+func publishResult(topic string, success bool, cReport *C.DeliveryReport) error {
+	if cReport.error != nil {
+		defer C.free_string(cReport.error)
+	}
 	if !success {
-		return fmt.Errorf("failed to publish message to topic '%s'", topic)
+		switch cReport.status {
+		case C.PUBLISH_NO_TOPIC:
+			return fmt.Errorf("failed to publish message to topic '%s': %w", topic, ErrNoTopic)
+		case C.PUBLISH_TOO_LARGE:
+			return ErrMessageTooLarge
+		case C.PUBLISH_UNKNOWN_PUBLISHER:
+			return fmt.Errorf("failed to publish message to topic '%s': %w", topic, ErrUnknownPublisher)
+		case C.PUBLISH_QUOTA_EXCEEDED:
+			return fmt.Errorf("failed to publish message to topic '%s': %w", topic, ErrQuotaExceeded)
+		case C.PUBLISH_SCHEMA_INVALID:
+			return &SchemaValidationError{Topic: topic, Reason: C.GoString(cReport.error)}
+		case C.PUBLISH_MEMORY_LIMIT:
+			return fmt.Errorf("failed to publish message to topic '%s': %w", topic, ErrMemoryLimit)
+		case C.PUBLISH_KEY_REQUIRED:
+			return fmt.Errorf("failed to publish message to topic '%s': %w", topic, ErrOrderingKeyRequired)
+		case C.PUBLISH_REJECTED:
+			return &TransformRejectedError{Topic: topic, Reason: C.GoString(cReport.error)}
+		default:
+			return checkInternal(fmt.Errorf("failed to publish message to topic '%s'", topic))
+		}
+	}
+	if cReport.duplicate {
+		return ErrDuplicateMessage
 	}
 	
 	return nil
 }
 
+// toC converts the options to a C struct whose strings must be freed with freePublishOptions
+func (o publishOptions) toC() C.PublishOptions {
+	var cOptions C.PublishOptions
+	if o.orderingKey != "" {
+		cOptions.ordering_key = C.CString(o.orderingKey)
+	}
+	if o.messageID != "" {
+		cOptions.message_id = C.CString(o.messageID)
+	}
+	if o.publisherID != "" {
+		cOptions.publisher_id = C.CString(o.publisherID)
+	}
+	if len(o.headers) > 0 {
+		headers, _ := json.Marshal(o.headers)
+		cOptions.headers = C.CString(string(headers))
+	}
+	if o.receiptTopic != "" {
+		cOptions.receipt_topic = C.CString(o.receiptTopic)
+	}
+	cOptions.sync = C.bool(o.sync)
+	return cOptions
+}
+
+func freePublishOptions(cOptions *C.PublishOptions) {
+	C.free(unsafe.Pointer(cOptions.ordering_key))
+	C.free(unsafe.Pointer(cOptions.message_id))
+	C.free(unsafe.Pointer(cOptions.publisher_id))
+	C.free(unsafe.Pointer(cOptions.headers))
+	C.free(unsafe.Pointer(cOptions.receipt_topic))
+}
+
 // Message represents a pub/sub message
 type Message struct {
 	Topic   string
 	Content string
+	// ID is the ID the message was published with WithMessageID, set when it
+	// is delivered to a callback while being published
+	ID string
+	// Headers are the headers the message was published with, set when it is
+	// delivered to a callback while being published
+	Headers map[string]string
+	// Backfill is set on messages delivered from the topic's history to a
+	// subscription made WithBackfill
+	Backfill bool
+
+	// receipt is where to report the message being dead lettered, for
+	// messages published WithReceipts
+	receipt *receiptTarget
+}
+
+// backfillHeader marks messages delivered from a topic's history
+const backfillHeader = "$backfill"
+
+// syncHeader marks deliveries of a PublishSync, which waits for the callbacks
+const syncHeader = "$sync"
+
+// ErrMessageTruncated is returned by GetMessage when the next message is larger
+// than the receive buffer. The message stays queued and can be read after
+// raising MaxMessageSize to at least Needed.
+type ErrMessageTruncated struct {
+	// Needed is the message length in bytes
+	Needed int
+}
+
+func (e *ErrMessageTruncated) Error() string {
+	return fmt.Sprintf("message truncated: %d bytes needed", e.Needed)
 }
 
 // GetMessage retrieves the next message for a subscriber
 // If topic is empty, gets the next message from any topic
+// If the message is larger than the current MaxMessageSize it is left queued
+// and an *ErrMessageTruncated is returned
+// The message is removed as it is returned, so it is lost if the consumer
+// fails before processing it; Fetch keeps it until it is acknowledged
 func GetMessage(subscriberID string, topic string) (*Message, error) {
-	cSubscriberID := C.CString(subscriberID)
-	defer C.free(unsafe.Pointer(cSubscriberID))
-	
-	var cTopic *C.char
+	return readMessage(subscriberID, topic, true)
+}
+
+// PeekMessage returns the message GetMessage would retrieve next without
+// removing it from the queue, so it can be inspected before committing to
+// process it. If topic is empty, it peeks at the next message from any topic.
+func PeekMessage(subscriberID string, topic string) (*Message, error) {
+	return readMessage(subscriberID, topic, false)
+}
+
+// QueueDepth returns the number of messages queued for a subscriber, including
+// those spilled to disk. If topic is empty, it counts messages from every topic.
+func QueueDepth(subscriberID string, topic string) int {
+	if validateSubscriberID(subscriberID) != nil {
+		return 0
+	}
+	topic = ResolveTopic(topic)
+
+	cSubscriberID := internCString(subscriberID)
+	defer cSubscriberID.release()
+
+	cTopic := internOptional(topic)
+	defer cTopic.release()
+
+	done := timeCgo("queue_depth")
+	defer done()
+	return int(C.queue_depth(cSubscriberID.ptr, cTopic.ptr))
+}
+
+// readMessage copies the next queued message, removing it if consume is set
+func readMessage(subscriberID string, topic string, consume bool) (*Message, error) {
+	if err := validateSubscriberID(subscriberID); err != nil {
+		return nil, err
+	}
 	if topic != "" {
-		cTopic = C.CString(topic)
-		defer C.free(unsafe.Pointer(cTopic))
+		if err := validateTopic(topic); err != nil {
+			return nil, err
+		}
+		topic = ResolveTopic(topic)
+	}
+
+	operation := "get_next_message"
+	if !consume {
+		operation = "peek_next_message"
+	}
+	if err := injectedFault(operation); err != nil {
+		return nil, err
 	}
+
+	cSubscriberID := internCString(subscriberID)
+	defer cSubscriberID.release()
 	
-	// Allocate buffers for the output
-	cOutTopic := (*C.char)(C.malloc(C.size_t(MaxTopicSize)))
-	defer C.free(unsafe.Pointer(cOutTopic))
+	cTopic := internOptional(topic)
+	defer cTopic.release()
 	
-	cOutMessage := (*C.char)(C.malloc(C.size_t(MaxMessageSize)))
-	defer C.free(unsafe.Pointer(cOutMessage))
+	// Reuse pooled C buffers rather than allocating them on every call
+	buffers := messageBufferPool.Get().(*messageBuffers)
+	defer messageBufferPool.Put(buffers)
+
+	// Size the output buffers by the current limits plus the terminator
+	limits := GetLimits()
+	topicSize := C.size_t(limits.MaxTopicSize + 1)
+	messageSize := C.size_t(limits.MaxMessageSize + 1)
+
+	cOutTopic := buffers.outTopic.reserve(int(topicSize))
+	cOutMessage := buffers.outMessage.reserve(int(messageSize))
 	
-	success := C.get_next_message(
-		cSubscriberID,
-		cTopic,
-		cOutTopic,
-		C.size_t(MaxTopicSize),
-		cOutMessage,
-		C.size_t(MaxMessageSize),
-	)
+	var topicLen, messageLen C.size_t
+	var success C.bool
+	done := timeCgo(operation)
+	if consume {
+		success = C.get_next_message(
+			cSubscriberID.ptr,
+			cTopic.ptr,
+			cOutTopic,
+			topicSize,
+			cOutMessage,
+			messageSize,
+			&topicLen,
+			&messageLen,
+		)
+	} else {
+		success = C.peek_next_message(
+			cSubscriberID.ptr,
+			cTopic.ptr,
+			cOutTopic,
+			topicSize,
+			cOutMessage,
+			messageSize,
+			&topicLen,
+			&messageLen,
+		)
+	}
+	done()
 	
 	if !success {
-		return nil, errors.New("no messages available")
+		// A message that doesn't fit is left queued and its lengths reported
+		if topicLen >= topicSize {
+			return nil, fmt.Errorf("failed to get message: %w", ErrTopicTooLong)
+		}
+		if messageLen >= messageSize {
+			return nil, &ErrMessageTruncated{Needed: int(messageLen)}
+		}
+		return nil, checkInternal(errors.New("no messages available"))
 	}
 	
 	return &Message{
-		Topic:   C.GoString(cOutTopic),
-		Content: C.GoString(cOutMessage),
+		Topic:   C.GoStringN(cOutTopic, C.int(topicLen)),
+		Content: C.GoStringN(cOutMessage, C.int(messageLen)),
 	}, nil
 }
 
 // HasMessages checks if there are any messages available for a subscriber
 // If topic is empty, checks for messages from any topic
 func HasMessages(subscriberID string, topic string) bool {
-	cSubscriberID := C.CString(subscriberID)
-	defer C.free(unsafe.Pointer(cSubscriberID))
-	
-	var cTopic *C.char
-	if topic != "" {
-		cTopic = C.CString(topic)
-		defer C.free(unsafe.Pointer(cTopic))
+	if validateSubscriberID(subscriberID) != nil {
+		return false
 	}
+	topic = ResolveTopic(topic)
+
+	cSubscriberID := internCString(subscriberID)
+	defer cSubscriberID.release()
+	
+	cTopic := internOptional(topic)
+	defer cTopic.release()
 	
-	return bool(C.has_messages(cSubscriberID, cTopic))
+	done := timeCgo("has_messages")
+	defer done()
+	return bool(C.has_messages(cSubscriberID.ptr, cTopic.ptr))
 }
@@ -0,0 +1,255 @@
+// Declarations for the functions exported by the Rust pubsub_core library
+#ifndef PUBSUB_CORE_H
+#define PUBSUB_CORE_H
+
+#include <stdlib.h>
+#include <stdbool.h>
+#include <stdint.h>
+#include <string.h>
+
+// Version of this interface, returned by abi_version
+#define ABI_VERSION 40
+
+typedef bool (*message_callback)(const char* topic, const char* message, void* user_data);
+
+// A message in a batch handed to a batch_callback
+typedef struct {
+    const char* topic;
+    const char* message;
+} BatchMessage;
+
+// Callback receiving a batch of messages, returning how many were delivered
+typedef size_t (*batch_callback)(const BatchMessage* messages, size_t count, void* user_data);
+
+// Called with the message of each panic caught at the FFI boundary
+typedef void (*panic_callback)(const char* message);
+
+// Called with each log event: its level, message and fields as a JSON object
+typedef void (*log_callback)(uint32_t level, const char* message, const char* fields);
+
+// Callback encrypting or decrypting data written to disk, returning a buffer
+// allocated with malloc that the core frees, or NULL if it failed
+typedef uint8_t* (*storage_cipher_callback)(uint32_t op, const uint8_t* data, size_t len, size_t* out_len);
+
+typedef struct {
+    const char* ordering_key;
+    const char* message_id;
+    const char* publisher_id;
+    const char* headers;
+    const char* receipt_topic;
+    bool sync;
+} PublishOptions;
+
+typedef struct {
+    size_t subscribers;
+    size_t delivered;
+    size_t dropped;
+    bool duplicate;
+    bool filtered;
+    uint32_t status;
+    char* error;
+} DeliveryReport;
+
+// DeliveryReport status codes
+#define PUBLISH_OK 0
+#define PUBLISH_NO_TOPIC 1
+#define PUBLISH_TOO_LARGE 2
+#define PUBLISH_UNKNOWN_PUBLISHER 3
+#define PUBLISH_QUOTA_EXCEEDED 4
+#define PUBLISH_SCHEMA_INVALID 5
+#define PUBLISH_MEMORY_LIMIT 6
+#define PUBLISH_KEY_REQUIRED 7
+#define PUBLISH_REJECTED 8
+
+// Policies for set_memory_limit
+#define MEMORY_POLICY_REJECT 0
+#define MEMORY_POLICY_EVICT_OLDEST 1
+#define MEMORY_POLICY_DROP_LARGEST 2
+
+// Balancing strategies for set_group_balance
+#define BALANCE_ROUND_ROBIN 0
+#define BALANCE_LEAST_PENDING 1
+#define BALANCE_WEIGHTED 2
+
+// Number of partitions ordering keys are hashed into within a consumer group
+#define GROUP_PARTITIONS 64
+
+// Values a transform module's transform function returns to drop the
+// message or reject the publish, instead of a packed pointer and length
+#define TRANSFORM_DROP (-1)
+#define TRANSFORM_REJECT (-2)
+
+// Schema types for register_schema
+#define SCHEMA_JSON 0
+
+// Delivery modes of create_topic
+#define DELIVERY_ANY 0
+#define DELIVERY_FANOUT 1
+#define DELIVERY_QUEUE 2
+#define DELIVERY_KEYED 3
+
+// Feature bits reported by get_features
+#define FEATURE_ACK (1 << 0)
+#define FEATURE_PERSISTENCE (1 << 1)
+#define FEATURE_WILDCARDS (1 << 2)
+#define FEATURE_GROUPS (1 << 3)
+#define FEATURE_TRANSACTIONS (1 << 4)
+#define FEATURE_SCHEMAS (1 << 5)
+#define FEATURE_ZERO_COPY (1 << 6)
+#define FEATURE_TRACING (1 << 7)
+#define FEATURE_CHAOS (1 << 8)
+#define FEATURE_STORAGE_WAL (1 << 9)
+#define FEATURE_STORAGE_SQLITE (1 << 10)
+#define FEATURE_STORAGE_ROCKSDB (1 << 11)
+#define FEATURE_TRANSFORMS (1 << 12)
+
+// Storage engines of set_storage_engine
+#define STORAGE_MEMORY 0
+#define STORAGE_WAL 1
+#define STORAGE_SQLITE 2
+#define STORAGE_ROCKSDB 3
+
+// Operations of a storage_cipher_callback
+#define CIPHER_SEAL 0
+#define CIPHER_OPEN 1
+
+// Log levels of set_log_callback, most severe first
+#define LOG_ERROR 1
+#define LOG_WARN 2
+#define LOG_INFO 3
+#define LOG_DEBUG 4
+#define LOG_TRACE 5
+
+typedef struct {
+    double messages_per_sec;
+    uint64_t bytes_per_day;
+    uint64_t max_retained_bytes;
+} Quota;
+
+typedef struct {
+    size_t max_topic_size;
+    size_t max_message_size;
+    size_t max_topics;
+    size_t max_subscribers_per_topic;
+} Limits;
+
+// A queued message leased by get_next_buffer until release_buffer is called
+typedef struct {
+    uint64_t id;
+    const char* topic;
+    size_t topic_len;
+    const uint8_t* data;
+    size_t len;
+} PayloadBuffer;
+
+// A message copied into the caller's buffer by poll_and_fetch or fetch_messages
+typedef struct {
+    size_t topic_offset;
+    size_t topic_len;
+    size_t message_offset;
+    size_t message_len;
+    uint64_t delivery_tag;
+    uint32_t attempt;
+} FetchedMessage;
+
+extern bool subscribe(const char* subscriber_id, const char* topic, message_callback callback, void* user_data);
+extern bool subscribe_backfill(const char* subscriber_id, const char* topic, message_callback callback, void* user_data, size_t backfill);
+extern bool subscribe_many(const char* subscriber_id, const char* topics, message_callback callback, void* user_data);
+extern bool subscribe_group(const char* subscriber_id, const char* topic, const char* group, message_callback callback, void* user_data);
+extern bool set_group_balance(const char* topic, const char* group, uint32_t balance);
+extern bool set_group_weight(const char* subscriber_id, const char* topic, const char* group, uint32_t weight);
+extern uint32_t key_partition(const char* key);
+extern bool unsubscribe(const char* subscriber_id, const char* topic);
+extern char* unsubscribe_prefix(const char* subscriber_id, const char* prefix);
+extern char* list_subscriptions(const char* subscriber_id);
+extern bool create_topic(const char* topic, uint32_t mode);
+extern uint32_t topic_delivery_mode(const char* topic);
+extern bool set_topic_template(const char* pattern, const char* template_json);
+extern char* topic_templates(void);
+extern bool delete_topic(const char* topic);
+extern char* list_topics(void);
+extern bool pause_subscription(const char* subscriber_id, const char* topic);
+extern bool set_subscription_sampling(const char* subscriber_id, const char* topic, double rate, uint64_t every);
+extern bool set_subscription_throttle(const char* subscriber_id, const char* topic, double rate);
+extern bool resume_subscription(const char* subscriber_id, const char* topic);
+extern bool tap_subscribe(const char* tap_id, double sample_rate, message_callback callback, void* user_data);
+extern bool tap_unsubscribe(const char* tap_id);
+extern bool publish(const char* topic, const char* message);
+extern bool publish_with_options(const char* topic, const char* message, const PublishOptions* options, DeliveryReport* report);
+extern bool publish_bytes(const char* topic, const uint8_t* data, size_t len, const PublishOptions* options, DeliveryReport* report);
+extern bool send_to(const char* subscriber_id, const char* message);
+extern char* export_topic(const char* topic);
+extern bool import_message(const char* subscriber_id, const char* topic, const uint8_t* data, size_t len, uint64_t published_at_ms, const char* publisher_id);
+extern bool start_sys_topics(uint64_t interval_ms);
+extern bool stop_sys_topics(void);
+extern bool set_dedup_window(uint64_t window_ms);
+extern bool set_subscriber_ttl(uint64_t ttl_ms);
+extern bool set_topic_idle_ttl(uint64_t ttl_ms);
+extern bool set_group_cooldown(uint64_t cooldown_ms);
+extern bool set_message_tracing(bool enabled);
+extern bool set_topic_history(const char* topic, size_t limit);
+extern bool set_topic_compaction(const char* topic, const char* key_header);
+extern bool set_compaction_interval(uint64_t interval_ms);
+extern bool merge_topic(const char* from, const char* to);
+extern bool set_deterministic(bool enabled);
+extern bool advance_clock(uint64_t ns);
+extern bool touch_subscriber(const char* subscriber_id);
+extern bool get_limits(Limits* out_limits);
+extern bool set_limits(const Limits* limits);
+extern bool get_next_message(const char* subscriber_id, const char* topic, char* out_topic, size_t out_topic_size, char* out_message, size_t out_message_size, size_t* out_topic_len, size_t* out_message_len);
+extern bool peek_next_message(const char* subscriber_id, const char* topic, char* out_topic, size_t out_topic_size, char* out_message, size_t out_message_size, size_t* out_topic_len, size_t* out_message_len);
+extern size_t queue_depth(const char* subscriber_id, const char* topic);
+extern size_t poll_and_fetch(const char* subscriber_id, const char* topic, size_t max_messages, FetchedMessage* out_messages, char* out_buffer, size_t out_buffer_size, size_t* out_remaining, size_t* out_needed);
+extern size_t fetch_messages(const char* subscriber_id, const char* topic, size_t max_messages, FetchedMessage* out_messages, char* out_buffer, size_t out_buffer_size, size_t* out_remaining, size_t* out_needed);
+extern bool ack_message(const char* subscriber_id, uint64_t delivery_tag);
+extern bool nack_message(const char* subscriber_id, uint64_t delivery_tag);
+extern bool set_visibility_timeout(const char* subscriber_id, const char* topic, uint64_t timeout_ms);
+extern bool extend_visibility(const char* subscriber_id, uint64_t delivery_tag, uint64_t timeout_ms);
+extern bool discard_message(const char* subscriber_id, uint64_t delivery_tag);
+extern char* list_in_flight(const char* subscriber_id, const char* topic);
+extern bool set_ack_timeout(uint64_t timeout_ms);
+extern bool get_next_buffer(const char* subscriber_id, const char* topic, PayloadBuffer* out_buffer);
+extern bool release_buffer(uint64_t buffer_id);
+extern bool has_messages(const char* subscriber_id, const char* topic);
+
+extern char* get_stats(void);
+extern char* get_presence(const char* topic);
+extern bool register_publisher(const char* publisher_id, const char* labels_json);
+extern bool unregister_publisher(const char* publisher_id);
+extern bool set_namespace_quota(const char* namespace, const Quota* quota);
+extern bool set_publisher_quota(const char* publisher_id, const Quota* quota);
+extern bool register_schema(const char* subject, uint32_t schema_type, const char* definition, uint32_t* out_version, char** out_error);
+extern bool bind_schema(const char* topic, const char* subject, uint32_t version, const char* rejects_topic);
+extern bool unbind_schema(const char* topic);
+extern char* get_topic_schema(const char* topic);
+extern bool attach_transform(const char* topic, const uint8_t* data, size_t len, uint64_t* out_id, char** out_error);
+extern bool detach_transform(const char* topic, uint64_t id);
+extern char* topic_transforms(const char* topic);
+extern bool set_subscriber_labels(const char* subscriber_id, const char* labels_json);
+extern bool set_batch_delivery(const char* subscriber_id, size_t max_batch, uint64_t max_delay_ms, batch_callback callback);
+extern bool set_queue_capacity(const char* subscriber_id, size_t capacity);
+extern bool set_queue_watermarks(const char* subscriber_id, double high, double low);
+extern bool set_queue_spill(const char* subscriber_id, const char* directory, size_t memory_budget);
+extern bool set_memory_limit(uint64_t limit_bytes, uint32_t policy);
+extern bool set_storage_engine(uint32_t engine, const char* options);
+extern char* last_recovery_report(void);
+extern bool set_chaos(double drop_rate, double queue_full_rate, uint64_t seed);
+extern const char* current_headers(void);
+extern uint32_t abi_version(void);
+extern uint64_t get_features(void);
+extern const char* target_libc(void);
+extern void set_panic_callback(panic_callback callback);
+extern void set_log_callback(log_callback callback, uint32_t max_level);
+extern void set_storage_cipher(storage_cipher_callback callback);
+extern void set_allocator_stats(bool enabled);
+extern bool init_core(void);
+extern bool shutdown_core(void);
+extern char* take_last_panic(void);
+extern void free_string(char* s);
+
+extern uint64_t tx_begin(void);
+extern bool tx_publish(uint64_t tx_id, const char* topic, const char* message, const PublishOptions* options);
+extern bool tx_commit(uint64_t tx_id);
+extern bool tx_rollback(uint64_t tx_id);
+
+#endif
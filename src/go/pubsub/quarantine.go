@@ -0,0 +1,58 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// QuarantineTopic receives messages that failed every delivery attempt of a
+// subscription created with WithQuarantine
+const QuarantineTopic = "$quarantine"
+
+// Diagnostic headers attached to quarantined messages
+const (
+	HeaderOriginalTopic = "x-original-topic"
+	HeaderSubscriberID  = "x-subscriber-id"
+	HeaderAttempts      = "x-attempts"
+	HeaderLastError     = "x-last-error"
+	HeaderQuarantinedAt = "x-quarantined-at"
+)
+
+// QuarantinedMessage is the envelope published to QuarantineTopic. The Rust core
+// carries plain strings, so the diagnostic headers travel alongside the original
+// payload as JSON.
+type QuarantinedMessage struct {
+	Headers map[string]string `json:"headers"`
+	Payload string            `json:"payload"`
+}
+
+// ParseQuarantined decodes a message received on QuarantineTopic
+func ParseQuarantined(content string) (*QuarantinedMessage, error) {
+	var msg QuarantinedMessage
+	if err := json.Unmarshal([]byte(content), &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+// quarantine publishes a poison message to QuarantineTopic
+func quarantine(subscriberID, topic, message string, attempts int, lastErr error) {
+	envelope, err := json.Marshal(QuarantinedMessage{
+		Headers: map[string]string{
+			HeaderOriginalTopic: topic,
+			HeaderSubscriberID:  subscriberID,
+			HeaderAttempts:      strconv.Itoa(attempts),
+			HeaderLastError:     lastErr.Error(),
+			HeaderQuarantinedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		},
+		Payload: message,
+	})
+	if err != nil {
+		return
+	}
+
+	// The Rust core holds its lock while invoking callbacks, so publishing from
+	// the gateway has to happen on another goroutine
+	go publish(QuarantineTopic, string(envelope), nil, nil)
+}
@@ -0,0 +1,116 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// ErrQuotaExceeded is returned when a publish would exceed the quota of its
+// topic's namespace or of its publisher
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Quota limits the traffic of a namespace or publisher. Zero fields are unlimited.
+type Quota struct {
+	// MessagesPerSecond is the sustained publish rate, with bursts of up to one
+	// second's worth of messages
+	MessagesPerSecond float64
+	// BytesPerDay limits the message bytes published per 24 hour window
+	BytesPerDay uint64
+	// MaxRetainedBytes limits the message bytes waiting in subscriber queues
+	MaxRetainedBytes uint64
+}
+
+// QuotaScope is what a quota applies to
+type QuotaScope string
+
+// Quota scopes
+const (
+	QuotaNamespace QuotaScope = "namespace"
+	QuotaPublisher QuotaScope = "publisher"
+)
+
+// QuotaUsage reports a quota and how much of it is used
+type QuotaUsage struct {
+	Scope QuotaScope
+	// Name is the namespace or publisher ID
+	Name          string
+	Quota         Quota
+	BytesToday    uint64
+	RetainedBytes uint64
+	// Rejected is the number of publishes refused by the quota
+	Rejected uint64
+}
+
+// Namespace returns the namespace of a topic, its first '/'-separated segment
+func Namespace(topic string) string {
+	namespace, _, _ := strings.Cut(topic, "/")
+	return namespace
+}
+
+// SetNamespaceQuota sets the quota for topics in a namespace. A zero Quota
+// removes it. Reserved '$' namespaces can't have a quota.
+func SetNamespaceQuota(namespace string, quota Quota) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: quotaDetails("namespace", namespace, quota)}, err)
+	}()
+
+	if namespace == "" || strings.Contains(namespace, "/") {
+		return fmt.Errorf("failed to set quota: invalid namespace '%s'", namespace)
+	}
+	if strings.HasPrefix(namespace, ReservedPrefix) {
+		return fmt.Errorf("failed to set quota for namespace '%s': %w", namespace, ErrReservedTopic)
+	}
+
+	cNamespace := C.CString(namespace)
+	defer C.free(unsafe.Pointer(cNamespace))
+
+	cQuota := quota.toC()
+	if !C.set_namespace_quota(cNamespace, &cQuota) {
+		return fmt.Errorf("failed to set quota for namespace '%s'", namespace)
+	}
+
+	return nil
+}
+
+// SetPublisherQuota sets the quota for a publisher. A zero Quota removes it.
+func SetPublisherQuota(publisherID string, quota Quota) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: quotaDetails("publisher", publisherID, quota)}, err)
+	}()
+
+	if err := validateSubscriberID(publisherID); err != nil {
+		return err
+	}
+
+	cPublisherID := C.CString(publisherID)
+	defer C.free(unsafe.Pointer(cPublisherID))
+
+	cQuota := quota.toC()
+	if !C.set_publisher_quota(cPublisherID, &cQuota) {
+		return fmt.Errorf("failed to set quota for publisher '%s'", publisherID)
+	}
+
+	return nil
+}
+
+func (q Quota) toC() C.Quota {
+	return C.Quota{
+		messages_per_sec:   C.double(q.MessagesPerSecond),
+		bytes_per_day:      C.uint64_t(q.BytesPerDay),
+		max_retained_bytes: C.uint64_t(q.MaxRetainedBytes),
+	}
+}
+
+func quotaDetails(scope, name string, q Quota) map[string]string {
+	return map[string]string{
+		scope + "_quota":     name,
+		"messages_per_sec":   strconv.FormatFloat(q.MessagesPerSecond, 'g', -1, 64),
+		"bytes_per_day":      strconv.FormatUint(q.BytesPerDay, 10),
+		"max_retained_bytes": strconv.FormatUint(q.MaxRetainedBytes, 10),
+	}
+}
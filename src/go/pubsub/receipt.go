@@ -0,0 +1,65 @@
+package pubsub
+
+import "encoding/json"
+
+// ReceiptType is the kind of receipt event
+type ReceiptType string
+
+const (
+	// ReceiptDelivered is published when a subscriber's callback accepts the
+	// message, or the subscriber reads it from its queue or acks it
+	ReceiptDelivered ReceiptType = "delivered"
+	// ReceiptDeadLettered is published when the message fails every delivery
+	// attempt of a subscription made WithQuarantine
+	ReceiptDeadLettered ReceiptType = "dead_lettered"
+	// ReceiptExpired is published when a subscriber expires with the message
+	// still queued
+	ReceiptExpired ReceiptType = "expired"
+)
+
+// Reserved headers carrying a message's ID and receipt topic to callbacks
+const (
+	messageIDHeader    = "$message_id"
+	receiptTopicHeader = "$receipt_topic"
+)
+
+// Receipt is an event published on the receipt topic of a message published
+// WithReceipts
+type Receipt struct {
+	Type ReceiptType `json:"event"`
+	// MessageID is the ID the message was published with
+	MessageID string `json:"message_id"`
+	// Topic is the topic the message was published to
+	Topic string `json:"topic"`
+	// SubscriberID is the subscriber the event concerns
+	SubscriberID string `json:"subscriber_id"`
+}
+
+// ParseReceipt decodes a message received on a receipt topic
+func ParseReceipt(content string) (*Receipt, error) {
+	var receipt Receipt
+	if err := json.Unmarshal([]byte(content), &receipt); err != nil {
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+// receiptTarget is where a message's receipts go
+type receiptTarget struct {
+	messageID string
+	topic     string
+}
+
+// send publishes a receipt event, if the message has a receipt topic
+func (t *receiptTarget) send(event ReceiptType, subscriberID, topic string) {
+	if t == nil {
+		return
+	}
+	content, err := json.Marshal(Receipt{Type: event, MessageID: t.messageID, Topic: topic, SubscriberID: subscriberID})
+	if err != nil {
+		return
+	}
+
+	// Callbacks run under the broker lock, so publish on another goroutine
+	go publish(t.topic, string(content), nil, nil)
+}
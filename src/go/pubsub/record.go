@@ -0,0 +1,214 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Headers of a RecordedPublish carrying the publish options. Its other
+// headers are the ones set with WithHeader.
+const (
+	RecordHeaderMessageID   = "message_id"
+	RecordHeaderOrderingKey = "ordering_key"
+	RecordHeaderPublisherID = "publisher_id"
+)
+
+// RecordedPublish is a publish in a recording, written as one line of JSON
+type RecordedPublish struct {
+	Time  time.Time `json:"time"`
+	Topic string    `json:"topic"`
+	// Payload is the message of a string publish, and Binary the payload of
+	// PublishBytes, base64 encoded in the file
+	Payload string `json:"payload,omitempty"`
+	Binary  []byte `json:"binary,omitempty"`
+	// Headers holds the publish options, under the RecordHeader names, and
+	// the message's headers
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Recorder writes every successful publish to a file until it is closed
+type Recorder struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	// err is the first error writing the file; later publishes aren't recorded
+	err error
+}
+
+// activeRecorder is the recording in progress, if any
+var activeRecorder atomic.Pointer[Recorder]
+
+// Record starts recording publishes to a new file at path, for replaying
+// later with Replay. Messages published with Publish, PublishSync,
+// PublishAsync, PublishBytes and committed transactions are recorded with
+// their publish time and options; the broker's own reserved topics are not.
+// Only one recording can be in progress at a time.
+func Record(path string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording '%s': %w", path, err)
+	}
+
+	recorder := &Recorder{file: file, writer: bufio.NewWriter(file)}
+	if !activeRecorder.CompareAndSwap(nil, recorder) {
+		file.Close()
+		os.Remove(path)
+		return nil, errors.New("failed to start recording: a recording is already in progress")
+	}
+
+	return recorder, nil
+}
+
+// Close stops recording and closes the file, returning the first error
+// writing it
+func (r *Recorder) Close() error {
+	activeRecorder.CompareAndSwap(r, nil)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return r.err
+	}
+	if err := r.writer.Flush(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if err := r.file.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	r.file = nil
+	return r.err
+}
+
+func (r *Recorder) write(record RecordedPublish) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil || r.err != nil {
+		return
+	}
+	if _, err := r.writer.Write(append(line, '\n')); err != nil {
+		r.err = err
+	}
+}
+
+// recording returns a record of a publish if a recording is in progress
+func recording(topic string, options publishOptions) (*Recorder, RecordedPublish) {
+	recorder := activeRecorder.Load()
+	if recorder == nil || strings.HasPrefix(topic, ReservedPrefix) {
+		return nil, RecordedPublish{}
+	}
+
+	record := RecordedPublish{Time: clockNow(), Topic: topic}
+	if len(options.headers) > 0 {
+		record.Headers = make(map[string]string, len(options.headers))
+		for name, value := range options.headers {
+			record.Headers[name] = value
+		}
+	}
+	for name, value := range map[string]string{
+		RecordHeaderMessageID:   options.messageID,
+		RecordHeaderOrderingKey: options.orderingKey,
+		RecordHeaderPublisherID: options.publisherID,
+	} {
+		if value == "" {
+			continue
+		}
+		if record.Headers == nil {
+			record.Headers = make(map[string]string)
+		}
+		record.Headers[name] = value
+	}
+	return recorder, record
+}
+
+// recordPublish records a successful string publish
+func recordPublish(topic, message string, options publishOptions) {
+	if recorder, record := recording(topic, options); recorder != nil {
+		record.Payload = message
+		recorder.write(record)
+	}
+}
+
+// recordPublishBytes records a successful binary publish
+func recordPublishBytes(topic string, payload []byte, options publishOptions) {
+	if recorder, record := recording(topic, options); recorder != nil {
+		record.Binary = payload
+		recorder.write(record)
+	}
+}
+
+// Replay publishes the messages of a recording made by Record, in order and
+// with their original options. A speed of 1 spaces them as they were
+// recorded, 2 replays twice as fast, and 0 publishes them as fast as
+// possible. Replay stops at the first publish that fails.
+func Replay(path string, speed float64) error {
+	if speed < 0 {
+		return errors.New("failed to replay: speed must not be negative")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open recording '%s': %w", path, err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	var first time.Time
+	started := time.Now()
+
+	for line := 1; ; line++ {
+		var record RecordedPublish
+		if err := decoder.Decode(&record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read recording '%s' at line %d: %w", path, line, err)
+		}
+
+		if first.IsZero() {
+			first = record.Time
+		}
+		if speed > 0 {
+			due := started.Add(time.Duration(float64(record.Time.Sub(first)) / speed))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		var opts []PublishOption
+		for name, value := range record.Headers {
+			switch name {
+			case RecordHeaderMessageID:
+				opts = append(opts, WithMessageID(value))
+			case RecordHeaderOrderingKey:
+				opts = append(opts, WithOrderingKey(value))
+			case RecordHeaderPublisherID:
+				opts = append(opts, WithPublisher(value))
+			default:
+				opts = append(opts, WithHeader(name, value))
+			}
+		}
+
+		if record.Binary != nil {
+			err = PublishBytes(record.Topic, record.Binary, opts...)
+		} else {
+			err = Publish(record.Topic, record.Payload, opts...)
+		}
+		if err != nil && !errors.Is(err, ErrDuplicateMessage) {
+			return fmt.Errorf("failed to replay line %d of '%s': %w", line, path, err)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package remote
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+)
+
+// Client is a connection to a broker served by a Server. Its methods mirror
+// the pubsub package's. Callbacks run one at a time on the connection's
+// reading goroutine, so like embedded callbacks they must not call back into
+// the client.
+type Client struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+	encoder *json.Encoder
+	writer  *bufio.Writer
+
+	mu        sync.Mutex
+	nextID    uint64
+	pending   map[uint64]chan frame
+	callbacks map[[2]string]func(topic, message string)
+	err       error
+
+	done chan struct{}
+}
+
+// Dial connects to a server, such as pubsubd on a Unix socket:
+//
+//	client, err := remote.Dial("unix", "/run/pubsubd.sock")
+func Dial(network, address string) (*Client, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn), nil
+}
+
+// NewClient speaks the protocol over an established connection
+func NewClient(conn net.Conn) *Client {
+	writer := bufio.NewWriter(conn)
+	c := &Client{
+		conn:      conn,
+		encoder:   json.NewEncoder(writer),
+		writer:    writer,
+		pending:   make(map[uint64]chan frame),
+		callbacks: make(map[[2]string]func(topic, message string)),
+		done:      make(chan struct{}),
+	}
+	go c.read()
+	return c
+}
+
+// Publish publishes a message to a topic on the server
+func (c *Client) Publish(topic, message string) error {
+	_, err := c.call(frame{Op: opPublish, Topic: topic, Message: message})
+	return err
+}
+
+// PublishWithHeaders publishes a message with headers, as pubsub.WithHeader does
+func (c *Client) PublishWithHeaders(topic, message string, headers map[string]string) error {
+	_, err := c.call(frame{Op: opPublish, Topic: topic, Message: message, Headers: headers})
+	return err
+}
+
+// Subscribe subscribes on the server. Messages for a callback are pushed to
+// the client; without one they are queued on the server for GetMessage.
+// The server removes the connection's subscriptions when it closes.
+func (c *Client) Subscribe(subscriberID, topic string, callback func(topic, message string)) error {
+	key := [2]string{subscriberID, topic}
+	if callback != nil {
+		c.mu.Lock()
+		c.callbacks[key] = callback
+		c.mu.Unlock()
+	}
+
+	_, err := c.call(frame{Op: opSubscribe, SubscriberID: subscriberID, Topic: topic, Queued: callback == nil})
+	if err != nil && callback != nil {
+		c.mu.Lock()
+		delete(c.callbacks, key)
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Unsubscribe unsubscribes on the server, from every topic if topic is empty
+func (c *Client) Unsubscribe(subscriberID, topic string) error {
+	_, err := c.call(frame{Op: opUnsubscribe, SubscriberID: subscriberID, Topic: topic})
+	if err == nil {
+		c.mu.Lock()
+		for key := range c.callbacks {
+			if key[0] == subscriberID && (topic == "" || key[1] == topic) {
+				delete(c.callbacks, key)
+			}
+		}
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// GetMessage returns the next message queued on the server for the
+// subscriber, from any topic if topic is empty, and false if there is none
+func (c *Client) GetMessage(subscriberID, topic string) (msgTopic, message string, ok bool, err error) {
+	result, err := c.call(frame{Op: opGet, SubscriberID: subscriberID, Topic: topic})
+	if err != nil {
+		return "", "", false, err
+	}
+	return result.Topic, result.Message, result.Found, nil
+}
+
+// Subscription names a subscription of a session
+type Subscription struct {
+	SubscriberID string
+	Topic        string
+}
+
+// StartSession makes the connection's subscriptions resumable and returns
+// the session's token. When the connection ends the server keeps them for its
+// SessionTTL, queueing their messages, for ResumeSession on a new connection.
+func (c *Client) StartSession() (string, error) {
+	result, err := c.call(frame{Op: opSession})
+	if err != nil {
+		return "", err
+	}
+	return result.Session, nil
+}
+
+// ResumeSession takes over the subscriptions of the session token names,
+// without subscribing again, and receives the messages queued for them while
+// it was disconnected. callbacks are the callbacks of the session's callback
+// subscriptions; messages for any other are dropped. It returns
+// ErrSessionExpired once the server no longer has the session, and must be
+// called before the connection subscribes.
+func (c *Client) ResumeSession(token string, callbacks map[Subscription]func(topic, message string)) error {
+	c.mu.Lock()
+	for sub, callback := range callbacks {
+		c.callbacks[[2]string{sub.SubscriberID, sub.Topic}] = callback
+	}
+	c.mu.Unlock()
+
+	_, err := c.call(frame{Op: opSession, Session: token})
+	if err != nil {
+		c.mu.Lock()
+		for sub := range callbacks {
+			delete(c.callbacks, [2]string{sub.SubscriberID, sub.Topic})
+		}
+		c.mu.Unlock()
+	}
+	return err
+}
+
+// Ping checks that the server is answering
+func (c *Client) Ping() error {
+	_, err := c.call(frame{Op: opPing})
+	return err
+}
+
+// Done is closed when the connection is lost or closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// call sends a request and waits for its result
+func (c *Client) call(request frame) (frame, error) {
+	result := make(chan frame, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return frame{}, ErrClosed
+	}
+	c.nextID++
+	request.ID = c.nextID
+	c.pending[request.ID] = result
+	c.mu.Unlock()
+
+	c.writeMu.Lock()
+	err := c.encoder.Encode(request)
+	if err == nil {
+		err = c.writer.Flush()
+	}
+	c.writeMu.Unlock()
+	if err != nil {
+		c.conn.Close()
+	}
+
+	select {
+	case response := <-result:
+		if response.Error != "" {
+			return response, &remoteError{message: response.Error, sentinel: errorCodes[response.Code]}
+		}
+		return response, nil
+	case <-c.done:
+		return frame{}, ErrClosed
+	}
+}
+
+// read dispatches results and pushed messages until the connection ends
+func (c *Client) read() {
+	defer close(c.done)
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 64*1024), maxFrameBytes)
+	for scanner.Scan() {
+		var f frame
+		if err := json.Unmarshal(scanner.Bytes(), &f); err != nil {
+			break
+		}
+
+		switch f.Op {
+		case opMessage:
+			c.mu.Lock()
+			callback := c.callbacks[[2]string{f.SubscriberID, f.Topic}]
+			c.mu.Unlock()
+			if callback != nil {
+				callback(f.Topic, f.Message)
+			}
+		case opResult:
+			c.mu.Lock()
+			result, ok := c.pending[f.ID]
+			delete(c.pending, f.ID)
+			c.mu.Unlock()
+			if ok {
+				result <- f
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.err = ErrClosed
+	c.pending = nil
+	c.mu.Unlock()
+	c.conn.Close()
+}
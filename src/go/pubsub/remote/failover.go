@@ -0,0 +1,417 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// Broker is the part of a broker a Failover client uses, implemented by
+// Client and by the embedded broker
+type Broker interface {
+	Publish(topic, message string) error
+	Subscribe(subscriberID, topic string, callback func(topic, message string)) error
+	Unsubscribe(subscriberID, topic string) error
+	GetMessage(subscriberID, topic string) (msgTopic, message string, ok bool, err error)
+	Close() error
+}
+
+// Endpoints understood by New
+const (
+	// EndpointEmbedded is the broker in this process
+	EndpointEmbedded = "embedded"
+	// unixPrefix and tcpPrefix start the endpoints of pubsubd servers, such
+	// as unix:/run/pubsubd.sock or tcp:localhost:7070
+	unixPrefix = "unix:"
+	tcpPrefix  = "tcp:"
+)
+
+// DefaultProbeInterval is how often a Failover client tries to return to a
+// preferred endpoint when New leaves it unset
+const DefaultProbeInterval = 5 * time.Second
+
+// maxReconnectDelay caps the wait between rounds of connection attempts
+const maxReconnectDelay = 5 * time.Second
+
+// Failover is a client that uses the first available of an ordered list of
+// endpoints. When its broker becomes unavailable it connects to the next one
+// that answers and makes its subscriptions again there, and it returns to a
+// preferred endpoint once that answers again. A publish interrupted by a lost
+// connection is retried on the next broker, so messages may be delivered
+// twice around a switch. Messages queued on a broker that fails are not
+// carried over, since brokers keep no offsets to replay from.
+type Failover struct {
+	endpoints     []string
+	probeInterval time.Duration
+	onSwitch      func(endpoint string)
+
+	mu            sync.Mutex
+	current       Broker
+	index         int
+	changed       chan struct{} // closed and replaced on every switch
+	subscriptions map[[2]string]func(topic, message string)
+	closed        bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// FailoverOption configures a Failover client
+type FailoverOption func(*Failover)
+
+// WithProbeInterval sets how often a Failover client tries to return to a
+// preferred endpoint
+func WithProbeInterval(interval time.Duration) FailoverOption {
+	return func(f *Failover) {
+		f.probeInterval = interval
+	}
+}
+
+// WithSwitchHook sets a function called with the endpoint a Failover client
+// switches to, after its subscriptions have been made there
+func WithSwitchHook(fn func(endpoint string)) FailoverOption {
+	return func(f *Failover) {
+		f.onSwitch = fn
+	}
+}
+
+// New connects to the first of the endpoints that answers, most preferred
+// first. An endpoint is EndpointEmbedded, unix:<socket path> or
+// tcp:<host:port>; there is no gRPC transport.
+//
+//	client, err := remote.New([]string{"unix:/run/pubsubd.sock", remote.EndpointEmbedded})
+func New(endpoints []string, opts ...FailoverOption) (*Failover, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("failover client requires at least one endpoint")
+	}
+	for _, endpoint := range endpoints {
+		if _, err := endpointAddress(endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &Failover{
+		endpoints:     endpoints,
+		probeInterval: DefaultProbeInterval,
+		changed:       make(chan struct{}),
+		subscriptions: make(map[[2]string]func(topic, message string)),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	var errs []error
+	for i, endpoint := range endpoints {
+		broker, err := connect(endpoint)
+		if err == nil {
+			f.current, f.index = broker, i
+			break
+		}
+		errs = append(errs, err)
+	}
+	if f.current == nil {
+		return nil, fmt.Errorf("failed to connect to any endpoint: %w", errors.Join(errs...))
+	}
+
+	go f.watch()
+	return f, nil
+}
+
+// Endpoint returns the endpoint in use
+func (f *Failover) Endpoint() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.endpoints[f.index]
+}
+
+// Publish publishes on the current broker, retrying once on the next if the
+// connection is lost
+func (f *Failover) Publish(topic, message string) error {
+	return f.retry(func(b Broker) error {
+		return b.Publish(topic, message)
+	})
+}
+
+// Subscribe subscribes on the current broker, and on every broker the client
+// switches to
+func (f *Failover) Subscribe(subscriberID, topic string, callback func(topic, message string)) error {
+	// Recorded first, so a switch while subscribing makes it on the new broker
+	key := [2]string{subscriberID, topic}
+	f.mu.Lock()
+	f.subscriptions[key] = callback
+	f.mu.Unlock()
+
+	err := f.retry(func(b Broker) error {
+		return b.Subscribe(subscriberID, topic, callback)
+	})
+	if err != nil {
+		f.mu.Lock()
+		delete(f.subscriptions, key)
+		f.mu.Unlock()
+	}
+	return err
+}
+
+// Unsubscribe unsubscribes on the current broker, from every topic if topic
+// is empty
+func (f *Failover) Unsubscribe(subscriberID, topic string) error {
+	f.mu.Lock()
+	for key := range f.subscriptions {
+		if key[0] == subscriberID && (topic == "" || key[1] == topic) {
+			delete(f.subscriptions, key)
+		}
+	}
+	f.mu.Unlock()
+
+	return f.retry(func(b Broker) error {
+		return b.Unsubscribe(subscriberID, topic)
+	})
+}
+
+// GetMessage reads the next message queued on the current broker
+func (f *Failover) GetMessage(subscriberID, topic string) (msgTopic, message string, ok bool, err error) {
+	err = f.retry(func(b Broker) error {
+		msgTopic, message, ok, err = b.GetMessage(subscriberID, topic)
+		return err
+	})
+	return msgTopic, message, ok, err
+}
+
+// Close stops failing over and closes the current broker connection
+func (f *Failover) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		<-f.done
+		return nil
+	}
+	f.closed = true
+	f.mu.Unlock()
+
+	close(f.stop)
+	<-f.done
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.current.Close()
+}
+
+// retry runs op on the current broker, and once more on the broker the
+// client switches to if the connection was lost
+func (f *Failover) retry(op func(Broker) error) error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return ErrClosed
+	}
+	broker, changed := f.current, f.changed
+	f.mu.Unlock()
+
+	err := op(broker)
+	if !errors.Is(err, ErrClosed) {
+		return err
+	}
+
+	select {
+	case <-changed:
+	case <-f.stop:
+		return ErrClosed
+	}
+	f.mu.Lock()
+	broker = f.current
+	f.mu.Unlock()
+	return op(broker)
+}
+
+// watch fails over when the current connection is lost, and probes the
+// preferred endpoints
+func (f *Failover) watch() {
+	defer close(f.done)
+
+	probe := time.NewTicker(f.probeInterval)
+	defer probe.Stop()
+
+	for {
+		f.mu.Lock()
+		lost := brokerDone(f.current)
+		index := f.index
+		f.mu.Unlock()
+
+		select {
+		case <-f.stop:
+			return
+		case <-lost:
+			f.reconnect(len(f.endpoints))
+		case <-probe.C:
+			if index > 0 {
+				f.reconnect(index)
+			}
+		}
+	}
+}
+
+// reconnect switches to the first of the endpoints before limit that
+// answers. With limit covering every endpoint it keeps trying until one does.
+func (f *Failover) reconnect(limit int) {
+	delay := 100 * time.Millisecond
+	for {
+		for i := 0; i < limit; i++ {
+			broker, err := connect(f.endpoints[i])
+			if err != nil {
+				continue
+			}
+			if f.switchTo(broker, i) {
+				return
+			}
+		}
+		if limit < len(f.endpoints) {
+			return
+		}
+
+		select {
+		case <-f.stop:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// switchTo makes the client's subscriptions on broker and makes it current
+func (f *Failover) switchTo(broker Broker, index int) bool {
+	f.mu.Lock()
+	subscriptions := make(map[[2]string]func(topic, message string), len(f.subscriptions))
+	for key, callback := range f.subscriptions {
+		subscriptions[key] = callback
+	}
+	f.mu.Unlock()
+
+	for key, callback := range subscriptions {
+		if err := broker.Subscribe(key[0], key[1], callback); err != nil {
+			broker.Close()
+			return false
+		}
+	}
+
+	f.mu.Lock()
+	previous := f.current
+	f.current, f.index = broker, index
+	close(f.changed)
+	f.changed = make(chan struct{})
+	f.mu.Unlock()
+
+	previous.Close()
+	if f.onSwitch != nil {
+		f.onSwitch(f.endpoints[index])
+	}
+	return true
+}
+
+// brokerDone returns a channel closed when a broker's connection is lost, or
+// nil for brokers that can't lose one
+func brokerDone(b Broker) <-chan struct{} {
+	if client, ok := b.(*Client); ok {
+		return client.Done()
+	}
+	return nil
+}
+
+// endpointAddress splits an endpoint into its network and address
+func endpointAddress(endpoint string) ([2]string, error) {
+	switch {
+	case endpoint == EndpointEmbedded:
+		return [2]string{EndpointEmbedded, ""}, nil
+	case strings.HasPrefix(endpoint, unixPrefix):
+		return [2]string{"unix", strings.TrimPrefix(endpoint, unixPrefix)}, nil
+	case strings.HasPrefix(endpoint, tcpPrefix):
+		return [2]string{"tcp", strings.TrimPrefix(endpoint, tcpPrefix)}, nil
+	default:
+		return [2]string{}, fmt.Errorf("unsupported endpoint '%s'", endpoint)
+	}
+}
+
+func connect(endpoint string) (Broker, error) {
+	address, err := endpointAddress(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if address[0] == EndpointEmbedded {
+		return Embedded(), nil
+	}
+
+	client, err := Dial(address[0], address[1])
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Ping(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+// Embedded returns the broker in this process as a Broker, for code that
+// takes a Broker to run in-process. Its Close removes the subscriptions made
+// through it.
+func Embedded() Broker {
+	return &embedded{subscriptions: make(map[[2]string]struct{})}
+}
+
+// embedded is the broker in this process, as a Broker whose Close removes
+// the subscriptions made through it
+type embedded struct {
+	mu            sync.Mutex
+	subscriptions map[[2]string]struct{}
+}
+
+func (e *embedded) Publish(topic, message string) error {
+	return pubsub.Publish(topic, message)
+}
+
+func (e *embedded) Subscribe(subscriberID, topic string, callback func(topic, message string)) error {
+	if err := pubsub.Subscribe(subscriberID, topic, callback); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.subscriptions[[2]string{subscriberID, topic}] = struct{}{}
+	e.mu.Unlock()
+	return nil
+}
+
+func (e *embedded) Unsubscribe(subscriberID, topic string) error {
+	e.mu.Lock()
+	for key := range e.subscriptions {
+		if key[0] == subscriberID && (topic == "" || key[1] == topic) {
+			delete(e.subscriptions, key)
+		}
+	}
+	e.mu.Unlock()
+	return pubsub.Unsubscribe(subscriberID, topic)
+}
+
+func (e *embedded) GetMessage(subscriberID, topic string) (string, string, bool, error) {
+	if !pubsub.HasMessages(subscriberID, topic) {
+		return "", "", false, nil
+	}
+	msg, err := pubsub.GetMessage(subscriberID, topic)
+	if err != nil {
+		return "", "", false, err
+	}
+	return msg.Topic, msg.Content, true, nil
+}
+
+func (e *embedded) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key := range e.subscriptions {
+		pubsub.Unsubscribe(key[0], key[1])
+	}
+	e.subscriptions = nil
+	return nil
+}
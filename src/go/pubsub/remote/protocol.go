@@ -0,0 +1,92 @@
+// Package remote serves the broker to other processes over a stream socket,
+// usually a Unix domain socket, and provides the client for it. Frames are
+// lines of JSON in both directions: the client sends requests carrying an ID,
+// and the server answers each with a frame of the same ID, and pushes the
+// messages of the connection's callback subscriptions as they are published.
+//
+// Pushed messages are written before the answer to the publish that caused
+// them, so a client's Publish returns once its own callbacks for the message
+// have run, as with the embedded broker.
+//
+// A connection's subscriptions are removed when it ends, unless the client
+// started a session. The server then keeps them for its SessionTTL, holding
+// pushed messages and queueing the others, and a client reconnecting with the
+// session's token resumes them without subscribing again.
+package remote
+
+import (
+	"errors"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// Operations of a frame
+const (
+	opPublish     = "publish"
+	opSubscribe   = "subscribe"
+	opUnsubscribe = "unsubscribe"
+	opGet         = "get"
+	opPing        = "ping"
+	// opSession starts a resumable session, or resumes the one named
+	opSession = "session"
+	// opMessage is a message pushed by the server
+	opMessage = "message"
+	// opResult answers a request
+	opResult = "result"
+)
+
+// frame is one line of the protocol
+type frame struct {
+	ID           uint64            `json:"id,omitempty"`
+	Op           string            `json:"op"`
+	SubscriberID string            `json:"subscriber_id,omitempty"`
+	Topic        string            `json:"topic,omitempty"`
+	Message      string            `json:"message,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	// Session is the token of a resumable session
+	Session string `json:"session,omitempty"`
+	// Queued subscribes without a callback; messages are read with get
+	Queued bool `json:"queued,omitempty"`
+	// Found is set on the result of a get that returned a message
+	Found bool   `json:"found,omitempty"`
+	Error string `json:"error,omitempty"`
+	// Code identifies errors the client maps back to pubsub's sentinels
+	Code string `json:"code,omitempty"`
+}
+
+// errorCodes are the pubsub errors that keep their identity across the socket
+var errorCodes = map[string]error{
+	"no_topic":          pubsub.ErrNoTopic,
+	"duplicate_message": pubsub.ErrDuplicateMessage,
+	"message_too_large": pubsub.ErrMessageTooLarge,
+	"quota_exceeded":    pubsub.ErrQuotaExceeded,
+	"memory_limit":      pubsub.ErrMemoryLimit,
+	"session_expired":   ErrSessionExpired,
+}
+
+// ErrClosed is returned by a Client's calls once its connection is closed
+var ErrClosed = errors.New("remote broker connection closed")
+
+// codeFor returns the code of a pubsub error, if it has one
+func codeFor(err error) string {
+	for code, sentinel := range errorCodes {
+		if errors.Is(err, sentinel) {
+			return code
+		}
+	}
+	return ""
+}
+
+// remoteError is an error returned by the server
+type remoteError struct {
+	message  string
+	sentinel error
+}
+
+func (e *remoteError) Error() string {
+	return e.message
+}
+
+func (e *remoteError) Unwrap() error {
+	return e.sentinel
+}
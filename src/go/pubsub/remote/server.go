@@ -0,0 +1,257 @@
+package remote
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// DefaultSendBuffer is how many frames wait to be written to a connection
+// when the server leaves it unset
+const DefaultSendBuffer = 1024
+
+// maxFrameBytes bounds a request line
+const maxFrameBytes = 16 << 20
+
+// Server serves the broker to the connections of its listeners
+type Server struct {
+	// SendBuffer is how many frames wait to be written to each connection.
+	// Messages for a connection whose buffer is full are dropped, and counted
+	// as dropped by the broker.
+	SendBuffer int
+	// OnError, if set, is called with errors on connections, which are then closed
+	OnError func(error)
+	// SessionTTL is how long the subscriptions of a session started by a
+	// client are kept after its connection ends, for a new connection to
+	// resume them
+	SessionTTL time.Duration
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+	sessions  map[string]*session
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// Serve accepts connections on l until the server is closed
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+		s.conns = make(map[*serverConn]struct{})
+		s.sessions = make(map[string]*session)
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, l)
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		c := &serverConn{
+			server: s,
+			conn:   conn,
+			out:    make(chan frame, s.sendBuffer()),
+			done:   make(chan struct{}),
+		}
+		c.session = newSession(s, c)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go c.serve()
+	}
+}
+
+// Close stops the listeners, closes every connection and removes the
+// subscriptions of every connection and session
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	s.mu.Lock()
+	sessions := s.sessions
+	s.sessions = make(map[string]*session)
+	s.mu.Unlock()
+	for _, sess := range sessions {
+		sess.close()
+	}
+	return nil
+}
+
+func (s *Server) sendBuffer() int {
+	if s.SendBuffer <= 0 {
+		return DefaultSendBuffer
+	}
+	return s.SendBuffer
+}
+
+func (s *Server) report(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// serverConn is a client connection
+type serverConn struct {
+	server *Server
+	conn   net.Conn
+	out    chan frame
+	// session holds the connection's subscriptions. It is only replaced by
+	// the connection's own reading goroutine, when resuming a session.
+	session *session
+	done    chan struct{}
+}
+
+func (c *serverConn) serve() {
+	defer c.server.wg.Done()
+
+	writerDone := make(chan struct{})
+	go c.write(writerDone)
+
+	scanner := bufio.NewScanner(c.conn)
+	scanner.Buffer(make([]byte, 64*1024), maxFrameBytes)
+	for scanner.Scan() {
+		var request frame
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil {
+			c.server.report(err)
+			break
+		}
+		select {
+		case c.out <- c.handle(request):
+		case <-writerDone:
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		c.server.report(err)
+	}
+
+	// Callbacks run under the broker lock, so none can send to out once the
+	// session is detached
+	c.session.detach(c)
+
+	close(c.done)
+	<-writerDone
+	c.conn.Close()
+
+	c.server.mu.Lock()
+	delete(c.server.conns, c)
+	c.server.mu.Unlock()
+}
+
+// write sends frames until the connection is done
+func (c *serverConn) write(done chan<- struct{}) {
+	defer close(done)
+
+	writer := bufio.NewWriter(c.conn)
+	encoder := json.NewEncoder(writer)
+	for {
+		var f frame
+		select {
+		case f = <-c.out:
+		case <-c.done:
+			return
+		}
+		if err := encoder.Encode(f); err != nil {
+			c.conn.Close()
+			return
+		}
+		if len(c.out) == 0 {
+			if err := writer.Flush(); err != nil {
+				c.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// handle runs a request and returns its result
+func (c *serverConn) handle(request frame) frame {
+	result := frame{ID: request.ID, Op: opResult}
+	var err error
+
+	switch request.Op {
+	case opPublish:
+		var opts []pubsub.PublishOption
+		for name, value := range request.Headers {
+			opts = append(opts, pubsub.WithHeader(name, value))
+		}
+		err = pubsub.Publish(request.Topic, request.Message, opts...)
+
+	case opSubscribe:
+		var handler pubsub.HandlerFunc
+		if !request.Queued {
+			handler = c.session.push(request.SubscriberID)
+		}
+		err = pubsub.SubscribeHandler(request.SubscriberID, request.Topic, handler)
+		if err == nil {
+			c.session.add(request.SubscriberID, request.Topic)
+		}
+
+	case opUnsubscribe:
+		err = pubsub.Unsubscribe(request.SubscriberID, request.Topic)
+		if err == nil {
+			c.session.remove(request.SubscriberID, request.Topic)
+		}
+
+	case opGet:
+		if !pubsub.HasMessages(request.SubscriberID, request.Topic) {
+			break
+		}
+		var msg *pubsub.Message
+		if msg, err = pubsub.GetMessage(request.SubscriberID, request.Topic); err == nil {
+			result.Found, result.Topic, result.Message = true, msg.Topic, msg.Content
+		}
+
+	case opSession:
+		if request.Session == "" {
+			result.Session = c.server.startSession(c.session)
+		} else if err = c.server.resumeSession(c, request.Session); err == nil {
+			result.Session = request.Session
+		}
+
+	case opPing:
+
+	default:
+		err = errors.New("unknown operation '" + request.Op + "'")
+	}
+
+	if err != nil {
+		result.Error, result.Code = err.Error(), codeFor(err)
+	}
+	return result
+}
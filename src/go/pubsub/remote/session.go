@@ -0,0 +1,215 @@
+package remote
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// DefaultSessionTTL is how long a disconnected session is kept when the
+// server leaves SessionTTL unset
+const DefaultSessionTTL = time.Minute
+
+// ErrSessionExpired is returned when resuming a session the server no longer has
+var ErrSessionExpired = errors.New("session expired")
+
+// session holds the subscriptions made by a connection. A connection's
+// session is removed with it unless the client started it, in which case it
+// is kept for the server's SessionTTL so a new connection can resume it.
+//
+// Its push handlers run under the broker lock and take mu, so nothing holding
+// mu may call into the broker.
+type session struct {
+	server *Server
+
+	mu            sync.Mutex
+	token         string
+	conn          *serverConn
+	subscriptions map[[2]string]struct{}
+	// backlog holds messages pushed while no connection is attached
+	backlog []frame
+	timer   *time.Timer
+}
+
+func newSession(server *Server, conn *serverConn) *session {
+	return &session{
+		server:        server,
+		conn:          conn,
+		subscriptions: make(map[[2]string]struct{}),
+	}
+}
+
+func (s *session) add(subscriberID, topic string) {
+	s.mu.Lock()
+	s.subscriptions[[2]string{subscriberID, topic}] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *session) remove(subscriberID, topic string) {
+	s.mu.Lock()
+	for key := range s.subscriptions {
+		if key[0] == subscriberID && (topic == "" || key[1] == topic) {
+			delete(s.subscriptions, key)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// push returns a handler that sends a subscription's messages to the
+// session's connection, or holds them while it has none. It runs under the
+// broker lock, so it drops messages rather than wait for a slow connection.
+func (s *session) push(subscriberID string) pubsub.HandlerFunc {
+	return func(ctx context.Context, msg *pubsub.Message) error {
+		f := frame{Op: opMessage, SubscriberID: subscriberID, Topic: msg.Topic, Message: msg.Content, Headers: msg.Headers}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.conn == nil {
+			if len(s.backlog) >= s.server.sendBuffer() {
+				return errors.New("session backlog is full")
+			}
+			s.backlog = append(s.backlog, f)
+			return nil
+		}
+		select {
+		case s.conn.out <- f:
+			return nil
+		default:
+			return errors.New("connection send buffer is full")
+		}
+	}
+}
+
+// attach makes c the session's connection, closing any it had, and sends it
+// the messages held while the session was disconnected
+func (s *session) attach(c *serverConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.conn != nil && s.conn != c {
+		s.conn.conn.Close()
+	}
+	s.conn = c
+	for _, f := range s.backlog {
+		select {
+		case c.out <- f:
+		default:
+		}
+	}
+	s.backlog = nil
+}
+
+// detach is called when c ends. The session's subscriptions are removed,
+// unless it was started by the client and is kept for resuming.
+func (s *session) detach(c *serverConn) {
+	closed := s.server.isClosed()
+
+	s.mu.Lock()
+	if s.conn != c {
+		// Resumed by another connection
+		s.mu.Unlock()
+		return
+	}
+	s.conn = nil
+	if s.token != "" && !closed {
+		s.timer = time.AfterFunc(s.server.sessionTTL(), func() {
+			s.server.expireSession(s)
+		})
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	s.close()
+}
+
+// close removes the session's subscriptions
+func (s *session) close() {
+	s.mu.Lock()
+	subscriptions := s.subscriptions
+	s.subscriptions = make(map[[2]string]struct{})
+	s.backlog = nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.mu.Unlock()
+
+	for key := range subscriptions {
+		pubsub.Unsubscribe(key[0], key[1])
+	}
+}
+
+// startSession makes a connection's session resumable and returns its token
+func (s *Server) startSession(sess *session) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	if sess.token == "" {
+		id := make([]byte, 16)
+		rand.Read(id)
+		sess.token = hex.EncodeToString(id)
+		s.sessions[sess.token] = sess
+	}
+	return sess.token
+}
+
+// resumeSession attaches c to the session named by token, in place of the
+// one it was given when it connected
+func (s *Server) resumeSession(c *serverConn, token string) error {
+	c.session.mu.Lock()
+	started := c.session.token != "" || len(c.session.subscriptions) > 0
+	c.session.mu.Unlock()
+	if started {
+		return errors.New("connection already has subscriptions")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[token]
+	if !ok {
+		return ErrSessionExpired
+	}
+	sess.attach(c)
+	c.session = sess
+	return nil
+}
+
+// expireSession removes a session that was not resumed in time
+func (s *Server) expireSession(sess *session) {
+	s.mu.Lock()
+	sess.mu.Lock()
+	if sess.conn != nil || s.sessions[sess.token] != sess {
+		sess.mu.Unlock()
+		s.mu.Unlock()
+		return
+	}
+	delete(s.sessions, sess.token)
+	sess.mu.Unlock()
+	s.mu.Unlock()
+
+	sess.close()
+}
+
+func (s *Server) sessionTTL() time.Duration {
+	if s.SessionTTL <= 0 {
+		return DefaultSessionTTL
+	}
+	return s.SessionTTL
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
@@ -0,0 +1,478 @@
+// Package saga orchestrates multi-step workflows across topics. Each step
+// publishes a command to a participant's topic and waits for its reply; when
+// a step fails or doesn't reply in time, the completed steps are compensated
+// in reverse order.
+//
+//	s, err := saga.New("order").
+//		Step("reserve", "inventory.reserve").Compensate("inventory.release").Timeout(5 * time.Second).
+//		Step("charge", "payments.charge").Compensate("payments.refund").
+//		Step("ship", "shipping.create").
+//		Start()
+//	id, err := s.Execute(`{"order":42}`)
+//
+// Participants decode commands with ParseCommand and answer with Reply. A
+// step's command carries the previous step's output, or the saga's input for
+// the first step.
+package saga
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub"
+)
+
+// DefaultStepTimeout is how long a step waits for its reply when the builder
+// leaves it unset
+const DefaultStepTimeout = 30 * time.Second
+
+// Status is the state of a saga instance
+type Status string
+
+const (
+	// StatusRunning is an instance waiting on a step
+	StatusRunning Status = "running"
+	// StatusCompleted is an instance whose steps all succeeded
+	StatusCompleted Status = "completed"
+	// StatusCompensated is an instance that failed and had its completed
+	// steps compensated
+	StatusCompensated Status = "compensated"
+)
+
+// Command is what a step publishes to its topic
+type Command struct {
+	Saga    string `json:"saga"`
+	ID      string `json:"id"`
+	Step    string `json:"step"`
+	Data    string `json:"data,omitempty"`
+	ReplyTo string `json:"reply_to,omitempty"`
+	// Compensate is set on commands to a step's compensation topic, which
+	// expect no reply
+	Compensate bool `json:"compensate,omitempty"`
+}
+
+// reply is what participants publish to the reply topic
+type reply struct {
+	ID    string `json:"id"`
+	Step  string `json:"step"`
+	Data  string `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// ParseCommand decodes a command received on a step's topic
+func ParseCommand(message string) (Command, error) {
+	var cmd Command
+	if err := json.Unmarshal([]byte(message), &cmd); err != nil {
+		return Command{}, fmt.Errorf("failed to parse saga command: %w", err)
+	}
+	if cmd.ID == "" || cmd.Step == "" {
+		return Command{}, errors.New("failed to parse saga command: missing id or step")
+	}
+	return cmd, nil
+}
+
+// Reply answers a command with the step's output, or with stepErr if the
+// step failed. Like any publish, it must not be called from a subscription
+// callback; reply from another goroutine.
+func Reply(cmd Command, data string, stepErr error) error {
+	if cmd.ReplyTo == "" {
+		return errors.New("saga command expects no reply")
+	}
+	r := reply{ID: cmd.ID, Step: cmd.Step, Data: data}
+	if stepErr != nil {
+		r.Error = stepErr.Error()
+	}
+	encoded, _ := json.Marshal(r)
+	return pubsub.Publish(cmd.ReplyTo, string(encoded))
+}
+
+// step is a step of a saga definition
+type step struct {
+	name       string
+	topic      string
+	compensate string
+	timeout    time.Duration
+}
+
+// Builder defines a saga
+type Builder struct {
+	name   string
+	steps  []step
+	onDone func(State)
+	err    error
+}
+
+// New starts defining a saga. The name identifies it in commands and names
+// its reply topic.
+func New(name string) *Builder {
+	b := &Builder{name: name}
+	if name == "" {
+		b.err = errors.New("saga requires a name")
+	}
+	return b
+}
+
+// Step adds a step publishing its command to topic
+func (b *Builder) Step(name, topic string) *Builder {
+	if name == "" || topic == "" {
+		b.fail(errors.New("saga step requires a name and a topic"))
+	}
+	for _, s := range b.steps {
+		if s.name == name {
+			b.fail(fmt.Errorf("duplicate saga step '%s'", name))
+		}
+	}
+	b.steps = append(b.steps, step{name: name, topic: topic, timeout: DefaultStepTimeout})
+	return b
+}
+
+// Compensate sets the topic that undoes the last step added
+func (b *Builder) Compensate(topic string) *Builder {
+	if len(b.steps) == 0 {
+		b.fail(errors.New("saga compensation requires a step"))
+		return b
+	}
+	b.steps[len(b.steps)-1].compensate = topic
+	return b
+}
+
+// Timeout sets how long the last step added waits for its reply
+func (b *Builder) Timeout(timeout time.Duration) *Builder {
+	if len(b.steps) == 0 || timeout <= 0 {
+		b.fail(errors.New("saga timeout requires a step and a positive duration"))
+		return b
+	}
+	b.steps[len(b.steps)-1].timeout = timeout
+	return b
+}
+
+// OnDone sets a function called with the final state of each instance. It
+// runs outside subscription callbacks, so it may publish.
+func (b *Builder) OnDone(fn func(State)) *Builder {
+	b.onDone = fn
+	return b
+}
+
+func (b *Builder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Start subscribes the saga to its reply topic so it can execute instances
+func (b *Builder) Start() (*Saga, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.steps) == 0 {
+		return nil, errors.New("saga requires at least one step")
+	}
+
+	s := &Saga{
+		name:      b.name,
+		steps:     b.steps,
+		onDone:    b.onDone,
+		instances: make(map[string]*instance),
+		notify:    make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err := pubsub.Subscribe(s.subscriberID(), s.ReplyTopic(), s.receive); err != nil {
+		return nil, fmt.Errorf("failed to start saga '%s': %w", b.name, err)
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// State is a snapshot of a saga instance
+type State struct {
+	ID     string
+	Status Status
+	// Step is the step running, or the one that failed
+	Step string
+	// Completed lists the steps that succeeded, in order
+	Completed []string
+	// Output is the last step's output once the instance completes
+	Output  string
+	Error   string
+	Started time.Time
+	Updated time.Time
+}
+
+// instance is a running or finished execution
+type instance struct {
+	state State
+	// attempt invalidates the timers of earlier steps
+	attempt int
+	timer   *time.Timer
+}
+
+// event is a reply or timeout waiting for the saga's goroutine
+type event struct {
+	id      string
+	step    string
+	attempt int
+	reply   *reply
+}
+
+// Saga executes instances of a saga definition
+type Saga struct {
+	name   string
+	steps  []step
+	onDone func(State)
+
+	mu        sync.Mutex
+	instances map[string]*instance
+
+	// events has its own lock, since receive runs under the broker lock
+	// while s.mu is held across publishes
+	queueMu sync.Mutex
+	events  []event
+
+	notify chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	close  sync.Once
+}
+
+// ReplyTopic returns the topic participants reply on
+func (s *Saga) ReplyTopic() string {
+	return "saga/" + s.name + "/replies"
+}
+
+func (s *Saga) subscriberID() string {
+	return "saga/" + s.name
+}
+
+// Execute starts an instance with input for its first step, returning its ID
+func (s *Saga) Execute(input string) (string, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	inst := &instance{state: State{
+		ID:      hex.EncodeToString(id),
+		Status:  StatusRunning,
+		Started: time.Now(),
+	}}
+
+	s.mu.Lock()
+	s.instances[inst.state.ID] = inst
+	err := s.advance(inst, 0, input)
+	s.mu.Unlock()
+
+	if err != nil {
+		return "", err
+	}
+	return inst.state.ID, nil
+}
+
+// State returns an instance's state and whether the saga knows it
+func (s *Saga) State(id string) (State, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inst, ok := s.instances[id]
+	if !ok {
+		return State{}, false
+	}
+	return inst.snapshot(), true
+}
+
+// Instances returns the states of all instances the saga knows
+func (s *Saga) Instances() []State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make([]State, 0, len(s.instances))
+	for _, inst := range s.instances {
+		states = append(states, inst.snapshot())
+	}
+	return states
+}
+
+// Forget drops a finished instance's state
+func (s *Saga) Forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if inst, ok := s.instances[id]; ok && inst.state.Status != StatusRunning {
+		delete(s.instances, id)
+	}
+}
+
+// Close stops the saga. Running instances stay running, and replies sent
+// after Close are lost.
+func (s *Saga) Close() error {
+	s.close.Do(func() {
+		pubsub.Unsubscribe(s.subscriberID(), s.ReplyTopic())
+		close(s.stop)
+	})
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, inst := range s.instances {
+		if inst.timer != nil {
+			inst.timer.Stop()
+		}
+	}
+	return nil
+}
+
+func (inst *instance) snapshot() State {
+	state := inst.state
+	state.Completed = append([]string(nil), state.Completed...)
+	return state
+}
+
+// advance publishes the command of step index, or completes the instance
+// after the last step; s.mu must be held
+func (s *Saga) advance(inst *instance, index int, data string) error {
+	inst.state.Updated = time.Now()
+	if index == len(s.steps) {
+		inst.state.Status = StatusCompleted
+		inst.state.Step = ""
+		inst.state.Output = data
+		s.finish(inst)
+		return nil
+	}
+
+	st := s.steps[index]
+	inst.state.Step = st.name
+	inst.attempt++
+
+	cmd, _ := json.Marshal(Command{Saga: s.name, ID: inst.state.ID, Step: st.name, Data: data, ReplyTo: s.ReplyTopic()})
+	if err := pubsub.Publish(st.topic, string(cmd)); err != nil {
+		err = fmt.Errorf("failed to publish saga step '%s': %w", st.name, err)
+		s.compensate(inst, err.Error())
+		return err
+	}
+
+	attempt := inst.attempt
+	inst.timer = time.AfterFunc(st.timeout, func() {
+		s.queue(event{id: inst.state.ID, step: st.name, attempt: attempt})
+	})
+	return nil
+}
+
+// compensate publishes the compensations of the completed steps in reverse
+// order; s.mu must be held
+func (s *Saga) compensate(inst *instance, reason string) {
+	inst.state.Error = reason
+	for i := len(inst.state.Completed) - 1; i >= 0; i-- {
+		st := s.stepNamed(inst.state.Completed[i])
+		if st.compensate == "" {
+			continue
+		}
+		cmd, _ := json.Marshal(Command{Saga: s.name, ID: inst.state.ID, Step: st.name, Compensate: true})
+		if err := pubsub.Publish(st.compensate, string(cmd)); err != nil {
+			inst.state.Error += fmt.Sprintf("; failed to compensate step '%s': %v", st.name, err)
+		}
+	}
+	inst.state.Status = StatusCompensated
+	inst.state.Updated = time.Now()
+	s.finish(inst)
+}
+
+// finish stops an instance's timer and reports it to OnDone; s.mu must be held
+func (s *Saga) finish(inst *instance) {
+	if inst.timer != nil {
+		inst.timer.Stop()
+		inst.timer = nil
+	}
+	if s.onDone != nil {
+		state := inst.snapshot()
+		s.mu.Unlock()
+		s.onDone(state)
+		s.mu.Lock()
+	}
+}
+
+func (s *Saga) stepNamed(name string) step {
+	for _, st := range s.steps {
+		if st.name == name {
+			return st
+		}
+	}
+	return step{}
+}
+
+func (s *Saga) stepIndex(name string) int {
+	for i, st := range s.steps {
+		if st.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// receive queues a reply. It runs as a callback under the broker lock, so
+// the next step is published from the saga's goroutine.
+func (s *Saga) receive(topic, message string) {
+	var r reply
+	if err := json.Unmarshal([]byte(message), &r); err != nil || r.ID == "" {
+		return
+	}
+	s.queue(event{id: r.ID, step: r.Step, reply: &r})
+}
+
+func (s *Saga) queue(e event) {
+	s.queueMu.Lock()
+	s.events = append(s.events, e)
+	s.queueMu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Saga) run() {
+	defer close(s.done)
+
+	for {
+		select {
+		case <-s.notify:
+		case <-s.stop:
+			return
+		}
+
+		s.queueMu.Lock()
+		events := s.events
+		s.events = nil
+		s.queueMu.Unlock()
+
+		s.mu.Lock()
+		for _, e := range events {
+			s.handle(e)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// handle applies a reply or timeout to its instance; s.mu must be held
+func (s *Saga) handle(e event) {
+	inst, ok := s.instances[e.id]
+	if !ok || inst.state.Status != StatusRunning || inst.state.Step != e.step {
+		return
+	}
+
+	if e.reply == nil {
+		if e.attempt == inst.attempt {
+			s.compensate(inst, fmt.Sprintf("step '%s' timed out", e.step))
+		}
+		return
+	}
+	if e.reply.Error != "" {
+		s.compensate(inst, fmt.Sprintf("step '%s' failed: %s", e.step, e.reply.Error))
+		return
+	}
+
+	inst.state.Completed = append(inst.state.Completed, e.step)
+	s.advance(inst, s.stepIndex(e.step)+1, e.reply.Data)
+}
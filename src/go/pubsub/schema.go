@@ -0,0 +1,179 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"unsafe"
+)
+
+// SchemaType is the language a schema is written in
+type SchemaType int
+
+// SchemaJSON is JSON Schema. The core validates the type, enum, const,
+// properties, required, additionalProperties, items, minimum, maximum,
+// minLength, maxLength, minItems and maxItems keywords and ignores the rest.
+// Protobuf descriptors are not supported yet.
+const SchemaJSON SchemaType = C.SCHEMA_JSON
+
+// ErrSchemaValidation is matched by errors.Is for every SchemaValidationError
+var ErrSchemaValidation = errors.New("message does not match the topic's schema")
+
+// SchemaValidationError is returned when a message fails its topic's schema
+type SchemaValidationError struct {
+	Topic string
+	// Reason names the failing value by JSON pointer, e.g. "/items/0: expected string"
+	Reason string
+}
+
+func (e *SchemaValidationError) Error() string {
+	return fmt.Sprintf("message for topic '%s' does not match its schema: %s", e.Topic, e.Reason)
+}
+
+func (e *SchemaValidationError) Unwrap() error {
+	return ErrSchemaValidation
+}
+
+// SchemaBinding binds a topic to a registered schema
+type SchemaBinding struct {
+	Subject string
+	// Version is the schema version, or 0 for the latest at the time of binding
+	Version int
+	// RejectsTopic, if set, receives messages that fail validation wrapped in a
+	// QuarantinedMessage envelope. The publish still returns the error.
+	RejectsTopic string
+}
+
+// SchemaInfo describes the schema bound to a topic
+type SchemaInfo struct {
+	Subject      string
+	Version      int
+	Type         SchemaType
+	Definition   string
+	RejectsTopic string
+}
+
+// RegisterSchema adds a schema version under a subject and returns the version
+// number. Registering the latest definition again returns its version.
+func RegisterSchema(subject string, schemaType SchemaType, definition string) (version int, err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{
+			"schema_subject": subject,
+			"schema_version": strconv.Itoa(version),
+		}}, err)
+	}()
+
+	cSubject := C.CString(subject)
+	defer C.free(unsafe.Pointer(cSubject))
+
+	cDefinition := C.CString(definition)
+	defer C.free(unsafe.Pointer(cDefinition))
+
+	var cVersion C.uint32_t
+	var cError *C.char
+	if !C.register_schema(cSubject, C.uint32_t(schemaType), cDefinition, &cVersion, &cError) {
+		if cError != nil {
+			defer C.free_string(cError)
+			return 0, fmt.Errorf("failed to register schema '%s': %s", subject, C.GoString(cError))
+		}
+		return 0, fmt.Errorf("failed to register schema '%s'", subject)
+	}
+
+	return int(cVersion), nil
+}
+
+// BindSchema makes publishes to a topic validate against a schema
+func BindSchema(topic string, binding SchemaBinding) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Topic: topic, Details: map[string]string{
+			"schema_subject": binding.Subject,
+			"schema_version": strconv.Itoa(binding.Version),
+		}}, err)
+	}()
+
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+	if binding.Version < 0 {
+		return fmt.Errorf("failed to bind schema '%s': invalid version %d", binding.Subject, binding.Version)
+	}
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	cSubject := C.CString(binding.Subject)
+	defer C.free(unsafe.Pointer(cSubject))
+
+	var cRejects *C.char
+	if binding.RejectsTopic != "" {
+		if err := validateTopic(binding.RejectsTopic); err != nil {
+			return err
+		}
+		cRejects = C.CString(binding.RejectsTopic)
+		defer C.free(unsafe.Pointer(cRejects))
+	}
+
+	if !C.bind_schema(cTopic, cSubject, C.uint32_t(binding.Version), cRejects) {
+		return fmt.Errorf("failed to bind topic '%s' to unknown schema '%s' version %d", topic, binding.Subject, binding.Version)
+	}
+
+	return nil
+}
+
+// UnbindSchema stops validating publishes to a topic
+func UnbindSchema(topic string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Topic: topic, Details: map[string]string{"schema_subject": ""}}, err)
+	}()
+
+	topic = ResolveTopic(topic)
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	if !C.unbind_schema(cTopic) {
+		return fmt.Errorf("failed to unbind schema: topic '%s' has none", topic)
+	}
+
+	return nil
+}
+
+// TopicSchema returns the schema bound to a topic, or nil if it has none
+func TopicSchema(topic string) (*SchemaInfo, error) {
+	topic = ResolveTopic(topic)
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	cInfo := C.get_topic_schema(cTopic)
+	if cInfo == nil {
+		return nil, nil
+	}
+	defer C.free_string(cInfo)
+
+	var raw struct {
+		Subject      string  `json:"subject"`
+		Version      int     `json:"version"`
+		SchemaType   int     `json:"schema_type"`
+		Definition   string  `json:"definition"`
+		RejectsTopic *string `json:"rejects_topic"`
+	}
+	if err := json.Unmarshal([]byte(C.GoString(cInfo)), &raw); err != nil {
+		return nil, err
+	}
+
+	info := &SchemaInfo{
+		Subject:    raw.Subject,
+		Version:    raw.Version,
+		Type:       SchemaType(raw.SchemaType),
+		Definition: raw.Definition,
+	}
+	if raw.RejectsTopic != nil {
+		info.RejectsTopic = *raw.RejectsTopic
+	}
+	return info, nil
+}
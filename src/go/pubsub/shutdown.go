@@ -0,0 +1,97 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// inflightCounter counts handler invocations that have started and not yet
+// returned
+type inflightCounter struct {
+	mu    sync.Mutex
+	count int
+	// idle is closed when count drops to zero, and replaced when it rises again
+	idle chan struct{}
+}
+
+var inflight = &inflightCounter{idle: closedChannel()}
+
+func closedChannel() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}
+
+func (c *inflightCounter) add() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.count == 0 {
+		c.idle = make(chan struct{})
+	}
+	c.count++
+}
+
+func (c *inflightCounter) done() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.count--
+	if c.count == 0 {
+		close(c.idle)
+	}
+}
+
+// wait blocks until no handler is in flight or the context is done
+func (c *inflightCounter) wait(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		idle := c.idle
+		c.mu.Unlock()
+
+		select {
+		case <-idle:
+			c.mu.Lock()
+			count := c.count
+			c.mu.Unlock()
+			if count == 0 {
+				return nil
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Close unsubscribes every subscriber with a callback and blocks until the
+// handlers still running have returned. This includes deliveries on the relaxed
+// ordering worker pool and handlers that overran their timeout. Once the
+// subscribers are removed the core never calls their handlers again. If the
+// context ends first, Close returns its error while handlers are still running.
+// Close must not be called from a handler, which would wait for itself. Taps
+// keep running until closed with Tap.Close.
+func Close(ctx context.Context) error {
+	var subscriberIDs []string
+	for _, shard := range callbackRegistry {
+		shard.RLock()
+		for subscriberID, entry := range shard.callbacks {
+			if !entry.tap {
+				subscriberIDs = append(subscriberIDs, subscriberID)
+			}
+		}
+		shard.RUnlock()
+	}
+
+	var errs []error
+	for _, subscriberID := range subscriberIDs {
+		err := unsubscribe(subscriberID, "")
+		recordAudit(AuditEvent{Operation: AuditUnsubscribe, SubscriberID: subscriberID}, err)
+		errs = append(errs, err)
+	}
+
+	if err := inflight.wait(ctx); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
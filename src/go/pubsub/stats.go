@@ -0,0 +1,239 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// LatencySummary summarizes a latency histogram. Percentiles are the upper
+// bound of the histogram bucket they fall in, so they are accurate to within
+// a factor of two.
+type LatencySummary struct {
+	Count uint64
+	Sum   time.Duration
+	P50   time.Duration
+	P95   time.Duration
+	P99   time.Duration
+}
+
+// SubscriptionStats holds delivery metrics for a subscriber/topic pair
+type SubscriptionStats struct {
+	SubscriberID string
+	Topic        string
+	// Lag is the time from publish until the message reached the callback
+	// or was taken from the queue
+	Lag LatencySummary
+	// Handler is the time spent inside the callback
+	Handler LatencySummary
+}
+
+// BrokerStats is a snapshot of the broker's counters and delivery metrics
+type BrokerStats struct {
+	Published   uint64
+	Delivered   uint64
+	Dropped     uint64
+	Topics      int
+	Subscribers int
+	QueueDepth  int
+	// RetainedBytes is the size of the message payloads held in queues and
+	// leased buffers
+	RetainedBytes uint64
+	// Memory breaks down the message data held by the core library
+	Memory MemoryUsage
+	// SpilledBytes is the size of the message payloads spilled to disk
+	SpilledBytes uint64
+	// TopicsCollected counts the idle empty topics removed after SetTopicIdleTTL
+	TopicsCollected uint64
+	Subscriptions   []SubscriptionStats
+	Publishers      []PublisherStats
+	Quotas          []QuotaUsage
+	// CgoCalls is the time spent in each C function while SetCgoTiming is on
+	CgoCalls []CgoCallStats
+	// Allocator is what the core library has allocated, or nil unless
+	// SetAllocatorStats is on
+	Allocator *AllocatorStats
+	// Compaction totals the compaction of topic histories
+	Compaction CompactionStats
+}
+
+// CompactionStats totals the compaction of topic histories set up with
+// SetTopicCompaction
+type CompactionStats struct {
+	// Topics is the number of topics whose history is compacted
+	Topics int
+	// Runs is the number of compaction passes over them
+	Runs uint64
+	// Removed is the number of messages dropped from their histories, and
+	// RemovedBytes the size of their payloads
+	Removed      uint64
+	RemovedBytes uint64
+}
+
+// PublisherStats holds the totals of a registered publisher
+type PublisherStats struct {
+	PublisherID string
+	Labels      map[string]string
+	// Published is the number of messages published to existing topics
+	Published uint64
+	// Bytes is the total size of those messages
+	Bytes uint64
+}
+
+// JSON shapes produced by get_stats
+type latencySummaryJSON struct {
+	Count uint64 `json:"count"`
+	SumUs uint64 `json:"sum_us"`
+	P50Us uint64 `json:"p50_us"`
+	P95Us uint64 `json:"p95_us"`
+	P99Us uint64 `json:"p99_us"`
+}
+
+type brokerStatsJSON struct {
+	Published     uint64 `json:"published"`
+	Delivered     uint64 `json:"delivered"`
+	Dropped       uint64 `json:"dropped"`
+	Topics        int    `json:"topics"`
+	Subscribers   int    `json:"subscribers"`
+	QueueDepth    int    `json:"queue_depth"`
+	RetainedBytes uint64 `json:"retained_bytes"`
+	Memory        struct {
+		QueuedBytes    uint64 `json:"queued_bytes"`
+		PausedBytes    uint64 `json:"paused_bytes"`
+		ThrottledBytes uint64 `json:"throttled_bytes"`
+		LeasedBytes    uint64 `json:"leased_bytes"`
+		BatchedBytes   uint64 `json:"batched_bytes"`
+		StagedBytes    uint64 `json:"staged_bytes"`
+		TotalBytes     uint64 `json:"total_bytes"`
+		LimitBytes     uint64 `json:"limit_bytes"`
+		Policy         string `json:"policy"`
+	} `json:"memory"`
+	SpilledBytes    uint64 `json:"spilled_bytes"`
+	TopicsCollected uint64 `json:"topics_collected"`
+	Subscriptions   []struct {
+		SubscriberID string             `json:"subscriber_id"`
+		Topic        string             `json:"topic"`
+		Lag          latencySummaryJSON `json:"lag"`
+		Handler      latencySummaryJSON `json:"handler"`
+	} `json:"subscriptions"`
+	Publishers []struct {
+		PublisherID string            `json:"publisher_id"`
+		Labels      map[string]string `json:"labels"`
+		Published   uint64            `json:"published"`
+		Bytes       uint64            `json:"bytes"`
+	} `json:"publishers"`
+	Quotas []struct {
+		Scope string `json:"scope"`
+		Name  string `json:"name"`
+		Quota struct {
+			MessagesPerSec   float64 `json:"messages_per_sec"`
+			BytesPerDay      uint64  `json:"bytes_per_day"`
+			MaxRetainedBytes uint64  `json:"max_retained_bytes"`
+		} `json:"quota"`
+		BytesToday    uint64 `json:"bytes_today"`
+		RetainedBytes uint64 `json:"retained_bytes"`
+		Rejected      uint64 `json:"rejected"`
+	} `json:"quotas"`
+	Allocator *struct {
+		AllocatedBytes uint64 `json:"allocated_bytes"`
+		Allocations    uint64 `json:"allocations"`
+	} `json:"allocator"`
+	Compaction struct {
+		Topics       int    `json:"topics"`
+		Runs         uint64 `json:"runs"`
+		Removed      uint64 `json:"removed"`
+		RemovedBytes uint64 `json:"removed_bytes"`
+	} `json:"compaction"`
+}
+
+func (l latencySummaryJSON) summary() LatencySummary {
+	return LatencySummary{
+		Count: l.Count,
+		Sum:   time.Duration(l.SumUs) * time.Microsecond,
+		P50:   time.Duration(l.P50Us) * time.Microsecond,
+		P95:   time.Duration(l.P95Us) * time.Microsecond,
+		P99:   time.Duration(l.P99Us) * time.Microsecond,
+	}
+}
+
+// Stats returns a snapshot of the broker's counters and per-subscription
+// delivery lag and handler latency
+func Stats() (*BrokerStats, error) {
+	done := timeCgo("get_stats")
+	cStats := C.get_stats()
+	done()
+	if cStats == nil {
+		return nil, errors.New("failed to get stats")
+	}
+	defer C.free_string(cStats)
+
+	var raw brokerStatsJSON
+	if err := json.Unmarshal([]byte(C.GoString(cStats)), &raw); err != nil {
+		return nil, err
+	}
+
+	stats := &BrokerStats{
+		Published:     raw.Published,
+		Delivered:     raw.Delivered,
+		Dropped:       raw.Dropped,
+		Topics:        raw.Topics,
+		Subscribers:   raw.Subscribers,
+		QueueDepth:    raw.QueueDepth,
+		RetainedBytes: raw.RetainedBytes,
+		Memory: MemoryUsage{
+			QueuedBytes:    raw.Memory.QueuedBytes,
+			PausedBytes:    raw.Memory.PausedBytes,
+			ThrottledBytes: raw.Memory.ThrottledBytes,
+			LeasedBytes:    raw.Memory.LeasedBytes,
+			BatchedBytes:   raw.Memory.BatchedBytes,
+			StagedBytes:    raw.Memory.StagedBytes,
+			TotalBytes:     raw.Memory.TotalBytes,
+			LimitBytes:     raw.Memory.LimitBytes,
+			Policy:         parseMemoryPolicy(raw.Memory.Policy),
+		},
+		SpilledBytes:    raw.SpilledBytes,
+		TopicsCollected: raw.TopicsCollected,
+		Compaction:      CompactionStats(raw.Compaction),
+		CgoCalls:        cgoCallStats(),
+	}
+	for _, sub := range raw.Subscriptions {
+		stats.Subscriptions = append(stats.Subscriptions, SubscriptionStats{
+			SubscriberID: sub.SubscriberID,
+			Topic:        sub.Topic,
+			Lag:          sub.Lag.summary(),
+			Handler:      sub.Handler.summary(),
+		})
+	}
+	for _, pub := range raw.Publishers {
+		stats.Publishers = append(stats.Publishers, PublisherStats{
+			PublisherID: pub.PublisherID,
+			Labels:      pub.Labels,
+			Published:   pub.Published,
+			Bytes:       pub.Bytes,
+		})
+	}
+	for _, q := range raw.Quotas {
+		stats.Quotas = append(stats.Quotas, QuotaUsage{
+			Scope: QuotaScope(q.Scope),
+			Name:  q.Name,
+			Quota: Quota{
+				MessagesPerSecond: q.Quota.MessagesPerSec,
+				BytesPerDay:       q.Quota.BytesPerDay,
+				MaxRetainedBytes:  q.Quota.MaxRetainedBytes,
+			},
+			BytesToday:    q.BytesToday,
+			RetainedBytes: q.RetainedBytes,
+			Rejected:      q.Rejected,
+		})
+	}
+	if raw.Allocator != nil {
+		stats.Allocator = &AllocatorStats{
+			AllocatedBytes: raw.Allocator.AllocatedBytes,
+			Allocations:    raw.Allocator.Allocations,
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,201 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StorageEngine is where the broker keeps the queues of subscribers without a
+// callback, the ones read with PollMessages or Fetch. Subscribers with a
+// callback aren't stored, since the callback can't outlive the process.
+type StorageEngine int
+
+const (
+	// StorageMemory keeps nothing beyond the process. It is the default.
+	StorageMemory StorageEngine = C.STORAGE_MEMORY
+	// StorageWAL appends every change to a write-ahead log in a directory,
+	// replayed by the next process to open it
+	StorageWAL StorageEngine = C.STORAGE_WAL
+	// StorageSQLite keeps the queues as rows of a SQLite database in a
+	// directory, which opens without replaying a log. Only builds of the core
+	// with the sqlite feature have it, linked against the system's
+	// libsqlite3: make rust CARGO_FEATURES=sqlite.
+	StorageSQLite StorageEngine = C.STORAGE_SQLITE
+	// StorageRocksDB keeps the queues in a RocksDB database. No build of the
+	// core has it: the engine is descoped, see docs/rocksdb-storage.md.
+	StorageRocksDB StorageEngine = C.STORAGE_ROCKSDB
+)
+
+func (e StorageEngine) String() string {
+	switch e {
+	case StorageMemory:
+		return "memory"
+	case StorageWAL:
+		return "wal"
+	case StorageSQLite:
+		return "sqlite"
+	case StorageRocksDB:
+		return "rocksdb"
+	default:
+		return fmt.Sprintf("StorageEngine(%d)", int(e))
+	}
+}
+
+// feature returns the Feature a build of the core needs for the engine, or 0
+// if every build has it
+func (e StorageEngine) feature() Feature {
+	switch e {
+	case StorageWAL:
+		return FeatureStorageWAL
+	case StorageSQLite:
+		return FeatureStorageSQLite
+	case StorageRocksDB:
+		return FeatureStorageRocksDB
+	default:
+		return 0
+	}
+}
+
+// storageEngineByName returns the storage engine with a name, as in a Config,
+// defaulting to StorageMemory
+func storageEngineByName(name string) (StorageEngine, error) {
+	if name == "" {
+		return StorageMemory, nil
+	}
+	for _, engine := range []StorageEngine{StorageMemory, StorageWAL, StorageSQLite, StorageRocksDB} {
+		if engine.String() == name {
+			return engine, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown storage engine '%s'", name)
+}
+
+// StorageOptions configures a storage engine
+type StorageOptions struct {
+	// Path is the directory the engine keeps its files in, created if needed.
+	// StorageWAL and StorageSQLite require it.
+	Path string `json:"path"`
+	// Sync makes every change reach the disk before the call making it
+	// returns, rather than only the operating system, which survives the
+	// process crashing but not the machine
+	Sync bool `json:"sync"`
+}
+
+// OpenStorage switches the storage engine. Opening an engine recovers what
+// an earlier process stored with it: its subscribers without a callback are
+// subscribed again, with the messages they hadn't taken queued. A subscriber
+// that has registered a callback since keeps it, and its stored messages are
+// dropped. Messages fetched but not acknowledged when the earlier process
+// ended are lost, as are subscriptions in named consumer groups.
+//
+// An engine missing from the linked core fails with an error wrapping
+// errors.ErrUnsupported; check Features to choose one. Call OpenStorage
+// before subscribing, usually through Config.Storage, so that recovered
+// subscribers don't mix with new ones.
+func OpenStorage(engine StorageEngine, options StorageOptions) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"storage": engine.String(), "path": options.Path}}, err)
+	}()
+
+	if engine.feature() != 0 {
+		if err := Features().Require(engine.feature()); err != nil {
+			return fmt.Errorf("failed to open %s storage: %w", engine, err)
+		}
+	} else if engine != StorageMemory {
+		return fmt.Errorf("failed to open storage: unknown storage engine %d", int(engine))
+	}
+	if (engine == StorageWAL || engine == StorageSQLite) && options.Path == "" {
+		return fmt.Errorf("failed to open %s storage: a path is required", engine)
+	}
+
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return fmt.Errorf("failed to encode storage options: %w", err)
+	}
+	if !coreSetStorageEngine(uint32(engine), string(encoded)) {
+		return checkInternal(fmt.Errorf("failed to open %s storage at '%s'; the core logs why", engine, options.Path))
+	}
+	return nil
+}
+
+// RecoveryReport is what OpenStorage recovered when it last opened a storage
+// engine, so operators can see what a restart kept and what it lost
+type RecoveryReport struct {
+	Engine StorageEngine
+	Path   string
+	// OpenedAt is when the engine was opened, and Duration how long
+	// recovering took
+	OpenedAt time.Time
+	Duration time.Duration
+	// Records is the number of records read back, and Corrupted the number
+	// skipped as unreadable, such as one torn by a crash while being written
+	Records   int
+	Corrupted int
+	// Subscribers is the number of subscribers subscribed again, Topics the
+	// number of topics they were subscribed to and Messages the number of
+	// messages put back in their queues
+	Subscribers int
+	Topics      int
+	Messages    int
+	// SkippedSubscribers is the number of subscribers that had subscribed
+	// with a callback before the engine was opened, whose SkippedMessages
+	// were dropped
+	SkippedSubscribers int
+	SkippedMessages    int
+	// Error is why recovering failed, in which case nothing was recovered
+	// and OpenStorage returned an error
+	Error string
+}
+
+// recoveryReportJSON is the report as last_recovery_report encodes it
+type recoveryReportJSON struct {
+	Engine             int    `json:"engine"`
+	Path               string `json:"path"`
+	OpenedAtMs         int64  `json:"opened_at_ms"`
+	DurationUs         int64  `json:"duration_us"`
+	Changes            int    `json:"changes"`
+	Corrupted          int    `json:"corrupted"`
+	Subscribers        int    `json:"subscribers"`
+	Topics             int    `json:"topics"`
+	Messages           int    `json:"messages"`
+	SkippedSubscribers int    `json:"skipped_subscribers"`
+	SkippedMessages    int    `json:"skipped_messages"`
+	Error              string `json:"error"`
+}
+
+// LastRecoveryReport returns what OpenStorage recovered the last time it
+// opened an engine other than StorageMemory, or nil if it hasn't. The core
+// also logs the report through SetLogger when it is made, as a warning if
+// anything was skipped.
+func LastRecoveryReport() (*RecoveryReport, error) {
+	encoded, ok := coreLastRecoveryReport()
+	if !ok {
+		return nil, checkInternal(errors.New("failed to get the recovery report"))
+	}
+
+	var raw *recoveryReportJSON
+	if err := json.Unmarshal([]byte(encoded), &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode the recovery report: %w", err)
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	return &RecoveryReport{
+		Engine:             StorageEngine(raw.Engine),
+		Path:               raw.Path,
+		OpenedAt:           time.UnixMilli(raw.OpenedAtMs),
+		Duration:           time.Duration(raw.DurationUs) * time.Microsecond,
+		Records:            raw.Changes,
+		Corrupted:          raw.Corrupted,
+		Subscribers:        raw.Subscribers,
+		Topics:             raw.Topics,
+		Messages:           raw.Messages,
+		SkippedSubscribers: raw.SkippedSubscribers,
+		SkippedMessages:    raw.SkippedMessages,
+		Error:              raw.Error,
+	}, nil
+}
@@ -0,0 +1,67 @@
+package pubsub
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Opening the SQLite engine again gives back the queue as it was left, and
+// what was taken from it stays taken
+func TestSQLiteStorageRecovers(t *testing.T) {
+	if !Features().Has(FeatureStorageSQLite) {
+		t.Skip("the core was built without the sqlite feature")
+	}
+	const subscriberID, topic = "sqlite-test", "test/sqlite"
+	options := StorageOptions{Path: t.TempDir()}
+	defer OpenStorage(StorageMemory, StorageOptions{})
+
+	if err := OpenStorage(StorageSQLite, options); err != nil {
+		t.Fatal(err)
+	}
+	if err := Subscribe(subscriberID, topic, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer Unsubscribe(subscriberID, "")
+	for i := range 3 {
+		if err := Publish(topic, fmt.Sprint("message ", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := GetMessage(subscriberID, topic); err != nil {
+		t.Fatal(err)
+	}
+
+	// Leave the database, drop the subscriber as a restart would, and open
+	// the database again
+	if err := OpenStorage(StorageMemory, StorageOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Unsubscribe(subscriberID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := OpenStorage(StorageSQLite, options); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := LastRecoveryReport()
+	if err != nil || report == nil || report.Subscribers != 1 || report.Messages != 2 || report.Corrupted != 0 {
+		t.Fatalf("got %+v, %v, want the subscriber recovered with two messages", report, err)
+	}
+	for i := 1; i < 3; i++ {
+		msg, err := GetMessage(subscriberID, topic)
+		if want := fmt.Sprint("message ", i); err != nil || msg.Content != want {
+			t.Fatalf("got %+v, %v, want %q", msg, err, want)
+		}
+	}
+}
+
+func TestOpenStorageRequiresPath(t *testing.T) {
+	for _, engine := range []StorageEngine{StorageWAL, StorageSQLite} {
+		if !Features().Has(engine.feature()) {
+			continue
+		}
+		if err := OpenStorage(engine, StorageOptions{}); err == nil {
+			t.Errorf("opened %s storage without a path", engine)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// Subscription is a handle to a subscription made with SubscribeAuto
+type Subscription struct {
+	// ID is the generated subscriber ID
+	ID string
+	// Topic is the subscribed topic
+	Topic string
+}
+
+// SubscribeAuto subscribes to a topic under a newly generated subscriber ID,
+// returned on the handle, so independent components don't have to coordinate
+// unique IDs
+func SubscribeAuto(topic string, callback MessageCallback, opts ...SubscribeOption) (*Subscription, error) {
+	id, err := newSubscriberID()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Subscribe(id, topic, callback, opts...); err != nil {
+		return nil, err
+	}
+
+	return &Subscription{ID: id, Topic: topic}, nil
+}
+
+// Unsubscribe removes the subscription
+func (s *Subscription) Unsubscribe() error {
+	return Unsubscribe(s.ID, s.Topic)
+}
+
+// Pause stops delivery to the subscription, holding messages until Resume
+func (s *Subscription) Pause() error {
+	return Pause(s.ID, s.Topic)
+}
+
+// Resume delivers the held messages and restarts delivery to the subscription
+func (s *Subscription) Resume() error {
+	return Resume(s.ID, s.Topic)
+}
+
+// Touch records activity for the subscription's subscriber so it doesn't expire
+func (s *Subscription) Touch() error {
+	return Touch(s.ID)
+}
+
+// newSubscriberID returns a random version 4 UUID
+func newSubscriberID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate subscriber ID: %w", err)
+	}
+
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
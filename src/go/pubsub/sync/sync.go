@@ -0,0 +1,381 @@
+// Package sync provides a mutex and a counting semaphore for code sharing a
+// broker, built on a coordination topic instead of an external store such as
+// etcd. Processes share one through pubsubd, by connecting to it with the
+// remote package. Permits are leases: each instance renews the permits it
+// holds in the background, so a holder that stops without releasing loses
+// them once its lease runs out.
+//
+//	client, err := remote.Dial("unix", "/run/pubsubd.sock")
+//	m, err := sync.NewMutex("invoices", sync.Config{Broker: client})
+//	if err := m.Lock(ctx); err == nil {
+//		defer m.Unlock()
+//		// ...
+//	}
+//
+// Every instance applies the claims on the topic in publish order, so all of
+// them agree on who holds each permit. The topic keeps no history, so a new
+// instance spends half a lease learning the current holders before it
+// acquires. Meanwhile it judges acquires against the holders it has learned,
+// and takes a renewal as the word of a holder, since every holder renews
+// within that time. Waiting acquirers are not served in order.
+package sync
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	stdsync "sync"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/remote"
+)
+
+const (
+	// DefaultTopic is the coordination topic when Config leaves it unset
+	DefaultTopic = "_sync"
+	// DefaultLease is how long a permit lasts without renewal when Config
+	// leaves it unset
+	DefaultLease = 10 * time.Second
+)
+
+var (
+	// ErrClosed is returned by Acquire and Lock once the instance is closed
+	ErrClosed = errors.New("semaphore closed")
+	// ErrNotHeld is returned when releasing a permit the instance doesn't hold
+	ErrNotHeld = errors.New("semaphore permit not held")
+)
+
+// Config configures a Mutex or Semaphore
+type Config struct {
+	// Broker is the broker the instances share, such as a remote.Client or
+	// remote.Failover connected to pubsubd. Nil is the broker in this
+	// process. Closing the instance leaves Broker open.
+	Broker remote.Broker
+	// Topic is the coordination topic shared by all instances
+	Topic string
+	// Lease is how long a permit lasts without renewal. Holders renew their
+	// permits every third of a lease.
+	Lease time.Duration
+}
+
+// claim is a message on the coordination topic
+type claim struct {
+	Name   string `json:"name"`
+	Holder string `json:"holder"`
+	Kind   string `json:"kind"`
+	// Size is the acquirer's semaphore size, so every instance checks an
+	// acquire against the same limit
+	Size int `json:"size,omitempty"`
+}
+
+const (
+	claimAcquire = "acquire"
+	claimRenew   = "renew"
+	claimRelease = "release"
+)
+
+// permit is a holder's lease on a permit
+type permit struct {
+	expires time.Time
+	// claim is set on a permit recorded from an acquire seen while syncing,
+	// numbering it among those, and cleared by the holder's renewal
+	claim uint64
+}
+
+// Semaphore limits how many holders share a named resource
+type Semaphore struct {
+	name   string
+	size   int
+	config Config
+	id     string
+
+	mu      stdsync.Mutex
+	holders map[string]permit // by holder
+	held    []string          // this instance's holders, oldest first
+	changed chan struct{}     // closed and replaced when a permit frees up
+	// claims numbers the acquires recorded while syncing
+	claims uint64
+	// synced is when the instance has seen a renewal from every live holder
+	synced time.Time
+
+	stop  chan struct{}
+	done  chan struct{}
+	close stdsync.Once
+}
+
+// NewSemaphore joins the named semaphore, which size holders may hold at once
+func NewSemaphore(name string, size int, config Config) (*Semaphore, error) {
+	if name == "" {
+		return nil, errors.New("semaphore requires a name")
+	}
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid semaphore size %d", size)
+	}
+	if config.Topic == "" {
+		config.Topic = DefaultTopic
+	}
+	if config.Lease <= 0 {
+		config.Lease = DefaultLease
+	}
+	if config.Broker == nil {
+		config.Broker = remote.Embedded()
+	}
+
+	s := &Semaphore{
+		name:    name,
+		size:    size,
+		config:  config,
+		id:      randomID(),
+		holders: make(map[string]permit),
+		changed: make(chan struct{}),
+		synced:  time.Now().Add(config.Lease / 2),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if err := config.Broker.Subscribe(s.subscriberID(), config.Topic, s.apply); err != nil {
+		return nil, fmt.Errorf("failed to join semaphore '%s': %w", name, err)
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// Acquire blocks until the instance holds a permit, ctx is done, or the
+// instance is closed. An instance may hold several permits.
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	holder := randomID()
+
+	for {
+		s.mu.Lock()
+		_, granted := s.holders[holder]
+		if granted {
+			s.held = append(s.held, holder)
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		if granted {
+			return nil
+		}
+
+		wait := time.Until(s.synced)
+		if wait <= 0 {
+			if err := s.publish(holder, claimAcquire); err != nil {
+				return err
+			}
+			wait = s.config.Lease / 3
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			// The acquire may have been granted as ctx ended
+			s.publish(holder, claimRelease)
+			return ctx.Err()
+		case <-s.stop:
+			timer.Stop()
+			return ErrClosed
+		}
+		timer.Stop()
+	}
+}
+
+// Release gives back the oldest permit the instance holds
+func (s *Semaphore) Release() error {
+	s.mu.Lock()
+	if len(s.held) == 0 {
+		s.mu.Unlock()
+		return ErrNotHeld
+	}
+	holder := s.held[0]
+	s.held = s.held[1:]
+	s.mu.Unlock()
+
+	return s.publish(holder, claimRelease)
+}
+
+// Held returns how many permits the instance holds
+func (s *Semaphore) Held() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.held)
+}
+
+// Close releases the instance's permits and leaves the topic
+func (s *Semaphore) Close() error {
+	s.close.Do(func() {
+		s.mu.Lock()
+		held := s.held
+		s.held = nil
+		s.mu.Unlock()
+
+		for _, holder := range held {
+			s.publish(holder, claimRelease)
+		}
+		s.config.Broker.Unsubscribe(s.subscriberID(), s.config.Topic)
+		close(s.stop)
+	})
+	<-s.done
+	return nil
+}
+
+func (s *Semaphore) subscriberID() string {
+	return "sync/" + s.id
+}
+
+func (s *Semaphore) publish(holder, kind string) error {
+	data, _ := json.Marshal(claim{Name: s.name, Holder: holder, Kind: kind, Size: s.size})
+	if err := s.config.Broker.Publish(s.config.Topic, string(data)); err != nil {
+		return fmt.Errorf("failed to publish %s claim for semaphore '%s': %w", kind, s.name, err)
+	}
+	return nil
+}
+
+// apply updates the instance's view from a claim. It runs as a callback, under
+// the broker lock or on a remote client's reading goroutine, so it must not
+// publish.
+func (s *Semaphore) apply(topic, message string) {
+	var m claim
+	if err := json.Unmarshal([]byte(message), &m); err != nil || m.Name != s.name || m.Holder == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	syncing := now.Before(s.synced)
+	s.expire(now)
+	_, holding := s.holders[m.Holder]
+
+	switch m.Kind {
+	case claimAcquire:
+		// While syncing, the holders not learned yet may have refused the
+		// acquire elsewhere; their renewals undo it below
+		if !holding && len(s.holders) < m.Size {
+			next := permit{expires: now.Add(s.config.Lease)}
+			if syncing {
+				s.claims++
+				next.claim = s.claims
+			}
+			s.holders[m.Holder] = next
+		}
+	case claimRenew:
+		if holding {
+			s.holders[m.Holder] = permit{expires: now.Add(s.config.Lease)}
+		} else if syncing {
+			s.holders[m.Holder] = permit{expires: now.Add(s.config.Lease)}
+			s.dropClaims(m.Size)
+		}
+	case claimRelease:
+		if holding {
+			delete(s.holders, m.Holder)
+			s.signal()
+		}
+	}
+}
+
+// dropClaims drops the latest acquires recorded while syncing until no more
+// than size holders remain, since those are the ones the other instances
+// refused for want of room; s.mu must be held
+func (s *Semaphore) dropClaims(size int) {
+	for len(s.holders) > size {
+		latest, claim := "", uint64(0)
+		for holder, p := range s.holders {
+			if p.claim > claim {
+				latest, claim = holder, p.claim
+			}
+		}
+		if latest == "" {
+			return
+		}
+		delete(s.holders, latest)
+	}
+}
+
+// expire drops the holders whose leases have run out; s.mu must be held
+func (s *Semaphore) expire(now time.Time) {
+	expired := false
+	for holder, p := range s.holders {
+		if now.After(p.expires) {
+			delete(s.holders, holder)
+			expired = true
+		}
+	}
+	if expired {
+		s.signal()
+	}
+}
+
+// signal wakes waiting acquirers; s.mu must be held
+func (s *Semaphore) signal() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// run renews the instance's permits and expires those of other holders
+func (s *Semaphore) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.Lease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stop:
+			return
+		}
+
+		s.mu.Lock()
+		s.expire(time.Now())
+		held := append([]string(nil), s.held...)
+		s.mu.Unlock()
+
+		for _, holder := range held {
+			s.publish(holder, claimRenew)
+		}
+	}
+}
+
+// Mutex is a semaphore of size one
+type Mutex struct {
+	s *Semaphore
+}
+
+// NewMutex joins the named mutex
+func NewMutex(name string, config Config) (*Mutex, error) {
+	s, err := NewSemaphore(name, 1, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Mutex{s: s}, nil
+}
+
+// Lock blocks until the mutex is held, ctx is done, or the mutex is closed
+func (m *Mutex) Lock(ctx context.Context) error {
+	return m.s.Acquire(ctx)
+}
+
+// Unlock releases the mutex
+func (m *Mutex) Unlock() error {
+	return m.s.Release()
+}
+
+// Close unlocks the mutex if it is held and leaves the topic
+func (m *Mutex) Close() error {
+	return m.s.Close()
+}
+
+func randomID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,91 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jbrinkman/go-rust-ffi/go/pubsub/remote"
+)
+
+// A permit acquired near the end of a new instance's sync window is renewed
+// only after the window closes. The new instance must still count it rather
+// than take the permit as well.
+func TestAcquireDuringSync(t *testing.T) {
+	const lease = 600 * time.Millisecond
+	config := Config{Topic: "_sync_test_window", Lease: lease}
+
+	// The holder renews every 200ms from its start, at 200, 400, 600, 800...
+	holder, err := NewMutex("job", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Close()
+	time.Sleep(400 * time.Millisecond)
+
+	// The other instance syncs until 700ms
+	other, err := NewMutex("job", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	// The holder acquires at 650ms and first renews at 800ms
+	time.Sleep(250 * time.Millisecond)
+	if err := holder.Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lease)
+	defer cancel()
+	if err := other.Lock(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second lock returned %v, want it to wait for the holder", err)
+	}
+}
+
+// Instances in different processes share a mutex through pubsubd
+func TestMutexOverRemote(t *testing.T) {
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "pubsubd.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &remote.Server{}
+	go server.Serve(listener)
+	defer server.Close()
+
+	var mutexes []*Mutex
+	for i := 0; i < 2; i++ {
+		client, err := remote.Dial("unix", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		m, err := NewMutex("job", Config{Broker: client, Topic: "_sync_test_remote", Lease: 300 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+		mutexes = append(mutexes, m)
+	}
+
+	if err := mutexes[0].Lock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := mutexes[1].Lock(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second lock returned %v, want it to wait for the holder", err)
+	}
+
+	if err := mutexes[0].Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := mutexes[1].Lock(ctx); err != nil {
+		t.Fatalf("second lock after unlock: %v", err)
+	}
+}
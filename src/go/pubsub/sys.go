@@ -0,0 +1,64 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"time"
+)
+
+// SysPrefix is the prefix of the reserved topics carrying broker internals
+const SysPrefix = "$SYS/"
+
+// $SYS topics the broker publishes its stats to while EnableSysTopics is active.
+// Each message is the current value as a decimal string.
+const (
+	// SysMessagesPublished is the total number of messages published
+	SysMessagesPublished = "$SYS/broker/messages/published"
+	// SysMessagesDelivered is the total number of deliveries to callbacks or queues
+	SysMessagesDelivered = "$SYS/broker/messages/delivered"
+	// SysMessagesDropped is the total number of deliveries dropped by subscribers
+	SysMessagesDropped = "$SYS/broker/messages/dropped"
+	// SysMessagesRate is the publish rate in messages per second over the last interval
+	SysMessagesRate = "$SYS/broker/messages/rate"
+	// SysTopicsCount is the number of topics
+	SysTopicsCount = "$SYS/broker/topics/count"
+	// SysSubscribersCount is the number of subscribers
+	SysSubscribersCount = "$SYS/broker/subscribers/count"
+	// SysQueuesDepth is the total number of messages waiting in subscriber queues
+	SysQueuesDepth = "$SYS/broker/queues/depth"
+)
+
+// EnableSysTopics starts publishing broker stats to the $SYS topics every interval.
+// Calling it again restarts publishing with the new interval.
+func EnableSysTopics(interval time.Duration) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"sys_topics": interval.String()}}, err)
+	}()
+
+	if interval < time.Millisecond {
+		return errors.New("$SYS interval must be at least one millisecond")
+	}
+
+	success := C.start_sys_topics(C.uint64_t(interval.Milliseconds()))
+	if !success {
+		return errors.New("failed to start $SYS topics")
+	}
+
+	return nil
+}
+
+// DisableSysTopics stops publishing broker stats. It must not be called from a
+// message callback.
+func DisableSysTopics() (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"sys_topics": "disabled"}}, err)
+	}()
+
+	success := C.stop_sys_topics()
+	if !success {
+		return errors.New("failed to stop $SYS topics")
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// DefaultTapBuffer is the number of messages a tap buffers when TapConfig leaves it unset
+const DefaultTapBuffer = 1024
+
+// TapConfig configures a tap
+type TapConfig struct {
+	// SampleRate is the fraction of messages copied to the tap, in (0, 1].
+	// Zero copies every message.
+	SampleRate float64
+	// BufferSize is the number of messages buffered between the broker and the
+	// tap's callback. Messages arriving while the buffer is full are dropped.
+	BufferSize int
+}
+
+// Tap receives a copy of every message published on any topic. Messages are
+// handed to the callback on the tap's own goroutine through a bounded buffer,
+// so a slow tap drops copies instead of slowing down normal delivery.
+type Tap struct {
+	id       string
+	messages chan Message
+	dropped  atomic.Uint64
+	done     chan struct{}
+	close    sync.Once
+}
+
+// tapCount numbers the taps so each gets a unique ID
+var tapCount atomic.Uint64
+
+// StartTap starts a tap calling callback for every sampled message
+func StartTap(config TapConfig, callback MessageCallback) (*Tap, error) {
+	if callback == nil {
+		return nil, errors.New("tap requires a callback")
+	}
+	if config.SampleRate == 0 {
+		config.SampleRate = 1
+	}
+	if config.SampleRate < 0 || config.SampleRate > 1 {
+		return nil, fmt.Errorf("tap sample rate %v is outside (0, 1]", config.SampleRate)
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultTapBuffer
+	}
+
+	tap := &Tap{
+		id:       fmt.Sprintf("$tap/%d", tapCount.Add(1)),
+		messages: make(chan Message, config.BufferSize),
+		done:     make(chan struct{}),
+	}
+
+	// The gateway only enqueues; the callback runs on the tap's goroutine
+	entry := &callbackEntry{
+		subscriberID: tap.id,
+		userData:     C.CString(tap.id),
+		tap:          true,
+		handler: func(ctx context.Context, msg *Message) error {
+			select {
+			case tap.messages <- *msg:
+			default:
+				tap.dropped.Add(1)
+			}
+			return nil
+		},
+	}
+	shard := registryShardFor(tap.id)
+	shard.Lock()
+	shard.callbacks[tap.id] = entry
+	shard.Unlock()
+
+	go func() {
+		defer close(tap.done)
+		for msg := range tap.messages {
+			callback(msg.Topic, msg.Content)
+		}
+	}()
+
+	cTapID := C.CString(tap.id)
+	defer C.free(unsafe.Pointer(cTapID))
+
+	success := C.tap_subscribe(cTapID, C.double(config.SampleRate), gatewayCallback(), unsafe.Pointer(entry.userData))
+	if !success {
+		tap.Close()
+		err := errors.New("failed to start tap")
+		recordAudit(AuditEvent{Operation: AuditSubscribe, SubscriberID: tap.id, Details: map[string]string{"tap": "true"}}, err)
+		return nil, err
+	}
+
+	recordAudit(AuditEvent{Operation: AuditSubscribe, SubscriberID: tap.id, Details: map[string]string{"tap": "true"}}, nil)
+	return tap, nil
+}
+
+// Dropped returns the number of copies dropped because the tap's buffer was full
+func (t *Tap) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+// Close stops the tap and waits for buffered messages to be handed to the callback
+func (t *Tap) Close() error {
+	t.close.Do(func() {
+		cTapID := C.CString(t.id)
+		defer C.free(unsafe.Pointer(cTapID))
+
+		// Callbacks run under the broker lock, so none are in flight once
+		// tap_unsubscribe returns
+		C.tap_unsubscribe(cTapID)
+
+		shard := registryShardFor(t.id)
+		shard.Lock()
+		if entry, exists := shard.callbacks[t.id]; exists {
+			C.free(unsafe.Pointer(entry.userData))
+			delete(shard.callbacks, t.id)
+		}
+		shard.Unlock()
+
+		close(t.messages)
+		recordAudit(AuditEvent{Operation: AuditUnsubscribe, SubscriberID: t.id, Details: map[string]string{"tap": "true"}}, nil)
+	})
+
+	<-t.done
+	return nil
+}
@@ -0,0 +1,143 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// TopicConfig is the configuration a topic template gives the topics matching
+// its pattern when they are created. Zero fields leave the setting alone.
+type TopicConfig struct {
+	// Delivery is the delivery mode, as with CreateTopic, for topics created
+	// by subscribing
+	Delivery DeliveryMode
+	// History is the number of messages kept, as with SetTopicHistory, and
+	// CompactionKey the header the history is compacted by, as with
+	// SetTopicCompaction, which needs a History
+	History       int
+	CompactionKey string
+	// MaxSubscribers limits the subscribers of the topic, in place of
+	// Limits.MaxSubscribersPerTopic
+	MaxSubscribers int
+	// Schema binds the topic to a schema, as with BindSchema. A Version of 0
+	// is the latest version when the topic is created.
+	Schema *SchemaBinding
+}
+
+// topicTemplateJSON is the template as set_topic_template decodes it
+type topicTemplateJSON struct {
+	Delivery       uint32              `json:"delivery"`
+	History        int                 `json:"history"`
+	CompactionKey  string              `json:"compaction_key,omitempty"`
+	MaxSubscribers int                 `json:"max_subscribers"`
+	Schema         *templateSchemaJSON `json:"schema,omitempty"`
+}
+
+type templateSchemaJSON struct {
+	Subject      string `json:"subject"`
+	Version      int    `json:"version"`
+	RejectsTopic string `json:"rejects_topic,omitempty"`
+}
+
+// SetTopicTemplate configures the topics matching a pattern as they are
+// created, by subscribing or CreateTopic, so that families of per-entity
+// topics such as "orders/*/events" needn't be set up one by one. Patterns use
+// path.Match syntax, where '*' matches within one '/'-separated segment.
+// Setting a pattern again replaces its template. When several match a topic,
+// the one whose pattern has the most characters other than wildcards applies.
+//
+// Topics that exist already keep their settings, and settings a topic is
+// created with, such as the delivery mode given to CreateTopic, take
+// precedence. A schema subject that isn't registered when a topic is created
+// is skipped with a warning through SetLogger.
+func SetTopicTemplate(pattern string, config TopicConfig) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"topic_template": pattern}}, err)
+	}()
+
+	if err := validateTopic(pattern); err != nil {
+		return err
+	}
+	if config.History < 0 || config.MaxSubscribers < 0 {
+		return fmt.Errorf("failed to set template '%s': sizes must not be negative", pattern)
+	}
+	if config.CompactionKey != "" && config.History == 0 {
+		return fmt.Errorf("failed to set template '%s': compaction needs a history", pattern)
+	}
+	switch config.Delivery {
+	case DeliveryAny, DeliveryFanout, DeliveryQueue, DeliveryKeyed:
+	default:
+		return fmt.Errorf("failed to set template '%s': unknown delivery mode %d", pattern, int(config.Delivery))
+	}
+
+	template := topicTemplateJSON{
+		Delivery:       uint32(config.Delivery),
+		History:        config.History,
+		CompactionKey:  config.CompactionKey,
+		MaxSubscribers: config.MaxSubscribers,
+	}
+	if schema := config.Schema; schema != nil {
+		if schema.Subject == "" || schema.Version < 0 {
+			return fmt.Errorf("failed to set template '%s': invalid schema binding", pattern)
+		}
+		template.Schema = &templateSchemaJSON{schema.Subject, schema.Version, schema.RejectsTopic}
+	}
+	encoded, err := json.Marshal(template)
+	if err != nil {
+		return fmt.Errorf("failed to encode template '%s': %w", pattern, err)
+	}
+
+	if !coreSetTopicTemplate(pattern, string(encoded)) {
+		return checkInternal(fmt.Errorf("failed to set template '%s'", pattern))
+	}
+	return nil
+}
+
+// RemoveTopicTemplate removes the template of a pattern. Topics created with
+// it keep their settings.
+func RemoveTopicTemplate(pattern string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"topic_template": pattern, "removed": "true"}}, err)
+	}()
+
+	cPattern := C.CString(pattern)
+	defer C.free(unsafe.Pointer(cPattern))
+
+	if !C.set_topic_template(cPattern, nil) {
+		return checkInternal(errors.New("failed to remove topic template"))
+	}
+	return nil
+}
+
+// TopicTemplates returns the templates set with SetTopicTemplate by their
+// patterns
+func TopicTemplates() (map[string]TopicConfig, error) {
+	result, ok := coreTopicTemplates()
+	if !ok {
+		return nil, checkInternal(errors.New("failed to list topic templates"))
+	}
+
+	var raw map[string]topicTemplateJSON
+	if err := json.Unmarshal([]byte(result), &raw); err != nil {
+		return nil, err
+	}
+
+	templates := make(map[string]TopicConfig, len(raw))
+	for pattern, template := range raw {
+		config := TopicConfig{
+			Delivery:       DeliveryMode(template.Delivery),
+			History:        template.History,
+			CompactionKey:  template.CompactionKey,
+			MaxSubscribers: template.MaxSubscribers,
+		}
+		if schema := template.Schema; schema != nil {
+			config.Schema = &SchemaBinding{schema.Subject, schema.Version, schema.RejectsTopic}
+		}
+		templates[pattern] = config
+	}
+	return templates, nil
+}
@@ -0,0 +1,207 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// SysTopics carries topic lifecycle events as JSON objects
+const SysTopics = "$SYS/topics"
+
+// TopicEventType is the kind of topic lifecycle event
+type TopicEventType string
+
+const (
+	// TopicCreated is emitted when the first subscription creates a topic
+	TopicCreated TopicEventType = "created"
+	// TopicEmpty is emitted when the last subscriber leaves a topic
+	TopicEmpty TopicEventType = "empty"
+	// TopicDeleted is emitted when a topic is deleted
+	TopicDeleted TopicEventType = "deleted"
+	// TopicCollected is emitted when an idle empty topic is removed
+	TopicCollected TopicEventType = "collected"
+)
+
+// TopicEvent is a topic lifecycle event published on SysTopics
+type TopicEvent struct {
+	Type  TopicEventType `json:"event"`
+	Topic string         `json:"topic"`
+}
+
+// topicEventBuffer is how many events a watcher buffers before dropping
+const topicEventBuffer = 64
+
+// watcherCount numbers the internal subscribers created by WatchTopics
+var watcherCount atomic.Uint64
+
+// DeleteTopic removes a topic and all subscriptions to it
+func DeleteTopic(topic string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditTopicDelete, Topic: topic}, err)
+	}()
+
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	success := C.delete_topic(cTopic)
+	if !success {
+		return checkInternal(fmt.Errorf("failed to delete topic '%s'", topic))
+	}
+	forgetCString(topic)
+
+	return nil
+}
+
+// ListTopics returns the names of all topics, including reserved ones such as
+// SysTopics once they are subscribed to, sorted by name
+func ListTopics() ([]string, error) {
+	encoded, ok := coreListTopics()
+	if !ok {
+		return nil, checkInternal(errors.New("failed to list topics"))
+	}
+
+	var topics []string
+	if err := json.Unmarshal([]byte(encoded), &topics); err != nil {
+		return nil, err
+	}
+	return topics, nil
+}
+
+// SetTopicIdleTTL removes topics that have no subscribers, hold no queued
+// messages and see no publish or subscription change for longer than ttl, so
+// dynamically named topics don't accumulate. Each removal publishes a
+// TopicCollected event and counts in BrokerStats.TopicsCollected. A ttl of 0
+// keeps topics until they are deleted, which is the default.
+func SetTopicIdleTTL(ttl time.Duration) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"topic_idle_ttl": ttl.String()}}, err)
+	}()
+
+	if ttl < 0 {
+		return errors.New("failed to set topic idle TTL: TTL must not be negative")
+	}
+
+	success := C.set_topic_idle_ttl(C.uint64_t(ttl.Milliseconds()))
+	if !success {
+		return checkInternal(errors.New("failed to set topic idle TTL"))
+	}
+
+	return nil
+}
+
+// SetTopicHistory keeps the last size messages published to a topic in
+// memory, for subscriptions made WithBackfill. Kept messages don't count
+// against memory limits or quotas, and keep an idle topic from being
+// collected. A size of 0 stops keeping messages and drops those kept.
+func SetTopicHistory(topic string, size int) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Topic: topic, Details: map[string]string{"topic_history": fmt.Sprint(size)}}, err)
+	}()
+
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+	if size < 0 {
+		return errors.New("failed to set topic history: size must not be negative")
+	}
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	success := C.set_topic_history(cTopic, C.size_t(size))
+	if !success {
+		return checkInternal(fmt.Errorf("failed to set history of topic '%s'", topic))
+	}
+
+	return nil
+}
+
+// SetTopicCompaction compacts the history of a topic by the key in a header:
+// a background task drops every message that a later one with the same
+// keyHeader value supersedes, so backfills replay the latest message of each
+// key, and drops every message of a key whose latest message is empty, which
+// deletes the key. Messages without the header are kept. An empty keyHeader
+// stops compacting.
+//
+// The topic needs a history from SetTopicHistory, whose size still drops the
+// oldest messages between compactions, so it should leave room for every key
+// and what is published between compactions. Compaction is removed along with
+// the history.
+func SetTopicCompaction(topic, keyHeader string) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Topic: topic, Details: map[string]string{"compaction_key": keyHeader}}, err)
+	}()
+
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+
+	if !coreSetTopicCompaction(topic, keyHeader) {
+		return checkInternal(fmt.Errorf("failed to set compaction of topic '%s': it keeps no history", topic))
+	}
+	return nil
+}
+
+// SetCompactionInterval changes how often compacted topic histories are
+// compacted, every 30 seconds by default. In deterministic mode they are
+// compacted by AdvanceClock once the interval has passed.
+func SetCompactionInterval(interval time.Duration) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"compaction_interval": interval.String()}}, err)
+	}()
+
+	if interval < time.Millisecond {
+		return fmt.Errorf("failed to set compaction interval: %v is under a millisecond", interval)
+	}
+	if !coreSetCompactionInterval(uint64(interval.Milliseconds())) {
+		return checkInternal(errors.New("failed to set compaction interval"))
+	}
+	return nil
+}
+
+// WatchTopics streams topic lifecycle events until the context is cancelled,
+// after which the channel is closed. Events are delivered while the broker is
+// locked, so a watcher that falls more than a small buffer behind loses events
+// rather than blocking the broker.
+func WatchTopics(ctx context.Context) (<-chan TopicEvent, error) {
+	subscriberID := fmt.Sprintf("$watch/topics/%d", watcherCount.Add(1))
+	events := make(chan TopicEvent, topicEventBuffer)
+
+	err := subscribe(subscriberID, SysTopics, FromCallback(func(topic, message string) {
+		var event TopicEvent
+		if err := json.Unmarshal([]byte(message), &event); err != nil {
+			return
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	}), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		<-ctx.Done()
+		// Callbacks run under the broker lock, so none are in flight once
+		// Unsubscribe returns and the channel can be closed
+		unsubscribe(subscriberID, "")
+		close(events)
+	}()
+
+	return events, nil
+}
@@ -0,0 +1,125 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SysTrace carries the lifecycle events of messages published with an ID, as
+// JSON objects shaped like a Receipt, while message tracing is on
+const SysTrace = "$SYS/trace"
+
+// Message lifecycle operations recorded in the audit stream while message
+// tracing is on, each with the message's ID in Details["message_id"]
+const (
+	AuditMessagePublish      = "message.publish"
+	AuditMessageEnqueued     = "message.enqueued"
+	AuditMessageHeld         = "message.held"
+	AuditMessageParked       = "message.parked"
+	AuditMessageDelivered    = "message.delivered"
+	AuditMessageDropped      = "message.dropped"
+	AuditMessageFetched      = "message.fetched"
+	AuditMessageNacked       = "message.nacked"
+	AuditMessageRedelivered  = "message.redelivered"
+	AuditMessageDiscarded    = "message.discarded"
+	AuditMessageExpired      = "message.expired"
+	AuditMessageDeadLettered = "message.dead_lettered"
+)
+
+// traceSubscriberID is the internal subscriber turning SysTrace events into
+// audit events
+const traceSubscriberID = "$trace"
+
+// messageTracing is whether message tracing is on
+var messageTracing atomic.Bool
+
+// tracingLifecycle serializes turning message tracing on and off
+var tracingLifecycle sync.Mutex
+
+// SetMessageTracing records what becomes of every message published with
+// WithMessageID in the audit stream set with SetAuditSink: its publish, and
+// for each subscriber whether it was enqueued, held while paused, parked for
+// an away group member, delivered, dropped, fetched, nacked, redelivered
+// after its ack timeout, discarded, expired unread or dead lettered. The
+// events are also published on SysTrace. pubsub-cli trace rebuilds a
+// message's path from an audit log of them. Tracing costs a publish per event
+// and is off by default.
+func SetMessageTracing(enabled bool) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Details: map[string]string{"message_tracing": strconv.FormatBool(enabled)}}, err)
+	}()
+
+	tracingLifecycle.Lock()
+	defer tracingLifecycle.Unlock()
+
+	if enabled == messageTracing.Load() {
+		return nil
+	}
+	if enabled {
+		if err := subscribe(traceSubscriberID, SysTrace, FromCallback(recordTrace), nil); err != nil {
+			return err
+		}
+	}
+	if !coreSetMessageTracing(enabled) {
+		return checkInternal(errors.New("failed to set message tracing"))
+	}
+	messageTracing.Store(enabled)
+	if !enabled {
+		return unsubscribe(traceSubscriberID, "")
+	}
+	return nil
+}
+
+// recordTrace turns an event from SysTrace into an audit event
+func recordTrace(topic, message string) {
+	var event Receipt
+	if err := json.Unmarshal([]byte(message), &event); err != nil {
+		return
+	}
+	recordAudit(AuditEvent{
+		Operation:    "message." + string(event.Type),
+		SubscriberID: event.SubscriberID,
+		Topic:        event.Topic,
+		Details:      map[string]string{"message_id": event.MessageID},
+	}, nil)
+}
+
+// tracePublish records the publish of a message with an ID while message
+// tracing is on. It is stamped with when the publish started, so it sorts
+// before the events of the deliveries it made.
+func tracePublish(topic string, options publishOptions, started time.Time, cReport *C.DeliveryReport, err error) {
+	if options.messageID == "" || !messageTracing.Load() {
+		return
+	}
+
+	details := map[string]string{"message_id": options.messageID}
+	if err == nil {
+		details["subscribers"] = strconv.Itoa(int(cReport.subscribers))
+		details["delivered"] = strconv.Itoa(int(cReport.delivered))
+		details["dropped"] = strconv.Itoa(int(cReport.dropped))
+		if cReport.duplicate {
+			details["duplicate"] = "true"
+		}
+	}
+	recordAudit(AuditEvent{Time: started, Operation: AuditMessagePublish, Topic: topic, Details: details}, err)
+}
+
+// traceDeadLetter records a message with an ID moved to QuarantineTopic while
+// message tracing is on
+func traceDeadLetter(subscriberID string, msg *Message) {
+	if msg.ID == "" || !messageTracing.Load() {
+		return
+	}
+	recordAudit(AuditEvent{
+		Operation:    AuditMessageDeadLettered,
+		SubscriberID: subscriberID,
+		Topic:        msg.Topic,
+		Details:      map[string]string{"message_id": msg.ID},
+	}, nil)
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// TransformID identifies a transform attached to a topic
+type TransformID uint64
+
+// ErrTransformRejected is matched by errors.Is for every TransformRejectedError
+var ErrTransformRejected = errors.New("message rejected by the topic's transforms")
+
+// TransformRejectedError is returned when one of a topic's transforms rejects
+// a message or fails while running, e.g. by running out of fuel
+type TransformRejectedError struct {
+	Topic string
+	// Reason names the transform and, if it gave one, its reason, e.g.
+	// "rejected by transform 3: missing tenant"
+	Reason string
+}
+
+func (e *TransformRejectedError) Error() string {
+	return fmt.Sprintf("message for topic '%s' was rejected: %s", e.Topic, e.Reason)
+}
+
+func (e *TransformRejectedError) Unwrap() error {
+	return ErrTransformRejected
+}
+
+// AttachTransform compiles a WebAssembly module and appends it to the
+// topic's transforms, which every message published to the topic passes
+// through in the order they were attached. See docs/wasm-transforms.md for
+// what the module must export.
+func AttachTransform(topic string, module []byte) (id TransformID, err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Topic: topic, Details: map[string]string{
+			"transform_attached": strconv.FormatUint(uint64(id), 10),
+		}}, err)
+	}()
+
+	if err := validateTopic(topic); err != nil {
+		return 0, err
+	}
+	// The core runs no transforms on reserved topics, the control topic included
+	if strings.HasPrefix(topic, ReservedPrefix) {
+		return 0, fmt.Errorf("failed to attach transform to topic '%s': %w", topic, ErrReservedTopic)
+	}
+	topic = ResolveTopic(topic)
+	if len(module) == 0 {
+		return 0, fmt.Errorf("failed to attach transform to topic '%s': empty module", topic)
+	}
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	var cID C.uint64_t
+	var cError *C.char
+	data := (*C.uint8_t)(unsafe.Pointer(&module[0]))
+	if !C.attach_transform(cTopic, data, C.size_t(len(module)), &cID, &cError) {
+		if cError != nil {
+			defer C.free_string(cError)
+			return 0, fmt.Errorf("failed to attach transform to topic '%s': %s", topic, C.GoString(cError))
+		}
+		return 0, checkInternal(fmt.Errorf("failed to attach transform to topic '%s'", topic))
+	}
+
+	return TransformID(cID), nil
+}
+
+// DetachTransform removes a transform from a topic
+func DetachTransform(topic string, id TransformID) (err error) {
+	defer func() {
+		recordAudit(AuditEvent{Operation: AuditConfig, Topic: topic, Details: map[string]string{
+			"transform_detached": strconv.FormatUint(uint64(id), 10),
+		}}, err)
+	}()
+
+	topic = ResolveTopic(topic)
+	if !coreDetachTransform(topic, uint64(id)) {
+		return fmt.Errorf("failed to detach transform %d: topic '%s' has no such transform", id, topic)
+	}
+
+	return nil
+}
+
+// TopicTransforms returns the IDs of a topic's transforms in the order they run
+func TopicTransforms(topic string) ([]TransformID, error) {
+	result, ok := coreTopicTransforms(ResolveTopic(topic))
+	if !ok {
+		return nil, checkInternal(fmt.Errorf("failed to list transforms of topic '%s'", topic))
+	}
+
+	var ids []TransformID
+	if err := json.Unmarshal([]byte(result), &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,148 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// transformModule assembles a transform module around the body of its
+// transform function, given with its locals. alloc bumps a pointer from 1024
+// and reason returns the "no thanks" data segment at 0.
+func transformModule(transform ...byte) []byte {
+	section := func(id byte, contents ...byte) []byte {
+		return append([]byte{id, byte(len(contents))}, contents...)
+	}
+	alloc := []byte{0x00, 0x23, 0x00, 0x23, 0x00, 0x20, 0x00, 0x6a, 0x24, 0x00, 0x0b}
+	reason := []byte{0x00, 0x42, 0x09, 0x0b}
+	transform = append(transform, 0x0b)
+
+	code := []byte{0x03}
+	for _, body := range [][]byte{alloc, transform, reason} {
+		code = append(code, byte(len(body)))
+		code = append(code, body...)
+	}
+
+	module := []byte{0x00, 'a', 's', 'm', 0x01, 0x00, 0x00, 0x00}
+	module = append(module, section(1, 0x03,
+		0x60, 0x01, 0x7f, 0x01, 0x7f,
+		0x60, 0x04, 0x7f, 0x7f, 0x7f, 0x7f, 0x01, 0x7e,
+		0x60, 0x00, 0x01, 0x7e)...)
+	module = append(module, section(3, 0x03, 0x00, 0x01, 0x02)...)
+	module = append(module, section(5, 0x01, 0x00, 0x01)...)
+	module = append(module, section(6, 0x01, 0x7f, 0x01, 0x41, 0x80, 0x08, 0x0b)...)
+	exports := []byte{0x04}
+	for i, name := range []string{"memory", "alloc", "transform", "reason"} {
+		kind, index := byte(0x00), byte(i-1)
+		if name == "memory" {
+			kind, index = 0x02, 0x00
+		}
+		exports = append(exports, byte(len(name)))
+		exports = append(exports, name...)
+		exports = append(exports, kind, index)
+	}
+	module = append(module, section(7, exports...)...)
+	module = append(module, section(10, code...)...)
+	data := append([]byte{0x01, 0x00, 0x41, 0x00, 0x0b, 0x09}, "no thanks"...)
+	return append(module, section(11, data...)...)
+}
+
+// Upper-cases ASCII letters of the message in place
+var upperModule = transformModule(
+	0x01, 0x02, 0x7f, // locals i, b
+	0x02, 0x40, 0x03, 0x40, // block loop
+	0x20, 0x04, 0x20, 0x03, 0x4f, 0x0d, 0x01, // br_if 1 (i >= len)
+	0x20, 0x02, 0x20, 0x04, 0x6a, 0x2d, 0x00, 0x00, 0x21, 0x05, // b = load8_u(ptr+i)
+	0x20, 0x05, 0x41, 0xe1, 0x00, 0x6b, 0x41, 0x1a, 0x49, 0x04, 0x40, // if b-'a' < 26
+	0x20, 0x02, 0x20, 0x04, 0x6a, 0x20, 0x05, 0x41, 0x20, 0x6b, 0x3a, 0x00, 0x00, // store8(ptr+i, b-32)
+	0x0b,
+	0x20, 0x04, 0x41, 0x01, 0x6a, 0x21, 0x04, 0x0c, 0x00, // i++; br 0
+	0x0b, 0x0b,
+	0x41, 0x80, 0x08, 0x24, 0x00, // reset the allocator
+	0x20, 0x02, 0xad, 0x42, 0x20, 0x86, 0x20, 0x03, 0xad, 0x84, // ptr<<32 | len
+)
+
+func TestTransforms(t *testing.T) {
+	const topic = "test/transforms"
+	received := make(chan string, 10)
+	if err := Subscribe("transform-test", topic, func(topic, message string) {
+		received <- message
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer Unsubscribe("transform-test", "")
+
+	upper, err := AttachTransform(topic, upperModule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PublishSync(topic, "hello, world"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != "HELLO, WORLD" {
+		t.Fatalf("got %q, want the message upper-cased", got)
+	}
+
+	// Transforms run in the order they were attached, so the message is
+	// dropped after being upper-cased
+	drop, err := AttachTransform(topic, transformModule(0x00, 0x42, 0x7f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := PublishSync(topic, "dropped")
+	if err != nil || !report.Filtered || report.Delivered != 0 {
+		t.Fatalf("got %+v, %v, want the message filtered", report, err)
+	}
+	if err := DetachTransform(topic, drop); err != nil {
+		t.Fatal(err)
+	}
+
+	reject, err := AttachTransform(topic, transformModule(0x00, 0x42, 0x7e))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rejected *TransformRejectedError
+	err = Publish(topic, "rejected")
+	if !errors.As(err, &rejected) || !strings.HasSuffix(rejected.Reason, "no thanks") {
+		t.Fatalf("got %v, want the transform's rejection", err)
+	}
+
+	ids, err := TopicTransforms(topic)
+	if err != nil || len(ids) != 2 || ids[0] != upper || ids[1] != reject {
+		t.Fatalf("got %v, %v, want [%d %d]", ids, err, upper, reject)
+	}
+	if err := DetachTransform(topic, drop); err == nil {
+		t.Fatal("detaching a detached transform succeeded")
+	}
+	DetachTransform(topic, upper)
+	DetachTransform(topic, reject)
+}
+
+// A transform that never returns runs out of fuel and fails the publish
+func TestTransformOutOfFuel(t *testing.T) {
+	const topic = "test/transform-fuel"
+	if err := Subscribe("transform-fuel-test", topic, func(topic, message string) {}); err != nil {
+		t.Fatal(err)
+	}
+	defer Unsubscribe("transform-fuel-test", "")
+
+	id, err := AttachTransform(topic, transformModule(0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x42, 0x00))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DetachTransform(topic, id)
+
+	err = Publish(topic, "message")
+	if !errors.Is(err, ErrTransformRejected) || !strings.Contains(err.Error(), "fuel") {
+		t.Fatalf("got %v, want the transform to run out of fuel", err)
+	}
+}
+
+func TestAttachTransformInvalidModule(t *testing.T) {
+	if _, err := AttachTransform("test/transform-invalid", []byte("not wasm")); err == nil {
+		t.Fatal("attached a module that isn't WebAssembly")
+	}
+	if _, err := AttachTransform("$SYS/broker", upperModule); !errors.Is(err, ErrReservedTopic) {
+		t.Fatalf("got %v, want ErrReservedTopic", err)
+	}
+}
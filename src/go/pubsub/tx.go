@@ -0,0 +1,111 @@
+package pubsub
+
+// #include "pubsub_core.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// ErrTxDone is returned when using a transaction that was already committed or rolled back
+var ErrTxDone = errors.New("transaction has already been committed or rolled back")
+
+// Tx stages messages across topics so they become visible to subscribers
+// atomically on Commit, or not at all
+type Tx struct {
+	mu   sync.Mutex
+	id   C.uint64_t
+	done bool
+	// staged are the messages to record on Commit while a recording is in progress
+	staged []RecordedPublish
+}
+
+// Begin starts a new publish transaction
+func Begin() *Tx {
+	return &Tx{id: C.tx_begin()}
+}
+
+// Publish stages a message in the transaction. Nothing is delivered until Commit.
+func (tx *Tx) Publish(topic, message string, opts ...PublishOption) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+	if err := validatePublishTopic(topic); err != nil {
+		return err
+	}
+	topic = ResolveTopic(topic)
+	if err := checkMessage(message); err != nil {
+		return err
+	}
+
+	var options publishOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	cTopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(cTopic))
+
+	cMessage := C.CString(message)
+	defer C.free(unsafe.Pointer(cMessage))
+
+	cOptions := options.toC()
+	defer freePublishOptions(&cOptions)
+
+	success := C.tx_publish(tx.id, cTopic, cMessage, &cOptions)
+	if !success {
+		return checkInternal(fmt.Errorf("failed to stage message for topic '%s'", topic))
+	}
+	if recorder, record := recording(topic, options); recorder != nil {
+		record.Payload = message
+		tx.staged = append(tx.staged, record)
+	}
+
+	return nil
+}
+
+// Commit delivers all staged messages. If any staged topic doesn't exist,
+// nothing is delivered and an error is returned.
+func (tx *Tx) Commit() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	success := C.tx_commit(tx.id)
+	if !success {
+		return checkInternal(errors.New("failed to commit transaction"))
+	}
+	if recorder := activeRecorder.Load(); recorder != nil {
+		committed := clockNow()
+		for _, record := range tx.staged {
+			record.Time = committed
+			recorder.write(record)
+		}
+	}
+	drainControl()
+
+	return nil
+}
+
+// Rollback discards all staged messages
+func (tx *Tx) Rollback() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+
+	C.tx_rollback(tx.id)
+	return nil
+}
@@ -0,0 +1,99 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxSubscriberIDSize is the maximum length of a subscriber ID in bytes
+const MaxSubscriberIDSize = 256
+
+// ReservedPrefix marks topics published by the broker itself, such as $SYS and
+// $quarantine, and subscriber IDs used internally by this package
+const ReservedPrefix = "$"
+
+var (
+	// ErrEmptyTopic is returned when a topic is empty
+	ErrEmptyTopic = errors.New("topic is empty")
+	// ErrEmptySubscriberID is returned when a subscriber ID is empty
+	ErrEmptySubscriberID = errors.New("subscriber ID is empty")
+	// ErrSubscriberIDTooLong is returned when a subscriber ID exceeds MaxSubscriberIDSize
+	ErrSubscriberIDTooLong = errors.New("subscriber ID exceeds the maximum size")
+	// ErrEmbeddedNUL is returned when a topic or subscriber ID contains a NUL
+	// byte, which would silently truncate it at the C boundary
+	ErrEmbeddedNUL = errors.New("contains a NUL byte")
+	// ErrControlCharacter is returned when a topic or subscriber ID contains a
+	// control character
+	ErrControlCharacter = errors.New("contains a control character")
+	// ErrInvalidUTF8 is returned when a topic, subscriber ID or message isn't
+	// valid UTF-8, which the core would replace with U+FFFD
+	ErrInvalidUTF8 = errors.New("is not valid UTF-8")
+	// ErrReservedTopic is returned when publishing to a topic with the reserved
+	// prefix other than ControlTopic
+	ErrReservedTopic = errors.New("topic uses the reserved '$' prefix")
+	// ErrReservedSubscriberID is returned when a subscriber ID has the reserved prefix
+	ErrReservedSubscriberID = errors.New("subscriber ID uses the reserved '$' prefix")
+)
+
+// validateTopic checks a topic's length and characters. Topics with the
+// reserved prefix are valid here so they can be subscribed to.
+func validateTopic(topic string) error {
+	if topic == "" {
+		return ErrEmptyTopic
+	}
+	if len(topic) > GetLimits().MaxTopicSize {
+		return fmt.Errorf("invalid topic %q: %w", topic, ErrTopicTooLong)
+	}
+	if err := checkCharacters(topic); err != nil {
+		return fmt.Errorf("invalid topic %q: %w", topic, err)
+	}
+	return nil
+}
+
+// validatePublishTopic checks a topic that a caller publishes to, which must
+// not have the reserved prefix
+func validatePublishTopic(topic string) error {
+	if err := validateTopic(topic); err != nil {
+		return err
+	}
+	if strings.HasPrefix(topic, ReservedPrefix) && topic != ControlTopic {
+		return fmt.Errorf("invalid topic %q: %w", topic, ErrReservedTopic)
+	}
+	return nil
+}
+
+// validateSubscriberID checks a caller-supplied subscriber ID
+func validateSubscriberID(subscriberID string) error {
+	if subscriberID == "" {
+		return ErrEmptySubscriberID
+	}
+	if len(subscriberID) > MaxSubscriberIDSize {
+		return fmt.Errorf("invalid subscriber ID %q: %w", subscriberID, ErrSubscriberIDTooLong)
+	}
+	if err := checkCharacters(subscriberID); err != nil {
+		return fmt.Errorf("invalid subscriber ID %q: %w", subscriberID, err)
+	}
+	if strings.HasPrefix(subscriberID, ReservedPrefix) {
+		return fmt.Errorf("invalid subscriber ID %q: %w", subscriberID, ErrReservedSubscriberID)
+	}
+	return nil
+}
+
+// checkCharacters rejects NUL bytes, other control characters and invalid UTF-8
+func checkCharacters(name string) error {
+	for _, r := range name {
+		switch {
+		case r == utf8.RuneError:
+			if !utf8.ValidString(name) {
+				return ErrInvalidUTF8
+			}
+		case r == 0:
+			return ErrEmbeddedNUL
+		case r < 0x20 || r == 0x7f:
+			return ErrControlCharacter
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// SysWatermarks is the topic receiving a WatermarkEvent, as JSON, whenever a
+// queue bounded WithQueueCapacity crosses one of the marks set WithWatermarks
+const SysWatermarks = "$SYS/watermarks"
+
+// Kinds of WatermarkEvent
+const (
+	// WatermarkHigh is raised when a queue fills to its high mark
+	WatermarkHigh = "high"
+	// WatermarkLow is raised when a queue that reached its high mark drains
+	// back to its low mark
+	WatermarkLow = "low"
+)
+
+// WatermarkEvent reports a subscriber's queue crossing one of its watermarks
+type WatermarkEvent struct {
+	// Event is WatermarkHigh or WatermarkLow
+	Event        string `json:"event"`
+	SubscriberID string `json:"subscriber_id"`
+	// Depth is the number of messages in the queue after crossing the mark,
+	// and Capacity the number it is bounded to
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+}
+
+// WithWatermarks raises a WatermarkEvent when the subscriber's queue fills to
+// high and again when it drains back to low, both fractions of the capacity
+// set WithQueueCapacity, with 0 <= low < high <= 1. Events are published on
+// SysWatermarks and passed to the handler set with OnWatermark, so that
+// publishers can shed load before the queue is full and messages are
+// dropped. It has no effect with a callback or without WithQueueCapacity.
+func WithWatermarks(high, low float64) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.watermarkHigh = high
+		o.watermarkLow = low
+	}
+}
+
+var watermarks struct {
+	start sync.Once
+	sync.Mutex
+	handler func(WatermarkEvent)
+	pending []WatermarkEvent
+	wake    chan struct{}
+}
+
+// OnWatermark calls handler with every WatermarkEvent from now on, in place of
+// any handler set before, or stops calling one if handler is nil. Events are
+// handled in order on a goroutine of their own, so the handler may call the
+// broker, for instance to pause a publisher.
+func OnWatermark(handler func(WatermarkEvent)) error {
+	var err error
+	if handler != nil {
+		watermarks.start.Do(func() {
+			watermarks.wake = make(chan struct{}, 1)
+			go runWatermarks()
+			err = subscribe("$watermarks", SysWatermarks, FromCallback(queueWatermark), nil)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to watch watermarks: %w", err)
+	}
+
+	watermarks.Lock()
+	watermarks.handler = handler
+	watermarks.Unlock()
+	return nil
+}
+
+// queueWatermark queues an event from SysWatermarks for the handler. It runs
+// under the broker lock, so the handler is called from runWatermarks, or
+// inline in deterministic mode.
+func queueWatermark(topic, message string) {
+	var event WatermarkEvent
+	if err := json.Unmarshal([]byte(message), &event); err != nil {
+		return
+	}
+
+	watermarks.Lock()
+	handler := watermarks.handler
+	if handler != nil && !deterministic.Load() {
+		watermarks.pending = append(watermarks.pending, event)
+	}
+	watermarks.Unlock()
+
+	if handler == nil {
+		return
+	}
+	if deterministic.Load() {
+		handler(event)
+		return
+	}
+	select {
+	case watermarks.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runWatermarks calls the handler with queued events in order
+func runWatermarks() {
+	for range watermarks.wake {
+		watermarks.Lock()
+		events := watermarks.pending
+		watermarks.pending = nil
+		handler := watermarks.handler
+		watermarks.Unlock()
+
+		if handler == nil {
+			continue
+		}
+		for _, event := range events {
+			handler(event)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+use crate::clock;
+use crate::QueuedMessage;
+use serde::Serialize;
+use std::collections::{BTreeMap, HashMap};
+use std::time::{Duration, Instant};
+
+// How long a fetched message may go unacknowledged before it is redelivered,
+// unless set_ack_timeout changes it
+pub const DEFAULT_ACK_TIMEOUT: Duration = Duration::from_secs(30);
+
+// A message fetched for acknowledgement
+pub struct InFlight {
+    pub subscriber_id: String,
+    pub message: QueuedMessage,
+    // When the message was fetched, and when it is redelivered unless acked
+    pub fetched_at: Instant,
+    pub deadline: Instant,
+}
+
+// An in-flight message as reported by list_in_flight
+#[derive(Serialize)]
+pub struct InFlightInfo {
+    pub delivery_tag: u64,
+    pub subscriber_id: String,
+    pub topic: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub message_id: Option<String>,
+    pub attempt: u32,
+    // Milliseconds since the message was fetched, and until it is redelivered
+    pub age_ms: u64,
+    pub redeliver_in_ms: u64,
+}
+
+// Messages fetched with fetch_messages and not yet acknowledged, by delivery tag
+pub struct AckTracker {
+    entries: BTreeMap<u64, InFlight>,
+    next_tag: u64,
+    pub timeout: Duration,
+    // Timeouts set for single subscriptions, by subscriber ID and topic
+    visibility: HashMap<(String, String), Duration>,
+}
+
+impl AckTracker {
+    pub fn new() -> Self {
+        AckTracker {
+            entries: BTreeMap::new(),
+            next_tag: 0,
+            timeout: DEFAULT_ACK_TIMEOUT,
+            visibility: HashMap::new(),
+        }
+    }
+
+    // Set the timeout of one subscription, or go back to the broker's with None
+    pub fn set_visibility(&mut self, subscriber_id: &str, topic: &str, timeout: Option<Duration>) {
+        let key = (subscriber_id.to_string(), topic.to_string());
+        match timeout {
+            Some(timeout) => self.visibility.insert(key, timeout),
+            None => self.visibility.remove(&key),
+        };
+    }
+
+    // Hold a fetched message until it is acked, returning its delivery tag
+    pub fn lease(&mut self, subscriber_id: &str, message: QueuedMessage) -> u64 {
+        self.next_tag += 1;
+        let now = clock::now();
+        let timeout = self
+            .visibility
+            .get(&(subscriber_id.to_string(), message.topic.clone()))
+            .copied()
+            .unwrap_or(self.timeout);
+        self.entries.insert(
+            self.next_tag,
+            InFlight {
+                subscriber_id: subscriber_id.to_string(),
+                message,
+                fetched_at: now,
+                deadline: now + timeout,
+            },
+        );
+        self.next_tag
+    }
+
+    // Remove a subscriber's in-flight message by tag
+    pub fn take(&mut self, subscriber_id: &str, tag: u64) -> Option<InFlight> {
+        match self.entries.get(&tag) {
+            Some(entry) if entry.subscriber_id == subscriber_id => self.entries.remove(&tag),
+            _ => None,
+        }
+    }
+
+    // Make a subscriber's in-flight message due for redelivery timeout from now
+    pub fn extend(&mut self, subscriber_id: &str, tag: u64, timeout: Duration) -> bool {
+        match self.entries.get_mut(&tag) {
+            Some(entry) if entry.subscriber_id == subscriber_id => {
+                entry.deadline = clock::now() + timeout;
+                true
+            }
+            _ => false,
+        }
+    }
+
+    // Remove a subscriber's messages whose deadline has passed, oldest
+    // delivery first
+    pub fn take_expired(&mut self, subscriber_id: &str) -> Vec<QueuedMessage> {
+        let now = clock::now();
+        let expired: Vec<u64> = self
+            .entries
+            .iter()
+            .filter(|(_, e)| e.subscriber_id == subscriber_id && e.deadline <= now)
+            .map(|(tag, _)| *tag)
+            .collect();
+        expired
+            .into_iter()
+            .filter_map(|tag| self.entries.remove(&tag))
+            .map(|e| e.message)
+            .collect()
+    }
+
+    // Number of a subscriber's messages due for redelivery, from the given
+    // topic if one is specified
+    pub fn expired_count(&self, subscriber_id: &str, topic: Option<&str>) -> usize {
+        let now = clock::now();
+        self.entries
+            .values()
+            .filter(|e| e.subscriber_id == subscriber_id && e.deadline <= now)
+            .filter(|e| topic.map_or(true, |t| e.message.topic == t))
+            .count()
+    }
+
+    // The in-flight messages, of one subscriber and topic if given, oldest
+    // delivery first
+    pub fn list(&self, subscriber_id: Option<&str>, topic: Option<&str>) -> Vec<InFlightInfo> {
+        let now = clock::now();
+        self.entries
+            .iter()
+            .filter(|(_, e)| subscriber_id.map_or(true, |id| e.subscriber_id == id))
+            .filter(|(_, e)| topic.map_or(true, |t| e.message.topic == t))
+            .map(|(tag, e)| InFlightInfo {
+                delivery_tag: *tag,
+                subscriber_id: e.subscriber_id.clone(),
+                topic: e.message.topic.clone(),
+                message_id: e.message.tracking.as_ref().map(|t| t.message_id.clone()),
+                attempt: e.message.attempts,
+                age_ms: now.saturating_duration_since(e.fetched_at).as_millis() as u64,
+                redeliver_in_ms: e.deadline.saturating_duration_since(now).as_millis() as u64,
+            })
+            .collect()
+    }
+
+    pub fn remove_subscriber(&mut self, subscriber_id: &str) {
+        self.entries.retain(|_, e| e.subscriber_id != subscriber_id);
+        self.visibility.retain(|(id, _), _| id != subscriber_id);
+    }
+
+    pub fn rename_topic(&mut self, from: &str, to: &str) {
+        let moved: Vec<(String, String)> = self
+            .visibility
+            .keys()
+            .filter(|(_, topic)| topic == from)
+            .cloned()
+            .collect();
+        for key in moved {
+            let timeout = self.visibility.remove(&key).unwrap();
+            self.visibility
+                .entry((key.0, to.to_string()))
+                .or_insert(timeout);
+        }
+        for entry in self.entries.values_mut() {
+            if entry.message.topic == from {
+                entry.message.topic = to.to_string();
+            }
+        }
+    }
+
+    pub fn subscriber_messages<'a>(
+        &'a self,
+        subscriber_id: &'a str,
+    ) -> impl Iterator<Item = &'a QueuedMessage> + 'a {
+        self.entries
+            .values()
+            .filter(move |e| e.subscriber_id == subscriber_id)
+            .map(|e| &e.message)
+    }
+
+    pub fn messages(&self) -> impl Iterator<Item = &QueuedMessage> {
+        self.entries.values().map(|e| &e.message)
+    }
+}
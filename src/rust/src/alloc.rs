@@ -0,0 +1,72 @@
+use serde::Serialize;
+use std::alloc::{GlobalAlloc, Layout, System};
+use std::sync::atomic::{AtomicBool, AtomicI64, AtomicU64, Ordering};
+
+// Whether allocations are being counted. Counting is off by default, since
+// it costs two atomic operations per allocation.
+static COUNTING: AtomicBool = AtomicBool::new(false);
+
+// Bytes allocated and not yet freed since counting started. Memory allocated
+// before then and freed after takes it below zero, so it is reported clamped.
+static ALLOCATED_BYTES: AtomicI64 = AtomicI64::new(0);
+
+// Allocations made since counting started
+static ALLOCATIONS: AtomicU64 = AtomicU64::new(0);
+
+// The system allocator, counting what the core allocates when enabled
+pub struct CountingAllocator;
+
+unsafe impl GlobalAlloc for CountingAllocator {
+    unsafe fn alloc(&self, layout: Layout) -> *mut u8 {
+        let ptr = System.alloc(layout);
+        if !ptr.is_null() && COUNTING.load(Ordering::Relaxed) {
+            ALLOCATED_BYTES.fetch_add(layout.size() as i64, Ordering::Relaxed);
+            ALLOCATIONS.fetch_add(1, Ordering::Relaxed);
+        }
+        ptr
+    }
+
+    unsafe fn dealloc(&self, ptr: *mut u8, layout: Layout) {
+        System.dealloc(ptr, layout);
+        if COUNTING.load(Ordering::Relaxed) {
+            ALLOCATED_BYTES.fetch_sub(layout.size() as i64, Ordering::Relaxed);
+        }
+    }
+
+    unsafe fn realloc(&self, ptr: *mut u8, layout: Layout, new_size: usize) -> *mut u8 {
+        let new_ptr = System.realloc(ptr, layout, new_size);
+        if !new_ptr.is_null() && COUNTING.load(Ordering::Relaxed) {
+            let grown = new_size as i64 - layout.size() as i64;
+            ALLOCATED_BYTES.fetch_add(grown, Ordering::Relaxed);
+            ALLOCATIONS.fetch_add(1, Ordering::Relaxed);
+        }
+        new_ptr
+    }
+}
+
+// What the core's allocator has handed out since counting started
+#[derive(Serialize)]
+pub struct AllocatorStats {
+    pub allocated_bytes: u64,
+    pub allocations: u64,
+}
+
+// Start counting from zero, or stop counting
+pub fn set_counting(enabled: bool) {
+    if enabled && !COUNTING.load(Ordering::Acquire) {
+        ALLOCATED_BYTES.store(0, Ordering::Relaxed);
+        ALLOCATIONS.store(0, Ordering::Relaxed);
+    }
+    COUNTING.store(enabled, Ordering::Release);
+}
+
+// The counts so far, or None if counting is off
+pub fn stats() -> Option<AllocatorStats> {
+    if !COUNTING.load(Ordering::Acquire) {
+        return None;
+    }
+    Some(AllocatorStats {
+        allocated_bytes: ALLOCATED_BYTES.load(Ordering::Relaxed).max(0) as u64,
+        allocations: ALLOCATIONS.load(Ordering::Relaxed),
+    })
+}
@@ -0,0 +1,63 @@
+use crate::clock;
+use libc::{c_char, c_void};
+use std::ffi::{CStr, CString};
+use std::time::{Duration, Instant};
+
+// A message in a batch handed to a BatchCallback
+#[repr(C)]
+pub struct BatchMessage {
+    pub topic: *const c_char,
+    pub message: *const c_char,
+}
+
+// Type for callback function receiving a batch of messages. Returns the number
+// of messages delivered rather than dropped.
+pub type BatchCallback = extern "C" fn(*const BatchMessage, usize, *mut c_void) -> usize;
+
+// Messages collected for a subscriber with batch delivery
+pub struct Batcher {
+    pub callback: BatchCallback,
+    pub max_batch: usize,
+    pub max_delay: Duration,
+    pending: Vec<(CString, CString)>,
+    // When the oldest pending message arrived
+    started: Option<Instant>,
+}
+
+impl Batcher {
+    pub fn new(callback: BatchCallback, max_batch: usize, max_delay: Duration) -> Self {
+        Batcher {
+            callback,
+            max_batch,
+            max_delay,
+            pending: Vec::new(),
+            started: None,
+        }
+    }
+
+    // Add a message, returning true once the batch is full
+    pub fn push(&mut self, topic: &CStr, message: &CStr) -> bool {
+        self.pending.push((topic.to_owned(), message.to_owned()));
+        self.started.get_or_insert_with(clock::now);
+        self.pending.len() >= self.max_batch
+    }
+
+    // Take the pending messages, leaving the batch empty
+    pub fn take(&mut self) -> Vec<(CString, CString)> {
+        self.started = None;
+        std::mem::take(&mut self.pending)
+    }
+
+    // Bytes of the pending topics and messages
+    pub fn pending_bytes(&self) -> u64 {
+        self.pending
+            .iter()
+            .map(|(topic, message)| (topic.as_bytes().len() + message.as_bytes().len()) as u64)
+            .sum()
+    }
+
+    // When the pending batch has to be flushed, if it has messages
+    pub fn deadline(&self) -> Option<Instant> {
+        self.started.map(|started| started + self.max_delay)
+    }
+}
@@ -0,0 +1,31 @@
+use libc::c_char;
+use std::sync::Arc;
+
+// A message payload. Every queue a message is delivered to shares the one
+// allocation, as do the buffers leased to readers.
+pub type Payload = Arc<[u8]>;
+
+// A queued message leased by get_next_buffer. The topic and data pointers stay
+// valid until the buffer is returned with release_buffer.
+#[repr(C)]
+pub struct PayloadBuffer {
+    pub id: u64,
+    pub topic: *const c_char,
+    pub topic_len: usize,
+    pub data: *const u8,
+    pub len: usize,
+}
+
+// A message copied into the caller's buffer by poll_and_fetch or
+// fetch_messages, located by offsets into that buffer. Neither string is
+// null-terminated. Messages from fetch_messages carry the tag to ack them by
+// and their delivery attempt, counting from 1; polled messages have neither.
+#[repr(C)]
+pub struct FetchedMessage {
+    pub topic_offset: usize,
+    pub topic_len: usize,
+    pub message_offset: usize,
+    pub message_len: usize,
+    pub delivery_tag: u64,
+    pub attempt: u32,
+}
@@ -0,0 +1,44 @@
+// Failures injected into deliveries to test how applications cope with them
+pub struct Chaos {
+    // Fraction of deliveries dropped
+    drop_rate: f64,
+    // Fraction of messages for queued subscribers rejected as if the queue were full
+    queue_full_rate: f64,
+    rng: u64,
+}
+
+impl Chaos {
+    pub fn new(drop_rate: f64, queue_full_rate: f64, seed: u64) -> Self {
+        Chaos {
+            drop_rate,
+            queue_full_rate,
+            rng: seed,
+        }
+    }
+
+    pub fn is_valid_rate(rate: f64) -> bool {
+        (0.0..=1.0).contains(&rate)
+    }
+
+    pub fn drop_delivery(&mut self) -> bool {
+        self.chance(self.drop_rate)
+    }
+
+    pub fn queue_full(&mut self) -> bool {
+        self.chance(self.queue_full_rate)
+    }
+
+    fn chance(&mut self, rate: f64) -> bool {
+        rate > 0.0 && self.next_f64() < rate
+    }
+
+    // splitmix64, so the same seed injects the same failures
+    fn next_f64(&mut self) -> f64 {
+        self.rng = self.rng.wrapping_add(0x9E37_79B9_7F4A_7C15);
+        let mut z = self.rng;
+        z = (z ^ (z >> 30)).wrapping_mul(0xBF58_476D_1CE4_E5B9);
+        z = (z ^ (z >> 27)).wrapping_mul(0x94D0_49BB_1331_11EB);
+        z ^= z >> 31;
+        (z >> 11) as f64 / (1u64 << 53) as f64
+    }
+}
@@ -0,0 +1,69 @@
+use std::io;
+use std::sync::{Mutex, PoisonError};
+
+// Operations of the cipher callback
+pub const CIPHER_SEAL: u32 = 0;
+pub const CIPHER_OPEN: u32 = 1;
+
+// Called to encrypt (CIPHER_SEAL) or decrypt (CIPHER_OPEN) data written to
+// disk. Returns the result in a buffer allocated with malloc, which the core
+// frees, setting out_len to its length, or null if it failed.
+pub type CipherCallback =
+    extern "C" fn(op: u32, data: *const u8, len: usize, out_len: *mut usize) -> *mut u8;
+
+// Callback set with set_storage_cipher, if any
+static CALLBACK: Mutex<Option<CipherCallback>> = Mutex::new(None);
+
+pub fn set_callback(callback: Option<CipherCallback>) {
+    *CALLBACK.lock().unwrap_or_else(PoisonError::into_inner) = callback;
+}
+
+// Whether data written now is encrypted
+pub fn is_set() -> bool {
+    CALLBACK
+        .lock()
+        .unwrap_or_else(PoisonError::into_inner)
+        .is_some()
+}
+
+// Encrypt data, or return None if no cipher is set
+pub fn seal(data: &[u8]) -> io::Result<Option<Vec<u8>>> {
+    match current() {
+        Some(callback) => call(callback, CIPHER_SEAL, data).map(Some),
+        None => Ok(None),
+    }
+}
+
+// Decrypt data sealed by an earlier cipher, which fails if none is set now
+pub fn open(data: &[u8]) -> io::Result<Vec<u8>> {
+    match current() {
+        Some(callback) => call(callback, CIPHER_OPEN, data),
+        None => Err(io::Error::new(
+            io::ErrorKind::InvalidInput,
+            "data is encrypted but no cipher is set",
+        )),
+    }
+}
+
+fn current() -> Option<CipherCallback> {
+    *CALLBACK.lock().unwrap_or_else(PoisonError::into_inner)
+}
+
+fn call(callback: CipherCallback, op: u32, data: &[u8]) -> io::Result<Vec<u8>> {
+    let mut len = 0;
+    let out = callback(op, data.as_ptr(), data.len(), &mut len);
+    if out.is_null() {
+        let action = if op == CIPHER_SEAL {
+            "encrypt"
+        } else {
+            "decrypt"
+        };
+        return Err(io::Error::new(
+            io::ErrorKind::Other,
+            format!("the cipher failed to {}", action),
+        ));
+    }
+    let result = unsafe { std::slice::from_raw_parts(out, len) }.to_vec();
+    unsafe { libc::free(out.cast()) };
+    Ok(result)
+}
@@ -0,0 +1,53 @@
+use std::sync::atomic::{AtomicBool, Ordering};
+use std::sync::Mutex;
+use std::time::{Duration, Instant};
+
+// Whether the manual clock is in use
+static MANUAL: AtomicBool = AtomicBool::new(false);
+
+// Current time of the manual clock
+static MANUAL_NOW: Mutex<Option<Instant>> = Mutex::new(None);
+
+// The time the broker runs on: the system's monotonic clock, or in
+// deterministic mode a manual clock that only moves when advanced
+pub fn now() -> Instant {
+    if MANUAL.load(Ordering::Acquire) {
+        if let Some(now) = *MANUAL_NOW.lock().unwrap_or_else(|e| e.into_inner()) {
+            return now;
+        }
+    }
+    Instant::now()
+}
+
+// Time passed on the broker's clock since an instant, or zero if it is later
+pub fn since(instant: Instant) -> Duration {
+    now().saturating_duration_since(instant)
+}
+
+pub fn is_manual() -> bool {
+    MANUAL.load(Ordering::Acquire)
+}
+
+// Switch to the manual clock, starting at the current time, or back to the
+// system clock
+pub fn set_manual(manual: bool) {
+    let mut manual_now = MANUAL_NOW.lock().unwrap_or_else(|e| e.into_inner());
+    if manual {
+        manual_now.get_or_insert_with(Instant::now);
+    } else {
+        *manual_now = None;
+    }
+    MANUAL.store(manual, Ordering::Release);
+}
+
+// Move the manual clock forward. Returns false if it isn't in use.
+pub fn advance(by: Duration) -> bool {
+    let mut manual_now = MANUAL_NOW.lock().unwrap_or_else(|e| e.into_inner());
+    match manual_now.as_mut() {
+        Some(now) => {
+            *now += by;
+            true
+        }
+        None => false,
+    }
+}
@@ -0,0 +1,19 @@
+// How a topic delivers its messages, declared when it is created with
+// create_topic. Topics created by subscribing take any subscription.
+pub const DELIVERY_ANY: u32 = 0;
+// Every subscriber gets every message; consumer groups are refused
+pub const DELIVERY_FANOUT: u32 = 1;
+// Subscribers share the messages, each going to one of them
+pub const DELIVERY_QUEUE: u32 = 2;
+// Subscribers share the messages by ordering key, which every publish must have
+pub const DELIVERY_KEYED: u32 = 3;
+
+pub fn is_valid(mode: u32) -> bool {
+    mode <= DELIVERY_KEYED
+}
+
+// Whether the subscribers of a topic share its messages rather than each
+// getting them all. They do so as members of one consumer group.
+pub fn is_shared(mode: u32) -> bool {
+    mode == DELIVERY_QUEUE || mode == DELIVERY_KEYED
+}
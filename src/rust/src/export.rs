@@ -0,0 +1,42 @@
+use crate::clock;
+use crate::presence::unix_millis;
+use crate::QueuedMessage;
+use serde::Serialize;
+use std::time::{Duration, Instant, SystemTime};
+
+// A message queued for a subscriber, as written by export_topic
+#[derive(Serialize)]
+pub struct ExportedMessage {
+    pub subscriber_id: String,
+    // Payloads that are valid UTF-8 are exported as text, others as bytes
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub payload: Option<String>,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub bytes: Option<Vec<u8>>,
+    pub published_at_ms: u64,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub publisher_id: Option<String>,
+}
+
+impl ExportedMessage {
+    pub fn new(subscriber_id: &str, message: &QueuedMessage) -> Self {
+        let (payload, bytes) = match std::str::from_utf8(&message.message) {
+            Ok(text) => (Some(text.to_string()), None),
+            Err(_) => (None, Some(message.message.to_vec())),
+        };
+        ExportedMessage {
+            subscriber_id: subscriber_id.to_string(),
+            payload,
+            bytes,
+            published_at_ms: unix_millis(SystemTime::now() - clock::since(message.published_at)),
+            publisher_id: message.publisher_id.clone(),
+        }
+    }
+}
+
+// The broker clock's instant for a wall-clock publish time, no later than now
+pub fn published_at(published_at_ms: u64) -> Instant {
+    let age = Duration::from_millis(unix_millis(SystemTime::now()).saturating_sub(published_at_ms));
+    let now = clock::now();
+    now.checked_sub(age).unwrap_or(now)
+}
@@ -0,0 +1,48 @@
+// Capabilities a build of the core may have, reported by get_features as a
+// bit set so callers can check for them before relying on them
+
+// Fetching messages to acknowledge, with nacks and visibility timeouts
+pub const FEATURE_ACK: u64 = 1 << 0;
+// Spilling queues to disk
+pub const FEATURE_PERSISTENCE: u64 = 1 << 1;
+// Subscribing to topic patterns; no build of the core has it yet
+pub const FEATURE_WILDCARDS: u64 = 1 << 2;
+// Consumer groups with balancing, partitions and rebalance events
+pub const FEATURE_GROUPS: u64 = 1 << 3;
+// Transactional publishes
+pub const FEATURE_TRANSACTIONS: u64 = 1 << 4;
+// Schema validation of publishes
+pub const FEATURE_SCHEMAS: u64 = 1 << 5;
+// Zero-copy payload buffers
+pub const FEATURE_ZERO_COPY: u64 = 1 << 6;
+// Message lifecycle tracing on $SYS/trace
+pub const FEATURE_TRACING: u64 = 1 << 7;
+// Chaos testing faults
+pub const FEATURE_CHAOS: u64 = 1 << 8;
+// Storage engines for set_storage_engine. SQLite is in builds with the sqlite
+// feature; RocksDB isn't in any build of the core.
+pub const FEATURE_STORAGE_WAL: u64 = 1 << 9;
+pub const FEATURE_STORAGE_SQLITE: u64 = 1 << 10;
+pub const FEATURE_STORAGE_ROCKSDB: u64 = 1 << 11;
+// WebAssembly transforms on the publish path
+pub const FEATURE_TRANSFORMS: u64 = 1 << 12;
+
+// The features of this build. Spilling and the write-ahead log need a
+// filesystem, which a WebAssembly build doesn't have.
+pub fn supported() -> u64 {
+    let mut features = FEATURE_ACK
+        | FEATURE_GROUPS
+        | FEATURE_TRANSACTIONS
+        | FEATURE_SCHEMAS
+        | FEATURE_ZERO_COPY
+        | FEATURE_TRACING
+        | FEATURE_CHAOS
+        | FEATURE_TRANSFORMS;
+    if cfg!(not(target_family = "wasm")) {
+        features |= FEATURE_PERSISTENCE | FEATURE_STORAGE_WAL;
+    }
+    if cfg!(feature = "sqlite") {
+        features |= FEATURE_STORAGE_SQLITE;
+    }
+    features
+}
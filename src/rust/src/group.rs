@@ -0,0 +1,372 @@
+use crate::clock;
+use crate::QueuedMessage;
+use serde::Serialize;
+use std::cmp::Reverse;
+use std::collections::hash_map::DefaultHasher;
+use std::collections::{HashMap, HashSet, VecDeque};
+use std::hash::{Hash, Hasher};
+use std::time::{Duration, Instant};
+
+// How a consumer group picks the member for a message without an ordering key
+pub const BALANCE_ROUND_ROBIN: u32 = 0;
+pub const BALANCE_LEAST_PENDING: u32 = 1;
+pub const BALANCE_WEIGHTED: u32 = 2;
+
+// Weight of a member that didn't set one
+const DEFAULT_WEIGHT: u32 = 1;
+
+// Number of partitions ordering keys are hashed into. Each partition belongs
+// to one member at a time, so a group with more members than partitions has
+// members that only receive messages without a key.
+pub const PARTITIONS: usize = 64;
+
+// The partition an ordering key belongs to
+pub fn partition(key: &str) -> usize {
+    let mut hasher = DefaultHasher::new();
+    key.hash(&mut hasher);
+    (hasher.finish() % PARTITIONS as u64) as usize
+}
+
+// What a member of a group gained and lost in a rebalance, published on
+// $SYS/groups
+#[derive(Serialize)]
+pub struct Rebalance {
+    pub topic: String,
+    pub group: String,
+    pub subscriber_id: String,
+    // Partitions the member gained and lost
+    pub assigned: Vec<usize>,
+    pub revoked: Vec<usize>,
+    // Partitions the member now owns
+    pub partitions: Vec<usize>,
+    // Members of the group after the rebalance, in join order
+    pub members: Vec<String>,
+}
+
+// A consumer group shares the messages of a topic between its members
+pub struct ConsumerGroup {
+    // Members in join order
+    pub members: Vec<String>,
+    pub balance: u32,
+    // Round-robin cursor for messages without an ordering key
+    next: usize,
+    // Weights of the members that set one, for BALANCE_WEIGHTED
+    weights: HashMap<String, u32>,
+    // Smooth weighted round-robin credit of each member
+    credit: HashMap<String, i64>,
+    // Owner of each partition, or None while the group has no members
+    owners: Vec<Option<String>>,
+    // Members that left during a cooldown, with when the partitions they
+    // still own go to the others if they haven't rejoined
+    away: HashMap<String, Instant>,
+    // Keyed messages for the partitions of away members, in publish order
+    parked: VecDeque<(usize, QueuedMessage)>,
+    // Away members that rejoined since the last rebalance, which regain the
+    // partitions they were told they lost
+    rejoined: HashSet<String>,
+}
+
+impl ConsumerGroup {
+    pub fn new() -> Self {
+        ConsumerGroup {
+            members: Vec::new(),
+            balance: BALANCE_ROUND_ROBIN,
+            next: 0,
+            weights: HashMap::new(),
+            credit: HashMap::new(),
+            owners: vec![None; PARTITIONS],
+            away: HashMap::new(),
+            parked: VecDeque::new(),
+            rejoined: HashSet::new(),
+        }
+    }
+
+    pub fn is_valid_balance(balance: u32) -> bool {
+        balance <= BALANCE_WEIGHTED
+    }
+
+    // Add a member. Returns false if it is one already. A member rejoining
+    // during its cooldown keeps its partitions.
+    pub fn join(&mut self, member: &str) -> bool {
+        if self.members.iter().any(|m| m == member) {
+            return false;
+        }
+        if self.away.remove(member).is_some() {
+            self.rejoined.insert(member.to_string());
+        }
+        self.members.push(member.to_string());
+        true
+    }
+
+    // Remove a member. Returns false if it wasn't one. With a cooldown, the
+    // member's partitions stay its own until the cooldown passes, and their
+    // messages are parked meanwhile.
+    pub fn leave(&mut self, member: &str, cooldown: Option<Duration>) -> bool {
+        let before = self.members.len();
+        self.members.retain(|m| m != member);
+        self.weights.remove(member);
+        self.credit.remove(member);
+        if self.members.len() == before {
+            return false;
+        }
+
+        if let Some(cooldown) = cooldown {
+            if self.owners.iter().any(|o| o.as_deref() == Some(member)) {
+                self.away
+                    .insert(member.to_string(), clock::now() + cooldown);
+            }
+        }
+        true
+    }
+
+    // Whether the group has neither members nor members away
+    pub fn is_empty(&self) -> bool {
+        self.members.is_empty() && self.away.is_empty()
+    }
+
+    // Add the members of another group, with their weights, cooldowns and
+    // parked messages
+    pub fn merge(&mut self, other: ConsumerGroup, topic: &str) {
+        for member in other.members {
+            self.join(&member);
+        }
+        self.weights.extend(other.weights);
+        for (partition, owner) in other.owners.into_iter().enumerate() {
+            if let Some(member) = owner.filter(|m| other.away.contains_key(m)) {
+                self.owners[partition] = Some(member);
+            }
+        }
+        self.away.extend(other.away);
+        self.parked
+            .extend(other.parked.into_iter().map(|(partition, mut message)| {
+                message.topic = topic.to_string();
+                (partition, message)
+            }));
+    }
+
+    pub fn set_weight(&mut self, member: &str, weight: u32) {
+        self.weights.insert(member.to_string(), weight);
+    }
+
+    // The partition of an ordering key if it belongs to an away member, in
+    // which case the message is parked rather than delivered
+    pub fn away_partition(&self, ordering_key: Option<&str>) -> Option<usize> {
+        let partition = partition(ordering_key?);
+        let owner = self.owners[partition].as_ref()?;
+        self.away.contains_key(owner).then_some(partition)
+    }
+
+    pub fn owner(&self, partition: usize) -> Option<&String> {
+        self.owners[partition].as_ref()
+    }
+
+    pub fn park(&mut self, partition: usize, message: QueuedMessage) {
+        self.parked.push_back((partition, message));
+    }
+
+    // Give up on away members whose cooldown has passed, or on all of them.
+    // Returns false if none were given up on. Their partitions are reassigned
+    // by the next rebalance.
+    pub fn expire_away(&mut self, all: bool) -> bool {
+        let now = clock::now();
+        let expired: Vec<String> = self
+            .away
+            .iter()
+            .filter(|(_, deadline)| all || **deadline <= now)
+            .map(|(member, _)| member.clone())
+            .collect();
+        for member in &expired {
+            self.away.remove(member);
+        }
+        !expired.is_empty()
+    }
+
+    // Take the parked messages whose partition has an owner again, with the
+    // owner, or None if the group has no members left to take them
+    pub fn unpark(&mut self) -> Vec<(Option<String>, QueuedMessage)> {
+        let (released, kept): (Vec<_>, Vec<_>) = std::mem::take(&mut self.parked)
+            .into_iter()
+            .partition(|(partition, _)| {
+                self.owners[*partition]
+                    .as_ref()
+                    .map_or(true, |owner| !self.away.contains_key(owner))
+            });
+        self.parked = kept.into_iter().collect();
+        released
+            .into_iter()
+            .map(|(partition, message)| (self.owners[partition].clone(), message))
+            .collect()
+    }
+
+    // Pick the member that receives the next message. Messages with an ordering
+    // key always go to the same member whatever the balance; pending holds the
+    // messages waiting for each member, for BALANCE_LEAST_PENDING.
+    pub fn select(
+        &mut self,
+        ordering_key: Option<&str>,
+        pending: &HashMap<String, usize>,
+    ) -> Option<&String> {
+        if self.members.is_empty() {
+            return None;
+        }
+
+        let index = match (ordering_key, self.balance) {
+            (Some(key), _) => {
+                let owner = self.owners[partition(key)].as_ref()?;
+                return self.members.iter().find(|m| *m == owner);
+            }
+            (None, BALANCE_LEAST_PENDING) => {
+                // Start from the round-robin cursor so members with equal
+                // backlogs take turns
+                let start = self.next % self.members.len();
+                self.next = self.next.wrapping_add(1);
+                (0..self.members.len())
+                    .map(|offset| (start + offset) % self.members.len())
+                    .min_by_key(|&i| pending.get(&self.members[i]).copied().unwrap_or(0))
+                    .unwrap_or(start)
+            }
+            (None, BALANCE_WEIGHTED) => self.select_weighted(),
+            (None, _) => {
+                let index = self.next % self.members.len();
+                self.next = self.next.wrapping_add(1);
+                index
+            }
+        };
+
+        self.members.get(index)
+    }
+
+    // Smooth weighted round-robin: every member gains its weight in credit and
+    // the one with the most is picked and pays the total weight, so a member
+    // with weight 3 gets three of every four messages when the other has 1,
+    // interleaved rather than in runs
+    fn select_weighted(&mut self) -> usize {
+        let mut total = 0i64;
+        for member in self.members.iter() {
+            let weight = self.weights.get(member).copied().unwrap_or(DEFAULT_WEIGHT) as i64;
+            total += weight;
+            *self.credit.entry(member.clone()).or_insert(0) += weight;
+        }
+
+        let credit = |index: usize| self.credit.get(&self.members[index]).copied().unwrap_or(0);
+        let mut best = 0;
+        for index in 1..self.members.len() {
+            if credit(index) > credit(best) {
+                best = index;
+            }
+        }
+        if let Some(credit) = self.credit.get_mut(&self.members[best]) {
+            *credit -= total;
+        }
+        best
+    }
+
+    // Reassign the partitions after the members changed and return the change
+    // for every member and for each of the departed ones. Assignment is
+    // sticky: members keep the partitions they own up to an even share, and
+    // only the partitions of departed members and those over a share move.
+    // Partitions of away members stay theirs. Every member is told even if its
+    // partitions didn't move, since its share of unkeyed messages did.
+    pub fn rebalance(&mut self, topic: &str, group: &str, departed: &[String]) -> Vec<Rebalance> {
+        let mut previous = self.owners.clone();
+        for owner in previous.iter_mut() {
+            if owner.as_ref().map_or(false, |o| self.rejoined.contains(o)) {
+                *owner = None;
+            }
+        }
+        self.rejoined.clear();
+        let count = |owners: &[Option<String>], member: &str| {
+            owners
+                .iter()
+                .filter(|o| o.as_deref() == Some(member))
+                .count()
+        };
+
+        // Free the partitions of members that are neither here nor away
+        for owner in self.owners.iter_mut() {
+            if owner.as_ref().map_or(false, |o| {
+                !self.members.contains(o) && !self.away.contains_key(o)
+            }) {
+                *owner = None;
+            }
+        }
+
+        if !self.members.is_empty() {
+            // Share the partitions not held for away members evenly, giving
+            // the larger shares to the members that own the most already
+            let held = self
+                .owners
+                .iter()
+                .filter(|o| o.as_ref().map_or(false, |o| self.away.contains_key(o)))
+                .count();
+            let available = PARTITIONS - held;
+            let (share, extra) = (
+                available / self.members.len(),
+                available % self.members.len(),
+            );
+            let mut ranked: Vec<&String> = self.members.iter().collect();
+            ranked.sort_by_key(|member| Reverse(count(&self.owners, member)));
+            let mut quota: HashMap<String, usize> = ranked
+                .into_iter()
+                .enumerate()
+                .map(|(rank, member)| (member.clone(), share + usize::from(rank < extra)))
+                .collect();
+
+            // Members keep partitions up to their quota and free the rest
+            for owner in self.owners.iter_mut() {
+                if let Some(left) = owner.as_ref().and_then(|o| quota.get_mut(o)) {
+                    if *left > 0 {
+                        *left -= 1;
+                    } else {
+                        *owner = None;
+                    }
+                }
+            }
+
+            // Free partitions go to members under their quota, in join order
+            for owner in self.owners.iter_mut().filter(|o| o.is_none()) {
+                if let Some(member) = self.members.iter().find(|m| quota[*m] > 0) {
+                    *quota.get_mut(member).unwrap() -= 1;
+                    *owner = Some(member.clone());
+                }
+            }
+        }
+
+        let owned = |owners: &[Option<String>], member: &str| -> Vec<usize> {
+            (0..PARTITIONS)
+                .filter(|&p| owners[p].as_deref() == Some(member))
+                .collect()
+        };
+        self.members
+            .iter()
+            .chain(departed.iter())
+            .map(|member| {
+                let before = owned(&previous, member);
+                // A departed member has lost its partitions even while they
+                // are held for it
+                let after = if departed.contains(member) {
+                    Vec::new()
+                } else {
+                    owned(&self.owners, member)
+                };
+                Rebalance {
+                    topic: topic.to_string(),
+                    group: group.to_string(),
+                    subscriber_id: member.clone(),
+                    assigned: after
+                        .iter()
+                        .filter(|p| !before.contains(p))
+                        .copied()
+                        .collect(),
+                    revoked: before
+                        .iter()
+                        .filter(|p| !after.contains(p))
+                        .copied()
+                        .collect(),
+                    partitions: after,
+                    members: self.members.clone(),
+                }
+            })
+            .collect()
+    }
+}
@@ -0,0 +1,83 @@
+use crate::receipt::Tracking;
+use libc::c_char;
+use std::cell::Cell;
+use std::collections::BTreeMap;
+use std::ffi::{CStr, CString};
+use std::ptr;
+
+thread_local! {
+    // Headers of the publish delivering on this thread, if it has any
+    static CURRENT: Cell<*const c_char> = const { Cell::new(ptr::null()) };
+}
+
+// Makes a publish's headers current until dropped, restoring the previous
+// ones, so callbacks called during the publish can read them
+pub struct Scope {
+    previous: *const c_char,
+}
+
+pub fn enter(headers: Option<&CStr>) -> Scope {
+    let headers = headers.map_or(ptr::null(), CStr::as_ptr);
+    Scope {
+        previous: CURRENT.with(|current| current.replace(headers)),
+    }
+}
+
+impl Drop for Scope {
+    fn drop(&mut self) {
+        CURRENT.with(|current| current.set(self.previous));
+    }
+}
+
+// The current headers, or null outside a publish with headers
+pub fn current() -> *const c_char {
+    CURRENT.with(Cell::get)
+}
+
+// Header marking messages delivered from a topic's history. The Go wrapper
+// reserves header names starting with '$' for the core.
+pub const BACKFILL: &str = "$backfill";
+
+// Headers telling callbacks the ID a message was published with and, if it
+// has one, where its receipts go, so they can report dead lettering
+pub const MESSAGE_ID: &str = "$message_id";
+pub const RECEIPT_TOPIC: &str = "$receipt_topic";
+
+// A message's headers with the backfill marker added
+pub fn backfill(headers: Option<&CStr>) -> CString {
+    mark(headers, &[(BACKFILL, "true")])
+}
+
+// Header telling callbacks the publisher waits for them to return, so they
+// mustn't hand the message on to run later
+pub const SYNC: &str = "$sync";
+
+// A message's headers with its ID and receipt topic added, and the sync
+// marker if the publisher waits, or None if it needs no markers
+pub fn delivery(
+    headers: Option<&CStr>,
+    tracking: Option<&Tracking>,
+    sync: bool,
+) -> Option<CString> {
+    let mut markers = Vec::new();
+    if let Some(tracking) = tracking {
+        markers.push((MESSAGE_ID, tracking.message_id.as_str()));
+        if let Some(receipt_topic) = &tracking.receipt_topic {
+            markers.push((RECEIPT_TOPIC, receipt_topic.as_str()));
+        }
+    }
+    if sync {
+        markers.push((SYNC, "true"));
+    }
+    (!markers.is_empty()).then(|| mark(headers, &markers))
+}
+
+fn mark(headers: Option<&CStr>, markers: &[(&str, &str)]) -> CString {
+    let mut map: BTreeMap<String, String> = headers
+        .and_then(|h| serde_json::from_slice(h.to_bytes()).ok())
+        .unwrap_or_default();
+    for (name, value) in markers {
+        map.insert(name.to_string(), value.to_string());
+    }
+    CString::new(serde_json::to_string(&map).unwrap_or_default()).unwrap_or_default()
+}
@@ -0,0 +1,110 @@
+use crate::buffer::Payload;
+use std::collections::{HashSet, VecDeque};
+use std::ffi::CString;
+use std::time::Instant;
+
+// A message kept in a topic's history
+#[derive(Clone)]
+pub struct HistoryEntry {
+    pub message: Payload,
+    pub published_at: Instant,
+    pub publisher_id: Option<String>,
+    pub headers: Option<CString>,
+}
+
+// The last messages published to a topic, replayed to subscriptions that ask
+// for a backfill
+pub struct TopicHistory {
+    limit: usize,
+    entries: VecDeque<HistoryEntry>,
+    // Header holding the key of each message, if the history is compacted
+    key_header: Option<String>,
+}
+
+impl TopicHistory {
+    pub fn new(limit: usize) -> Self {
+        TopicHistory {
+            limit,
+            entries: VecDeque::with_capacity(limit.min(1024)),
+            key_header: None,
+        }
+    }
+
+    // Compact the history by the key in a header, or stop compacting it
+    pub fn set_key_header(&mut self, key_header: Option<String>) {
+        self.key_header = key_header;
+    }
+
+    pub fn is_compacted(&self) -> bool {
+        self.key_header.is_some()
+    }
+
+    // Drop every message with a key that a later message has too, and the
+    // messages of keys whose latest message is empty, which deletes the key.
+    // Messages without the key header are kept. Returns the number of
+    // messages dropped and the bytes of their payloads.
+    pub fn compact(&mut self) -> (usize, u64) {
+        let key_header = match &self.key_header {
+            Some(key_header) => key_header,
+            None => return (0, 0),
+        };
+
+        // Walk from the newest message, keeping the first seen of each key
+        let mut seen = HashSet::new();
+        let mut kept = VecDeque::with_capacity(self.entries.len());
+        let mut removed = (0, 0);
+        while let Some(entry) = self.entries.pop_back() {
+            let keep = match key(&entry, key_header) {
+                Some(key) => seen.insert(key) && !entry.message.is_empty(),
+                None => true,
+            };
+            if keep {
+                kept.push_front(entry);
+            } else {
+                removed.0 += 1;
+                removed.1 += entry.message.len() as u64;
+            }
+        }
+        self.entries = kept;
+        removed
+    }
+
+    // Change how many messages are kept, dropping the oldest beyond it
+    pub fn set_limit(&mut self, limit: usize) {
+        self.limit = limit;
+        self.trim();
+    }
+
+    pub fn push(&mut self, entry: HistoryEntry) {
+        self.entries.push_back(entry);
+        self.trim();
+    }
+
+    // The last count messages, oldest first
+    pub fn last(&self, count: usize) -> impl Iterator<Item = &HistoryEntry> {
+        self.entries
+            .iter()
+            .skip(self.entries.len().saturating_sub(count))
+    }
+
+    pub fn clear(&mut self) {
+        self.entries.clear();
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.entries.is_empty()
+    }
+
+    fn trim(&mut self) {
+        while self.entries.len() > self.limit {
+            self.entries.pop_front();
+        }
+    }
+}
+
+// The value of a message's key header, if it has one
+fn key(entry: &HistoryEntry, key_header: &str) -> Option<String> {
+    let headers: serde_json::Map<String, serde_json::Value> =
+        serde_json::from_slice(entry.headers.as_ref()?.to_bytes()).ok()?;
+    headers.get(key_header)?.as_str().map(str::to_string)
+}
@@ -0,0 +1,88 @@
+use libc::c_char;
+use serde_json::Value;
+use std::any::Any;
+use std::ffi::CString;
+use std::panic;
+use std::sync::atomic::{AtomicU32, Ordering};
+use std::sync::{Mutex, PoisonError};
+
+// Levels of the core's log events, most severe first. A callback set for a
+// level receives events of that level and the more severe ones.
+pub const LOG_ERROR: u32 = 1;
+pub const LOG_WARN: u32 = 2;
+pub const LOG_INFO: u32 = 3;
+pub const LOG_DEBUG: u32 = 4;
+pub const LOG_TRACE: u32 = 5;
+
+// Called with each log event: its level, message and fields as a JSON object
+pub type LogCallback = extern "C" fn(level: u32, message: *const c_char, fields: *const c_char);
+
+// Callback set with set_log_callback, if any
+static CALLBACK: Mutex<Option<LogCallback>> = Mutex::new(None);
+
+// The most verbose level passed to the callback, or 0 while there is none
+static MAX_LEVEL: AtomicU32 = AtomicU32::new(0);
+
+// Send events up to a level to a callback, or stop sending them. While a
+// callback is set, panics are logged to it rather than printed to stderr.
+pub fn set_callback(callback: Option<LogCallback>, max_level: u32) {
+    let mut current = CALLBACK.lock().unwrap_or_else(PoisonError::into_inner);
+    match (current.is_some(), callback.is_some()) {
+        (false, true) => panic::set_hook(Box::new(log_panic)),
+        (true, false) => drop(panic::take_hook()), // Back to the default hook
+        _ => {}
+    }
+    *current = callback;
+    let max_level = if callback.is_some() { max_level } else { 0 };
+    MAX_LEVEL.store(max_level, Ordering::Release);
+}
+
+// Whether events of a level are sent anywhere, so fields aren't built for
+// nothing
+pub fn enabled(level: u32) -> bool {
+    level <= MAX_LEVEL.load(Ordering::Acquire)
+}
+
+// Send an event to the callback. It is called on the thread logging, which
+// may hold the broker lock.
+pub fn emit(level: u32, message: &str, fields: Value) {
+    let callback = match *CALLBACK.lock().unwrap_or_else(PoisonError::into_inner) {
+        Some(callback) => callback,
+        None => return,
+    };
+    let message = CString::new(message).unwrap_or_default();
+    let fields = CString::new(fields.to_string()).unwrap_or_default();
+    callback(level, message.as_ptr(), fields.as_ptr());
+}
+
+// Log an event with fields, if its level is enabled:
+//
+//     log_event!(LOG_INFO, "subscriber expired", "subscriber_id" => id);
+macro_rules! log_event {
+    ($level:expr, $message:expr $(, $key:literal => $value:expr)* $(,)?) => {
+        if crate::log::enabled($level) {
+            crate::log::emit($level, $message, serde_json::json!({ $($key: $value),* }));
+        }
+    };
+}
+pub(crate) use log_event;
+
+// Panic hook logging where a panic happened. catch_panic still reports the
+// panic to its caller.
+fn log_panic(info: &panic::PanicHookInfo) {
+    let message = panic_message(info.payload());
+    let location = info
+        .location()
+        .map(|l| format!("{}:{}", l.file(), l.line()))
+        .unwrap_or_default();
+    log_event!(LOG_ERROR, "panic in the core", "panic" => message, "location" => location);
+}
+
+// The message a panic was raised with
+pub fn panic_message(payload: &(dyn Any + Send)) -> String {
+    payload
+        .downcast_ref::<&str>()
+        .map(|s| s.to_string())
+        .or_else(|| payload.downcast_ref::<String>().cloned())
+        .unwrap_or_else(|| "unknown panic".to_string())
+}
@@ -0,0 +1,56 @@
+use serde::Serialize;
+
+// What a publish does when it would take the broker over its memory limit
+pub const MEMORY_POLICY_REJECT: u32 = 0;
+pub const MEMORY_POLICY_EVICT_OLDEST: u32 = 1;
+pub const MEMORY_POLICY_DROP_LARGEST: u32 = 2;
+
+// Global limit on the message bytes held by the broker; 0 means no limit
+#[derive(Clone, Copy)]
+pub struct MemoryLimit {
+    pub limit_bytes: u64,
+    pub policy: u32,
+}
+
+impl MemoryLimit {
+    pub fn unlimited() -> Self {
+        MemoryLimit {
+            limit_bytes: 0,
+            policy: MEMORY_POLICY_REJECT,
+        }
+    }
+
+    pub fn is_valid_policy(policy: u32) -> bool {
+        policy <= MEMORY_POLICY_DROP_LARGEST
+    }
+
+    pub fn policy_name(&self) -> &'static str {
+        match self.policy {
+            MEMORY_POLICY_EVICT_OLDEST => "evict_oldest",
+            MEMORY_POLICY_DROP_LARGEST => "drop_largest",
+            _ => "reject",
+        }
+    }
+}
+
+// Bytes of message data held by the broker, reported by get_stats. Memory
+// allocated by the core is invisible to Go's GC and to pprof, so this is the
+// only view of it.
+#[derive(Serialize)]
+pub struct MemoryUsage {
+    // Payloads waiting in subscriber queues
+    pub queued_bytes: u64,
+    // Payloads held for paused subscriptions
+    pub paused_bytes: u64,
+    // Payloads held for throttled subscriptions over their rate
+    pub throttled_bytes: u64,
+    // Payloads leased with get_next_buffer or fetched and not yet acked
+    pub leased_bytes: u64,
+    // Messages waiting in delivery batches
+    pub batched_bytes: u64,
+    // Messages staged in open transactions
+    pub staged_bytes: u64,
+    pub total_bytes: u64,
+    pub limit_bytes: u64,
+    pub policy: &'static str,
+}
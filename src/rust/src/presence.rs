@@ -0,0 +1,21 @@
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+// A subscriber of a topic as reported by get_presence
+#[derive(Serialize)]
+pub struct SubscriberInfo {
+    pub subscriber_id: String,
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub group: Option<String>,
+    // Milliseconds since the Unix epoch when the subscriber joined the topic
+    pub joined_at: u64,
+    #[serde(skip_serializing_if = "BTreeMap::is_empty")]
+    pub labels: BTreeMap<String, String>,
+}
+
+// Milliseconds since the Unix epoch
+pub fn unix_millis(time: SystemTime) -> u64 {
+    time.duration_since(UNIX_EPOCH)
+        .map_or(0, |d| d.as_millis() as u64)
+}
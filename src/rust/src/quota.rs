@@ -0,0 +1,100 @@
+use crate::clock;
+use serde::Serialize;
+use std::time::{Duration, Instant};
+
+// Length of the window bytes_per_day is counted over
+const DAY: Duration = Duration::from_secs(24 * 60 * 60);
+
+// Quota limits for a namespace or publisher; 0 means no limit
+#[repr(C)]
+#[derive(Clone, Copy, Serialize)]
+pub struct Quota {
+    pub messages_per_sec: f64,
+    pub bytes_per_day: u64,
+    pub max_retained_bytes: u64,
+}
+
+impl Quota {
+    pub fn is_unlimited(&self) -> bool {
+        self.messages_per_sec <= 0.0 && self.bytes_per_day == 0 && self.max_retained_bytes == 0
+    }
+}
+
+// Usage of a quota. The message rate is a token bucket holding up to one
+// second of messages; bytes are counted over fixed one-day windows.
+pub struct QuotaState {
+    pub quota: Quota,
+    tokens: f64,
+    refilled_at: Instant,
+    day_started: Instant,
+    pub bytes_today: u64,
+    pub rejected: u64,
+}
+
+impl QuotaState {
+    pub fn new(quota: Quota) -> Self {
+        let now = clock::now();
+        QuotaState {
+            quota,
+            tokens: quota.messages_per_sec,
+            refilled_at: now,
+            day_started: now,
+            bytes_today: 0,
+            rejected: 0,
+        }
+    }
+
+    // Whether a message of the given size fits, with the bytes currently
+    // retained in queues. Nothing is consumed until consume is called.
+    pub fn allows(&mut self, bytes: usize, retained: u64) -> bool {
+        let now = clock::now();
+
+        if self.quota.messages_per_sec > 0.0 {
+            let elapsed = now.duration_since(self.refilled_at).as_secs_f64();
+            self.tokens = (self.tokens + elapsed * self.quota.messages_per_sec)
+                .min(self.quota.messages_per_sec.max(1.0));
+            self.refilled_at = now;
+            if self.tokens < 1.0 {
+                return false;
+            }
+        }
+
+        if now.duration_since(self.day_started) >= DAY {
+            self.day_started = now;
+            self.bytes_today = 0;
+        }
+        if self.quota.bytes_per_day > 0
+            && self.bytes_today + bytes as u64 > self.quota.bytes_per_day
+        {
+            return false;
+        }
+
+        self.quota.max_retained_bytes == 0
+            || retained + bytes as u64 <= self.quota.max_retained_bytes
+    }
+
+    // Charge a published message against the quota
+    pub fn consume(&mut self, bytes: usize) {
+        if self.quota.messages_per_sec > 0.0 {
+            self.tokens -= 1.0;
+        }
+        self.bytes_today += bytes as u64;
+    }
+}
+
+// Quota usage reported by get_stats
+#[derive(Serialize)]
+pub struct QuotaUsage {
+    // "namespace" or "publisher"
+    pub scope: &'static str,
+    pub name: String,
+    pub quota: Quota,
+    pub bytes_today: u64,
+    pub retained_bytes: u64,
+    pub rejected: u64,
+}
+
+// The namespace of a topic, its first '/'-separated segment
+pub fn namespace(topic: &str) -> &str {
+    topic.split('/').next().unwrap_or(topic)
+}
@@ -0,0 +1,41 @@
+use crate::json_string;
+
+// Receipt events, published as JSON objects on a message's receipt topic
+pub const DELIVERED: &str = "delivered";
+pub const EXPIRED: &str = "expired";
+
+// Further lifecycle events, only published on $SYS/trace while message
+// tracing is on
+pub const ENQUEUED: &str = "enqueued";
+pub const HELD: &str = "held";
+pub const PARKED: &str = "parked";
+pub const DROPPED: &str = "dropped";
+pub const FETCHED: &str = "fetched";
+pub const NACKED: &str = "nacked";
+pub const REDELIVERED: &str = "redelivered";
+pub const DISCARDED: &str = "discarded";
+
+// Whether an event is also a receipt
+pub fn is_receipt(event: &str) -> bool {
+    event == DELIVERED || event == EXPIRED
+}
+
+// The ID a message was published with, and where to report what happens to
+// it if its publisher asked for receipts
+pub struct Tracking {
+    pub message_id: String,
+    pub receipt_topic: Option<String>,
+}
+
+impl Tracking {
+    // The receipt event for a subscriber and the topic the message was published to
+    pub fn event(&self, event: &str, subscriber_id: &str, message_topic: &str) -> String {
+        format!(
+            "{{\"event\":{},\"message_id\":{},\"topic\":{},\"subscriber_id\":{}}}",
+            json_string(event),
+            json_string(&self.message_id),
+            json_string(message_topic),
+            json_string(subscriber_id)
+        )
+    }
+}
@@ -0,0 +1,253 @@
+use serde_json::{Map, Value};
+use std::collections::HashMap;
+
+// Schema types accepted by register_schema. Only JSON Schema is supported;
+// protobuf descriptors would need a protobuf runtime in the core.
+pub const SCHEMA_JSON: u32 = 0;
+
+// A registered schema version
+pub struct Schema {
+    pub schema_type: u32,
+    pub definition: String,
+    compiled: Value,
+}
+
+// A topic's schema and what to do with messages that fail it
+pub struct Binding {
+    pub subject: String,
+    pub version: u32,
+    // Failing messages are published here instead of only being rejected
+    pub rejects_topic: Option<String>,
+}
+
+// Schemas by subject, each a list of versions starting at 1, and the topics
+// bound to them
+#[derive(Default)]
+pub struct SchemaRegistry {
+    subjects: HashMap<String, Vec<Schema>>,
+    pub bindings: HashMap<String, Binding>,
+}
+
+impl SchemaRegistry {
+    // Register a schema under a subject, returning its version. Registering
+    // the current definition again returns the existing version.
+    pub fn register(
+        &mut self,
+        subject: &str,
+        schema_type: u32,
+        definition: &str,
+    ) -> Result<u32, String> {
+        if schema_type != SCHEMA_JSON {
+            return Err("only JSON Schema is supported".to_string());
+        }
+        let compiled: Value =
+            serde_json::from_str(definition).map_err(|e| format!("invalid schema: {}", e))?;
+        if !compiled.is_object() && !compiled.is_boolean() {
+            return Err("invalid schema: must be an object or boolean".to_string());
+        }
+
+        let versions = self.subjects.entry(subject.to_string()).or_default();
+        if let Some(latest) = versions.last() {
+            if latest.definition == definition {
+                return Ok(versions.len() as u32);
+            }
+        }
+        versions.push(Schema {
+            schema_type,
+            definition: definition.to_string(),
+            compiled,
+        });
+        Ok(versions.len() as u32)
+    }
+
+    // A schema version, where 0 means the latest
+    pub fn get(&self, subject: &str, version: u32) -> Option<(u32, &Schema)> {
+        let versions = self.subjects.get(subject)?;
+        let version = if version == 0 {
+            versions.len() as u32
+        } else {
+            version
+        };
+        let schema = versions.get((version as usize).checked_sub(1)?)?;
+        Some((version, schema))
+    }
+
+    // Validate a message against the topic's schema, if it has one
+    pub fn validate(&self, topic: &str, message: &[u8]) -> Result<(), String> {
+        let binding = match self.bindings.get(topic) {
+            Some(binding) => binding,
+            None => return Ok(()),
+        };
+        let (_, schema) = match self.get(&binding.subject, binding.version) {
+            Some(schema) => schema,
+            None => return Ok(()),
+        };
+
+        let value: Value =
+            serde_json::from_slice(message).map_err(|e| format!("invalid JSON: {}", e))?;
+        validate(&value, &schema.compiled, "")
+    }
+}
+
+// Validate a value against a subset of JSON Schema: type, enum, const,
+// properties, required, additionalProperties, items, minimum, maximum,
+// minLength, maxLength, minItems and maxItems. Errors are prefixed with the
+// JSON pointer of the failing value.
+fn validate(value: &Value, schema: &Value, path: &str) -> Result<(), String> {
+    let schema = match schema {
+        Value::Bool(true) => return Ok(()),
+        Value::Bool(false) => return Err(format!("{}: no value is allowed", pointer(path))),
+        Value::Object(schema) => schema,
+        _ => return Ok(()),
+    };
+    let fail = |reason: String| Err(format!("{}: {}", pointer(path), reason));
+
+    if let Some(expected) = schema.get("type") {
+        let allowed: Vec<&str> = match expected {
+            Value::String(t) => vec![t.as_str()],
+            Value::Array(types) => types.iter().filter_map(Value::as_str).collect(),
+            _ => Vec::new(),
+        };
+        if !allowed.is_empty() && !allowed.iter().any(|t| has_type(value, t)) {
+            return fail(format!(
+                "expected {}, got {}",
+                allowed.join(" or "),
+                type_name(value)
+            ));
+        }
+    }
+
+    if let Some(Value::Array(options)) = schema.get("enum") {
+        if !options.contains(value) {
+            return fail("value is not one of the allowed values".to_string());
+        }
+    }
+    if let Some(constant) = schema.get("const") {
+        if constant != value {
+            return fail(format!("expected {}", constant));
+        }
+    }
+
+    match value {
+        Value::Number(n) => {
+            let n = n.as_f64().unwrap_or_default();
+            if let Some(min) = schema.get("minimum").and_then(Value::as_f64) {
+                if n < min {
+                    return fail(format!("{} is less than the minimum {}", n, min));
+                }
+            }
+            if let Some(max) = schema.get("maximum").and_then(Value::as_f64) {
+                if n > max {
+                    return fail(format!("{} is greater than the maximum {}", n, max));
+                }
+            }
+        }
+        Value::String(s) => {
+            let len = s.chars().count() as u64;
+            if let Some(min) = schema.get("minLength").and_then(Value::as_u64) {
+                if len < min {
+                    return fail(format!("length {} is less than {}", len, min));
+                }
+            }
+            if let Some(max) = schema.get("maxLength").and_then(Value::as_u64) {
+                if len > max {
+                    return fail(format!("length {} is greater than {}", len, max));
+                }
+            }
+        }
+        Value::Array(items) => {
+            let len = items.len() as u64;
+            if let Some(min) = schema.get("minItems").and_then(Value::as_u64) {
+                if len < min {
+                    return fail(format!("{} items is fewer than {}", len, min));
+                }
+            }
+            if let Some(max) = schema.get("maxItems").and_then(Value::as_u64) {
+                if len > max {
+                    return fail(format!("{} items is more than {}", len, max));
+                }
+            }
+            if let Some(item_schema) = schema.get("items") {
+                for (i, item) in items.iter().enumerate() {
+                    validate(item, item_schema, &format!("{}/{}", path, i))?;
+                }
+            }
+        }
+        Value::Object(object) => validate_object(object, schema, path)?,
+        _ => {}
+    }
+
+    Ok(())
+}
+
+fn validate_object(
+    object: &Map<String, Value>,
+    schema: &Map<String, Value>,
+    path: &str,
+) -> Result<(), String> {
+    if let Some(Value::Array(required)) = schema.get("required") {
+        for name in required.iter().filter_map(Value::as_str) {
+            if !object.contains_key(name) {
+                return Err(format!(
+                    "{}: missing required property '{}'",
+                    pointer(path),
+                    name
+                ));
+            }
+        }
+    }
+
+    let properties = schema.get("properties").and_then(Value::as_object);
+    for (name, value) in object {
+        let child = format!("{}/{}", path, escape_pointer(name));
+        match properties.and_then(|p| p.get(name)) {
+            Some(property) => validate(value, property, &child)?,
+            None => {
+                if let Some(additional) = schema.get("additionalProperties") {
+                    validate(value, additional, &child)?;
+                }
+            }
+        }
+    }
+
+    Ok(())
+}
+
+fn has_type(value: &Value, name: &str) -> bool {
+    match name {
+        "null" => value.is_null(),
+        "boolean" => value.is_boolean(),
+        "number" => value.is_number(),
+        "integer" => {
+            value.is_i64() || value.is_u64() || value.as_f64().map_or(false, |n| n.fract() == 0.0)
+        }
+        "string" => value.is_string(),
+        "array" => value.is_array(),
+        "object" => value.is_object(),
+        _ => false,
+    }
+}
+
+fn type_name(value: &Value) -> &'static str {
+    match value {
+        Value::Null => "null",
+        Value::Bool(_) => "boolean",
+        Value::Number(_) => "number",
+        Value::String(_) => "string",
+        Value::Array(_) => "array",
+        Value::Object(_) => "object",
+    }
+}
+
+// The JSON pointer of a path, with the root shown as "/"
+fn pointer(path: &str) -> &str {
+    if path.is_empty() {
+        "/"
+    } else {
+        path
+    }
+}
+
+fn escape_pointer(name: &str) -> String {
+    name.replace('~', "~0").replace('/', "~1")
+}
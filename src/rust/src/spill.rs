@@ -0,0 +1,279 @@
+use crate::cipher;
+use crate::clock;
+use crate::QueuedMessage;
+use std::collections::{HashMap, VecDeque};
+use std::fs::{self, File};
+use std::io::{self, BufWriter, Read, Write};
+use std::path::{Path, PathBuf};
+use std::sync::atomic::{AtomicU64, Ordering};
+use std::time::{Duration, Instant};
+
+// Size at which a segment is closed and the next message starts a new one
+const SEGMENT_SIZE: u64 = 1 << 20;
+
+// Marks a record without a publisher ID
+const NO_PUBLISHER: u32 = u32::MAX;
+
+// Distinguishes the segment files of spill queues sharing a directory
+static NEXT_SPILL_ID: AtomicU64 = AtomicU64::new(0);
+
+// A file of spilled messages, in the order they were queued
+struct Segment {
+    path: PathBuf,
+    // When the segment was opened; publish times are stored relative to it
+    opened: Instant,
+    len: usize,
+    size: u64,
+    topics: HashMap<String, usize>,
+    // Whether the records are encrypted, as they are if a cipher was set
+    // when the segment was opened. Each is then written sealed, prefixed with
+    // the length of the result.
+    sealed: bool,
+}
+
+// Messages of a subscriber's queue that went over its memory budget, kept in
+// numbered segment files. Spilled messages are always newer than the ones in
+// memory, so they are read back a segment at a time, oldest first, once the
+// queue runs dry. The files only live as long as the process and are removed
+// when the spill queue is dropped.
+pub struct SpillQueue {
+    dir: PathBuf,
+    prefix: String,
+    // Bytes of payload the in-memory queue may hold before messages spill
+    pub budget: usize,
+    segments: VecDeque<Segment>,
+    next_segment: u64,
+    // Open writer of the newest segment
+    writer: Option<BufWriter<File>>,
+    // Bytes of payload on disk
+    pub bytes: u64,
+}
+
+impl SpillQueue {
+    pub fn new(dir: &Path, budget: usize) -> io::Result<Self> {
+        fs::create_dir_all(dir)?;
+        Ok(SpillQueue {
+            dir: dir.to_path_buf(),
+            prefix: format!(
+                "spill-{}-{}",
+                std::process::id(),
+                NEXT_SPILL_ID.fetch_add(1, Ordering::Relaxed)
+            ),
+            budget,
+            segments: VecDeque::new(),
+            next_segment: 0,
+            writer: None,
+            bytes: 0,
+        })
+    }
+
+    pub fn is_empty(&self) -> bool {
+        self.segments.is_empty()
+    }
+
+    // Number of spilled messages, from the given topic if one is specified
+    pub fn count(&self, topic: Option<&str>) -> usize {
+        self.segments
+            .iter()
+            .map(|s| match topic {
+                Some(topic) => s.topics.get(topic).copied().unwrap_or(0),
+                None => s.len,
+            })
+            .sum()
+    }
+
+    // Append a message to the newest segment, starting a new one if it is full
+    pub fn push(&mut self, message: &QueuedMessage) -> io::Result<()> {
+        let full = self
+            .segments
+            .back()
+            .map_or(true, |s| s.size >= SEGMENT_SIZE);
+        if full || self.writer.is_none() {
+            self.open_segment()?;
+        }
+        let segment = self.segments.back_mut().unwrap();
+
+        let mut record = encode(message, segment.opened)?;
+        if segment.sealed {
+            let sealed = cipher::seal(&record)?
+                .ok_or_else(|| io::Error::new(io::ErrorKind::Other, "the cipher was removed"))?;
+            record.clear();
+            put_bytes(&mut record, &sealed)?;
+        }
+        self.writer.as_mut().unwrap().write_all(&record)?;
+
+        segment.len += 1;
+        segment.size += record.len() as u64;
+        *segment.topics.entry(message.topic.clone()).or_insert(0) += 1;
+        self.bytes += message.message.len() as u64;
+        Ok(())
+    }
+
+    // Read back and remove the oldest segment. If it can't be read, the
+    // number of messages lost with it is returned instead.
+    pub fn pop_segment(&mut self) -> Option<Result<Vec<QueuedMessage>, usize>> {
+        if self.segments.len() == 1 {
+            // The writer belongs to the newest segment, so finish it first
+            if let Some(mut writer) = self.writer.take() {
+                let _ = writer.flush();
+            }
+        }
+        let segment = self.segments.pop_front()?;
+
+        let messages = fs::read(&segment.path).and_then(|data| decode(&data, &segment));
+        let _ = fs::remove_file(&segment.path);
+
+        Some(match messages {
+            Ok(messages) => {
+                self.bytes -= messages.iter().map(|m| m.message.len() as u64).sum::<u64>();
+                Ok(messages)
+            }
+            Err(_) => Err(segment.len),
+        })
+    }
+
+    // Read every spilled message without removing any, skipping segments
+    // that can't be read
+    pub fn peek_all(&mut self) -> Vec<QueuedMessage> {
+        if let Some(writer) = self.writer.as_mut() {
+            let _ = writer.flush();
+        }
+        self.segments
+            .iter()
+            .filter_map(|s| fs::read(&s.path).and_then(|data| decode(&data, s)).ok())
+            .flatten()
+            .collect()
+    }
+
+    fn open_segment(&mut self) -> io::Result<()> {
+        if let Some(mut writer) = self.writer.take() {
+            writer.flush()?;
+        }
+
+        let path = self
+            .dir
+            .join(format!("{}-{:08}.seg", self.prefix, self.next_segment));
+        let file = File::create(&path)?;
+        self.next_segment += 1;
+
+        self.writer = Some(BufWriter::new(file));
+        self.segments.push_back(Segment {
+            path,
+            opened: clock::now(),
+            len: 0,
+            size: 0,
+            topics: HashMap::new(),
+            sealed: cipher::is_set(),
+        });
+        Ok(())
+    }
+}
+
+impl Drop for SpillQueue {
+    fn drop(&mut self) {
+        self.writer = None;
+        for segment in self.segments.iter() {
+            let _ = fs::remove_file(&segment.path);
+        }
+    }
+}
+
+// Encode a message as a record: the publish time in microseconds relative to
+// the segment, then the topic, publisher ID and payload, each prefixed with
+// its length
+fn encode(message: &QueuedMessage, opened: Instant) -> io::Result<Vec<u8>> {
+    let offset = if message.published_at >= opened {
+        message.published_at.duration_since(opened).as_micros() as i64
+    } else {
+        -(opened.duration_since(message.published_at).as_micros() as i64)
+    };
+
+    let publisher_id = message.publisher_id.as_deref().map(str::as_bytes);
+    let mut record = Vec::with_capacity(
+        20 + message.topic.len() + publisher_id.map_or(0, <[u8]>::len) + message.message.len(),
+    );
+    record.extend_from_slice(&offset.to_le_bytes());
+    put_bytes(&mut record, message.topic.as_bytes())?;
+    match publisher_id {
+        Some(publisher_id) => put_bytes(&mut record, publisher_id)?,
+        None => record.extend_from_slice(&NO_PUBLISHER.to_le_bytes()),
+    }
+    put_bytes(&mut record, &message.message)?;
+    Ok(record)
+}
+
+fn put_bytes(record: &mut Vec<u8>, bytes: &[u8]) -> io::Result<()> {
+    let len = u32::try_from(bytes.len())
+        .ok()
+        .filter(|&len| len != NO_PUBLISHER)
+        .ok_or_else(|| io::Error::new(io::ErrorKind::InvalidInput, "record too large"))?;
+    record.extend_from_slice(&len.to_le_bytes());
+    record.extend_from_slice(bytes);
+    Ok(())
+}
+
+fn decode(mut data: &[u8], segment: &Segment) -> io::Result<Vec<QueuedMessage>> {
+    let mut messages = Vec::new();
+    while !data.is_empty() {
+        if segment.sealed {
+            let sealed = take_bytes(&mut data)?.ok_or_else(invalid)?;
+            let record = cipher::open(sealed)?;
+            messages.push(decode_record(&mut &record[..], segment.opened)?);
+        } else {
+            messages.push(decode_record(&mut data, segment.opened)?);
+        }
+    }
+    Ok(messages)
+}
+
+// Decode one record, leaving data at the next
+fn decode_record(data: &mut &[u8], opened: Instant) -> io::Result<QueuedMessage> {
+    let mut offset = [0; 8];
+    data.read_exact(&mut offset)?;
+    let offset = i64::from_le_bytes(offset);
+    let published_at = if offset >= 0 {
+        opened + Duration::from_micros(offset as u64)
+    } else {
+        opened
+            .checked_sub(Duration::from_micros(offset.unsigned_abs()))
+            .unwrap_or(opened)
+    };
+
+    let topic = take_bytes(data)?.ok_or_else(invalid)?;
+    let publisher_id = take_bytes(data)?;
+    let message = take_bytes(data)?.ok_or_else(invalid)?;
+
+    Ok(QueuedMessage {
+        topic: String::from_utf8(topic.to_vec()).map_err(|_| invalid())?,
+        message: message.into(),
+        published_at,
+        publisher_id: match publisher_id {
+            Some(id) => Some(String::from_utf8(id.to_vec()).map_err(|_| invalid())?),
+            None => None,
+        },
+        attempts: 0,
+        tracking: None,
+    })
+}
+
+// Take a length-prefixed field, or None for a missing publisher ID
+fn take_bytes<'a>(data: &mut &'a [u8]) -> io::Result<Option<&'a [u8]>> {
+    let mut len = [0; 4];
+    data.read_exact(&mut len)?;
+    let len = u32::from_le_bytes(len);
+    if len == NO_PUBLISHER {
+        return Ok(None);
+    }
+
+    let len = len as usize;
+    if data.len() < len {
+        return Err(invalid());
+    }
+    let (bytes, rest) = data.split_at(len);
+    *data = rest;
+    Ok(Some(bytes))
+}
+
+fn invalid() -> io::Error {
+    io::Error::new(io::ErrorKind::InvalidData, "corrupt spill segment")
+}
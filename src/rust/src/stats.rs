@@ -0,0 +1,147 @@
+use crate::alloc::AllocatorStats;
+use crate::memory::MemoryUsage;
+use crate::quota::QuotaUsage;
+use serde::Serialize;
+use std::collections::BTreeMap;
+use std::time::Duration;
+
+// Upper bounds of the latency buckets in microseconds, doubling from 1us to ~67s
+const BUCKET_COUNT: usize = 27;
+
+// Log-scale latency histogram. Percentiles are reported as the upper bound of
+// the bucket they fall in, so they are accurate to within a factor of two.
+pub struct LatencyHistogram {
+    buckets: [u64; BUCKET_COUNT + 1],
+    count: u64,
+    sum_us: u64,
+}
+
+impl LatencyHistogram {
+    pub fn new() -> Self {
+        LatencyHistogram {
+            buckets: [0; BUCKET_COUNT + 1],
+            count: 0,
+            sum_us: 0,
+        }
+    }
+
+    pub fn record(&mut self, latency: Duration) {
+        let us = latency.as_micros().min(u64::MAX as u128) as u64;
+
+        // Bucket i holds latencies up to 2^i microseconds; the last one is unbounded
+        let index = if us <= 1 {
+            0
+        } else {
+            (64 - (us - 1).leading_zeros() as usize).min(BUCKET_COUNT)
+        };
+
+        self.buckets[index] += 1;
+        self.count += 1;
+        self.sum_us = self.sum_us.saturating_add(us);
+    }
+
+    // Upper bound in microseconds of the bucket containing the given percentile
+    pub fn percentile(&self, p: f64) -> u64 {
+        if self.count == 0 {
+            return 0;
+        }
+
+        let rank = ((p / 100.0) * self.count as f64).ceil().max(1.0) as u64;
+        let mut seen = 0;
+        for (i, count) in self.buckets.iter().enumerate() {
+            seen += count;
+            if seen >= rank {
+                return 1u64 << i.min(63);
+            }
+        }
+        1u64 << BUCKET_COUNT
+    }
+
+    pub fn summary(&self) -> LatencySummary {
+        LatencySummary {
+            count: self.count,
+            sum_us: self.sum_us,
+            p50_us: self.percentile(50.0),
+            p95_us: self.percentile(95.0),
+            p99_us: self.percentile(99.0),
+        }
+    }
+}
+
+// Delivery metrics for a single subscriber/topic pair
+pub struct SubscriptionMetrics {
+    // Time from publish until the message reached the callback or was dequeued
+    pub lag: LatencyHistogram,
+    // Time spent inside the subscriber's callback
+    pub handler: LatencyHistogram,
+}
+
+impl SubscriptionMetrics {
+    pub fn new() -> Self {
+        SubscriptionMetrics {
+            lag: LatencyHistogram::new(),
+            handler: LatencyHistogram::new(),
+        }
+    }
+}
+
+#[derive(Serialize)]
+pub struct LatencySummary {
+    pub count: u64,
+    pub sum_us: u64,
+    pub p50_us: u64,
+    pub p95_us: u64,
+    pub p99_us: u64,
+}
+
+#[derive(Serialize)]
+pub struct SubscriptionStats {
+    pub subscriber_id: String,
+    pub topic: String,
+    pub lag: LatencySummary,
+    pub handler: LatencySummary,
+}
+
+#[derive(Serialize)]
+pub struct PublisherStats {
+    pub publisher_id: String,
+    pub labels: BTreeMap<String, String>,
+    pub published: u64,
+    pub bytes: u64,
+}
+
+// Running totals of topic history compaction
+#[derive(Serialize, Default, Clone)]
+pub struct CompactionStats {
+    // Topics whose history is compacted
+    pub topics: usize,
+    // Compaction passes over every compacted topic
+    pub runs: u64,
+    // Messages dropped from histories, and the bytes of their payloads
+    pub removed: u64,
+    pub removed_bytes: u64,
+}
+
+// Snapshot of the broker returned by get_stats as JSON
+#[derive(Serialize)]
+pub struct BrokerStats {
+    pub published: u64,
+    pub delivered: u64,
+    pub dropped: u64,
+    pub topics: usize,
+    pub topics_collected: u64,
+    pub subscribers: usize,
+    pub queue_depth: usize,
+    // Bytes of the message payloads held in queues and leased buffers
+    pub retained_bytes: u64,
+    pub memory: MemoryUsage,
+    // Bytes of the message payloads spilled to disk
+    pub spilled_bytes: u64,
+    pub compaction: CompactionStats,
+    pub subscriptions: Vec<SubscriptionStats>,
+    pub publishers: Vec<PublisherStats>,
+    pub quotas: Vec<QuotaUsage>,
+    // What the core has allocated, if allocator stats are on
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub allocator: Option<AllocatorStats>,
+}
@@ -0,0 +1,222 @@
+use crate::log::{log_event, LOG_ERROR};
+use crate::QueuedMessage;
+use serde::{Deserialize, Serialize};
+use std::collections::{BTreeMap, BTreeSet, HashMap, VecDeque};
+use std::io;
+
+// Storage engines, selected with set_storage_engine. Only the queues of
+// subscribers without a callback are stored: a callback can't outlive the
+// process that registered it.
+pub const STORAGE_MEMORY: u32 = 0;
+// Write-ahead log of queue changes in a directory, replayed when it is opened
+pub const STORAGE_WAL: u32 = 1;
+// Tables of a SQLite database in a directory, in builds with the sqlite
+// feature
+pub const STORAGE_SQLITE: u32 = 2;
+// A RocksDB database; no build of the core has it
+pub const STORAGE_ROCKSDB: u32 = 3;
+
+// Settings of a storage engine, passed to set_storage_engine as a JSON object
+#[derive(Deserialize, Default)]
+#[serde(default)]
+pub struct StorageOptions {
+    // Directory the engine keeps its files in
+    pub path: String,
+    // Whether every change is synced to disk before the call making it
+    // returns, rather than only written to the operating system
+    pub sync: bool,
+}
+
+// A change to the stored queues of subscribers without a callback
+pub enum Change<'a> {
+    Subscribed {
+        subscriber_id: &'a str,
+        topic: &'a str,
+    },
+    Unsubscribed {
+        subscriber_id: &'a str,
+        topic: &'a str,
+    },
+    // A message added to the back of a queue, or put back at its front
+    Enqueued {
+        subscriber_id: &'a str,
+        message: &'a QueuedMessage,
+        front: bool,
+    },
+    // The message at a position of a queue taken or dropped
+    Dequeued {
+        subscriber_id: &'a str,
+        index: usize,
+    },
+    // A subscriber removed along with its queue
+    Removed {
+        subscriber_id: &'a str,
+    },
+    // A topic merged into another
+    Renamed {
+        from: &'a str,
+        to: &'a str,
+    },
+}
+
+// Where the broker keeps what has to survive a restart. An engine records
+// each change as it happens, and gives back the state they add up to when it
+// is opened again.
+pub trait StorageEngine: Send {
+    fn kind(&self) -> u32;
+
+    // Record a change to the stored state
+    fn record(&mut self, change: &Change) -> io::Result<()>;
+
+    // Read back the state recorded by an earlier process
+    fn recover(&mut self) -> io::Result<Recovered>;
+
+    // Replace everything recorded with the changes that build the current
+    // state from nothing, so the records don't grow without bound
+    fn compact(&mut self, snapshot: &[Change]) -> io::Result<()>;
+}
+
+// The default engine, keeping nothing beyond the process
+pub struct MemoryEngine;
+
+impl StorageEngine for MemoryEngine {
+    fn kind(&self) -> u32 {
+        STORAGE_MEMORY
+    }
+
+    fn record(&mut self, _change: &Change) -> io::Result<()> {
+        Ok(())
+    }
+
+    fn recover(&mut self) -> io::Result<Recovered> {
+        Ok(Recovered::default())
+    }
+
+    fn compact(&mut self, _snapshot: &[Change]) -> io::Result<()> {
+        Ok(())
+    }
+}
+
+// The engine in use. Changes that can't be recorded are logged rather than
+// failing the call that made them, which has already happened in memory.
+pub struct Storage {
+    pub engine: Box<dyn StorageEngine>,
+}
+
+impl Storage {
+    pub fn memory() -> Self {
+        Storage {
+            engine: Box::new(MemoryEngine),
+        }
+    }
+
+    pub fn record(&mut self, change: Change) {
+        if let Err(e) = self.engine.record(&change) {
+            log_event!(LOG_ERROR, "failed to record a change in storage",
+                "engine" => self.engine.kind(),
+                "error" => e.to_string());
+        }
+    }
+}
+
+// State recovered by an engine: the topics of each subscriber without a
+// callback and its queue, built by replaying the recorded changes
+#[derive(Default)]
+pub struct Recovered {
+    pub subscriptions: BTreeMap<String, BTreeSet<String>>,
+    pub queues: HashMap<String, VecDeque<QueuedMessage>>,
+    // Changes read back
+    pub changes: usize,
+    // Records skipped as unreadable
+    pub corrupted: usize,
+}
+
+impl Recovered {
+    // Apply a change read back by the engine
+    pub fn apply(&mut self, change: &Change) {
+        self.changes += 1;
+        match *change {
+            Change::Subscribed {
+                subscriber_id,
+                topic,
+            } => {
+                self.subscriptions
+                    .entry(subscriber_id.to_string())
+                    .or_default()
+                    .insert(topic.to_string());
+                self.queues.entry(subscriber_id.to_string()).or_default();
+            }
+            Change::Unsubscribed {
+                subscriber_id,
+                topic,
+            } => {
+                if let Some(topics) = self.subscriptions.get_mut(subscriber_id) {
+                    topics.remove(topic);
+                }
+            }
+            Change::Enqueued {
+                subscriber_id,
+                message,
+                front,
+            } => {
+                let queue = self.queues.entry(subscriber_id.to_string()).or_default();
+                if front {
+                    queue.push_front(message.clone());
+                } else {
+                    queue.push_back(message.clone());
+                }
+            }
+            Change::Dequeued {
+                subscriber_id,
+                index,
+            } => {
+                if let Some(queue) = self.queues.get_mut(subscriber_id) {
+                    queue.remove(index);
+                }
+            }
+            Change::Removed { subscriber_id } => {
+                self.subscriptions.remove(subscriber_id);
+                self.queues.remove(subscriber_id);
+            }
+            Change::Renamed { from, to } => {
+                for topics in self.subscriptions.values_mut() {
+                    if topics.remove(from) {
+                        topics.insert(to.to_string());
+                    }
+                }
+                for message in self.queues.values_mut().flatten() {
+                    if message.topic == from {
+                        message.topic = to.to_string();
+                    }
+                }
+            }
+        }
+    }
+}
+
+// What the last storage engine opened recovered, reported by
+// last_recovery_report
+#[derive(Serialize, Default)]
+pub struct RecoveryReport {
+    pub engine: u32,
+    pub path: String,
+    // When the engine was opened, in milliseconds since the Unix epoch, and
+    // how long recovering took
+    pub opened_at_ms: u64,
+    pub duration_us: u64,
+    // Records read back, and those skipped as unreadable
+    pub changes: usize,
+    pub corrupted: usize,
+    // Subscribers subscribed again, the topics they were subscribed to, and
+    // the messages put back in their queues
+    pub subscribers: usize,
+    pub topics: usize,
+    pub messages: usize,
+    // Subscribers that had registered a callback before the engine was
+    // opened, whose stored messages were dropped
+    pub skipped_subscribers: usize,
+    pub skipped_messages: usize,
+    // Why recovering failed, in which case nothing was recovered
+    #[serde(skip_serializing_if = "Option::is_none")]
+    pub error: Option<String>,
+}
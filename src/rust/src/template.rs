@@ -0,0 +1,60 @@
+use serde::{Deserialize, Serialize};
+
+// Default configuration of the topics matching a pattern, passed to
+// set_topic_template as a JSON object, listed by topic_templates and applied when such a topic is
+// created. Zero and missing fields leave the topic's setting alone.
+#[derive(Serialize, Deserialize, Default)]
+#[serde(default)]
+pub struct TopicTemplate {
+    // DELIVERY_* mode, unless the topic is created with one
+    pub delivery: u32,
+    // Number of messages kept for backfill, and the header the history is
+    // compacted by
+    pub history: usize,
+    pub compaction_key: Option<String>,
+    // Subscribers the topic allows, in place of the broker-wide limit
+    pub max_subscribers: usize,
+    pub schema: Option<TemplateSchema>,
+}
+
+// Schema binding of a template. Version 0 is the latest version when the
+// topic is created.
+#[derive(Serialize, Deserialize)]
+pub struct TemplateSchema {
+    pub subject: String,
+    #[serde(default)]
+    pub version: u32,
+    #[serde(default)]
+    pub rejects_topic: Option<String>,
+}
+
+// Whether a topic matches a template pattern. '*' matches any run of
+// characters other than '/', and '?' any one of them, so each wildcard stays
+// within a segment of the topic as in the Go package's Mux patterns.
+pub fn matches(pattern: &str, topic: &str) -> bool {
+    let (pattern, topic): (Vec<char>, Vec<char>) =
+        (pattern.chars().collect(), topic.chars().collect());
+    matches_from(&pattern, &topic)
+}
+
+// How specific a pattern is: the number of characters it matches literally
+pub fn specificity(pattern: &str) -> usize {
+    pattern.chars().filter(|&c| c != '*' && c != '?').count()
+}
+
+fn matches_from(pattern: &[char], topic: &[char]) -> bool {
+    match pattern.split_first() {
+        None => topic.is_empty(),
+        Some(('*', rest)) => {
+            // Try every length of the run, up to the end of the segment
+            let segment = topic.iter().position(|&c| c == '/').unwrap_or(topic.len());
+            (0..=segment).any(|len| matches_from(rest, &topic[len..]))
+        }
+        Some((&p, rest)) => match topic.split_first() {
+            Some((&c, topic_rest)) if (p == '?' && c != '/') || p == c => {
+                matches_from(rest, topic_rest)
+            }
+            _ => false,
+        },
+    }
+}
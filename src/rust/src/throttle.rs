@@ -0,0 +1,37 @@
+use crate::clock;
+use std::time::{Duration, Instant};
+
+// Schedule capping the deliveries to a subscription at a rate, spaced evenly
+// rather than let through in bursts after a quiet spell
+pub struct Throttle {
+    // Time between deliveries
+    pub interval: Duration,
+    // When the next delivery is allowed
+    next: Instant,
+}
+
+impl Throttle {
+    pub fn new(interval: Duration) -> Self {
+        Throttle {
+            interval,
+            next: clock::now(),
+        }
+    }
+
+    // Take the turn of a delivery if it is due. A held message takes the turn
+    // it was scheduled for, so a backlog keeps to the rate however late it is
+    // released, while a new one starts the schedule over from now.
+    pub fn try_take(&mut self, held: bool) -> bool {
+        let now = clock::now();
+        if now < self.next {
+            return false;
+        }
+        self.next = if held { self.next } else { now } + self.interval;
+        true
+    }
+
+    // How long until the next delivery is allowed
+    pub fn wait(&self) -> Duration {
+        self.next.saturating_duration_since(clock::now())
+    }
+}
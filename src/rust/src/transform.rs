@@ -0,0 +1,217 @@
+use crate::wasm::{FuncType, Instance, Module, ValType};
+use std::collections::HashMap;
+use std::sync::{Arc, Mutex, PoisonError};
+
+// Instructions a transform may run for one message, including its calls to
+// alloc, before it traps. Instantiating a module gets the same budget for its
+// start function. The interpreter runs simple instructions at around 200
+// million a second, so a module that uses it all holds up its topic, and no
+// other, for about 5ms.
+pub const TRANSFORM_FUEL: u64 = 1_000_000;
+
+// Values transform may return instead of a message: drop the message, which
+// is then published to no one, or reject the publish
+pub const TRANSFORM_DROP: i64 = -1;
+pub const TRANSFORM_REJECT: i64 = -2;
+
+// What a topic's pipeline made of a message
+pub enum Outcome {
+    Replaced(Vec<u8>),
+    Dropped(u64),
+    Rejected(String),
+}
+
+// A module attached to a topic, and its instance. The instance lives as long
+// as the transform, so a module's memory carries over from one message to the
+// next; a module that traps gets a fresh instance for the next message. The
+// instance has a lock of its own, so transforms run without the broker lock
+// and publishes to different topics run theirs in parallel.
+pub struct Transform {
+    id: u64,
+    module: Arc<Module>,
+    instance: Mutex<Option<Instance>>,
+}
+
+// A topic's transforms, taken from its pipeline to run a message through
+pub struct Pipeline(Vec<Arc<Transform>>);
+
+// The transforms attached to each topic, run in the order they were attached
+#[derive(Default)]
+pub struct Pipelines {
+    topics: HashMap<String, Vec<Arc<Transform>>>,
+    last_id: u64,
+}
+
+impl Transform {
+    // Compile and instantiate a module, which gets its ID once it is attached.
+    // This is the slow part of attaching, done before the broker is locked.
+    pub fn compile(bytes: &[u8]) -> Result<Transform, String> {
+        let module = Arc::new(Module::compile(bytes)?);
+        check_exports(&module)?;
+        let instance = Instance::new(module.clone(), TRANSFORM_FUEL)?;
+        Ok(Transform {
+            id: 0,
+            module,
+            instance: Mutex::new(Some(instance)),
+        })
+    }
+}
+
+impl Pipelines {
+    // Append a compiled module to a topic's pipeline, returning its ID
+    pub fn attach(&mut self, topic: &str, mut transform: Transform) -> u64 {
+        self.last_id += 1;
+        transform.id = self.last_id;
+        self.topics
+            .entry(topic.to_string())
+            .or_default()
+            .push(Arc::new(transform));
+        self.last_id
+    }
+
+    // Remove a transform from a topic's pipeline. Returns false if the topic
+    // has no transform with that ID.
+    pub fn detach(&mut self, topic: &str, id: u64) -> bool {
+        let pipeline = match self.topics.get_mut(topic) {
+            Some(pipeline) => pipeline,
+            None => return false,
+        };
+        let before = pipeline.len();
+        pipeline.retain(|t| t.id != id);
+        let removed = pipeline.len() < before;
+        if pipeline.is_empty() {
+            self.topics.remove(topic);
+        }
+        removed
+    }
+
+    // IDs of a topic's transforms, in the order they run
+    pub fn ids(&self, topic: &str) -> Vec<u64> {
+        self.topics
+            .get(topic)
+            .map_or_else(Vec::new, |p| p.iter().map(|t| t.id).collect())
+    }
+
+    // A topic's transforms, to run once the broker is unlocked, or None if it
+    // has none. A transform detached meanwhile still runs on the messages of
+    // publishes that took it.
+    pub fn pipeline(&self, topic: &str) -> Option<Pipeline> {
+        self.topics.get(topic).map(|p| Pipeline(p.clone()))
+    }
+}
+
+impl Pipeline {
+    // Run a message through the pipeline, each transform taking the message
+    // the one before it left
+    pub fn run(&self, topic: &str, message: &[u8]) -> Outcome {
+        let mut message = message.to_vec();
+        for transform in &self.0 {
+            match transform.run(topic, &message) {
+                Ok(Some(output)) => message = output,
+                Ok(None) => return Outcome::Dropped(transform.id),
+                Err(reason) => return Outcome::Rejected(reason),
+            }
+        }
+        Outcome::Replaced(message)
+    }
+}
+
+impl Transform {
+    // Run the module on a message, returning what it replaced it with, None
+    // if it dropped it, or why the publish is rejected. Messages published to
+    // the topic at the same time take turns with the instance.
+    fn run(&self, topic: &str, message: &[u8]) -> Result<Option<Vec<u8>>, String> {
+        let mut slot = self.instance.lock().unwrap_or_else(PoisonError::into_inner);
+        let mut instance = match slot.take() {
+            Some(instance) => instance,
+            None => Instance::new(self.module.clone(), TRANSFORM_FUEL)
+                .map_err(|trap| format!("transform {} failed: {}", self.id, trap))?,
+        };
+
+        match call(&mut instance, topic, message) {
+            Ok(result) => {
+                let outcome = match result {
+                    TRANSFORM_DROP => Ok(None),
+                    result if result < 0 => Err(match reason(&mut instance) {
+                        Some(reason) => format!("rejected by transform {}: {}", self.id, reason),
+                        None => format!("rejected by transform {}", self.id),
+                    }),
+                    result => read(&instance, result)
+                        .map(|output| Some(output.to_vec()))
+                        .ok_or_else(|| {
+                            format!("transform {} returned memory out of bounds", self.id)
+                        }),
+                };
+                *slot = Some(instance);
+                outcome
+            }
+            Err(trap) => Err(format!("transform {} failed: {}", self.id, trap)),
+        }
+    }
+}
+
+// Copy the topic and message into the instance's memory and call transform
+fn call(instance: &mut Instance, topic: &str, message: &[u8]) -> Result<i64, String> {
+    let mut fuel = TRANSFORM_FUEL;
+    let mut args = Vec::with_capacity(4);
+    for bytes in [topic.as_bytes(), message] {
+        let ptr = instance.call("alloc", &[bytes.len() as u64], fuel)?[0] as u32 as usize;
+        fuel = instance.fuel();
+        instance
+            .memory_mut()
+            .get_mut(ptr..ptr + bytes.len())
+            .ok_or_else(|| "alloc returned memory out of bounds".to_string())?
+            .copy_from_slice(bytes);
+        args.push(ptr as u64);
+        args.push(bytes.len() as u64);
+    }
+    Ok(instance.call("transform", &args, fuel)?[0] as i64)
+}
+
+// The reason the module gives for a rejection, if it exports one
+fn reason(instance: &mut Instance) -> Option<String> {
+    let result = instance.call("reason", &[], TRANSFORM_FUEL).ok()?[0] as i64;
+    read(instance, result).map(|reason| String::from_utf8_lossy(reason).into_owned())
+}
+
+// The memory a result packing a pointer and a length refers to
+fn read(instance: &Instance, result: i64) -> Option<&[u8]> {
+    if result < 0 {
+        return None;
+    }
+    let ptr = (result >> 32) as usize;
+    let len = (result & 0xffff_ffff) as usize;
+    instance.memory().get(ptr..ptr + len)
+}
+
+// Check that a module exports what a transform needs
+fn check_exports(module: &Module) -> Result<(), String> {
+    use ValType::{I32, I64};
+
+    if !module.exports_memory("memory") {
+        return Err("module doesn't export its memory as 'memory'".to_string());
+    }
+    let required = [
+        ("alloc", vec![I32], vec![I32]),
+        ("transform", vec![I32, I32, I32, I32], vec![I64]),
+    ];
+    for (name, params, results) in required {
+        if module.export_type(name) != Some(&FuncType { params, results }) {
+            return Err(format!(
+                "module doesn't export a function '{}' of the right type",
+                name
+            ));
+        }
+    }
+    let reason = FuncType {
+        params: vec![],
+        results: vec![I64],
+    };
+    if module
+        .export_type("reason")
+        .map_or(false, |ty| *ty != reason)
+    {
+        return Err("module's 'reason' function has the wrong type".to_string());
+    }
+    Ok(())
+}
@@ -0,0 +1,343 @@
+use crate::cipher;
+use crate::clock;
+use crate::storage::{Change, Recovered, StorageEngine, StorageOptions, STORAGE_WAL};
+use crate::QueuedMessage;
+use std::fs::{self, File, OpenOptions};
+use std::io::{self, BufWriter, Write};
+use std::path::{Path, PathBuf};
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
+
+// Name of the log in the engine's directory, and of the log being written
+// to replace it when it is compacted
+const LOG_FILE: &str = "wal.log";
+const COMPACT_FILE: &str = "wal.log.compact";
+
+// Kinds of record, one per kind of change
+const OP_SUBSCRIBED: u8 = 1;
+const OP_UNSUBSCRIBED: u8 = 2;
+const OP_ENQUEUED: u8 = 3;
+const OP_DEQUEUED: u8 = 4;
+const OP_REMOVED: u8 = 5;
+const OP_RENAMED: u8 = 6;
+// Marks a body encrypted by the cipher, which decrypts to one of the above
+const OP_SEALED: u8 = 0x80;
+
+// Marks a message without a publisher ID
+const NO_PUBLISHER: u32 = u32::MAX;
+
+// Storage engine appending every change to a log file. Opening the log
+// replays it and rewrites it as a snapshot of the state it adds up to.
+//
+// Each record is its length and an FNV-1a checksum of its body, followed by
+// the body: the kind of change and its fields, strings and payloads prefixed
+// with their lengths. Publish times are stored as wall-clock time, since the
+// broker's monotonic clock doesn't carry over between processes. With a
+// cipher set, bodies are written encrypted, and a log holding encrypted
+// records can't be opened without one. Compacting rewrites every record with
+// the cipher set at the time, so records move to a new key, or out of
+// encryption, when the log is next opened.
+pub struct WalEngine {
+    dir: PathBuf,
+    sync: bool,
+    writer: BufWriter<File>,
+}
+
+impl WalEngine {
+    pub fn open(options: &StorageOptions) -> io::Result<Self> {
+        if options.path.is_empty() {
+            return Err(io::Error::new(
+                io::ErrorKind::InvalidInput,
+                "the WAL engine needs a path",
+            ));
+        }
+        let dir = PathBuf::from(&options.path);
+        fs::create_dir_all(&dir)?;
+        Ok(WalEngine {
+            writer: open_log(&dir)?,
+            dir,
+            sync: options.sync,
+        })
+    }
+
+    fn write(&mut self, record: &[u8]) -> io::Result<()> {
+        self.writer.write_all(record)?;
+        self.writer.flush()?;
+        if self.sync {
+            self.writer.get_ref().sync_data()?;
+        }
+        Ok(())
+    }
+}
+
+impl StorageEngine for WalEngine {
+    fn kind(&self) -> u32 {
+        STORAGE_WAL
+    }
+
+    fn record(&mut self, change: &Change) -> io::Result<()> {
+        let record = encode(change)?;
+        self.write(&record)
+    }
+
+    fn recover(&mut self) -> io::Result<Recovered> {
+        let data = fs::read(self.dir.join(LOG_FILE))?;
+        let mut recovered = Recovered::default();
+        let mut rest = &data[..];
+        while !rest.is_empty() {
+            let (len, checksum) = match (take_u32(&mut rest), take_u32(&mut rest)) {
+                (Ok(len), Ok(checksum)) => (len as usize, checksum),
+                _ => {
+                    recovered.corrupted += 1; // Torn header at the end
+                    break;
+                }
+            };
+            if rest.len() < len {
+                recovered.corrupted += 1; // Torn record at the end
+                break;
+            }
+            let (body, next) = rest.split_at(len);
+            rest = next;
+            if fnv1a(body) != checksum {
+                recovered.corrupted += 1;
+                continue;
+            }
+            // A record the cipher can't decrypt isn't damaged, but the key
+            // is wrong or missing, so the log is left as it is
+            let opened;
+            let body = match body.split_first() {
+                Some((&OP_SEALED, sealed)) => {
+                    opened = cipher::open(sealed)?;
+                    &opened[..]
+                }
+                _ => body,
+            };
+            if decode(body, &mut recovered).is_err() {
+                recovered.corrupted += 1;
+            }
+        }
+        Ok(recovered)
+    }
+
+    fn compact(&mut self, snapshot: &[Change]) -> io::Result<()> {
+        let path = self.dir.join(COMPACT_FILE);
+        let mut writer = BufWriter::new(File::create(&path)?);
+        for change in snapshot {
+            writer.write_all(&encode(change)?)?;
+        }
+        writer
+            .into_inner()
+            .map_err(|e| e.into_error())?
+            .sync_all()?;
+
+        fs::rename(&path, self.dir.join(LOG_FILE))?;
+        self.writer = open_log(&self.dir)?;
+        Ok(())
+    }
+}
+
+fn open_log(dir: &Path) -> io::Result<BufWriter<File>> {
+    let file = OpenOptions::new()
+        .create(true)
+        .append(true)
+        .open(dir.join(LOG_FILE))?;
+    Ok(BufWriter::new(file))
+}
+
+fn encode(change: &Change) -> io::Result<Vec<u8>> {
+    let mut body = Vec::new();
+    match *change {
+        Change::Subscribed {
+            subscriber_id,
+            topic,
+        } => {
+            body.push(OP_SUBSCRIBED);
+            put_bytes(&mut body, subscriber_id.as_bytes())?;
+            put_bytes(&mut body, topic.as_bytes())?;
+        }
+        Change::Unsubscribed {
+            subscriber_id,
+            topic,
+        } => {
+            body.push(OP_UNSUBSCRIBED);
+            put_bytes(&mut body, subscriber_id.as_bytes())?;
+            put_bytes(&mut body, topic.as_bytes())?;
+        }
+        Change::Enqueued {
+            subscriber_id,
+            message,
+            front,
+        } => {
+            // The wall-clock time the message was published
+            let published = SystemTime::now() - clock::since(message.published_at);
+            let micros = published
+                .duration_since(UNIX_EPOCH)
+                .unwrap_or_default()
+                .as_micros() as u64;
+
+            body.push(OP_ENQUEUED);
+            put_bytes(&mut body, subscriber_id.as_bytes())?;
+            body.push(front as u8);
+            body.extend_from_slice(&micros.to_le_bytes());
+            put_bytes(&mut body, message.topic.as_bytes())?;
+            match &message.publisher_id {
+                Some(publisher_id) => put_bytes(&mut body, publisher_id.as_bytes())?,
+                None => body.extend_from_slice(&NO_PUBLISHER.to_le_bytes()),
+            }
+            put_bytes(&mut body, &message.message)?;
+        }
+        Change::Dequeued {
+            subscriber_id,
+            index,
+        } => {
+            body.push(OP_DEQUEUED);
+            put_bytes(&mut body, subscriber_id.as_bytes())?;
+            body.extend_from_slice(&(index as u64).to_le_bytes());
+        }
+        Change::Removed { subscriber_id } => {
+            body.push(OP_REMOVED);
+            put_bytes(&mut body, subscriber_id.as_bytes())?;
+        }
+        Change::Renamed { from, to } => {
+            body.push(OP_RENAMED);
+            put_bytes(&mut body, from.as_bytes())?;
+            put_bytes(&mut body, to.as_bytes())?;
+        }
+    }
+
+    if let Some(sealed) = cipher::seal(&body)? {
+        body = Vec::with_capacity(1 + sealed.len());
+        body.push(OP_SEALED);
+        body.extend_from_slice(&sealed);
+    }
+
+    let mut record = Vec::with_capacity(8 + body.len());
+    record.extend_from_slice(&(body.len() as u32).to_le_bytes());
+    record.extend_from_slice(&fnv1a(&body).to_le_bytes());
+    record.extend_from_slice(&body);
+    Ok(record)
+}
+
+// Decode the body of a record and apply its change
+fn decode(mut body: &[u8], recovered: &mut Recovered) -> io::Result<()> {
+    let data = &mut body;
+    let op = take_u8(data)?;
+    let subscriber_id = take_str(data)?;
+    match op {
+        OP_SUBSCRIBED | OP_UNSUBSCRIBED => {
+            let topic = take_str(data)?;
+            let change = if op == OP_SUBSCRIBED {
+                Change::Subscribed {
+                    subscriber_id,
+                    topic,
+                }
+            } else {
+                Change::Unsubscribed {
+                    subscriber_id,
+                    topic,
+                }
+            };
+            recovered.apply(&change);
+        }
+        OP_ENQUEUED => {
+            let front = take_u8(data)? != 0;
+            let micros = take_u64(data)?;
+            let topic = take_str(data)?.to_string();
+            let publisher_id = match take_u32(data)? {
+                NO_PUBLISHER => None,
+                len => Some(take_utf8(data, len as usize)?.to_string()),
+            };
+            let len = take_u32(data)? as usize;
+            let payload = take(data, len)?;
+
+            // Keep the message's age, on the broker's clock
+            let published = UNIX_EPOCH + Duration::from_micros(micros);
+            let age = SystemTime::now()
+                .duration_since(published)
+                .unwrap_or_default();
+            let now = clock::now();
+            let message = QueuedMessage {
+                topic,
+                message: payload.into(),
+                published_at: now.checked_sub(age).unwrap_or(now),
+                publisher_id,
+                attempts: 0,
+                tracking: None,
+            };
+            let change = Change::Enqueued {
+                subscriber_id,
+                message: &message,
+                front,
+            };
+            recovered.apply(&change);
+        }
+        OP_DEQUEUED => {
+            let index = take_u64(data)? as usize;
+            recovered.apply(&Change::Dequeued {
+                subscriber_id,
+                index,
+            });
+        }
+        OP_REMOVED => recovered.apply(&Change::Removed { subscriber_id }),
+        OP_RENAMED => {
+            let to = take_str(data)?;
+            recovered.apply(&Change::Renamed {
+                from: subscriber_id,
+                to,
+            });
+        }
+        _ => return Err(invalid()),
+    }
+    Ok(())
+}
+
+fn put_bytes(body: &mut Vec<u8>, bytes: &[u8]) -> io::Result<()> {
+    let len = u32::try_from(bytes.len())
+        .ok()
+        .filter(|&len| len != NO_PUBLISHER)
+        .ok_or_else(|| io::Error::new(io::ErrorKind::InvalidInput, "record too large"))?;
+    body.extend_from_slice(&len.to_le_bytes());
+    body.extend_from_slice(bytes);
+    Ok(())
+}
+
+fn take<'a>(data: &mut &'a [u8], len: usize) -> io::Result<&'a [u8]> {
+    if data.len() < len {
+        return Err(invalid());
+    }
+    let (bytes, rest) = data.split_at(len);
+    *data = rest;
+    Ok(bytes)
+}
+
+fn take_u8(data: &mut &[u8]) -> io::Result<u8> {
+    Ok(take(data, 1)?[0])
+}
+
+fn take_u32(data: &mut &[u8]) -> io::Result<u32> {
+    Ok(u32::from_le_bytes(take(data, 4)?.try_into().unwrap()))
+}
+
+fn take_u64(data: &mut &[u8]) -> io::Result<u64> {
+    Ok(u64::from_le_bytes(take(data, 8)?.try_into().unwrap()))
+}
+
+fn take_utf8<'a>(data: &mut &'a [u8], len: usize) -> io::Result<&'a str> {
+    std::str::from_utf8(take(data, len)?).map_err(|_| invalid())
+}
+
+// Take a length-prefixed string
+fn take_str<'a>(data: &mut &'a [u8]) -> io::Result<&'a str> {
+    let len = take_u32(data)? as usize;
+    take_utf8(data, len)
+}
+
+// 32-bit FNV-1a hash, enough to tell a damaged record from a good one
+fn fnv1a(bytes: &[u8]) -> u32 {
+    bytes.iter().fold(0x811c_9dc5, |hash, &b| {
+        (hash ^ b as u32).wrapping_mul(0x0100_0193)
+    })
+}
+
+fn invalid() -> io::Error {
+    io::Error::new(io::ErrorKind::InvalidData, "corrupt WAL record")
+}
@@ -0,0 +1,1621 @@
+// A WebAssembly interpreter for the transforms run on the publish path. It
+// runs modules without imports, using the instructions of WebAssembly 1.0 plus
+// sign extension, saturating float to int conversion, multiple results and
+// bulk memory copy and fill. Modules aren't type checked when they are
+// compiled, only decoded; a module that would fail validation traps instead
+// when it misuses its stack, which is all the broker needs from it.
+use std::collections::HashMap;
+use std::sync::Arc;
+
+// Size of a page of linear memory
+const PAGE_SIZE: usize = 65536;
+
+// Most pages of memory an instance may have, whatever its module declares
+pub const MAX_PAGES: u32 = 256;
+
+// Deepest a call stack may get before the call traps
+const MAX_CALL_DEPTH: u32 = 512;
+
+// Most values the operand stack and locals of all frames may hold together
+const MAX_STACK: usize = 1 << 20;
+
+#[derive(Clone, Copy, PartialEq, Eq, Debug)]
+pub enum ValType {
+    I32,
+    I64,
+    F32,
+    F64,
+}
+
+#[derive(Clone, PartialEq, Eq, Debug)]
+pub struct FuncType {
+    pub params: Vec<ValType>,
+    pub results: Vec<ValType>,
+}
+
+// A function's code, with the matching end, and else for an if, of each of
+// its blocks by the offset of the block's opcode
+struct Function {
+    type_index: u32,
+    locals: usize,
+    code: Vec<u8>,
+    blocks: HashMap<usize, (usize, Option<usize>)>,
+}
+
+struct Global {
+    mutable: bool,
+    init: u64,
+}
+
+#[derive(Clone, Copy)]
+enum Export {
+    Func(u32),
+    Memory,
+    Global(u32),
+    Table,
+}
+
+// A compiled module, shared by the instances made from it
+pub struct Module {
+    types: Vec<FuncType>,
+    functions: Vec<Function>,
+    table_size: Option<u32>,
+    memory: Option<(u32, Option<u32>)>,
+    globals: Vec<Global>,
+    exports: HashMap<String, Export>,
+    elements: Vec<(u32, Vec<u32>)>,
+    data: Vec<(u32, Vec<u8>)>,
+    start: Option<u32>,
+}
+
+// Reads the binary format
+struct Reader<'a> {
+    bytes: &'a [u8],
+    pos: usize,
+}
+
+impl<'a> Reader<'a> {
+    fn new(bytes: &'a [u8]) -> Self {
+        Reader { bytes, pos: 0 }
+    }
+
+    fn at_end(&self) -> bool {
+        self.pos >= self.bytes.len()
+    }
+
+    fn byte(&mut self) -> Result<u8, String> {
+        let b = *self
+            .bytes
+            .get(self.pos)
+            .ok_or_else(|| "unexpected end of module".to_string())?;
+        self.pos += 1;
+        Ok(b)
+    }
+
+    fn take(&mut self, len: usize) -> Result<&'a [u8], String> {
+        let end = self
+            .pos
+            .checked_add(len)
+            .filter(|&end| end <= self.bytes.len())
+            .ok_or_else(|| "unexpected end of module".to_string())?;
+        let taken = &self.bytes[self.pos..end];
+        self.pos = end;
+        Ok(taken)
+    }
+
+    // Read an unsigned LEB128 integer of up to bits bits
+    fn unsigned(&mut self, bits: u32) -> Result<u64, String> {
+        let mut result = 0u64;
+        let mut shift = 0;
+        loop {
+            let b = self.byte()?;
+            result |= ((b & 0x7f) as u64) << shift;
+            shift += 7;
+            if b & 0x80 == 0 {
+                break;
+            }
+            if shift >= bits {
+                return Err("integer too long".to_string());
+            }
+        }
+        if bits < 64 && result >> bits != 0 {
+            return Err("integer too large".to_string());
+        }
+        Ok(result)
+    }
+
+    // Read a signed LEB128 integer of up to bits bits
+    fn signed(&mut self, bits: u32) -> Result<i64, String> {
+        let longest = (bits + 6) / 7 * 7;
+        let mut result = 0i64;
+        let mut shift = 0;
+        let mut b;
+        loop {
+            b = self.byte()?;
+            if shift < 64 {
+                result |= ((b & 0x7f) as i64) << shift;
+            }
+            shift += 7;
+            if b & 0x80 == 0 {
+                break;
+            }
+            if shift >= longest {
+                return Err("integer too long".to_string());
+            }
+        }
+        if shift < 64 && b & 0x40 != 0 {
+            result |= -1i64 << shift;
+        }
+        if bits < 64 && (result < -(1i64 << (bits - 1)) || result >= 1i64 << (bits - 1)) {
+            return Err("integer too large".to_string());
+        }
+        Ok(result)
+    }
+
+    fn u32(&mut self) -> Result<u32, String> {
+        self.unsigned(32).map(|v| v as u32)
+    }
+
+    fn len(&mut self) -> Result<usize, String> {
+        let len = self.u32()? as usize;
+        // Every element takes at least a byte
+        if len > self.bytes.len() - self.pos {
+            return Err("length out of bounds".to_string());
+        }
+        Ok(len)
+    }
+
+    fn name(&mut self) -> Result<String, String> {
+        let len = self.len()?;
+        String::from_utf8(self.take(len)?.to_vec()).map_err(|_| "invalid name".to_string())
+    }
+
+    fn val_type(&mut self) -> Result<ValType, String> {
+        match self.byte()? {
+            0x7f => Ok(ValType::I32),
+            0x7e => Ok(ValType::I64),
+            0x7d => Ok(ValType::F32),
+            0x7c => Ok(ValType::F64),
+            b => Err(format!("unsupported value type 0x{:02x}", b)),
+        }
+    }
+
+    fn limits(&mut self) -> Result<(u32, Option<u32>), String> {
+        match self.byte()? {
+            0x00 => Ok((self.u32()?, None)),
+            0x01 => Ok((self.u32()?, Some(self.u32()?))),
+            _ => Err("unsupported limits".to_string()),
+        }
+    }
+
+    fn memarg(&mut self) -> Result<u32, String> {
+        self.u32()?; // Alignment is only a hint
+        self.u32()
+    }
+}
+
+impl Module {
+    // Decode a module, checking that everything it refers to exists
+    pub fn compile(bytes: &[u8]) -> Result<Module, String> {
+        let mut r = Reader::new(bytes);
+        if r.take(4).ok() != Some(&b"\0asm"[..]) {
+            return Err("not a WebAssembly module".to_string());
+        }
+        if r.take(4)? != [1, 0, 0, 0] {
+            return Err("unsupported WebAssembly version".to_string());
+        }
+
+        let mut module = Module {
+            types: Vec::new(),
+            functions: Vec::new(),
+            table_size: None,
+            memory: None,
+            globals: Vec::new(),
+            exports: HashMap::new(),
+            elements: Vec::new(),
+            data: Vec::new(),
+            start: None,
+        };
+        let mut function_types = Vec::new();
+        let mut bodies: Vec<&[u8]> = Vec::new();
+
+        while !r.at_end() {
+            let id = r.byte()?;
+            let len = r.len()?;
+            let mut s = Reader::new(r.take(len)?);
+            match id {
+                0 | 12 => continue, // Custom sections and the data count
+                1 => {
+                    for _ in 0..s.len()? {
+                        if s.byte()? != 0x60 {
+                            return Err("invalid function type".to_string());
+                        }
+                        let params = (0..s.len()?)
+                            .map(|_| s.val_type())
+                            .collect::<Result<_, _>>()?;
+                        let results = (0..s.len()?)
+                            .map(|_| s.val_type())
+                            .collect::<Result<_, _>>()?;
+                        module.types.push(FuncType { params, results });
+                    }
+                }
+                2 => {
+                    if s.len()? > 0 {
+                        return Err("modules can't have imports".to_string());
+                    }
+                }
+                3 => {
+                    for _ in 0..s.len()? {
+                        function_types.push(s.u32()?);
+                    }
+                }
+                4 => {
+                    for _ in 0..s.len()? {
+                        if s.byte()? != 0x70 || module.table_size.is_some() {
+                            return Err("only one table of functions is supported".to_string());
+                        }
+                        module.table_size = Some(s.limits()?.0);
+                    }
+                }
+                5 => {
+                    for _ in 0..s.len()? {
+                        if module.memory.is_some() {
+                            return Err("only one memory is supported".to_string());
+                        }
+                        module.memory = Some(s.limits()?);
+                    }
+                }
+                6 => {
+                    for _ in 0..s.len()? {
+                        s.val_type()?;
+                        let mutable = s.byte()? == 1;
+                        let init = module.const_expr(&mut s)?;
+                        module.globals.push(Global { mutable, init });
+                    }
+                }
+                7 => {
+                    for _ in 0..s.len()? {
+                        let name = s.name()?;
+                        let kind = s.byte()?;
+                        let index = s.u32()?;
+                        let export = match kind {
+                            0 => Export::Func(index),
+                            1 => Export::Table,
+                            2 => Export::Memory,
+                            3 => Export::Global(index),
+                            _ => return Err("invalid export".to_string()),
+                        };
+                        module.exports.insert(name, export);
+                    }
+                }
+                8 => module.start = Some(s.u32()?),
+                9 => {
+                    for _ in 0..s.len()? {
+                        if s.u32()? != 0 {
+                            return Err("only active element segments are supported".to_string());
+                        }
+                        let offset = module.const_expr(&mut s)? as u32;
+                        let functions = (0..s.len()?).map(|_| s.u32()).collect::<Result<_, _>>()?;
+                        module.elements.push((offset, functions));
+                    }
+                }
+                10 => {
+                    for _ in 0..s.len()? {
+                        let len = s.len()?;
+                        bodies.push(s.take(len)?);
+                    }
+                }
+                11 => {
+                    for _ in 0..s.len()? {
+                        if s.u32()? != 0 {
+                            return Err("only active data segments are supported".to_string());
+                        }
+                        let offset = module.const_expr(&mut s)? as u32;
+                        let len = s.len()?;
+                        module.data.push((offset, s.take(len)?.to_vec()));
+                    }
+                }
+                _ => return Err(format!("unknown section {}", id)),
+            }
+            if !s.at_end() {
+                return Err(format!("malformed section {}", id));
+            }
+        }
+
+        if function_types.len() != bodies.len() {
+            return Err("function and code sections don't match".to_string());
+        }
+        let functions = function_types.len() as u32;
+        for (type_index, body) in function_types.into_iter().zip(bodies) {
+            if type_index as usize >= module.types.len() {
+                return Err("unknown function type".to_string());
+            }
+            let function = module.decode_function(type_index, body, functions)?;
+            module.functions.push(function);
+        }
+
+        for export in module.exports.values() {
+            let valid = match *export {
+                Export::Func(index) => index < functions,
+                Export::Global(index) => (index as usize) < module.globals.len(),
+                Export::Memory => module.memory.is_some(),
+                Export::Table => module.table_size.is_some(),
+            };
+            if !valid {
+                return Err("export of an unknown item".to_string());
+            }
+        }
+        if module.start.map_or(false, |start| start >= functions) {
+            return Err("unknown start function".to_string());
+        }
+        if module
+            .elements
+            .iter()
+            .any(|(_, fs)| module.table_size.is_none() || fs.iter().any(|&f| f >= functions))
+        {
+            return Err("element segment refers to an unknown table or function".to_string());
+        }
+        if !module.data.is_empty() && module.memory.is_none() {
+            return Err("data segment without a memory".to_string());
+        }
+        if let Some((min, max)) = module.memory {
+            if min > MAX_PAGES || max.map_or(false, |max| max < min) {
+                return Err(format!("memory is larger than {} pages", MAX_PAGES));
+            }
+        }
+
+        Ok(module)
+    }
+
+    // Evaluate a constant expression: a constant or an earlier global
+    fn const_expr(&self, r: &mut Reader) -> Result<u64, String> {
+        let value = match r.byte()? {
+            0x41 => r.signed(32)? as u32 as u64,
+            0x42 => r.signed(64)? as u64,
+            0x43 => u32::from_le_bytes(r.take(4)?.try_into().unwrap()) as u64,
+            0x44 => u64::from_le_bytes(r.take(8)?.try_into().unwrap()),
+            0x23 => {
+                let index = r.u32()? as usize;
+                self.globals
+                    .get(index)
+                    .ok_or_else(|| "unknown global".to_string())?
+                    .init
+            }
+            _ => return Err("unsupported constant expression".to_string()),
+        };
+        if r.byte()? != 0x0b {
+            return Err("unsupported constant expression".to_string());
+        }
+        Ok(value)
+    }
+
+    fn block_type(&self, r: &mut Reader) -> Result<(usize, usize), String> {
+        match r.bytes.get(r.pos) {
+            Some(0x40) => {
+                r.pos += 1;
+                Ok((0, 0))
+            }
+            Some(0x7f | 0x7e | 0x7d | 0x7c) => {
+                r.pos += 1;
+                Ok((0, 1))
+            }
+            _ => {
+                let index = r.signed(33)?;
+                let ty = usize::try_from(index)
+                    .ok()
+                    .and_then(|i| self.types.get(i))
+                    .ok_or_else(|| "unknown block type".to_string())?;
+                Ok((ty.params.len(), ty.results.len()))
+            }
+        }
+    }
+
+    // Decode a function body, recording where each block ends and checking
+    // the instructions and what they refer to
+    fn decode_function(
+        &self,
+        type_index: u32,
+        body: &[u8],
+        functions: u32,
+    ) -> Result<Function, String> {
+        let mut r = Reader::new(body);
+        let mut locals = self.types[type_index as usize].params.len();
+        for _ in 0..r.len()? {
+            let count = r.u32()? as usize;
+            r.val_type()?;
+            locals = locals
+                .checked_add(count)
+                .filter(|&n| n <= 50_000)
+                .ok_or_else(|| "too many locals".to_string())?;
+        }
+        let code = body[r.pos..].to_vec();
+        let mut r = Reader::new(&code);
+
+        let mut blocks = HashMap::new();
+        let mut open: Vec<(usize, Option<usize>)> = Vec::new();
+        let mut ended = false;
+        while !r.at_end() {
+            if ended {
+                return Err("code after the end of a function".to_string());
+            }
+            let at = r.pos;
+            let op = r.byte()?;
+            match op {
+                0x00 | 0x01 | 0x0f | 0x1a | 0x1b => {}
+                0x02..=0x04 => {
+                    self.block_type(&mut r)?;
+                    open.push((at, None));
+                }
+                0x05 => match open.last_mut() {
+                    Some((start, otherwise)) if code[*start] == 0x04 && otherwise.is_none() => {
+                        *otherwise = Some(at)
+                    }
+                    _ => return Err("else outside an if".to_string()),
+                },
+                0x0b => match open.pop() {
+                    Some((start, otherwise)) => {
+                        blocks.insert(start, (at, otherwise));
+                    }
+                    None => ended = true,
+                },
+                0x0c | 0x0d => {
+                    if r.u32()? as usize > open.len() {
+                        return Err("branch to an unknown label".to_string());
+                    }
+                }
+                0x0e => {
+                    for _ in 0..=r.len()? {
+                        if r.u32()? as usize > open.len() {
+                            return Err("branch to an unknown label".to_string());
+                        }
+                    }
+                }
+                0x10 => {
+                    if r.u32()? >= functions {
+                        return Err("call of an unknown function".to_string());
+                    }
+                }
+                0x11 => {
+                    if r.u32()? as usize >= self.types.len() || r.byte()? != 0 {
+                        return Err("invalid indirect call".to_string());
+                    }
+                    if self.table_size.is_none() {
+                        return Err("indirect call without a table".to_string());
+                    }
+                }
+                0x1c => {
+                    if r.u32()? != 1 {
+                        return Err("invalid select".to_string());
+                    }
+                    r.val_type()?;
+                }
+                0x20..=0x22 => {
+                    if r.u32()? as usize >= locals {
+                        return Err("unknown local".to_string());
+                    }
+                }
+                0x23 | 0x24 => {
+                    let index = r.u32()? as usize;
+                    match self.globals.get(index) {
+                        Some(global) if op == 0x23 || global.mutable => {}
+                        Some(_) => return Err("set of an immutable global".to_string()),
+                        None => return Err("unknown global".to_string()),
+                    }
+                }
+                0x28..=0x3e => {
+                    self.need_memory()?;
+                    r.memarg()?;
+                }
+                0x3f | 0x40 => {
+                    self.need_memory()?;
+                    if r.byte()? != 0 {
+                        return Err("unknown memory".to_string());
+                    }
+                }
+                0x41 => {
+                    r.signed(32)?;
+                }
+                0x42 => {
+                    r.signed(64)?;
+                }
+                0x43 => {
+                    r.take(4)?;
+                }
+                0x44 => {
+                    r.take(8)?;
+                }
+                0x45..=0xc4 => {}
+                0xfc => match r.u32()? {
+                    0..=7 => {}
+                    10 => {
+                        self.need_memory()?;
+                        if r.byte()? != 0 || r.byte()? != 0 {
+                            return Err("unknown memory".to_string());
+                        }
+                    }
+                    11 => {
+                        self.need_memory()?;
+                        if r.byte()? != 0 {
+                            return Err("unknown memory".to_string());
+                        }
+                    }
+                    sub => return Err(format!("unsupported instruction 0xfc {}", sub)),
+                },
+                _ => return Err(format!("unsupported instruction 0x{:02x}", op)),
+            }
+        }
+        if !ended {
+            return Err("function doesn't end".to_string());
+        }
+
+        Ok(Function {
+            type_index,
+            locals: locals - self.types[type_index as usize].params.len(),
+            code,
+            blocks,
+        })
+    }
+
+    fn need_memory(&self) -> Result<(), String> {
+        match self.memory {
+            Some(_) => Ok(()),
+            None => Err("memory instruction without a memory".to_string()),
+        }
+    }
+
+    // The type of an exported function, if the module exports one by that name
+    pub fn export_type(&self, name: &str) -> Option<&FuncType> {
+        match self.exports.get(name)? {
+            Export::Func(index) => Some(self.func_type(*index)),
+            _ => None,
+        }
+    }
+
+    // Whether the module exports its memory by that name
+    pub fn exports_memory(&self, name: &str) -> bool {
+        matches!(self.exports.get(name), Some(Export::Memory))
+    }
+
+    fn func_type(&self, index: u32) -> &FuncType {
+        &self.types[self.functions[index as usize].type_index as usize]
+    }
+}
+
+// A block being run, and where a branch to it continues
+struct Label {
+    // A branch to a loop starts it again; to anything else it leaves it
+    is_loop: bool,
+    target: usize,
+    height: usize,
+    arity: usize,
+}
+
+fn trap<T>(reason: &str) -> Result<T, String> {
+    Err(reason.to_string())
+}
+
+// A module's memory, globals and table, in which its functions run
+pub struct Instance {
+    module: Arc<Module>,
+    memory: Vec<u8>,
+    max_pages: u32,
+    globals: Vec<u64>,
+    table: Vec<Option<u32>>,
+    fuel: u64,
+    depth: u32,
+}
+
+impl Instance {
+    // Instantiate a module, running its start function with the fuel given
+    pub fn new(module: Arc<Module>, fuel: u64) -> Result<Instance, String> {
+        let (min, max) = module.memory.unwrap_or((0, Some(0)));
+        let mut instance = Instance {
+            memory: vec![0; min as usize * PAGE_SIZE],
+            max_pages: max.map_or(MAX_PAGES, |max| max.min(MAX_PAGES)),
+            globals: module.globals.iter().map(|g| g.init).collect(),
+            table: vec![None; module.table_size.unwrap_or(0) as usize],
+            fuel,
+            depth: 0,
+            module: module.clone(),
+        };
+
+        for (offset, functions) in &module.elements {
+            let start = *offset as usize;
+            let slots = instance
+                .table
+                .get_mut(start..start + functions.len())
+                .ok_or_else(|| "element segment out of bounds".to_string())?;
+            for (slot, &function) in slots.iter_mut().zip(functions) {
+                *slot = Some(function);
+            }
+        }
+        for (offset, bytes) in &module.data {
+            let start = *offset as usize;
+            instance
+                .memory
+                .get_mut(start..start + bytes.len())
+                .ok_or_else(|| "data segment out of bounds".to_string())?
+                .copy_from_slice(bytes);
+        }
+
+        if let Some(start) = module.start {
+            instance.call_index(start, &[])?;
+        }
+        Ok(instance)
+    }
+
+    // Fuel left over from the last call
+    pub fn fuel(&self) -> u64 {
+        self.fuel
+    }
+
+    pub fn memory(&self) -> &[u8] {
+        &self.memory
+    }
+
+    pub fn memory_mut(&mut self) -> &mut [u8] {
+        &mut self.memory
+    }
+
+    // Call an exported function with up to the fuel given, which is used up
+    // at one unit per instruction
+    pub fn call(&mut self, name: &str, args: &[u64], fuel: u64) -> Result<Vec<u64>, String> {
+        let index = match self.module.exports.get(name) {
+            Some(Export::Func(index)) => *index,
+            _ => return Err(format!("no function '{}' is exported", name)),
+        };
+        self.fuel = fuel;
+        self.depth = 0;
+        self.call_index(index, args)
+    }
+
+    fn call_index(&mut self, index: u32, args: &[u64]) -> Result<Vec<u64>, String> {
+        if args.len() != self.module.func_type(index).params.len() {
+            return Err("wrong number of arguments".to_string());
+        }
+        let mut stack = args.to_vec();
+        self.run(index, &mut stack)?;
+        Ok(stack)
+    }
+
+    // Run a function, taking its parameters from the top of the stack and
+    // leaving its results there
+    fn run(&mut self, index: u32, stack: &mut Vec<u64>) -> Result<(), String> {
+        self.depth += 1;
+        if self.depth > MAX_CALL_DEPTH {
+            return trap("call stack exhausted");
+        }
+        let module = self.module.clone();
+        let function = &module.functions[index as usize];
+        let ty = module.func_type(index);
+        let params = ty.params.len();
+        if stack.len() < params {
+            return trap("stack underflow");
+        }
+        let mut locals = stack.split_off(stack.len() - params);
+        locals.resize(params + function.locals, 0);
+        if stack.len() + locals.len() > MAX_STACK {
+            return trap("stack exhausted");
+        }
+
+        let code = &function.code[..];
+        let base = stack.len();
+        let mut labels = vec![Label {
+            is_loop: false,
+            target: code.len(),
+            height: base,
+            arity: ty.results.len(),
+        }];
+        let mut r = Reader::new(code);
+
+        macro_rules! pop {
+            () => {
+                match stack.pop() {
+                    Some(v) if stack.len() >= base => v,
+                    _ => return trap("stack underflow"),
+                }
+            };
+        }
+        // Evaluates the value before borrowing the stack, which it may pop
+        macro_rules! push {
+            ($v:expr) => {{
+                let v = $v;
+                stack.push(v)
+            }};
+        }
+        macro_rules! pop32 {
+            () => {
+                pop!() as u32
+            };
+        }
+        macro_rules! push32 {
+            ($v:expr) => {
+                push!(($v) as u32 as u64)
+            };
+        }
+        macro_rules! push_bool {
+            ($v:expr) => {
+                push!(($v) as u64)
+            };
+        }
+        macro_rules! unop32 {
+            ($f:expr) => {{
+                let a = pop32!();
+                push32!($f(a))
+            }};
+        }
+        macro_rules! binop32 {
+            ($f:expr) => {{
+                let b = pop32!();
+                let a = pop32!();
+                push32!($f(a, b))
+            }};
+        }
+        macro_rules! unop64 {
+            ($f:expr) => {{
+                let a = pop!();
+                push!($f(a))
+            }};
+        }
+        macro_rules! binop64 {
+            ($f:expr) => {{
+                let b = pop!();
+                let a = pop!();
+                push!($f(a, b))
+            }};
+        }
+        macro_rules! cmp32 {
+            ($f:expr) => {{
+                let b = pop32!();
+                let a = pop32!();
+                push_bool!($f(a, b))
+            }};
+        }
+        macro_rules! cmp64 {
+            ($f:expr) => {{
+                let b = pop!();
+                let a = pop!();
+                push_bool!($f(a, b))
+            }};
+        }
+        macro_rules! f32op {
+            ($f:expr) => {{
+                let a = f32::from_bits(pop32!());
+                push32!(($f(a) as f32).to_bits())
+            }};
+        }
+        macro_rules! f32binop {
+            ($f:expr) => {{
+                let b = f32::from_bits(pop32!());
+                let a = f32::from_bits(pop32!());
+                push32!(($f(a, b) as f32).to_bits())
+            }};
+        }
+        macro_rules! f32cmp {
+            ($f:expr) => {{
+                let b = f32::from_bits(pop32!());
+                let a = f32::from_bits(pop32!());
+                push_bool!($f(a, b))
+            }};
+        }
+        macro_rules! f64op {
+            ($f:expr) => {{
+                let a = f64::from_bits(pop!());
+                push!(($f(a) as f64).to_bits())
+            }};
+        }
+        macro_rules! f64binop {
+            ($f:expr) => {{
+                let b = f64::from_bits(pop!());
+                let a = f64::from_bits(pop!());
+                push!(($f(a, b) as f64).to_bits())
+            }};
+        }
+        macro_rules! f64cmp {
+            ($f:expr) => {{
+                let b = f64::from_bits(pop!());
+                let a = f64::from_bits(pop!());
+                push_bool!($f(a, b))
+            }};
+        }
+        macro_rules! load {
+            ($n:expr, $convert:expr) => {{
+                let offset = r.memarg()?;
+                let at = self.address(pop32!(), offset, $n)?;
+                let mut bytes = [0u8; 8];
+                bytes[..$n].copy_from_slice(&self.memory[at..at + $n]);
+                push!($convert(u64::from_le_bytes(bytes)))
+            }};
+        }
+        macro_rules! store {
+            ($n:expr) => {{
+                let offset = r.memarg()?;
+                let value = pop!();
+                let at = self.address(pop32!(), offset, $n)?;
+                self.memory[at..at + $n].copy_from_slice(&value.to_le_bytes()[..$n]);
+            }};
+        }
+
+        loop {
+            if self.fuel == 0 {
+                return trap("out of fuel");
+            }
+            self.fuel -= 1;
+
+            let at = r.pos;
+            let op = r.byte()?;
+            match op {
+                0x00 => return trap("unreachable"),
+                0x01 => {}
+                0x02 | 0x03 => {
+                    let (params, results) = module.block_type(&mut r)?;
+                    if stack.len() < base + params {
+                        return trap("stack underflow");
+                    }
+                    let is_loop = op == 0x03;
+                    labels.push(Label {
+                        is_loop,
+                        target: if is_loop {
+                            r.pos
+                        } else {
+                            function.blocks[&at].0 + 1
+                        },
+                        height: stack.len() - params,
+                        arity: if is_loop { params } else { results },
+                    });
+                }
+                0x04 => {
+                    let (params, results) = module.block_type(&mut r)?;
+                    let condition = pop32!();
+                    if stack.len() < base + params {
+                        return trap("stack underflow");
+                    }
+                    let (end, otherwise) = function.blocks[&at];
+                    if condition != 0 || otherwise.is_some() {
+                        labels.push(Label {
+                            is_loop: false,
+                            target: end + 1,
+                            height: stack.len() - params,
+                            arity: results,
+                        });
+                    }
+                    if condition == 0 {
+                        r.pos = match otherwise {
+                            Some(otherwise) => otherwise + 1,
+                            None => end + 1,
+                        };
+                    }
+                }
+                // The end of an if's first arm leaves the if
+                0x05 => {
+                    self.branch(0, &mut labels, stack, &mut r)?;
+                }
+                0x0b => {
+                    let label = labels.pop().unwrap();
+                    keep(stack, label.height, label.arity)?;
+                    if labels.is_empty() {
+                        break;
+                    }
+                }
+                0x0c => {
+                    let depth = r.u32()?;
+                    if self.branch(depth, &mut labels, stack, &mut r)? {
+                        break;
+                    }
+                }
+                0x0d => {
+                    let depth = r.u32()?;
+                    if pop32!() != 0 && self.branch(depth, &mut labels, stack, &mut r)? {
+                        break;
+                    }
+                }
+                0x0e => {
+                    let count = r.len()?;
+                    let mut depths = Vec::with_capacity(count + 1);
+                    for _ in 0..=count {
+                        depths.push(r.u32()?);
+                    }
+                    let i = pop32!() as usize;
+                    let depth = depths[i.min(count)];
+                    if self.branch(depth, &mut labels, stack, &mut r)? {
+                        break;
+                    }
+                }
+                0x0f => {
+                    let depth = labels.len() as u32 - 1;
+                    self.branch(depth, &mut labels, stack, &mut r)?;
+                    break;
+                }
+                0x10 => {
+                    let callee = r.u32()?;
+                    if callee as usize >= module.functions.len() {
+                        return trap("call of an unknown function");
+                    }
+                    self.run(callee, stack)?;
+                }
+                0x11 => {
+                    let type_index = r.u32()?;
+                    r.byte()?;
+                    let slot = pop32!() as usize;
+                    let callee = match self.table.get(slot) {
+                        Some(Some(callee)) => *callee,
+                        Some(None) => return trap("uninitialized table element"),
+                        None => return trap("undefined table element"),
+                    };
+                    if *module.func_type(callee) != module.types[type_index as usize] {
+                        return trap("indirect call type mismatch");
+                    }
+                    self.run(callee, stack)?;
+                }
+                0x1a => {
+                    pop!();
+                }
+                0x1b | 0x1c => {
+                    if op == 0x1c {
+                        r.u32()?;
+                        r.byte()?;
+                    }
+                    let condition = pop32!();
+                    let b = pop!();
+                    let a = pop!();
+                    push!(if condition != 0 { a } else { b });
+                }
+                0x20 => {
+                    let i = r.u32()? as usize;
+                    push!(locals[i]);
+                }
+                0x21 => {
+                    let i = r.u32()? as usize;
+                    locals[i] = pop!();
+                }
+                0x22 => {
+                    let i = r.u32()? as usize;
+                    let v = pop!();
+                    locals[i] = v;
+                    push!(v);
+                }
+                0x23 => {
+                    let i = r.u32()? as usize;
+                    push!(self.globals[i]);
+                }
+                0x24 => {
+                    let i = r.u32()? as usize;
+                    self.globals[i] = pop!();
+                }
+                0x28 => load!(4, |v| v),
+                0x29 => load!(8, |v| v),
+                0x2a => load!(4, |v| v),
+                0x2b => load!(8, |v| v),
+                0x2c => load!(1, |v| v as i8 as i32 as u32 as u64),
+                0x2d => load!(1, |v| v),
+                0x2e => load!(2, |v| v as i16 as i32 as u32 as u64),
+                0x2f => load!(2, |v| v),
+                0x30 => load!(1, |v| v as i8 as i64 as u64),
+                0x31 => load!(1, |v| v),
+                0x32 => load!(2, |v| v as i16 as i64 as u64),
+                0x33 => load!(2, |v| v),
+                0x34 => load!(4, |v| v as i32 as i64 as u64),
+                0x35 => load!(4, |v| v),
+                0x36 | 0x38 | 0x3e => store!(4),
+                0x37 | 0x39 => store!(8),
+                0x3a | 0x3c => store!(1),
+                0x3b | 0x3d => store!(2),
+                0x3f => {
+                    r.byte()?;
+                    push32!(self.memory.len() / PAGE_SIZE);
+                }
+                0x40 => {
+                    r.byte()?;
+                    let delta = pop32!();
+                    let pages = (self.memory.len() / PAGE_SIZE) as u32;
+                    match pages.checked_add(delta).filter(|&n| n <= self.max_pages) {
+                        Some(n) => {
+                            self.memory.resize(n as usize * PAGE_SIZE, 0);
+                            push32!(pages);
+                        }
+                        None => push32!(u32::MAX),
+                    }
+                }
+                0x41 => push32!(r.signed(32)?),
+                0x42 => push!(r.signed(64)? as u64),
+                0x43 => push32!(u32::from_le_bytes(r.take(4)?.try_into().unwrap())),
+                0x44 => push!(u64::from_le_bytes(r.take(8)?.try_into().unwrap())),
+
+                0x45 => unop32!(|a| (a == 0) as u32),
+                0x46 => cmp32!(|a, b| a == b),
+                0x47 => cmp32!(|a, b| a != b),
+                0x48 => cmp32!(|a: u32, b: u32| (a as i32) < (b as i32)),
+                0x49 => cmp32!(|a, b| a < b),
+                0x4a => cmp32!(|a: u32, b: u32| (a as i32) > (b as i32)),
+                0x4b => cmp32!(|a, b| a > b),
+                0x4c => cmp32!(|a: u32, b: u32| (a as i32) <= (b as i32)),
+                0x4d => cmp32!(|a, b| a <= b),
+                0x4e => cmp32!(|a: u32, b: u32| (a as i32) >= (b as i32)),
+                0x4f => cmp32!(|a, b| a >= b),
+
+                0x50 => unop64!(|a| (a == 0) as u64),
+                0x51 => cmp64!(|a, b| a == b),
+                0x52 => cmp64!(|a, b| a != b),
+                0x53 => cmp64!(|a: u64, b: u64| (a as i64) < (b as i64)),
+                0x54 => cmp64!(|a, b| a < b),
+                0x55 => cmp64!(|a: u64, b: u64| (a as i64) > (b as i64)),
+                0x56 => cmp64!(|a, b| a > b),
+                0x57 => cmp64!(|a: u64, b: u64| (a as i64) <= (b as i64)),
+                0x58 => cmp64!(|a, b| a <= b),
+                0x59 => cmp64!(|a: u64, b: u64| (a as i64) >= (b as i64)),
+                0x5a => cmp64!(|a, b| a >= b),
+
+                0x5b => f32cmp!(|a, b| a == b),
+                0x5c => f32cmp!(|a, b| a != b),
+                0x5d => f32cmp!(|a, b| a < b),
+                0x5e => f32cmp!(|a, b| a > b),
+                0x5f => f32cmp!(|a, b| a <= b),
+                0x60 => f32cmp!(|a, b| a >= b),
+                0x61 => f64cmp!(|a, b| a == b),
+                0x62 => f64cmp!(|a, b| a != b),
+                0x63 => f64cmp!(|a, b| a < b),
+                0x64 => f64cmp!(|a, b| a > b),
+                0x65 => f64cmp!(|a, b| a <= b),
+                0x66 => f64cmp!(|a, b| a >= b),
+
+                0x67 => unop32!(u32::leading_zeros),
+                0x68 => unop32!(u32::trailing_zeros),
+                0x69 => unop32!(u32::count_ones),
+                0x6a => binop32!(u32::wrapping_add),
+                0x6b => binop32!(u32::wrapping_sub),
+                0x6c => binop32!(u32::wrapping_mul),
+                0x6d => {
+                    let b = pop32!() as i32;
+                    let a = pop32!() as i32;
+                    push32!(signed_div(a as i64, b as i64, i32::MIN as i64)?);
+                }
+                0x6e => {
+                    let b = pop32!();
+                    let a = pop32!();
+                    push32!(a.checked_div(b).ok_or("integer divide by zero")?);
+                }
+                0x6f => {
+                    let b = pop32!() as i32;
+                    let a = pop32!() as i32;
+                    if b == 0 {
+                        return trap("integer divide by zero");
+                    }
+                    push32!(a.wrapping_rem(b));
+                }
+                0x70 => {
+                    let b = pop32!();
+                    let a = pop32!();
+                    push32!(a.checked_rem(b).ok_or("integer divide by zero")?);
+                }
+                0x71 => binop32!(|a, b| a & b),
+                0x72 => binop32!(|a, b| a | b),
+                0x73 => binop32!(|a, b| a ^ b),
+                0x74 => binop32!(u32::wrapping_shl),
+                0x75 => binop32!(|a: u32, b| (a as i32).wrapping_shr(b)),
+                0x76 => binop32!(u32::wrapping_shr),
+                0x77 => binop32!(u32::rotate_left),
+                0x78 => binop32!(u32::rotate_right),
+
+                0x79 => unop64!(|a: u64| a.leading_zeros() as u64),
+                0x7a => unop64!(|a: u64| a.trailing_zeros() as u64),
+                0x7b => unop64!(|a: u64| a.count_ones() as u64),
+                0x7c => binop64!(u64::wrapping_add),
+                0x7d => binop64!(u64::wrapping_sub),
+                0x7e => binop64!(u64::wrapping_mul),
+                0x7f => {
+                    let b = pop!() as i64;
+                    let a = pop!() as i64;
+                    push!(signed_div(a, b, i64::MIN)? as u64);
+                }
+                0x80 => {
+                    let b = pop!();
+                    let a = pop!();
+                    push!(a.checked_div(b).ok_or("integer divide by zero")?);
+                }
+                0x81 => {
+                    let b = pop!() as i64;
+                    let a = pop!() as i64;
+                    if b == 0 {
+                        return trap("integer divide by zero");
+                    }
+                    push!(a.wrapping_rem(b) as u64);
+                }
+                0x82 => {
+                    let b = pop!();
+                    let a = pop!();
+                    push!(a.checked_rem(b).ok_or("integer divide by zero")?);
+                }
+                0x83 => binop64!(|a, b| a & b),
+                0x84 => binop64!(|a, b| a | b),
+                0x85 => binop64!(|a, b| a ^ b),
+                0x86 => binop64!(|a: u64, b: u64| a.wrapping_shl(b as u32)),
+                0x87 => binop64!(|a: u64, b: u64| (a as i64).wrapping_shr(b as u32) as u64),
+                0x88 => binop64!(|a: u64, b: u64| a.wrapping_shr(b as u32)),
+                0x89 => binop64!(|a: u64, b: u64| a.rotate_left((b % 64) as u32)),
+                0x8a => binop64!(|a: u64, b: u64| a.rotate_right((b % 64) as u32)),
+
+                0x8b => f32op!(f32::abs),
+                0x8c => f32op!(|a: f32| -a),
+                0x8d => f32op!(f32::ceil),
+                0x8e => f32op!(f32::floor),
+                0x8f => f32op!(f32::trunc),
+                0x90 => f32op!(f32::round_ties_even),
+                0x91 => f32op!(f32::sqrt),
+                0x92 => f32binop!(|a, b| a + b),
+                0x93 => f32binop!(|a, b| a - b),
+                0x94 => f32binop!(|a, b| a * b),
+                0x95 => f32binop!(|a, b| a / b),
+                0x96 => f32binop!(|a: f32, b: f32| fmin(a as f64, b as f64)),
+                0x97 => f32binop!(|a: f32, b: f32| fmax(a as f64, b as f64)),
+                0x98 => f32binop!(f32::copysign),
+                0x99 => f64op!(f64::abs),
+                0x9a => f64op!(|a: f64| -a),
+                0x9b => f64op!(f64::ceil),
+                0x9c => f64op!(f64::floor),
+                0x9d => f64op!(f64::trunc),
+                0x9e => f64op!(f64::round_ties_even),
+                0x9f => f64op!(f64::sqrt),
+                0xa0 => f64binop!(|a, b| a + b),
+                0xa1 => f64binop!(|a, b| a - b),
+                0xa2 => f64binop!(|a, b| a * b),
+                0xa3 => f64binop!(|a, b| a / b),
+                0xa4 => f64binop!(fmin),
+                0xa5 => f64binop!(fmax),
+                0xa6 => f64binop!(f64::copysign),
+
+                0xa7 => push32!(pop!()),
+                0xa8 => push32!(
+                    trunc(f32::from_bits(pop32!()) as f64, -2147483649.0, 2147483648.0)? as i32
+                ),
+                0xa9 => push32!(trunc(f32::from_bits(pop32!()) as f64, -1.0, 4294967296.0)? as u32),
+                0xaa => push32!(trunc(f64::from_bits(pop!()), -2147483649.0, 2147483648.0)? as i32),
+                0xab => push32!(trunc(f64::from_bits(pop!()), -1.0, 4294967296.0)? as u32),
+                0xac => push!(pop32!() as i32 as i64 as u64),
+                0xad => push!(pop32!() as u64),
+                0xae => push!(trunc(
+                    f32::from_bits(pop32!()) as f64,
+                    -9223372036854777856.0,
+                    9223372036854775808.0,
+                )? as i64 as u64),
+                0xaf => push!(trunc(
+                    f32::from_bits(pop32!()) as f64,
+                    -1.0,
+                    18446744073709551616.0,
+                )? as u64),
+                0xb0 => push!(trunc(
+                    f64::from_bits(pop!()),
+                    -9223372036854777856.0,
+                    9223372036854775808.0,
+                )? as i64 as u64),
+                0xb1 => {
+                    push!(trunc(f64::from_bits(pop!()), -1.0, 18446744073709551616.0)? as u64)
+                }
+                0xb2 => push32!((pop32!() as i32 as f32).to_bits()),
+                0xb3 => push32!((pop32!() as f32).to_bits()),
+                0xb4 => push32!((pop!() as i64 as f32).to_bits()),
+                0xb5 => push32!((pop!() as f32).to_bits()),
+                0xb6 => push32!((f64::from_bits(pop!()) as f32).to_bits()),
+                0xb7 => push!((pop32!() as i32 as f64).to_bits()),
+                0xb8 => push!((pop32!() as f64).to_bits()),
+                0xb9 => push!((pop!() as i64 as f64).to_bits()),
+                0xba => push!((pop!() as f64).to_bits()),
+                0xbb => push!((f32::from_bits(pop32!()) as f64).to_bits()),
+                // Values are kept as bits, so reinterpreting leaves them be
+                0xbc..=0xbf => {}
+
+                0xc0 => unop32!(|a: u32| a as i8 as i32),
+                0xc1 => unop32!(|a: u32| a as i16 as i32),
+                0xc2 => unop64!(|a: u64| a as i8 as i64 as u64),
+                0xc3 => unop64!(|a: u64| a as i16 as i64 as u64),
+                0xc4 => unop64!(|a: u64| a as i32 as i64 as u64),
+
+                0xfc => match r.u32()? {
+                    // Float to int conversions saturate with as, as these do
+                    0 => push32!(f32::from_bits(pop32!()) as i32),
+                    1 => push32!(f32::from_bits(pop32!()) as u32),
+                    2 => push32!(f64::from_bits(pop!()) as i32),
+                    3 => push32!(f64::from_bits(pop!()) as u32),
+                    4 => push!(f32::from_bits(pop32!()) as i64 as u64),
+                    5 => push!(f32::from_bits(pop32!()) as u64),
+                    6 => push!(f64::from_bits(pop!()) as i64 as u64),
+                    7 => push!(f64::from_bits(pop!()) as u64),
+                    10 => {
+                        r.take(2)?;
+                        let len = pop32!();
+                        let src = self.address(pop32!(), 0, len as usize)?;
+                        let dst = self.address(pop32!(), 0, len as usize)?;
+                        self.memory.copy_within(src..src + len as usize, dst);
+                    }
+                    11 => {
+                        r.byte()?;
+                        let len = pop32!();
+                        let value = pop32!() as u8;
+                        let dst = self.address(pop32!(), 0, len as usize)?;
+                        self.memory[dst..dst + len as usize].fill(value);
+                    }
+                    _ => return trap("unsupported instruction"),
+                },
+                _ => return trap("unsupported instruction"),
+            }
+        }
+
+        if stack.len() < base + ty.results.len() {
+            return trap("stack underflow");
+        }
+        keep(stack, base, ty.results.len())?;
+        self.depth -= 1;
+        Ok(())
+    }
+
+    // Branch to the label depth levels out, returning whether that leaves the
+    // function
+    fn branch(
+        &self,
+        depth: u32,
+        labels: &mut Vec<Label>,
+        stack: &mut Vec<u64>,
+        r: &mut Reader,
+    ) -> Result<bool, String> {
+        let index = labels
+            .len()
+            .checked_sub(depth as usize + 1)
+            .ok_or_else(|| "branch to an unknown label".to_string())?;
+        let label = &labels[index];
+        keep(stack, label.height, label.arity)?;
+        r.pos = label.target;
+        if label.is_loop {
+            labels.truncate(index + 1);
+        } else {
+            labels.truncate(index);
+        }
+        Ok(labels.is_empty())
+    }
+
+    // The offset in memory of an access of len bytes at address plus offset
+    fn address(&self, address: u32, offset: u32, len: usize) -> Result<usize, String> {
+        let at = address as usize + offset as usize;
+        if at + len > self.memory.len() {
+            return trap("out of bounds memory access");
+        }
+        Ok(at)
+    }
+}
+
+// Keep the top arity values of the stack, dropping the others above height
+fn keep(stack: &mut Vec<u64>, height: usize, arity: usize) -> Result<(), String> {
+    if stack.len() < height + arity {
+        return trap("stack underflow");
+    }
+    stack.drain(height..stack.len() - arity);
+    Ok(())
+}
+
+fn signed_div(a: i64, b: i64, min: i64) -> Result<i64, String> {
+    if b == 0 {
+        return trap("integer divide by zero");
+    }
+    if a == min && b == -1 {
+        return trap("integer overflow");
+    }
+    Ok(a / b)
+}
+
+// Truncate a float towards zero, trapping unless the result lies strictly
+// between the bounds
+fn trunc(value: f64, lower: f64, upper: f64) -> Result<f64, String> {
+    if value.is_nan() {
+        return trap("invalid conversion to integer");
+    }
+    let value = value.trunc();
+    if value <= lower || value >= upper {
+        return trap("integer overflow");
+    }
+    Ok(value)
+}
+
+// Minimum as WebAssembly defines it: NaN if either is, and -0 below +0
+fn fmin(a: f64, b: f64) -> f64 {
+    if a.is_nan() || b.is_nan() {
+        f64::NAN
+    } else if a == b {
+        if a.is_sign_negative() {
+            a
+        } else {
+            b
+        }
+    } else {
+        a.min(b)
+    }
+}
+
+fn fmax(a: f64, b: f64) -> f64 {
+    if a.is_nan() || b.is_nan() {
+        f64::NAN
+    } else if a == b {
+        if a.is_sign_positive() {
+            a
+        } else {
+            b
+        }
+    } else {
+        a.max(b)
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const I32: u8 = 0x7f;
+    const I64: u8 = 0x7e;
+    const F32: u8 = 0x7d;
+
+    fn leb(mut n: usize, out: &mut Vec<u8>) {
+        loop {
+            let byte = (n & 0x7f) as u8;
+            n >>= 7;
+            if n == 0 {
+                out.push(byte);
+                return;
+            }
+            out.push(byte | 0x80);
+        }
+    }
+
+    fn section(id: u8, contents: &[u8], out: &mut Vec<u8>) {
+        out.push(id);
+        leb(contents.len(), out);
+        out.extend_from_slice(contents);
+    }
+
+    // Assemble a module with a memory of one page and one function, exported
+    // as "f", whose body starts with its local declarations
+    fn module(params: &[u8], results: &[u8], body: &[u8]) -> Vec<u8> {
+        let mut types = vec![0x01, 0x60, params.len() as u8];
+        types.extend_from_slice(params);
+        types.push(results.len() as u8);
+        types.extend_from_slice(results);
+
+        let mut code = vec![0x01];
+        leb(body.len() + 1, &mut code);
+        code.extend_from_slice(body);
+        code.push(0x0b);
+
+        let mut bytes = b"\0asm\x01\0\0\0".to_vec();
+        section(1, &types, &mut bytes);
+        section(3, &[0x01, 0x00], &mut bytes);
+        section(5, &[0x01, 0x00, 0x01], &mut bytes);
+        section(7, b"\x02\x01f\x00\x00\x06memory\x02\x00", &mut bytes);
+        section(10, &code, &mut bytes);
+        bytes
+    }
+
+    fn call(bytes: &[u8], args: &[u64], fuel: u64) -> Result<Vec<u64>, String> {
+        let module = Arc::new(Module::compile(bytes)?);
+        Instance::new(module, fuel)?.call("f", args, fuel)
+    }
+
+    // Sums the integers from 1 to its parameter
+    const SUM: &[u8] = &[
+        0x01, 0x01, I32, // local acc
+        0x02, 0x40, 0x03, 0x40, // block loop
+        0x20, 0x00, 0x45, 0x0d, 0x01, // br_if 1 (n == 0)
+        0x20, 0x01, 0x20, 0x00, 0x6a, 0x21, 0x01, // acc += n
+        0x20, 0x00, 0x41, 0x01, 0x6b, 0x21, 0x00, // n--
+        0x0c, 0x00, 0x0b, 0x0b, // br 0
+        0x20, 0x01,
+    ];
+
+    #[test]
+    fn compile_refuses_what_it_cannot_run() {
+        let cases: [(&[u8], &str); 4] = [
+            (b"not wasm", "not a WebAssembly module"),
+            (
+                b"\0asm\x01\0\0\0\x02\x07\x01\x01m\x01f\x00\x00",
+                "modules can't have imports",
+            ),
+            (
+                &module(&[], &[], &[0x00, 0xfd, 0x0c]),
+                "unsupported instruction 0xfd",
+            ),
+            (
+                &module(&[], &[], &[0x00, 0x10, 0x05]),
+                "call of an unknown function",
+            ),
+        ];
+        for (bytes, want) in cases {
+            match Module::compile(bytes) {
+                Err(err) => assert_eq!(err, want),
+                Ok(_) => panic!("compiled a module that should fail with {}", want),
+            }
+        }
+
+        // Cut anywhere from the start of its code section on, the module
+        // lacks the code of its function
+        let bytes = module(&[I32], &[I32], SUM);
+        let code = bytes.len() - (SUM.len() + 5);
+        for len in code..bytes.len() {
+            assert!(
+                Module::compile(&bytes[..len]).is_err(),
+                "compiled {} bytes",
+                len
+            );
+        }
+    }
+
+    #[test]
+    fn arithmetic() {
+        let add = module(&[I32, I32], &[I32], &[0x00, 0x20, 0x00, 0x20, 0x01, 0x6a]);
+        assert_eq!(call(&add, &[2, 3], 100), Ok(vec![5]));
+        assert_eq!(call(&add, &[u32::MAX as u64, 1], 100), Ok(vec![0]));
+
+        let mul = module(&[I64, I64], &[I64], &[0x00, 0x20, 0x00, 0x20, 0x01, 0x7e]);
+        assert_eq!(call(&mul, &[1 << 40, 3], 100), Ok(vec![3 << 40]));
+
+        let div = module(&[I32, I32], &[I32], &[0x00, 0x20, 0x00, 0x20, 0x01, 0x6d]);
+        assert_eq!(
+            call(&div, &[(-7i32) as u32 as u64, 2], 100),
+            Ok(vec![(-3i32) as u32 as u64])
+        );
+        assert_eq!(
+            call(&div, &[1, 0], 100),
+            Err("integer divide by zero".to_string())
+        );
+        assert_eq!(
+            call(&div, &[i32::MIN as u32 as u64, u32::MAX as u64], 100),
+            Err("integer overflow".to_string())
+        );
+
+        let extend = module(&[I32], &[I32], &[0x00, 0x20, 0x00, 0xc0]);
+        assert_eq!(call(&extend, &[0x80], 100), Ok(vec![0xffff_ff80]));
+
+        let nan = f32::NAN.to_bits() as u64;
+        let trunc = module(&[F32], &[I32], &[0x00, 0x20, 0x00, 0xa8]);
+        assert_eq!(
+            call(&trunc, &[nan], 100),
+            Err("invalid conversion to integer".to_string())
+        );
+        let trunc_sat = module(&[F32], &[I32], &[0x00, 0x20, 0x00, 0xfc, 0x00]);
+        assert_eq!(call(&trunc_sat, &[nan], 100), Ok(vec![0]));
+        assert_eq!(
+            call(&trunc_sat, &[1e10f32.to_bits() as u64], 100),
+            Ok(vec![i32::MAX as u64])
+        );
+    }
+
+    #[test]
+    fn control_flow() {
+        let sum = module(&[I32], &[I32], SUM);
+        assert_eq!(call(&sum, &[100], 10_000), Ok(vec![5050]));
+
+        // Three nested blocks, left by br_table with the parameter as index
+        let table = module(
+            &[I32],
+            &[I32],
+            &[
+                0x00, 0x02, 0x40, 0x02, 0x40, 0x02, 0x40, //
+                0x20, 0x00, 0x0e, 0x02, 0x00, 0x01, 0x02, 0x0b, //
+                0x41, 0x0a, 0x0f, 0x0b, //
+                0x41, 0x0b, 0x0f, 0x0b, //
+                0x41, 0x0c,
+            ],
+        );
+        for (index, want) in [(0, 10), (1, 11), (2, 12), (99, 12)] {
+            assert_eq!(call(&table, &[index], 100), Ok(vec![want]));
+        }
+
+        let select = module(
+            &[I32],
+            &[I32],
+            &[
+                0x00, 0x20, 0x00, 0x04, I32, 0x41, 0x01, 0x05, 0x41, 0x02, 0x0b,
+            ],
+        );
+        assert_eq!(call(&select, &[1], 100), Ok(vec![1]));
+        assert_eq!(call(&select, &[0], 100), Ok(vec![2]));
+    }
+
+    #[test]
+    fn traps() {
+        let unreachable = module(&[], &[], &[0x00, 0x00]);
+        assert_eq!(call(&unreachable, &[], 100), Err("unreachable".to_string()));
+
+        // Bodies aren't validated, so a bad one traps as it runs
+        let underflow = module(&[], &[I32], &[0x00, 0x6a]);
+        assert_eq!(
+            call(&underflow, &[], 100),
+            Err("stack underflow".to_string())
+        );
+
+        let recursive = module(&[], &[], &[0x00, 0x10, 0x00]);
+        assert_eq!(
+            call(&recursive, &[], 1_000_000),
+            Err("call stack exhausted".to_string())
+        );
+
+        let sum = module(&[I32], &[I32], SUM);
+        assert_eq!(
+            call(&sum, &[1_000_000], 1000),
+            Err("out of fuel".to_string())
+        );
+    }
+
+    #[test]
+    fn fuel_is_charged_per_instruction() {
+        let module = Arc::new(Module::compile(&module(&[I32], &[I32], SUM)).unwrap());
+        let mut instance = Instance::new(module, 0).unwrap();
+        let mut used = |n| {
+            instance.call("f", &[n], 1000).unwrap();
+            1000 - instance.fuel()
+        };
+
+        // Each pass of the loop runs the same instructions
+        let (ten, twenty, thirty) = (used(10), used(20), used(30));
+        assert!(twenty > ten, "used {} and {}", ten, twenty);
+        assert_eq!(thirty - twenty, twenty - ten);
+    }
+
+    #[test]
+    fn memory() {
+        // Stores 42 at the parameter and loads it back
+        let store = module(
+            &[I32],
+            &[I32],
+            &[
+                0x00, 0x20, 0x00, 0x41, 0x2a, 0x36, 0x02, 0x00, 0x20, 0x00, 0x28, 0x02, 0x00,
+            ],
+        );
+        assert_eq!(call(&store, &[8], 100), Ok(vec![42]));
+        assert_eq!(
+            call(&store, &[65534], 100),
+            Err("out of bounds memory access".to_string())
+        );
+        assert_eq!(
+            call(&store, &[u32::MAX as u64], 100),
+            Err("out of bounds memory access".to_string())
+        );
+
+        let grow = module(&[I32], &[I32], &[0x00, 0x20, 0x00, 0x40, 0x00]);
+        assert_eq!(call(&grow, &[1], 100), Ok(vec![1]));
+        assert_eq!(
+            call(&grow, &[MAX_PAGES as u64], 100),
+            Ok(vec![u32::MAX as u64])
+        );
+
+        // Memory carries over from one call to the next
+        let bytes = module(
+            &[I32],
+            &[I32],
+            &[
+                0x00, 0x41, 0x00, 0x28, 0x02, 0x00, 0x41, 0x00, 0x20, 0x00, 0x36, 0x02, 0x00,
+            ],
+        );
+        let mut instance = Instance::new(Arc::new(Module::compile(&bytes).unwrap()), 100).unwrap();
+        assert_eq!(instance.call("f", &[7], 100), Ok(vec![0]));
+        assert_eq!(instance.call("f", &[9], 100), Ok(vec![7]));
+        assert_eq!(&instance.memory()[..4], &9u32.to_le_bytes());
+    }
+
+    // Mutations of a valid module either fail to compile or run to a result
+    // or a trap, and never panic
+    #[test]
+    fn mutated_modules_never_panic() {
+        let original = module(&[I32], &[I32], SUM);
+        let mut seed = 0x2545_f491_4f6c_dd1du64;
+        let mut next = move || {
+            seed ^= seed << 13;
+            seed ^= seed >> 7;
+            seed ^= seed << 17;
+            seed
+        };
+
+        for _ in 0..20_000 {
+            let mut bytes = original.clone();
+            for _ in 0..1 + next() % 4 {
+                let i = next() as usize % bytes.len();
+                bytes[i] = next() as u8;
+            }
+            let _ = call(&bytes, &[next() % 64], 10_000);
+        }
+    }
+}
@@ -0,0 +1,39 @@
+// High and low marks on the depth of a bounded queue, as fractions of its
+// capacity. Reaching the high mark raises an event, and so does draining back
+// to the low mark after it, so a queue hovering around one mark raises no
+// stream of events.
+pub struct Watermark {
+    high: f64,
+    low: f64,
+    // Whether the queue reached the high mark and hasn't drained since
+    above: bool,
+}
+
+// Events raised as a queue crosses its marks
+const WATERMARK_HIGH: &str = "high";
+const WATERMARK_LOW: &str = "low";
+
+impl Watermark {
+    pub fn new(high: f64, low: f64) -> Self {
+        Watermark {
+            high,
+            low,
+            above: false,
+        }
+    }
+
+    // The event a queue's depth raises, if it crossed a mark since the last
+    // check
+    pub fn check(&mut self, depth: usize, capacity: usize) -> Option<&'static str> {
+        let fill = depth as f64 / capacity as f64;
+        if !self.above && fill >= self.high {
+            self.above = true;
+            Some(WATERMARK_HIGH)
+        } else if self.above && fill <= self.low {
+            self.above = false;
+            Some(WATERMARK_LOW)
+        } else {
+            None
+        }
+    }
+}